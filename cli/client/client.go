@@ -21,6 +21,7 @@
 package client
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -30,8 +31,9 @@ import (
 	"strings"
 	"time"
 
-	"github.com/dnote/dnote/cli/crypt"
+	"github.com/dnote/dnote/cli/core"
 	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
 	"github.com/dnote/dnote/cli/utils"
 	"github.com/pkg/errors"
 )
@@ -39,6 +41,9 @@ import (
 // ErrInvalidLogin is an error for invalid credentials for login
 var ErrInvalidLogin = errors.New("wrong credentials")
 
+// ErrInvalidTOTPCode is an error for an invalid two-factor authentication code
+var ErrInvalidTOTPCode = errors.New("invalid code")
+
 // GetSyncStateResp is the response get sync state endpoint
 type GetSyncStateResp struct {
 	FullSyncBefore int   `json:"full_sync_before"`
@@ -51,7 +56,7 @@ func GetSyncState(ctx infra.DnoteCtx) (GetSyncStateResp, error) {
 	var ret GetSyncStateResp
 
 	hc := http.Client{}
-	res, err := utils.DoAuthorizedReq(ctx, hc, "GET", "/v1/sync/state", "")
+	res, err := doAuthorizedReq(ctx, hc, "GET", "/v1/sync/state", "")
 	if err != nil {
 		return ret, errors.Wrap(err, "constructing http request")
 	}
@@ -106,52 +111,152 @@ type SyncFragment struct {
 	ExpungedBooks []string       `json:"expunged_books"`
 }
 
-// GetSyncFragmentResp is the response from the get sync fragment endpoint
-type GetSyncFragmentResp struct {
-	Fragment SyncFragment `json:"fragment"`
+// ErrSyncStreamInterrupted is an error for a sync stream that ended before the final fragment
+var ErrSyncStreamInterrupted = errors.New("sync stream interrupted")
+
+// syncStreamETag returns the entity tag that the server gives to the sync stream after the
+// given usn when the max_usn of the user is the given usn, that is, when the client is up
+// to date
+func syncStreamETag(afterUSN int) string {
+	return fmt.Sprintf(`W/"%d-%d"`, afterUSN, afterUSN)
 }
 
-// GetSyncFragment gets a sync fragment response from the server
-func GetSyncFragment(ctx infra.DnoteCtx, afterUSN int) (GetSyncFragmentResp, error) {
+// StreamSyncFragments gets all sync fragments after the given usn from the server in a single
+// streamed response and calls the given function with each fragment in order. The final
+// fragment, whose FragMaxUSN is 0, signals the end of the stream. If the stream ends before
+// the final fragment, it returns ErrSyncStreamInterrupted. If the server responds that
+// nothing has changed after the given usn, the function is called with the final fragment only.
+func StreamSyncFragments(ctx infra.DnoteCtx, afterUSN int, handle func(SyncFragment) error) error {
 	v := url.Values{}
 	v.Set("after_usn", strconv.Itoa(afterUSN))
 	queryStr := v.Encode()
 
-	path := fmt.Sprintf("/v1/sync/fragment?%s", queryStr)
+	path := fmt.Sprintf("/v2/sync/stream?%s", queryStr)
 	hc := http.Client{}
-	res, err := utils.DoAuthorizedReq(ctx, hc, "GET", path, "")
+	header := http.Header{}
+	header.Set("If-None-Match", syncStreamETag(afterUSN))
+	res, err := doAuthorizedReqWithHeader(ctx, hc, "GET", path, "", header)
+	if err != nil {
+		return errors.Wrap(err, "making http request")
+	}
+	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	if res.StatusCode == http.StatusNotModified {
+		currentTime := time.Now()
+		if t, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+			currentTime = t
+		}
+
+		frag := SyncFragment{
+			FragMaxUSN:  0,
+			UserMaxUSN:  afterUSN,
+			CurrentTime: currentTime.Unix(),
+		}
+		if err := handle(frag); err != nil {
+			return errors.Wrap(err, "handling fragment")
+		}
+
+		return nil
+	}
+
+	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return GetSyncFragmentResp{}, errors.Wrap(err, "reading the response body")
+		return errors.Wrap(err, "checking repsonse error")
 	}
+	if hasErr {
+		return errors.New(message)
+	}
+
+	// The response body is transparently decompressed by the http client
+	dec := json.NewDecoder(res.Body)
+	for {
+		var frag SyncFragment
+		if err := dec.Decode(&frag); err != nil {
+			return errors.Wrap(ErrSyncStreamInterrupted, err.Error())
+		}
 
-	var resp GetSyncFragmentResp
-	if err = json.Unmarshal(body, &resp); err != nil {
-		return resp, errors.Wrap(err, "unmarshalling the payload")
+		if err := handle(frag); err != nil {
+			return errors.Wrap(err, "handling fragment")
+		}
+
+		if frag.FragMaxUSN == 0 {
+			return nil
+		}
 	}
+}
 
-	return resp, nil
+// Types of the items and the actions for the changes pushed to the server
+const (
+	SyncTypeBook     = "book"
+	SyncTypeNote     = "note"
+	SyncActionCreate = "create"
+	SyncActionUpdate = "update"
+	SyncActionDelete = "delete"
+)
+
+// SyncPushChange is a change to a book or a note to be pushed to the server. ClientUUID is
+// the uuid of the item on the client.
+type SyncPushChange struct {
+	Type       string  `json:"type"`
+	Action     string  `json:"action"`
+	ClientUUID string  `json:"client_uuid"`
+	Label      *string `json:"label,omitempty"`
+	BookUUID   *string `json:"book_uuid,omitempty"`
+	Content    *string `json:"content,omitempty"`
+	Public     *bool   `json:"public,omitempty"`
+	AddedOn    *int64  `json:"added_on,omitempty"`
+	EditedOn   *int64  `json:"edited_on,omitempty"`
 }
 
-// RespBook is the book in the response from the create book api
-type RespBook struct {
-	ID        int       `json:"id"`
-	UUID      string    `json:"uuid"`
-	USN       int       `json:"usn"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Label     string    `json:"label"`
+// SyncPushPayload is a payload for pushing changes
+type SyncPushPayload struct {
+	Changes []SyncPushChange `json:"changes"`
 }
 
-// CreateBookPayload is a payload for creating a book
-type CreateBookPayload struct {
-	Name string `json:"name"`
+// SyncPushResult is a result of a change pushed to the server. UUID is the uuid of
+// the item on the server.
+type SyncPushResult struct {
+	ClientUUID string `json:"client_uuid"`
+	UUID       string `json:"uuid"`
+	USN        int    `json:"usn"`
 }
 
-// CreateBookResp is the response from create book api
-type CreateBookResp struct {
-	Book RespBook `json:"book"`
+// SyncPushResp is a response from the sync push endpoint. The results are in the same
+// order as the changes in the payload.
+type SyncPushResp struct {
+	Results []SyncPushResult `json:"results"`
+}
+
+// PushSync pushes the given changes to the server, which applies them all or none
+func PushSync(ctx infra.DnoteCtx, changes []SyncPushChange) (SyncPushResp, error) {
+	payload := SyncPushPayload{
+		Changes: changes,
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return SyncPushResp{}, errors.Wrap(err, "marshaling payload")
+	}
+
+	hc := http.Client{}
+	res, err := doAuthorizedReq(ctx, hc, "POST", "/v2/sync/push", string(b))
+	if err != nil {
+		return SyncPushResp{}, errors.Wrap(err, "making http request")
+	}
+
+	hasErr, message, err := checkRespErr(res)
+	if err != nil {
+		return SyncPushResp{}, errors.Wrap(err, "checking repsonse error")
+	}
+	if hasErr {
+		return SyncPushResp{}, errors.New(message)
+	}
+
+	var resp SyncPushResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return SyncPushResp{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
 }
 
 // checkRespErr checks if the given http response indicates an error. It returns a boolean indicating
@@ -171,363 +276,479 @@ func checkRespErr(res *http.Response) (bool, string, error) {
 	return true, message, nil
 }
 
-// CreateBook creates a new book in the server
-func CreateBook(ctx infra.DnoteCtx, label string) (CreateBookResp, error) {
-	encLabel, err := crypt.AesGcmEncrypt(ctx.CipherKey, []byte(label))
+// GetBooksResp is a response from get books endpoint
+type GetBooksResp []struct {
+	UUID  string `json:"uuid"`
+	Label string `json:"label"`
+}
+
+// GetBooks gets books from the server
+func GetBooks(ctx infra.DnoteCtx, sessionKey string) (GetBooksResp, error) {
+	hc := http.Client{}
+	res, err := doAuthorizedReq(ctx, hc, "GET", "/v1/books", "")
 	if err != nil {
-		return CreateBookResp{}, errors.Wrap(err, "encrypting the label")
+		return GetBooksResp{}, errors.Wrap(err, "making http request")
 	}
 
-	payload := CreateBookPayload{
-		Name: encLabel,
-	}
-	b, err := json.Marshal(payload)
+	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return CreateBookResp{}, errors.Wrap(err, "marshaling payload")
+		return GetBooksResp{}, errors.Wrap(err, "checking repsonse error")
+	}
+	if hasErr {
+		return GetBooksResp{}, errors.New(message)
 	}
 
-	hc := http.Client{}
-	res, err := utils.DoAuthorizedReq(ctx, hc, "POST", "/v2/books", string(b))
+	var resp GetBooksResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return GetBooksResp{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// PresigninResponse is a reponse from /v1/presignin endpoint
+type PresigninResponse struct {
+	Iteration int `json:"iteration"`
+}
+
+// GetPresignin gets presignin credentials
+func GetPresignin(ctx infra.DnoteCtx, email string) (PresigninResponse, error) {
+	res, err := utils.DoReq(ctx, "GET", fmt.Sprintf("/v1/presignin?email=%s", email), "")
 	if err != nil {
-		return CreateBookResp{}, errors.Wrap(err, "posting a book to the server")
+		return PresigninResponse{}, errors.Wrap(err, "making http request")
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return CreateBookResp{}, errors.Wrap(err, "checking repsonse error")
+		return PresigninResponse{}, errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return CreateBookResp{}, errors.New(message)
+		return PresigninResponse{}, errors.New(message)
 	}
 
-	var resp CreateBookResp
+	var resp PresigninResponse
 	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return resp, errors.Wrap(err, "decoding response payload")
+		return PresigninResponse{}, errors.Wrap(err, "decoding payload")
 	}
 
 	return resp, nil
 }
 
-type updateBookPayload struct {
-	Name *string `json:"name"`
+// SigninPayload is a payload for /v1/signin
+type SigninPayload struct {
+	Email   string `json:"email"`
+	AuthKey string `json:"auth_key"`
 }
 
-// UpdateBookResp is the response from create book api
-type UpdateBookResp struct {
-	Book RespBook `json:"book"`
+// SigninResponse is a response from /v1/signin endpoint. If two-factor authentication is
+// enabled, TOTPRequired is true and the challenge needs to be completed using SigninTOTP.
+type SigninResponse struct {
+	Key          string `json:"key"`
+	ExpiresAt    int64  `json:"expires_at"`
+	CipherKeyEnc string `json:"cipher_key_enc"`
+	RefreshToken string `json:"refresh_token"`
+	TOTPRequired bool   `json:"totp_required"`
+	Challenge    string `json:"challenge"`
 }
 
-// UpdateBook updates a book in the server
-func UpdateBook(ctx infra.DnoteCtx, label, uuid string) (UpdateBookResp, error) {
-	encName, err := crypt.AesGcmEncrypt(ctx.CipherKey, []byte(label))
-	if err != nil {
-		return UpdateBookResp{}, errors.Wrap(err, "encrypting the content")
-	}
-
-	payload := updateBookPayload{
-		Name: &encName,
+// Signin requests a session token
+func Signin(ctx infra.DnoteCtx, email, authKey string) (SigninResponse, error) {
+	payload := SigninPayload{
+		Email:   email,
+		AuthKey: authKey,
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
-		return UpdateBookResp{}, errors.Wrap(err, "marshaling payload")
+		return SigninResponse{}, errors.Wrap(err, "marshaling payload")
 	}
-
-	hc := http.Client{}
-	endpoint := fmt.Sprintf("/v1/books/%s", uuid)
-	res, err := utils.DoAuthorizedReq(ctx, hc, "PATCH", endpoint, string(b))
+	res, err := utils.DoReq(ctx, "POST", "/v1/signin", string(b))
 	if err != nil {
-		return UpdateBookResp{}, errors.Wrap(err, "posting a book to the server")
+		return SigninResponse{}, errors.Wrap(err, "making http request")
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return SigninResponse{}, ErrInvalidLogin
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return UpdateBookResp{}, errors.Wrap(err, "checking repsonse error")
+		return SigninResponse{}, errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return UpdateBookResp{}, errors.New(message)
+		return SigninResponse{}, errors.New(message)
 	}
 
-	var resp UpdateBookResp
+	var resp SigninResponse
 	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return resp, errors.Wrap(err, "decoding payload")
+		return SigninResponse{}, errors.Wrap(err, "decoding payload")
 	}
 
 	return resp, nil
 }
 
-// DeleteBookResp is the response from create book api
-type DeleteBookResp struct {
-	Status int      `json:"status"`
-	Book   RespBook `json:"book"`
+// SigninTOTPPayload is a payload for /v1/signin/totp
+type SigninTOTPPayload struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
 }
 
-// DeleteBook deletes a book in the server
-func DeleteBook(ctx infra.DnoteCtx, uuid string) (DeleteBookResp, error) {
-	hc := http.Client{}
-	endpoint := fmt.Sprintf("/v1/books/%s", uuid)
-	res, err := utils.DoAuthorizedReq(ctx, hc, "DELETE", endpoint, "")
+// SigninTOTP completes a signin challenge with a two-factor authentication code
+func SigninTOTP(ctx infra.DnoteCtx, challenge, code string) (SigninResponse, error) {
+	payload := SigninTOTPPayload{
+		Challenge: challenge,
+		Code:      code,
+	}
+	b, err := json.Marshal(payload)
 	if err != nil {
-		return DeleteBookResp{}, errors.Wrap(err, "deleting a book in the server")
+		return SigninResponse{}, errors.Wrap(err, "marshaling payload")
+	}
+	res, err := utils.DoReq(ctx, "POST", "/v1/signin/totp", string(b))
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "making http request")
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return SigninResponse{}, ErrInvalidTOTPCode
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return DeleteBookResp{}, errors.Wrap(err, "checking repsonse error")
+		return SigninResponse{}, errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return DeleteBookResp{}, errors.New(message)
+		return SigninResponse{}, errors.New(message)
 	}
 
-	var resp DeleteBookResp
+	var resp SigninResponse
 	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return resp, errors.Wrap(err, "decoding the response")
+		return SigninResponse{}, errors.Wrap(err, "decoding payload")
 	}
 
 	return resp, nil
 }
 
-// CreateNotePayload is a payload for creating a note
-type CreateNotePayload struct {
-	BookUUID string `json:"book_uuid"`
-	Body     string `json:"content"`
-}
+// Signout deletes a user session on the server side
+func Signout(ctx infra.DnoteCtx, sessionKey string) error {
+	hc := http.Client{
+		// No need to follow redirect
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
 
-// CreateNoteResp is the response from create note endpoint
-type CreateNoteResp struct {
-	Result RespNote `json:"result"`
-}
+	res, err := doAuthorizedReq(ctx, hc, "POST", "/v1/signout", "")
+	if err != nil {
+		return errors.Wrap(err, "making http request")
+	}
 
-type respNoteBook struct {
-	UUID  string `json:"uuid"`
-	Label string `json:"label"`
-}
+	hasErr, message, err := checkRespErr(res)
+	if err != nil {
+		return errors.Wrap(err, "checking repsonse error")
+	}
+	if hasErr {
+		return errors.New(message)
+	}
 
-type respNoteUser struct {
-	Name string `json:"name"`
+	return nil
 }
 
-// RespNote is a note in the response
-type RespNote struct {
-	UUID      string       `json:"uuid"`
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
-	Body      string       `json:"content"`
-	AddedOn   int64        `json:"added_on"`
-	Public    bool         `json:"public"`
-	USN       int          `json:"usn"`
-	Book      respNoteBook `json:"book"`
-	User      respNoteUser `json:"user"`
+// CreateResetTokenPayload is a payload for creating a password reset token
+type CreateResetTokenPayload struct {
+	Email string `json:"email"`
 }
 
-// CreateNote creates a note in the server
-func CreateNote(ctx infra.DnoteCtx, bookUUID, content string) (CreateNoteResp, error) {
-	encBody, err := crypt.AesGcmEncrypt(ctx.CipherKey, []byte(content))
+// CreateResetToken requests the server to send a password reset email
+func CreateResetToken(ctx infra.DnoteCtx, email string) error {
+	payload := CreateResetTokenPayload{
+		Email: email,
+	}
+	b, err := json.Marshal(payload)
 	if err != nil {
-		return CreateNoteResp{}, errors.Wrap(err, "encrypting the content")
+		return errors.Wrap(err, "marshaling payload")
 	}
 
-	payload := CreateNotePayload{
-		BookUUID: bookUUID,
-		Body:     encBody,
+	res, err := utils.DoReq(ctx, "POST", "/v1/password-reset", string(b))
+	if err != nil {
+		return errors.Wrap(err, "making http request")
 	}
-	b, err := json.Marshal(payload)
+
+	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return CreateNoteResp{}, errors.Wrap(err, "marshaling payload")
+		return errors.Wrap(err, "checking repsonse error")
+	}
+	if hasErr {
+		return errors.New(message)
 	}
 
-	hc := http.Client{}
-	res, err := utils.DoAuthorizedReq(ctx, hc, "POST", "/v2/notes", string(b))
+	return nil
+}
+
+// GetResetTokenResponse is a response from getting a password reset token
+type GetResetTokenResponse struct {
+	Email                string `json:"email"`
+	CipherKeyRecoveryEnc string `json:"cipher_key_recovery_enc"`
+	TOTPRequired         bool   `json:"totp_required"`
+}
+
+// GetResetToken checks the password reset token and gets the email of the account it belongs to
+func GetResetToken(ctx infra.DnoteCtx, token string) (GetResetTokenResponse, error) {
+	endpoint := fmt.Sprintf("/v1/password-reset?token=%s", url.QueryEscape(token))
+	res, err := utils.DoReq(ctx, "GET", endpoint, "")
 	if err != nil {
-		return CreateNoteResp{}, errors.Wrap(err, "posting a book to the server")
+		return GetResetTokenResponse{}, errors.Wrap(err, "making http request")
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return CreateNoteResp{}, errors.Wrap(err, "checking repsonse error")
+		return GetResetTokenResponse{}, errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return CreateNoteResp{}, errors.New(message)
+		return GetResetTokenResponse{}, errors.New(message)
 	}
 
-	var resp CreateNoteResp
+	var resp GetResetTokenResponse
 	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return CreateNoteResp{}, errors.Wrap(err, "decoding payload")
+		return GetResetTokenResponse{}, errors.Wrap(err, "decoding payload")
 	}
 
 	return resp, nil
 }
 
-type updateNotePayload struct {
-	BookUUID *string `json:"book_uuid"`
-	Body     *string `json:"content"`
-	Public   *bool   `json:"public"`
-}
+// ResetPasswordPayload is a payload for resetting the password
+type ResetPasswordPayload struct {
+	Token           string `json:"token"`
+	AuthKey         string `json:"auth_key"`
+	CipherKeyEnc    string `json:"cipher_key_enc"`
+	Iteration       int    `json:"iteration"`
+	RecoveryAuthKey string `json:"recovery_auth_key,omitempty"`
+	Code            string `json:"code,omitempty"`
+}
+
+// ResetPassword sets new credentials using the password reset token and requests a session token.
+// recoveryAuthKey should be given if the cipher key was recovered using the recovery key, and
+// code should be given if two-factor authentication is enabled.
+func ResetPassword(ctx infra.DnoteCtx, token, authKey, cipherKeyEnc string, iteration int, recoveryAuthKey, code string) (SigninResponse, error) {
+	payload := ResetPasswordPayload{
+		Token:           token,
+		AuthKey:         authKey,
+		CipherKeyEnc:    cipherKeyEnc,
+		Iteration:       iteration,
+		RecoveryAuthKey: recoveryAuthKey,
+		Code:            code,
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "marshaling payload")
+	}
 
-// UpdateNoteResp is the response from create book api
-type UpdateNoteResp struct {
-	Status int      `json:"status"`
-	Result RespNote `json:"result"`
-}
+	res, err := utils.DoReq(ctx, "PATCH", "/v1/password-reset", string(b))
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "making http request")
+	}
 
-// UpdateNote updates a note in the server
-func UpdateNote(ctx infra.DnoteCtx, uuid, bookUUID, content string, public bool) (UpdateNoteResp, error) {
-	encBody, err := crypt.AesGcmEncrypt(ctx.CipherKey, []byte(content))
+	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return UpdateNoteResp{}, errors.Wrap(err, "encrypting the content")
+		return SigninResponse{}, errors.Wrap(err, "checking repsonse error")
 	}
+	if hasErr {
+		return SigninResponse{}, errors.New(message)
+	}
+
+	var resp SigninResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return SigninResponse{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// SetRecoveryKeyPayload is a payload for setting a recovery key
+type SetRecoveryKeyPayload struct {
+	AuthKey              string `json:"auth_key"`
+	RecoveryAuthKey      string `json:"recovery_auth_key"`
+	CipherKeyRecoveryEnc string `json:"cipher_key_recovery_enc"`
+}
 
-	payload := updateNotePayload{
-		BookUUID: &bookUUID,
-		Body:     &encBody,
-		Public:   &public,
+// SetRecoveryKey registers the cipher key wrapped by a recovery key in the server
+func SetRecoveryKey(ctx infra.DnoteCtx, authKey, recoveryAuthKey, cipherKeyRecoveryEnc string) error {
+	payload := SetRecoveryKeyPayload{
+		AuthKey:              authKey,
+		RecoveryAuthKey:      recoveryAuthKey,
+		CipherKeyRecoveryEnc: cipherKeyRecoveryEnc,
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
-		return UpdateNoteResp{}, errors.Wrap(err, "marshaling payload")
+		return errors.Wrap(err, "marshaling payload")
 	}
 
 	hc := http.Client{}
-	endpoint := fmt.Sprintf("/v1/notes/%s", uuid)
-	res, err := utils.DoAuthorizedReq(ctx, hc, "PATCH", endpoint, string(b))
+	res, err := doAuthorizedReq(ctx, hc, "PATCH", "/v1/account/recovery-key", string(b))
 	if err != nil {
-		return UpdateNoteResp{}, errors.Wrap(err, "patching a note to the server")
+		return errors.Wrap(err, "making http request")
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return ErrInvalidLogin
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return UpdateNoteResp{}, errors.Wrap(err, "checking repsonse error")
+		return errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return UpdateNoteResp{}, errors.New(message)
-	}
-
-	var resp UpdateNoteResp
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return UpdateNoteResp{}, errors.Wrap(err, "decoding payload")
+		return errors.New(message)
 	}
 
-	return resp, nil
+	return nil
 }
 
-// DeleteNoteResp is the response from remove note api
-type DeleteNoteResp struct {
-	Status int      `json:"status"`
-	Result RespNote `json:"result"`
+// RespSession is a session in the response from the server
+type RespSession struct {
+	ID         int       `json:"id"`
+	ClientType string    `json:"client_type"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
 }
 
-// DeleteNote removes a note in the server
-func DeleteNote(ctx infra.DnoteCtx, uuid string) (DeleteNoteResp, error) {
+// GetSessions gets the active sessions of the user
+func GetSessions(ctx infra.DnoteCtx) ([]RespSession, error) {
 	hc := http.Client{}
-	endpoint := fmt.Sprintf("/v1/notes/%s", uuid)
-	res, err := utils.DoAuthorizedReq(ctx, hc, "DELETE", endpoint, "")
+	res, err := doAuthorizedReq(ctx, hc, "GET", "/v1/sessions", "")
 	if err != nil {
-		return DeleteNoteResp{}, errors.Wrap(err, "patching a note to the server")
+		return nil, errors.Wrap(err, "making http request")
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return DeleteNoteResp{}, errors.Wrap(err, "checking repsonse error")
+		return nil, errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return DeleteNoteResp{}, errors.New(message)
+		return nil, errors.New(message)
 	}
 
-	var resp DeleteNoteResp
+	var resp []RespSession
 	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return DeleteNoteResp{}, errors.Wrap(err, "decoding payload")
+		return nil, errors.Wrap(err, "decoding payload")
 	}
 
 	return resp, nil
 }
 
-// GetBooksResp is a response from get books endpoint
-type GetBooksResp []struct {
-	UUID  string `json:"uuid"`
-	Label string `json:"label"`
-}
-
-// GetBooks gets books from the server
-func GetBooks(ctx infra.DnoteCtx, sessionKey string) (GetBooksResp, error) {
+// DeleteSession revokes a session of the user
+func DeleteSession(ctx infra.DnoteCtx, id int) error {
 	hc := http.Client{}
-	res, err := utils.DoAuthorizedReq(ctx, hc, "GET", "/v1/books", "")
+	endpoint := fmt.Sprintf("/v1/sessions/%d", id)
+	res, err := doAuthorizedReq(ctx, hc, "DELETE", endpoint, "")
 	if err != nil {
-		return GetBooksResp{}, errors.Wrap(err, "making http request")
+		return errors.Wrap(err, "making http request")
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return GetBooksResp{}, errors.Wrap(err, "checking repsonse error")
+		return errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return GetBooksResp{}, errors.New(message)
+		return errors.New(message)
 	}
 
-	var resp GetBooksResp
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return GetBooksResp{}, errors.Wrap(err, "decoding payload")
-	}
+	return nil
+}
 
-	return resp, nil
+// DeleteAccountPayload is a payload for deleting the account
+type DeleteAccountPayload struct {
+	AuthKey string `json:"auth_key"`
 }
 
-// PresigninResponse is a reponse from /v1/presignin endpoint
-type PresigninResponse struct {
-	Iteration int `json:"iteration"`
+// DeleteAccountResp is a response from the server for deleting the account
+type DeleteAccountResp struct {
+	DeleteAt time.Time `json:"delete_at"`
 }
 
-// GetPresignin gets presignin credentials
-func GetPresignin(ctx infra.DnoteCtx, email string) (PresigninResponse, error) {
-	res, err := utils.DoReq(ctx, "GET", fmt.Sprintf("/v1/presignin?email=%s", email), "")
+// DeleteAccount schedules the deletion of the account. The server deletes the account
+// after a grace period, during which the deletion can be cancelled.
+func DeleteAccount(ctx infra.DnoteCtx, authKey string) (DeleteAccountResp, error) {
+	payload := DeleteAccountPayload{
+		AuthKey: authKey,
+	}
+	b, err := json.Marshal(payload)
 	if err != nil {
-		return PresigninResponse{}, errors.Wrap(err, "making http request")
+		return DeleteAccountResp{}, errors.Wrap(err, "marshaling payload")
+	}
+
+	hc := http.Client{}
+	res, err := doAuthorizedReq(ctx, hc, "DELETE", "/v1/account", string(b))
+	if err != nil {
+		return DeleteAccountResp{}, errors.Wrap(err, "making http request")
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return DeleteAccountResp{}, ErrInvalidLogin
 	}
 
 	hasErr, message, err := checkRespErr(res)
 	if err != nil {
-		return PresigninResponse{}, errors.Wrap(err, "checking repsonse error")
+		return DeleteAccountResp{}, errors.Wrap(err, "checking repsonse error")
 	}
 	if hasErr {
-		return PresigninResponse{}, errors.New(message)
+		return DeleteAccountResp{}, errors.New(message)
 	}
 
-	var resp PresigninResponse
+	var resp DeleteAccountResp
 	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return PresigninResponse{}, errors.Wrap(err, "decoding payload")
+		return DeleteAccountResp{}, errors.Wrap(err, "decoding payload")
 	}
 
 	return resp, nil
 }
 
-// SigninPayload is a payload for /v1/signin
-type SigninPayload struct {
-	Email   string `json:"email"`
-	AuthKey string `json:"auth_key"`
+// CancelAccountDeletion cancels the scheduled deletion of the account
+func CancelAccountDeletion(ctx infra.DnoteCtx) error {
+	hc := http.Client{}
+	res, err := doAuthorizedReq(ctx, hc, "DELETE", "/v1/account/deletion", "")
+	if err != nil {
+		return errors.Wrap(err, "making http request")
+	}
+
+	hasErr, message, err := checkRespErr(res)
+	if err != nil {
+		return errors.Wrap(err, "checking repsonse error")
+	}
+	if hasErr {
+		return errors.New(message)
+	}
+
+	return nil
 }
 
-// SigninResponse is a response from /v1/signin endpoint
-type SigninResponse struct {
-	Key          string `json:"key"`
-	ExpiresAt    int64  `json:"expires_at"`
-	CipherKeyEnc string `json:"cipher_key_enc"`
+// ErrSessionExpired is an error for a session that has expired and cannot be refreshed
+var ErrSessionExpired = errors.New("session expired. please run `dnote login`")
+
+// RefreshSessionPayload is a payload for refreshing a session
+type RefreshSessionPayload struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
-// Signin requests a session token
-func Signin(ctx infra.DnoteCtx, email, authKey string) (SigninResponse, error) {
-	payload := SigninPayload{
-		Email:   email,
-		AuthKey: authKey,
+// RefreshSession renews an expired session using the refresh token. The response contains
+// a new session key and a new refresh token, and the given refresh token can no longer be used.
+func RefreshSession(ctx infra.DnoteCtx, refreshToken string) (SigninResponse, error) {
+	payload := RefreshSessionPayload{
+		RefreshToken: refreshToken,
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
 		return SigninResponse{}, errors.Wrap(err, "marshaling payload")
 	}
-	res, err := utils.DoReq(ctx, "POST", "/v1/signin", string(b))
+	res, err := utils.DoReq(ctx, "POST", "/v1/sessions/refresh", string(b))
 	if err != nil {
 		return SigninResponse{}, errors.Wrap(err, "making http request")
 	}
 
 	if res.StatusCode == http.StatusUnauthorized {
-		return SigninResponse{}, ErrInvalidLogin
+		return SigninResponse{}, ErrSessionExpired
 	}
 
 	hasErr, message, err := checkRespErr(res)
@@ -546,27 +767,119 @@ func Signin(ctx infra.DnoteCtx, email, authKey string) (SigninResponse, error) {
 	return resp, nil
 }
 
-// Signout deletes a user session on the server side
-func Signout(ctx infra.DnoteCtx, sessionKey string) error {
-	hc := http.Client{
-		// No need to follow redirect
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+// getSessionKey returns the session key in the local storage, which is more recent than
+// the one in the context if the session was renewed after the context had been set up
+func getSessionKey(ctx infra.DnoteCtx) (string, error) {
+	var sessionKey string
+	err := core.GetSystem(ctx.DB, infra.SystemSessionKey, &sessionKey)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return ctx.SessionKey, nil
+	} else if err != nil {
+		return "", errors.Wrap(err, "getting session key")
 	}
 
-	res, err := utils.DoAuthorizedReq(ctx, hc, "POST", "/v1/signout", "")
+	return sessionKey, nil
+}
+
+// renewSession refreshes the session using the refresh token in the local storage and
+// saves the new credentials. It returns the new session key.
+func renewSession(ctx infra.DnoteCtx) (string, error) {
+	db := ctx.DB
+
+	var refreshToken string
+	err := core.GetSystem(db, infra.SystemRefreshToken, &refreshToken)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return "", ErrSessionExpired
+	} else if err != nil {
+		return "", errors.Wrap(err, "getting refresh token")
+	}
+
+	resp, err := RefreshSession(ctx, refreshToken)
 	if err != nil {
-		return errors.Wrap(err, "making http request")
+		return "", errors.Wrap(err, "refreshing session")
 	}
 
-	hasErr, message, err := checkRespErr(res)
+	tx, err := db.Begin()
 	if err != nil {
-		return errors.Wrap(err, "checking repsonse error")
+		return "", errors.Wrap(err, "beginning a transaction")
 	}
-	if hasErr {
-		return errors.New(message)
+
+	if err := core.UpsertSystem(tx, infra.SystemSessionKey, resp.Key); err != nil {
+		tx.Rollback()
+		return "", errors.Wrap(err, "saving session key")
+	}
+	if err := core.UpsertSystem(tx, infra.SystemSessionKeyExpiry, strconv.FormatInt(resp.ExpiresAt, 10)); err != nil {
+		tx.Rollback()
+		return "", errors.Wrap(err, "saving session key expiry")
+	}
+	if err := core.UpsertSystem(tx, infra.SystemRefreshToken, resp.RefreshToken); err != nil {
+		tx.Rollback()
+		return "", errors.Wrap(err, "saving refresh token")
 	}
 
-	return nil
+	tx.Commit()
+
+	return resp.Key, nil
+}
+
+func isMutatingMethod(method string) bool {
+	return method == "POST" || method == "PATCH" || method == "PUT" || method == "DELETE"
+}
+
+// doIdempotentReq does an authorized http request and, if the request has an idempotency key,
+// retries once when the request fails before getting a response. Retrying is safe because the
+// server replays the stored response if the first attempt has been processed.
+func doIdempotentReq(ctx infra.DnoteCtx, hc http.Client, method, path, body string, header http.Header) (*http.Response, error) {
+	res, err := utils.DoAuthorizedReq(ctx, hc, method, path, body, header)
+	if _, ok := errors.Cause(err).(*utils.UpgradeRequiredError); ok {
+		return res, err
+	}
+	if err != nil && header.Get("Idempotency-Key") != "" {
+		log.Debug("retrying %s %s: %s\n", method, path, err.Error())
+
+		return utils.DoAuthorizedReq(ctx, hc, method, path, body, header)
+	}
+
+	return res, err
+}
+
+// doAuthorizedReq does an authorized http request using the current session. If the server
+// rejects the session, it renews the session using the refresh token and retries once. Each
+// call is a logical operation, and mutating requests are given an idempotency key which is
+// shared by any retries.
+func doAuthorizedReq(ctx infra.DnoteCtx, hc http.Client, method, path, body string) (*http.Response, error) {
+	return doAuthorizedReqWithHeader(ctx, hc, method, path, body, http.Header{})
+}
+
+// doAuthorizedReqWithHeader is like doAuthorizedReq but sends the given header as well
+func doAuthorizedReqWithHeader(ctx infra.DnoteCtx, hc http.Client, method, path, body string, header http.Header) (*http.Response, error) {
+	sessionKey, err := getSessionKey(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting session key")
+	}
+
+	if isMutatingMethod(method) {
+		header.Set("Idempotency-Key", utils.GenerateUUID())
+	}
+
+	ctx.SessionKey = sessionKey
+	res, err := doIdempotentReq(ctx, hc, method, path, body, header)
+	if err != nil {
+		return res, err
+	}
+
+	// The server rejects an invalid session with the WWW-Authenticate header. Other
+	// unauthorized responses, such as ones for a wrong password, do not have the header.
+	if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") == "" {
+		return res, nil
+	}
+	res.Body.Close()
+
+	sessionKey, err = renewSession(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "renewing session")
+	}
+
+	ctx.SessionKey = sessionKey
+	return doIdempotentReq(ctx, hc, method, path, body, header)
 }
@@ -0,0 +1,44 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package account
+
+import (
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Delete your account after a grace period
+ dnote account delete
+
+ * Cancel the deletion of your account
+ dnote account delete --cancel`
+
+// NewCmd returns a new account command
+func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "account",
+		Short:   "Manage your account",
+		Example: example,
+	}
+
+	cmd.AddCommand(newDeleteCmd(ctx))
+
+	return cmd
+}
@@ -0,0 +1,135 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package account
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/crypt"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var cancel bool
+
+func newDeleteCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete your account and all of your data on the server",
+		Long: `Delete your account and all of your data on the server.
+
+The account is deleted after a grace period, during which you can cancel the deletion
+with 'dnote account delete --cancel'. The notes on this device are not deleted.`,
+		Args: cobra.NoArgs,
+		RunE: newDeleteRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&cancel, "cancel", "", false, "Cancel the scheduled deletion of your account")
+
+	return cmd
+}
+
+// Delete derives the auth key from the given credentials and schedules the deletion of
+// the account. It returns the time at which the account will be deleted.
+func Delete(ctx infra.DnoteCtx, email, password string) (time.Time, error) {
+	presigninResp, err := client.GetPresignin(ctx, email)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "getting presiginin")
+	}
+
+	_, authKey, err := crypt.MakeKeys([]byte(password), []byte(email), presigninResp.Iteration)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "making keys")
+	}
+
+	authKeyB64 := base64.StdEncoding.EncodeToString(authKey)
+	resp, err := client.DeleteAccount(ctx, authKeyB64)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "requesting deletion")
+	}
+
+	return resp.DeleteAt, nil
+}
+
+func runCancel(ctx infra.DnoteCtx) error {
+	if err := client.CancelAccountDeletion(ctx); err != nil {
+		return errors.Wrap(err, "cancelling deletion")
+	}
+
+	log.Success("cancelled the deletion of your account\n")
+
+	return nil
+}
+
+func newDeleteRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if ctx.SessionKey == "" {
+			return errors.New("not logged in")
+		}
+
+		if cancel {
+			return runCancel(ctx)
+		}
+
+		log.Warnf("this deletes your account and all of your notes on the server.\n")
+		ok, err := utils.AskConfirmation("delete your account?", false)
+		if err != nil {
+			return errors.Wrap(err, "getting confirmation")
+		}
+		if !ok {
+			log.Warnf("aborted by user\n")
+			return nil
+		}
+
+		var email, password string
+		if err := utils.PromptInput("email", &email); err != nil {
+			return errors.Wrap(err, "getting email input")
+		}
+		if email == "" {
+			return errors.New("Email is empty")
+		}
+
+		if err := utils.PromptPassword("password", &password); err != nil {
+			return errors.Wrap(err, "getting password input")
+		}
+		if password == "" {
+			return errors.New("Password is empty")
+		}
+
+		deleteAt, err := Delete(ctx, email, password)
+		if errors.Cause(err) == client.ErrInvalidLogin {
+			log.Error("wrong login\n")
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "deleting account")
+		}
+
+		log.Successf("your account will be deleted on %s\n", deleteAt.Local().Format("2006-01-02 15:04"))
+		log.Plain("run 'dnote account delete --cancel' to keep it.\n")
+
+		return nil
+	}
+}
@@ -20,8 +20,10 @@ package add
 
 import (
 	"database/sql"
+	"io/ioutil"
 	"time"
 
+	"github.com/dnote/dnote/cli/cmd/sync"
 	"github.com/dnote/dnote/cli/core"
 	"github.com/dnote/dnote/cli/infra"
 	"github.com/dnote/dnote/cli/log"
@@ -33,18 +35,28 @@ import (
 var reservedBookNames = []string{"trash", "conflicts"}
 
 var content string
+var templateName string
 
 var example = `
  * Open an editor to write content
  dnote add git
 
  * Skip the editor by providing content directly
- dnote add git -c "time is a part of the commit hash"`
+ dnote add git -c "time is a part of the commit hash"
+
+ * Add to the default_book in the config
+ dnote add -c "time is a part of the commit hash"
+
+ * Start from a template
+ dnote add postmortems --template incident`
 
 func preRun(cmd *cobra.Command, args []string) error {
-	if len(args) != 1 {
+	if len(args) > 1 {
 		return errors.New("Incorrect number of argument")
 	}
+	if content != "" && templateName != "" {
+		return errors.New("--template cannot be used with --content")
+	}
 
 	return nil
 }
@@ -52,7 +64,7 @@ func preRun(cmd *cobra.Command, args []string) error {
 // NewCmd returns a new add command
 func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:     "add <book>",
+		Use:     "add <book?>",
 		Short:   "Add a new note",
 		Aliases: []string{"a", "n", "new"},
 		Example: example,
@@ -62,6 +74,7 @@ func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
 
 	f := cmd.Flags()
 	f.StringVarP(&content, "content", "c", "", "The new content for the note")
+	f.StringVarP(&templateName, "template", "t", "", "The template with which the editor starts. Defaults to the template of the book in the config")
 
 	return cmd
 }
@@ -78,7 +91,14 @@ func isReservedName(name string) bool {
 
 func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
-		bookName := args[0]
+		var bookName string
+		if len(args) == 1 {
+			bookName = args[0]
+		} else if ctx.Settings.DefaultBook != "" {
+			bookName = ctx.Settings.DefaultBook
+		} else {
+			return errors.New("no book is given and default_book is not set in the config")
+		}
 
 		if isReservedName(bookName) {
 			return errors.Errorf("book name '%s' is reserved", bookName)
@@ -86,6 +106,11 @@ func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 
 		if content == "" {
 			fpath := core.GetDnoteTmpContentPath(ctx)
+
+			if err := prepareTemplate(ctx, bookName, fpath); err != nil {
+				return errors.Wrap(err, "preparing the template")
+			}
+
 			err := core.GetEditorInput(ctx, fpath, &content)
 			if err != nil {
 				return errors.Wrap(err, "Failed to get editor input")
@@ -105,6 +130,10 @@ func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 		log.Successf("added to %s\n", bookName)
 		log.PrintContent(content)
 
+		if err := sync.AutoSync(ctx); err != nil {
+			log.Error(errors.Wrap(err, "automatically syncing").Error())
+		}
+
 		if err := core.CheckUpdate(ctx); err != nil {
 			log.Error(errors.Wrap(err, "automatically checking updates").Error())
 		}
@@ -113,6 +142,28 @@ func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 	}
 }
 
+// prepareTemplate writes the template for the note to the given path, if the note has one
+func prepareTemplate(ctx infra.DnoteCtx, bookName, fpath string) error {
+	name := templateName
+	if name == "" {
+		name = core.GetBookTemplate(ctx, bookName)
+	}
+	if name == "" {
+		return nil
+	}
+
+	c, err := core.ExpandTemplate(ctx, name, core.NewTemplateData(bookName))
+	if err != nil {
+		return errors.Wrap(err, "expanding the template")
+	}
+
+	if err := ioutil.WriteFile(fpath, []byte(c), 0644); err != nil {
+		return errors.Wrap(err, "writing the template to the tmp content file")
+	}
+
+	return nil
+}
+
 func writeNote(ctx infra.DnoteCtx, bookLabel string, content string, ts int64) error {
 	tx, err := ctx.DB.Begin()
 	if err != nil {
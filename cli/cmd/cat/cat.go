@@ -98,6 +98,12 @@ func NewRun(ctx infra.DnoteCtx) core.RunEFunc {
 			return errors.Wrap(err, "querying the note")
 		}
 
+		stopPager, err := core.StartPager(ctx)
+		if err != nil {
+			return errors.Wrap(err, "starting the pager")
+		}
+		defer stopPager()
+
 		log.Infof("book name: %s\n", info.BookLabel)
 		log.Infof("note uuid: %s\n", info.UUID)
 		log.Infof("created at: %s\n", time.Unix(0, info.AddedOn).Format("Jan 2, 2006 3:04pm (MST)"))
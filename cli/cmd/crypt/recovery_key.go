@@ -0,0 +1,119 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"encoding/base64"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/crypt"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newRecoveryKeyCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recovery-key",
+		Short: "Generate a recovery key to restore your data if you forget the password",
+		Long: `Generate a recovery key to restore your data if you forget the password.
+
+The recovery key is generated on this device and is never sent to the server.
+Generating a new recovery key replaces the existing one.`,
+		RunE: newRecoveryKeyRun(ctx),
+	}
+
+	return cmd
+}
+
+// MakeRecoveryKey generates a recovery key, and registers in the server the cipher key
+// wrapped by it. It returns the encoded recovery key.
+func MakeRecoveryKey(ctx infra.DnoteCtx, email, password string) (string, error) {
+	presigninResp, err := client.GetPresignin(ctx, email)
+	if err != nil {
+		return "", errors.Wrap(err, "getting presiginin")
+	}
+
+	_, authKey, err := crypt.MakeKeys([]byte(password), []byte(email), presigninResp.Iteration)
+	if err != nil {
+		return "", errors.Wrap(err, "making keys")
+	}
+
+	recoveryKey, err := crypt.MakeRecoveryKey()
+	if err != nil {
+		return "", errors.Wrap(err, "making recovery key")
+	}
+	encKey, recoveryAuthKey, err := crypt.MakeRecoveryKeys(recoveryKey)
+	if err != nil {
+		return "", errors.Wrap(err, "making recovery keys")
+	}
+
+	cipherKeyRecoveryEnc, err := crypt.AesGcmEncrypt(encKey, ctx.CipherKey)
+	if err != nil {
+		return "", errors.Wrap(err, "wrapping cipher key")
+	}
+
+	authKeyB64 := base64.StdEncoding.EncodeToString(authKey)
+	recoveryAuthKeyB64 := base64.StdEncoding.EncodeToString(recoveryAuthKey)
+	if err := client.SetRecoveryKey(ctx, authKeyB64, recoveryAuthKeyB64, cipherKeyRecoveryEnc); err != nil {
+		return "", errors.Wrap(err, "registering recovery key")
+	}
+
+	return crypt.EncodeRecoveryKey(recoveryKey), nil
+}
+
+func newRecoveryKeyRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if ctx.SessionKey == "" || ctx.CipherKey == nil {
+			return errors.New("not logged in")
+		}
+
+		var email, password string
+		if err := utils.PromptInput("email", &email); err != nil {
+			return errors.Wrap(err, "getting email input")
+		}
+		if email == "" {
+			return errors.New("Email is empty")
+		}
+
+		if err := utils.PromptPassword("password", &password); err != nil {
+			return errors.Wrap(err, "getting password input")
+		}
+		if password == "" {
+			return errors.New("Password is empty")
+		}
+
+		recoveryKey, err := MakeRecoveryKey(ctx, email, password)
+		if errors.Cause(err) == client.ErrInvalidLogin {
+			log.Error("wrong login\n")
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "making recovery key")
+		}
+
+		log.Success("recovery key generated\n")
+		log.Plainf("\n  %s\n\n", recoveryKey)
+		log.Warnf("store it in a safe place. It is the only way to keep your data if you forget the password, and it cannot be shown again.\n")
+
+		return nil
+	}
+}
@@ -0,0 +1,305 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package doctor
+
+import (
+	"fmt"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+  dnote doctor`
+
+// recoveredBookLabel is the label of the book to which notes without a book are moved
+const recoveredBookLabel = "recovered"
+
+// NewCmd returns a new doctor command
+func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "doctor",
+		Short:   "Check the local data for problems and repair them",
+		Example: example,
+		Args:    cobra.NoArgs,
+		RunE:    newRun(ctx),
+	}
+
+	return cmd
+}
+
+// issue is a problem found in the local data. If it can be repaired safely, repair is
+// not nil and repairMsg describes what it does.
+type issue struct {
+	msg       string
+	repairMsg string
+	repair    func(tx *infra.DB) error
+}
+
+type check struct {
+	name string
+	run  func(ctx infra.DnoteCtx) ([]issue, error)
+}
+
+// checkOrphanNotes finds notes whose books do not exist
+func checkOrphanNotes(ctx infra.DnoteCtx) ([]issue, error) {
+	var count int
+	if err := ctx.DB.QueryRow("SELECT count(*) FROM notes WHERE book_uuid NOT IN (SELECT uuid FROM books)").Scan(&count); err != nil {
+		return nil, errors.Wrap(err, "counting notes without a book")
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	ret := issue{
+		msg:       fmt.Sprintf("%d note(s) belong to a book that does not exist", count),
+		repairMsg: fmt.Sprintf("move them to a new book %q", recoveredBookLabel),
+		repair:    repairOrphanNotes,
+	}
+
+	return []issue{ret}, nil
+}
+
+// repairOrphanNotes moves notes whose books do not exist to a new book
+func repairOrphanNotes(tx *infra.DB) error {
+	label := recoveredBookLabel
+
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", label).Scan(&count); err != nil {
+		return errors.Wrap(err, "checking label")
+	}
+	if count > 0 {
+		l, err := core.ResolveBookLabel(tx, label)
+		if err != nil {
+			return errors.Wrap(err, "resolving label")
+		}
+
+		label = l
+	}
+
+	book := core.NewBook(utils.GenerateUUID(), label, 0, false, true)
+	if err := book.Insert(tx); err != nil {
+		return errors.Wrap(err, "inserting book")
+	}
+
+	if _, err := tx.Exec("UPDATE notes SET book_uuid = ?, dirty = ? WHERE book_uuid NOT IN (SELECT uuid FROM books)", book.UUID, true); err != nil {
+		return errors.Wrap(err, "moving notes")
+	}
+
+	return nil
+}
+
+// checkDuplicateBookLabels finds books that have the same label
+func checkDuplicateBookLabels(ctx infra.DnoteCtx) ([]issue, error) {
+	rows, err := ctx.DB.Query("SELECT label FROM books GROUP BY label HAVING count(*) > 1")
+	if err != nil {
+		return nil, errors.Wrap(err, "finding duplicate labels")
+	}
+	defer rows.Close()
+
+	var ret []issue
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return nil, errors.Wrap(err, "scanning label")
+		}
+
+		ret = append(ret, issue{
+			msg:       fmt.Sprintf("more than one book is labelled %q", label),
+			repairMsg: "rename all but the oldest one",
+			repair: func(tx *infra.DB) error {
+				return repairDuplicateBookLabel(tx, label)
+			},
+		})
+	}
+
+	return ret, nil
+}
+
+// repairDuplicateBookLabel renames all but the oldest book with the given label
+func repairDuplicateBookLabel(tx *infra.DB, label string) error {
+	rows, err := tx.Query("SELECT uuid FROM books WHERE label = ? ORDER BY rowid ASC", label)
+	if err != nil {
+		return errors.Wrap(err, "finding books")
+	}
+
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scanning book")
+		}
+
+		uuids = append(uuids, uuid)
+	}
+	rows.Close()
+
+	for _, uuid := range uuids[1:] {
+		newLabel, err := core.ResolveBookLabel(tx, label)
+		if err != nil {
+			return errors.Wrap(err, "resolving label")
+		}
+
+		if _, err := tx.Exec("UPDATE books SET label = ?, dirty = ? WHERE uuid = ?", newLabel, true, uuid); err != nil {
+			return errors.Wrapf(err, "renaming book %s", uuid)
+		}
+	}
+
+	return nil
+}
+
+// checkSearchIndex checks that the full text search index is consistent with the notes
+func checkSearchIndex(ctx infra.DnoteCtx) ([]issue, error) {
+	if _, err := ctx.DB.Exec("INSERT INTO note_fts(note_fts) VALUES('integrity-check')"); err != nil {
+		log.Debug("search index integrity check failed: %s\n", err.Error())
+
+		ret := issue{
+			msg:       "the search index is inconsistent with the notes",
+			repairMsg: "rebuild the search index",
+			repair:    repairSearchIndex,
+		}
+
+		return []issue{ret}, nil
+	}
+
+	return nil, nil
+}
+
+func repairSearchIndex(tx *infra.DB) error {
+	if _, err := tx.Exec("INSERT INTO note_fts(note_fts) VALUES('rebuild')"); err != nil {
+		return errors.Wrap(err, "rebuilding search index")
+	}
+
+	return nil
+}
+
+// checkLastMaxUSN checks that the last max_usn is not ahead of that of the server
+func checkLastMaxUSN(ctx infra.DnoteCtx) ([]issue, error) {
+	if ctx.SessionKey == "" {
+		log.Debug("skipping the max_usn check because not logged in\n")
+		return nil, nil
+	}
+
+	var lastMaxUSN int
+	if err := core.GetSystem(ctx.DB, infra.SystemLastMaxUSN, &lastMaxUSN); err != nil {
+		return nil, errors.Wrap(err, "getting the last max_usn")
+	}
+
+	syncState, err := client.GetSyncState(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the sync state from the server")
+	}
+
+	if lastMaxUSN <= syncState.MaxUSN {
+		return nil, nil
+	}
+
+	ret := issue{
+		msg:       fmt.Sprintf("the last synced usn %d is ahead of the server's %d", lastMaxUSN, syncState.MaxUSN),
+		repairMsg: "reset the last synced usn. Run 'dnote sync --full' afterwards",
+		repair:    repairLastMaxUSN,
+	}
+
+	return []issue{ret}, nil
+}
+
+func repairLastMaxUSN(tx *infra.DB) error {
+	if err := core.UpdateSystem(tx, infra.SystemLastMaxUSN, 0); err != nil {
+		return errors.Wrap(err, "resetting the last max_usn")
+	}
+
+	return nil
+}
+
+var checks = []check{
+	{name: "notes without a book", run: checkOrphanNotes},
+	{name: "duplicate book labels", run: checkDuplicateBookLabels},
+	{name: "search index", run: checkSearchIndex},
+	{name: "sync state", run: checkLastMaxUSN},
+}
+
+func repair(ctx infra.DnoteCtx, i issue) error {
+	ok, err := utils.AskConfirmation(fmt.Sprintf("%s?", i.repairMsg), false)
+	if err != nil {
+		return errors.Wrap(err, "getting confirmation")
+	}
+	if !ok {
+		return nil
+	}
+
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
+
+	if err := i.repair(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing the repair")
+	}
+
+	log.Success("repaired\n")
+
+	return nil
+}
+
+func newRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		var issueCount int
+
+		for _, c := range checks {
+			issues, err := c.run(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "checking %s", c.name)
+			}
+
+			if len(issues) == 0 {
+				log.Successf("%s: ok\n", c.name)
+				continue
+			}
+
+			for _, i := range issues {
+				issueCount++
+				log.Warnf("%s: %s\n", c.name, i.msg)
+
+				if i.repair == nil {
+					continue
+				}
+				if err := repair(ctx, i); err != nil {
+					return errors.Wrapf(err, "repairing %s", c.name)
+				}
+			}
+		}
+
+		if issueCount == 0 {
+			log.Plain("no problems found\n")
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,223 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package doctor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func mustRepair(t *testing.T, db *infra.DB, i issue) {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	if err := i.repair(tx); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "repairing"))
+	}
+
+	tx.Commit()
+}
+
+func TestCheckOrphanNotes(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	defer testutils.TeardownEnv(ctx)
+
+	db := ctx.DB
+	testutils.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "js", 1, false, false)
+	testutils.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b2-uuid", recoveredBookLabel, 2, false, false)
+	testutils.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, 3, false)
+	testutils.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n2-uuid", "missing-uuid", "n2 body", 1541108743, 4, false)
+
+	// execute
+	issues, err := checkOrphanNotes(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking"))
+	}
+	testutils.AssertEqual(t, len(issues), 1, "issue count mismatch")
+
+	mustRepair(t, db, issues[0])
+
+	// test
+	var n1BookUUID, n2BookUUID, recoveredUUID string
+	var n2Dirty, recoveredDirty bool
+	testutils.MustScan(t, "getting n1", db.QueryRow("SELECT book_uuid FROM notes WHERE uuid = ?", "n1-uuid"), &n1BookUUID)
+	testutils.MustScan(t, "getting n2", db.QueryRow("SELECT book_uuid, dirty FROM notes WHERE uuid = ?", "n2-uuid"), &n2BookUUID, &n2Dirty)
+	testutils.MustScan(t, "getting the recovered book", db.QueryRow("SELECT uuid, dirty FROM books WHERE label = ?", "recovered (2)"), &recoveredUUID, &recoveredDirty)
+
+	testutils.AssertEqual(t, n1BookUUID, "b1-uuid", "n1 book_uuid mismatch")
+	testutils.AssertEqual(t, n2BookUUID, recoveredUUID, "n2 book_uuid mismatch")
+	testutils.AssertEqual(t, n2Dirty, true, "n2 dirty mismatch")
+	testutils.AssertEqual(t, recoveredDirty, true, "recovered book dirty mismatch")
+
+	issues, err = checkOrphanNotes(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking again"))
+	}
+	testutils.AssertEqual(t, len(issues), 0, "issue count mismatch after repair")
+}
+
+func TestCheckDuplicateBookLabels(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	defer testutils.TeardownEnv(ctx)
+
+	db := ctx.DB
+	// databases created before the unique index was introduced can have duplicate labels
+	testutils.MustExec(t, "dropping the label index", db, "DROP INDEX idx_books_label")
+	testutils.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "js", 1, false, false)
+	testutils.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b2-uuid", "js", 2, false, false)
+	testutils.MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b3-uuid", "js", 3, false, false)
+	testutils.MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b4-uuid", "css", 4, false, false)
+
+	// execute
+	issues, err := checkDuplicateBookLabels(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking"))
+	}
+	testutils.AssertEqual(t, len(issues), 1, "issue count mismatch")
+
+	mustRepair(t, db, issues[0])
+
+	// test
+	var b1Label, b2Label, b3Label string
+	var b1Dirty, b2Dirty bool
+	testutils.MustScan(t, "getting b1", db.QueryRow("SELECT label, dirty FROM books WHERE uuid = ?", "b1-uuid"), &b1Label, &b1Dirty)
+	testutils.MustScan(t, "getting b2", db.QueryRow("SELECT label, dirty FROM books WHERE uuid = ?", "b2-uuid"), &b2Label, &b2Dirty)
+	testutils.MustScan(t, "getting b3", db.QueryRow("SELECT label FROM books WHERE uuid = ?", "b3-uuid"), &b3Label)
+
+	testutils.AssertEqual(t, b1Label, "js", "b1 label mismatch")
+	testutils.AssertEqual(t, b1Dirty, false, "b1 dirty mismatch")
+	testutils.AssertEqual(t, b2Label, "js (2)", "b2 label mismatch")
+	testutils.AssertEqual(t, b2Dirty, true, "b2 dirty mismatch")
+	testutils.AssertEqual(t, b3Label, "js (3)", "b3 label mismatch")
+
+	issues, err = checkDuplicateBookLabels(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking again"))
+	}
+	testutils.AssertEqual(t, len(issues), 0, "issue count mismatch after repair")
+}
+
+func TestCheckSearchIndex(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	defer testutils.TeardownEnv(ctx)
+
+	db := ctx.DB
+	testutils.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	testutils.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743)
+
+	issues, err := checkSearchIndex(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking a consistent index"))
+	}
+	testutils.AssertEqual(t, len(issues), 0, "issue count mismatch for a consistent index")
+
+	// index an entry that does not exist in notes
+	testutils.MustExec(t, "corrupting the index", db, "INSERT INTO note_fts (rowid, body) VALUES (?, ?)", 999, "foo")
+
+	// execute
+	issues, err = checkSearchIndex(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking"))
+	}
+	testutils.AssertEqual(t, len(issues), 1, "issue count mismatch")
+
+	mustRepair(t, db, issues[0])
+
+	// test
+	issues, err = checkSearchIndex(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking again"))
+	}
+	testutils.AssertEqual(t, len(issues), 0, "issue count mismatch after repair")
+}
+
+func TestCheckLastMaxUSN(t *testing.T) {
+	testCases := []struct {
+		lastMaxUSN     int
+		serverMaxUSN   int
+		expectedIssues int
+	}{
+		{
+			lastMaxUSN:     5,
+			serverMaxUSN:   5,
+			expectedIssues: 0,
+		},
+		{
+			lastMaxUSN:     3,
+			serverMaxUSN:   5,
+			expectedIssues: 0,
+		},
+		{
+			lastMaxUSN:     8,
+			serverMaxUSN:   5,
+			expectedIssues: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		func() {
+			// set up
+			ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+			testutils.Login(t, &ctx)
+			defer testutils.TeardownEnv(ctx)
+
+			db := ctx.DB
+			testutils.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", infra.SystemLastMaxUSN, tc.lastMaxUSN)
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewEncoder(w).Encode(client.GetSyncStateResp{MaxUSN: tc.serverMaxUSN}); err != nil {
+					t.Fatal(errors.Wrap(err, "encoding response"))
+				}
+			}))
+			defer ts.Close()
+
+			ctx.APIEndpoint = ts.URL
+
+			// execute
+			issues, err := checkLastMaxUSN(ctx)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "checking"))
+			}
+
+			// test
+			testutils.AssertEqual(t, len(issues), tc.expectedIssues, "issue count mismatch")
+
+			if len(issues) > 0 {
+				mustRepair(t, db, issues[0])
+
+				var lastMaxUSN int
+				testutils.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", infra.SystemLastMaxUSN), &lastMaxUSN)
+				testutils.AssertEqual(t, lastMaxUSN, 0, "last max usn mismatch after repair")
+			}
+		}()
+	}
+}
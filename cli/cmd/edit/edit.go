@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"time"
 
+	"github.com/dnote/dnote/cli/cmd/sync"
 	"github.com/dnote/dnote/cli/core"
 	"github.com/dnote/dnote/cli/infra"
 	"github.com/dnote/dnote/cli/log"
@@ -125,6 +126,10 @@ func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 		fmt.Printf("%s", newContent)
 		fmt.Printf("\n-------------------------------------------------------\n")
 
+		if err := sync.AutoSync(ctx); err != nil {
+			log.Error(errors.Wrap(err, "automatically syncing").Error())
+		}
+
 		return nil
 	}
 }
@@ -186,6 +186,12 @@ func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 			infos = append(infos, info)
 		}
 
+		stopPager, err := core.StartPager(ctx)
+		if err != nil {
+			return errors.Wrap(err, "starting the pager")
+		}
+		defer stopPager()
+
 		for _, info := range infos {
 			bookLabel := log.ColorYellow.Sprintf("(%s)", info.BookLabel)
 			rowid := log.ColorYellow.Sprintf("(%d)", info.RowID)
@@ -65,6 +65,18 @@ func Do(ctx infra.DnoteCtx, email, password string) error {
 		return errors.Wrap(err, "requesting session")
 	}
 
+	if signinResp.TOTPRequired {
+		code, err := promptTOTPCode()
+		if err != nil {
+			return errors.Wrap(err, "getting two-factor authentication code")
+		}
+
+		signinResp, err = client.SigninTOTP(ctx, signinResp.Challenge, code)
+		if err != nil {
+			return errors.Wrap(err, "requesting session with two-factor authentication")
+		}
+	}
+
 	cipherKeyDec, err := crypt.AesGcmDecrypt(masterKey, signinResp.CipherKeyEnc)
 	if err != nil {
 		return errors.Wrap(err, "decrypting cipher key")
@@ -87,12 +99,28 @@ func Do(ctx infra.DnoteCtx, email, password string) error {
 	if err := core.UpsertSystem(tx, infra.SystemSessionKeyExpiry, strconv.FormatInt(signinResp.ExpiresAt, 10)); err != nil {
 		return errors.Wrap(err, "saving session key")
 	}
+	if err := core.UpsertSystem(tx, infra.SystemRefreshToken, signinResp.RefreshToken); err != nil {
+		return errors.Wrap(err, "saving refresh token")
+	}
 
 	tx.Commit()
 
 	return nil
 }
 
+// promptTOTPCode prompts for a code from the authenticator app or a backup code
+func promptTOTPCode() (string, error) {
+	var code string
+	if err := utils.PromptInput("two-factor authentication code (or a backup code)", &code); err != nil {
+		return "", errors.Wrap(err, "getting code input")
+	}
+	if code == "" {
+		return "", errors.New("Code is empty")
+	}
+
+	return code, nil
+}
+
 func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
 		var email, password string
@@ -114,6 +142,9 @@ func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 		if errors.Cause(err) == client.ErrInvalidLogin {
 			log.Error("wrong login\n")
 			return nil
+		} else if errors.Cause(err) == client.ErrInvalidTOTPCode {
+			log.Error("invalid two-factor authentication code\n")
+			return nil
 		} else if err != nil {
 			return errors.Wrap(err, "logging in")
 		}
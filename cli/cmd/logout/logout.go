@@ -77,6 +77,9 @@ func Do(ctx infra.DnoteCtx) error {
 	if err := core.DeleteSystem(tx, infra.SystemSessionKeyExpiry); err != nil {
 		return errors.Wrap(err, "deleting session key expiry")
 	}
+	if err := core.DeleteSystem(tx, infra.SystemRefreshToken); err != nil {
+		return errors.Wrap(err, "deleting refresh token")
+	}
 
 	tx.Commit()
 
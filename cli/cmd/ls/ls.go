@@ -69,6 +69,12 @@ func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
 // NewRun returns a new run function for ls
 func NewRun(ctx infra.DnoteCtx) core.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
+		stopPager, err := core.StartPager(ctx)
+		if err != nil {
+			return errors.Wrap(err, "starting the pager")
+		}
+		defer stopPager()
+
 		if len(args) == 0 {
 			if err := printBooks(ctx); err != nil {
 				return errors.Wrap(err, "viewing books")
@@ -22,6 +22,7 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/dnote/dnote/cli/cmd/sync"
 	"github.com/dnote/dnote/cli/core"
 	"github.com/dnote/dnote/cli/infra"
 	"github.com/dnote/dnote/cli/log"
@@ -61,19 +62,21 @@ func newRun(ctx infra.DnoteCtx) core.RunEFunc {
 			if err := removeBook(ctx, targetBookName); err != nil {
 				return errors.Wrap(err, "removing the book")
 			}
+		} else {
+			if len(args) < 2 {
+				return errors.New("Missing argument")
+			}
 
-			return nil
-		}
+			targetBook := args[0]
+			noteRowID := args[1]
 
-		if len(args) < 2 {
-			return errors.New("Missing argument")
+			if err := removeNote(ctx, noteRowID, targetBook); err != nil {
+				return errors.Wrap(err, "removing the note")
+			}
 		}
 
-		targetBook := args[0]
-		noteRowID := args[1]
-
-		if err := removeNote(ctx, noteRowID, targetBook); err != nil {
-			return errors.Wrap(err, "removing the note")
+		if err := sync.AutoSync(ctx); err != nil {
+			log.Error(errors.Wrap(err, "automatically syncing").Error())
 		}
 
 		return nil
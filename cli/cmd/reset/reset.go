@@ -0,0 +1,300 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package reset
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/crypt"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// iteration is the number of PBKDF2 iterations used for deriving the new master key
+var iteration = 100000
+
+// ErrInvalidRecoveryKey is an error for a recovery key that cannot unwrap the cipher key
+var ErrInvalidRecoveryKey = errors.New("wrong recovery key")
+
+var token string
+
+var example = `
+ * Request a password reset email and complete the reset
+ dnote reset-password
+
+ * Complete the reset using the token from the email
+ dnote reset-password --token xxxx`
+
+// NewCmd returns a new reset-password command
+func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "reset-password",
+		Short:   "Reset the password of your account",
+		Example: example,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&token, "token", "t", "", "The password reset token from the email")
+
+	return cmd
+}
+
+// markUnsynced marks all local books and notes as never having been uploaded, so that
+// the next sync re-uploads them after the server wipes the data encrypted with the old key.
+func markUnsynced(tx *infra.DB) error {
+	if _, err := tx.Exec("DELETE FROM notes WHERE deleted"); err != nil {
+		return errors.Wrap(err, "expunging deleted notes")
+	}
+	if _, err := tx.Exec("DELETE FROM books WHERE deleted"); err != nil {
+		return errors.Wrap(err, "expunging deleted books")
+	}
+	if _, err := tx.Exec("UPDATE notes SET usn = 0, dirty = true"); err != nil {
+		return errors.Wrap(err, "marking notes dirty")
+	}
+	if _, err := tx.Exec("UPDATE books SET usn = 0, dirty = true"); err != nil {
+		return errors.Wrap(err, "marking books dirty")
+	}
+
+	if err := core.UpdateSystem(tx, infra.SystemLastMaxUSN, 0); err != nil {
+		return errors.Wrapf(err, "updating %s", infra.SystemLastMaxUSN)
+	}
+	if err := core.UpdateSystem(tx, infra.SystemLastSyncAt, 0); err != nil {
+		return errors.Wrapf(err, "updating %s", infra.SystemLastSyncAt)
+	}
+
+	return nil
+}
+
+// recoverCipherKey unwraps the cipher key using the recovery key. It returns the cipher key
+// and the auth key derived from the recovery key.
+func recoverCipherKey(recoveryKey []byte, cipherKeyRecoveryEnc string) ([]byte, string, error) {
+	encKey, recoveryAuthKey, err := crypt.MakeRecoveryKeys(recoveryKey)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "making recovery keys")
+	}
+
+	cipherKey, err := crypt.AesGcmDecrypt(encKey, cipherKeyRecoveryEnc)
+	if err != nil {
+		return nil, "", ErrInvalidRecoveryKey
+	}
+
+	return cipherKey, base64.StdEncoding.EncodeToString(recoveryAuthKey), nil
+}
+
+// Do derives new credentials on the client side, resets the password using the given token,
+// and saves the new session. If a recovery key is given, the cipher key is recovered and
+// wrapped with the new password. Otherwise a new cipher key is generated and, because the
+// server cannot recover the data encrypted with the old key, the local data is marked to be
+// uploaded again upon the next sync.
+func Do(ctx infra.DnoteCtx, token, email, password string, recoveryKey []byte, cipherKeyRecoveryEnc, code string) error {
+	masterKey, authKey, err := crypt.MakeKeys([]byte(password), []byte(email), iteration)
+	if err != nil {
+		return errors.Wrap(err, "making keys")
+	}
+
+	var cipherKey []byte
+	var recoveryAuthKey string
+	if recoveryKey != nil {
+		cipherKey, recoveryAuthKey, err = recoverCipherKey(recoveryKey, cipherKeyRecoveryEnc)
+		if err != nil {
+			return errors.Wrap(err, "recovering cipher key")
+		}
+	} else {
+		cipherKey, err = crypt.MakeCipherKey()
+		if err != nil {
+			return errors.Wrap(err, "making cipher key")
+		}
+	}
+
+	cipherKeyEnc, err := crypt.AesGcmEncrypt(masterKey, cipherKey)
+	if err != nil {
+		return errors.Wrap(err, "encrypting cipher key")
+	}
+
+	authKeyB64 := base64.StdEncoding.EncodeToString(authKey)
+	resp, err := client.ResetPassword(ctx, token, authKeyB64, cipherKeyEnc, iteration, recoveryAuthKey, code)
+	if err != nil {
+		return errors.Wrap(err, "requesting password reset")
+	}
+
+	cipherKeyB64 := base64.StdEncoding.EncodeToString(cipherKey)
+
+	db := ctx.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
+
+	if recoveryKey == nil {
+		if err := markUnsynced(tx); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "marking local data unsynced")
+		}
+	}
+	if err := core.UpsertSystem(tx, infra.SystemCipherKey, cipherKeyB64); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving enc key")
+	}
+	if err := core.UpsertSystem(tx, infra.SystemSessionKey, resp.Key); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving session key")
+	}
+	if err := core.UpsertSystem(tx, infra.SystemSessionKeyExpiry, strconv.FormatInt(resp.ExpiresAt, 10)); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving session key")
+	}
+	if err := core.UpsertSystem(tx, infra.SystemRefreshToken, resp.RefreshToken); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving refresh token")
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+func promptNewPassword() (string, error) {
+	var password, passwordConfirm string
+	if err := utils.PromptPassword("new password", &password); err != nil {
+		return "", errors.Wrap(err, "getting password input")
+	}
+	if password == "" {
+		return "", errors.New("Password is empty")
+	}
+
+	if err := utils.PromptPassword("confirm new password", &passwordConfirm); err != nil {
+		return "", errors.Wrap(err, "getting password confirmation input")
+	}
+	if password != passwordConfirm {
+		return "", errors.New("Passwords do not match")
+	}
+
+	return password, nil
+}
+
+// promptRecoveryKey asks for the recovery key if the account has one. It returns nil
+// if the account does not have a recovery key or if the user chooses not to use it.
+func promptRecoveryKey(cipherKeyRecoveryEnc string) ([]byte, error) {
+	if cipherKeyRecoveryEnc == "" {
+		return nil, nil
+	}
+
+	ok, err := utils.AskConfirmation("your account has a recovery key. use it to keep your data?", true)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting confirmation")
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	var input string
+	if err := utils.PromptInput("recovery key", &input); err != nil {
+		return nil, errors.Wrap(err, "getting recovery key input")
+	}
+
+	recoveryKey, err := crypt.DecodeRecoveryKey(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding recovery key")
+	}
+
+	return recoveryKey, nil
+}
+
+func newRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if token == "" {
+			var email string
+			if err := utils.PromptInput("email", &email); err != nil {
+				return errors.Wrap(err, "getting email input")
+			}
+			if email == "" {
+				return errors.New("Email is empty")
+			}
+
+			if err := client.CreateResetToken(ctx, email); err != nil {
+				return errors.Wrap(err, "requesting password reset email")
+			}
+
+			log.Infof("if the account exists, a password reset email has been sent to %s\n", email)
+
+			if err := utils.PromptInput("token from the email", &token); err != nil {
+				return errors.Wrap(err, "getting token input")
+			}
+			if token == "" {
+				return errors.New("Token is empty")
+			}
+		}
+
+		tokenResp, err := client.GetResetToken(ctx, token)
+		if err != nil {
+			return errors.Wrap(err, "checking the token")
+		}
+
+		recoveryKey, err := promptRecoveryKey(tokenResp.CipherKeyRecoveryEnc)
+		if err != nil {
+			return err
+		}
+
+		if recoveryKey == nil {
+			log.Warnf("the server cannot decrypt your data without the old password or a recovery key. Encrypted data on the server will be removed and re-uploaded from this device on the next sync.\n")
+			ok, err := utils.AskConfirmation("continue?", false)
+			if err != nil {
+				return errors.Wrap(err, "getting confirmation")
+			}
+			if !ok {
+				return nil
+			}
+		}
+
+		password, err := promptNewPassword()
+		if err != nil {
+			return err
+		}
+
+		var code string
+		if tokenResp.TOTPRequired {
+			if err := utils.PromptInput("two-factor authentication code (or a backup code)", &code); err != nil {
+				return errors.Wrap(err, "getting code input")
+			}
+		}
+
+		err = Do(ctx, token, tokenResp.Email, password, recoveryKey, tokenResp.CipherKeyRecoveryEnc, code)
+		if errors.Cause(err) == ErrInvalidRecoveryKey {
+			log.Error("wrong recovery key\n")
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "resetting password")
+		}
+
+		if recoveryKey == nil {
+			log.Success("password reset. Run `dnote sync` to re-upload your notes\n")
+		} else {
+			log.Success("password reset\n")
+		}
+
+		return nil
+	}
+}
@@ -19,6 +19,9 @@
 package root
 
 import (
+	"os"
+	"strings"
+
 	"github.com/dnote/dnote/cli/core"
 	"github.com/dnote/dnote/cli/infra"
 	"github.com/dnote/dnote/cli/migrate"
@@ -33,6 +36,34 @@ var root = &cobra.Command{
 	SilenceUsage:  true,
 }
 
+// profile is registered as a flag so that it is accepted and documented, but its value is
+// read by GetProfile before the commands are set up because the context depends on it
+var profile string
+
+func init() {
+	root.PersistentFlags().StringVarP(&profile, "profile", "", "", "the profile to use. Defaults to DNOTE_PROFILE or the profile in the config")
+}
+
+// GetProfile returns the profile given by the --profile flag in the arguments. If the flag is
+// not given, it returns the DNOTE_PROFILE environment variable.
+func GetProfile(args []string) string {
+	for i, arg := range args {
+		// the rest are positional arguments
+		if arg == "--" {
+			break
+		}
+
+		if arg == "--profile" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--profile=") {
+			return strings.TrimPrefix(arg, "--profile=")
+		}
+	}
+
+	return os.Getenv("DNOTE_PROFILE")
+}
+
 // Register adds a new command
 func Register(cmd *cobra.Command) {
 	root.AddCommand(cmd)
@@ -56,8 +87,11 @@ func Prepare(ctx infra.DnoteCtx) error {
 		return errors.Wrap(err, "initializing system data")
 	}
 
-	if err := migrate.Legacy(ctx); err != nil {
-		return errors.Wrap(err, "running legacy migration")
+	// the legacy data belongs to the default profile
+	if ctx.Profile == infra.DefaultProfile {
+		if err := migrate.Legacy(ctx); err != nil {
+			return errors.Wrap(err, "running legacy migration")
+		}
 	}
 	if err := migrate.Run(ctx, migrate.LocalSequence, migrate.LocalMode); err != nil {
 		return errors.Wrap(err, "running migration")
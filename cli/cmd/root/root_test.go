@@ -0,0 +1,45 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package root
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dnote/dnote/cli/testutils"
+)
+
+func TestGetProfile(t *testing.T) {
+	os.Setenv("DNOTE_PROFILE", "env")
+	defer os.Unsetenv("DNOTE_PROFILE")
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"ls"}, "env"},
+		{[]string{"--profile", "work", "ls"}, "work"},
+		{[]string{"ls", "--profile=work"}, "work"},
+		{[]string{"add", "js", "--", "--profile", "work"}, "env"},
+	}
+
+	for _, tc := range testCases {
+		testutils.AssertEqual(t, GetProfile(tc.args), tc.expected, "profile mismatch")
+	}
+}
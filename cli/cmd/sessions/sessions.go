@@ -0,0 +1,124 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * List the devices you are logged in from
+ dnote sessions
+
+ * Log out a device using the id shown in the list
+ dnote sessions revoke 12`
+
+// NewCmd returns a new sessions command
+func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "sessions",
+		Short:   "List the devices you are logged in from",
+		Example: example,
+		Args:    cobra.NoArgs,
+		RunE:    newRun(ctx),
+	}
+
+	cmd.AddCommand(newRevokeCmd(ctx))
+
+	return cmd
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func printSession(s client.RespSession) {
+	var current string
+	if s.Current {
+		current = log.ColorGreen.Sprint(" (current)")
+	}
+
+	log.Plainf("%s %s%s\n", log.ColorYellow.Sprintf("(%d)", s.ID), s.ClientType, current)
+	if s.UserAgent != "" {
+		log.Plainf("    agent: %s\n", s.UserAgent)
+	}
+	if s.IP != "" {
+		log.Plainf("    ip: %s\n", s.IP)
+	}
+	log.Plainf("    created: %s, last used: %s\n", formatTime(s.CreatedAt), formatTime(s.LastUsedAt))
+}
+
+func newRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if ctx.SessionKey == "" {
+			return errors.New("not logged in")
+		}
+
+		sessions, err := client.GetSessions(ctx)
+		if err != nil {
+			return errors.Wrap(err, "getting sessions")
+		}
+
+		for _, s := range sessions {
+			printSession(s)
+		}
+
+		return nil
+	}
+}
+
+func newRevokeCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Log out a device",
+		Args:  cobra.ExactArgs(1),
+		RunE:  newRevokeRun(ctx),
+	}
+
+	return cmd
+}
+
+func newRevokeRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if ctx.SessionKey == "" {
+			return errors.New("not logged in")
+		}
+
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errors.Errorf("invalid session id '%s'", args[0])
+		}
+
+		if err := client.DeleteSession(ctx, id); err != nil {
+			return errors.Wrap(err, "revoking session")
+		}
+
+		log.Successf("revoked session %d\n", id)
+
+		return nil
+	}
+}
@@ -0,0 +1,313 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/pkg/errors"
+)
+
+// syncPlan is a list of the changes that a sync would make
+type syncPlan struct {
+	Receive []string
+	Send    []string
+}
+
+func checkExists(tx *infra.DB, table, uuid string) (bool, error) {
+	var count int
+	if err := tx.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s WHERE uuid = ?", table), uuid).Scan(&count); err != nil {
+		return false, errors.Wrapf(err, "counting %s", table)
+	}
+
+	return count > 0, nil
+}
+
+// getBookLabel returns the label of the book with the given uuid, looking it up in the sync
+// list first and then in the local database
+func getBookLabel(tx *infra.DB, list *syncList, uuid string) (string, error) {
+	if list != nil {
+		if b, ok := list.Books[uuid]; ok {
+			return b.Label, nil
+		}
+	}
+
+	var label string
+	err := tx.QueryRow("SELECT label FROM books WHERE uuid = ?", uuid).Scan(&label)
+	if err == sql.ErrNoRows {
+		return uuid, nil
+	} else if err != nil {
+		return "", errors.Wrap(err, "finding book")
+	}
+
+	return label, nil
+}
+
+// planReceive describes the changes that merging the given sync list would make locally
+func planReceive(tx *infra.DB, list *syncList, full bool) ([]string, error) {
+	ret := []string{}
+
+	var books []client.SyncFragBook
+	for _, b := range list.Books {
+		books = append(books, b)
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].USN < books[j].USN })
+
+	for _, b := range books {
+		ok, err := checkExists(tx, "books", b.UUID)
+		if err != nil {
+			return ret, err
+		}
+
+		if ok {
+			ret = append(ret, fmt.Sprintf("update book %q", b.Label))
+		} else {
+			ret = append(ret, fmt.Sprintf("add book %q", b.Label))
+		}
+	}
+
+	var notes []client.SyncFragNote
+	for _, n := range list.Notes {
+		notes = append(notes, n)
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].USN < notes[j].USN })
+
+	for _, n := range notes {
+		ok, err := checkExists(tx, "notes", n.UUID)
+		if err != nil {
+			return ret, err
+		}
+		label, err := getBookLabel(tx, list, n.BookUUID)
+		if err != nil {
+			return ret, err
+		}
+
+		if ok {
+			ret = append(ret, fmt.Sprintf("update note %s in book %q", n.UUID, label))
+		} else {
+			ret = append(ret, fmt.Sprintf("add note %s in book %q", n.UUID, label))
+		}
+	}
+
+	for uuid := range list.ExpungedNotes {
+		ok, err := checkExists(tx, "notes", uuid)
+		if err != nil {
+			return ret, err
+		}
+
+		if ok {
+			ret = append(ret, fmt.Sprintf("remove note %s", uuid))
+		}
+	}
+	for uuid := range list.ExpungedBooks {
+		ok, err := checkExists(tx, "books", uuid)
+		if err != nil {
+			return ret, err
+		}
+
+		if ok {
+			label, err := getBookLabel(tx, nil, uuid)
+			if err != nil {
+				return ret, err
+			}
+
+			ret = append(ret, fmt.Sprintf("remove book %q", label))
+		}
+	}
+
+	if full {
+		// mirror cleanLocalNotes and cleanLocalBooks
+		rows, err := tx.Query("SELECT uuid FROM notes WHERE NOT dirty OR usn != 0")
+		if err != nil {
+			return ret, errors.Wrap(err, "getting local notes")
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var uuid string
+			if err := rows.Scan(&uuid); err != nil {
+				return ret, errors.Wrap(err, "scanning a row for local note")
+			}
+
+			if !checkNoteInList(uuid, list) {
+				ret = append(ret, fmt.Sprintf("remove note %s (not on the server)", uuid))
+			}
+		}
+
+		bookRows, err := tx.Query("SELECT uuid, label FROM books WHERE NOT dirty OR usn != 0")
+		if err != nil {
+			return ret, errors.Wrap(err, "getting local books")
+		}
+		defer bookRows.Close()
+
+		for bookRows.Next() {
+			var uuid, label string
+			if err := bookRows.Scan(&uuid, &label); err != nil {
+				return ret, errors.Wrap(err, "scanning a row for local book")
+			}
+
+			if !checkBookInList(uuid, list) {
+				ret = append(ret, fmt.Sprintf("remove book %q (not on the server)", label))
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// planSend describes the changes that sending the dirty books and notes would make
+func planSend(tx *infra.DB) ([]string, error) {
+	ret := []string{}
+
+	rows, err := tx.Query("SELECT label, usn, deleted FROM books WHERE dirty ORDER BY label")
+	if err != nil {
+		return ret, errors.Wrap(err, "getting syncable books")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var label string
+		var usn int
+		var deleted bool
+		if err := rows.Scan(&label, &usn, &deleted); err != nil {
+			return ret, errors.Wrap(err, "scanning a syncable book")
+		}
+
+		ret = append(ret, describeSend("book", fmt.Sprintf("%q", label), usn, deleted))
+	}
+
+	noteRows, err := tx.Query("SELECT uuid, book_uuid, usn, deleted FROM notes WHERE dirty ORDER BY added_on")
+	if err != nil {
+		return ret, errors.Wrap(err, "getting syncable notes")
+	}
+	defer noteRows.Close()
+
+	var notes []core.Note
+	for noteRows.Next() {
+		var note core.Note
+		if err := noteRows.Scan(&note.UUID, &note.BookUUID, &note.USN, &note.Deleted); err != nil {
+			return ret, errors.Wrap(err, "scanning a syncable note")
+		}
+
+		notes = append(notes, note)
+	}
+	noteRows.Close()
+
+	for _, note := range notes {
+		label, err := getBookLabel(tx, nil, note.BookUUID)
+		if err != nil {
+			return ret, err
+		}
+
+		desc := fmt.Sprintf("%s in book %q", note.UUID, label)
+		ret = append(ret, describeSend("note", desc, note.USN, note.Deleted))
+	}
+
+	return ret, nil
+}
+
+func describeSend(kind, desc string, usn int, deleted bool) string {
+	if usn == 0 && deleted {
+		return fmt.Sprintf("discard %s %s (added and removed locally)", kind, desc)
+	} else if deleted {
+		return fmt.Sprintf("delete %s %s", kind, desc)
+	} else if usn == 0 {
+		return fmt.Sprintf("create %s %s", kind, desc)
+	}
+
+	return fmt.Sprintf("update %s %s", kind, desc)
+}
+
+func printPlan(p syncPlan) {
+	log.Infof("would receive %d change(s) from the server\n", len(p.Receive))
+	for _, item := range p.Receive {
+		log.Plainf("    %s\n", item)
+	}
+
+	log.Infof("would send %d change(s) to the server\n", len(p.Send))
+	for _, item := range p.Send {
+		log.Plainf("    %s\n", item)
+	}
+}
+
+// dryRun computes the changes that a sync would make and prints them. It does not change
+// anything locally or on the server.
+func dryRun(ctx infra.DnoteCtx) error {
+	// The calls to the server can renew the session, which writes to the database. So they
+	// are made before the transaction that reads the local changes is begun.
+	syncState, err := client.GetSyncState(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting the sync state from the server")
+	}
+	lastSyncAt, err := getLastSyncAt(ctx.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting the last sync time")
+	}
+	lastMaxUSN, err := getLastMaxUSN(ctx.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting the last max_usn")
+	}
+
+	full := isFullSync || lastSyncAt < syncState.FullSyncBefore
+	receive := full || lastMaxUSN != syncState.MaxUSN
+
+	var list syncList
+	if receive {
+		afterUSN := lastMaxUSN
+		if full {
+			afterUSN = 0
+		}
+
+		list, err = getSyncList(ctx, afterUSN)
+		if err != nil {
+			return errors.Wrap(err, "getting sync list")
+		}
+	}
+
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
+	// nothing should be changed
+	defer tx.Rollback()
+
+	var p syncPlan
+
+	if receive {
+		p.Receive, err = planReceive(tx, &list, full)
+		if err != nil {
+			return errors.Wrap(err, "planning changes from the server")
+		}
+	}
+
+	p.Send, err = planSend(tx)
+	if err != nil {
+		return errors.Wrap(err, "planning changes to the server")
+	}
+
+	printPlan(p)
+
+	return nil
+}
@@ -0,0 +1,152 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/cli/client"
+	"github.com/dnote/dnote/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestPlanSend(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	defer testutils.TeardownEnv(ctx)
+
+	db := ctx.DB
+	testutils.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
+	testutils.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b2-uuid", "b2-label", 0, false, true)
+	testutils.MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b3-uuid", "b3-label", 0, true, true)
+	testutils.MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b4-uuid", "b4-label", 4, true, true)
+	testutils.MustExec(t, "inserting b5", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b5-uuid", "b5-label", 5, false, true)
+	testutils.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 1, "n1 body", 1541108743, false, false)
+	testutils.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", 0, "n2 body", 1541108744, false, true)
+	testutils.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "b2-uuid", 3, "n3 body", 1541108745, true, true)
+	testutils.MustExec(t, "inserting n4", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n4-uuid", "b5-uuid", 4, "n4 body", 1541108746, false, true)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	defer tx.Rollback()
+
+	// execute
+	got, err := planSend(tx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	testutils.AssertDeepEqual(t, got, []string{
+		`create book "b2-label"`,
+		`discard book "b3-label" (added and removed locally)`,
+		`delete book "b4-label"`,
+		`update book "b5-label"`,
+		`create note n2-uuid in book "b1-label"`,
+		`delete note n3-uuid in book "b2-label"`,
+		`update note n4-uuid in book "b5-label"`,
+	}, "plan mismatch")
+}
+
+func TestPlanReceive(t *testing.T) {
+	testCases := []struct {
+		full     bool
+		expected []string
+	}{
+		{
+			full: false,
+			expected: []string{
+				`update book "b1-label-edited"`,
+				`add book "b9-label"`,
+				`update note n1-uuid in book "b1-label-edited"`,
+				`add note n9-uuid in book "b9-label"`,
+				`remove note n2-uuid`,
+				`remove book "b2-label"`,
+			},
+		},
+		{
+			full: true,
+			expected: []string{
+				`update book "b1-label-edited"`,
+				`add book "b9-label"`,
+				`update note n1-uuid in book "b1-label-edited"`,
+				`add note n9-uuid in book "b9-label"`,
+				`remove note n2-uuid`,
+				`remove book "b2-label"`,
+				`remove note n3-uuid (not on the server)`,
+				`remove book "b3-label" (not on the server)`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		func() {
+			// set up
+			ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+			defer testutils.TeardownEnv(ctx)
+
+			db := ctx.DB
+			testutils.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
+			testutils.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b2-uuid", "b2-label", 2, false, false)
+			testutils.MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b3-uuid", "b3-label", 3, false, false)
+			// added locally and not yet synced. A full sync should keep it.
+			testutils.MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b4-uuid", "b4-label", 0, false, true)
+			testutils.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 1, "n1 body", 1541108743, false, false)
+			testutils.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b2-uuid", 2, "n2 body", 1541108744, false, false)
+			testutils.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "b3-uuid", 3, "n3 body", 1541108745, false, false)
+			testutils.MustExec(t, "inserting n4", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n4-uuid", "b4-uuid", 0, "n4 body", 1541108746, false, true)
+
+			list := syncList{
+				Notes: map[string]client.SyncFragNote{
+					"n9-uuid": {UUID: "n9-uuid", BookUUID: "b9-uuid", USN: 13},
+					"n1-uuid": {UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 12},
+				},
+				Books: map[string]client.SyncFragBook{
+					"b9-uuid": {UUID: "b9-uuid", Label: "b9-label", USN: 11},
+					"b1-uuid": {UUID: "b1-uuid", Label: "b1-label-edited", USN: 10},
+				},
+				ExpungedNotes: map[string]bool{
+					"n2-uuid": true,
+					"n8-uuid": true,
+				},
+				ExpungedBooks: map[string]bool{
+					"b2-uuid": true,
+				},
+				MaxUSN: 13,
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "beginning a transaction"))
+			}
+			defer tx.Rollback()
+
+			// execute
+			got, err := planReceive(tx, &list, tc.full)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			// test
+			testutils.AssertDeepEqual(t, got, tc.expected, "plan mismatch")
+		}()
+	}
+}
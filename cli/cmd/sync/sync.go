@@ -21,6 +21,7 @@ package sync
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/dnote/dnote/cli/client"
 	"github.com/dnote/dnote/cli/core"
@@ -38,9 +39,13 @@ const (
 )
 
 var example = `
-  dnote sync`
+  dnote sync
+
+  * See what would be synced without changing anything
+  dnote sync --dry-run`
 
 var isFullSync bool
+var isDryRun bool
 
 // NewCmd returns a new sync command
 func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
@@ -54,6 +59,7 @@ func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
 
 	f := cmd.Flags()
 	f.BoolVarP(&isFullSync, "full", "f", false, "perform a full sync instead of incrementally syncing only the changed data.")
+	f.BoolVarP(&isDryRun, "dry-run", "", false, "print the changes that would be synced without making them.")
 
 	return cmd
 }
@@ -133,6 +139,11 @@ func processFragments(fragments []client.SyncFragment, cipherKey []byte) (syncLi
 		if fragment.FragMaxUSN > maxUSN {
 			maxUSN = fragment.FragMaxUSN
 		}
+		// the final fragment of a stream marks that all items up to the max_usn of the
+		// user have been sent, even if there was none
+		if fragment.FragMaxUSN == 0 && fragment.UserMaxUSN > maxUSN {
+			maxUSN = fragment.UserMaxUSN
+		}
 		if fragment.CurrentTime > maxCurrentTime {
 			maxCurrentTime = fragment.CurrentTime
 		}
@@ -166,28 +177,35 @@ func getSyncList(ctx infra.DnoteCtx, afterUSN int) (syncList, error) {
 	return ret, nil
 }
 
-// getSyncFragments repeatedly gets all sync fragments after the specified usn until there is no more new data
-// remaining and returns the buffered list
+// maxSyncStreamAttempts is the maximum number of times the sync stream is requested when
+// it is interrupted
+const maxSyncStreamAttempts = 3
+
+// getSyncFragments streams all sync fragments after the specified usn and returns the buffered
+// list. If the stream is interrupted, it resumes after the last received fragment.
 func getSyncFragments(ctx infra.DnoteCtx, afterUSN int) ([]client.SyncFragment, error) {
 	var buf []client.SyncFragment
 
 	nextAfterUSN := afterUSN
 
-	for {
-		resp, err := client.GetSyncFragment(ctx, nextAfterUSN)
-		if err != nil {
-			return buf, errors.Wrap(err, "getting sync fragment")
-		}
-
-		frag := resp.Fragment
-		buf = append(buf, frag)
+	for attempt := 1; ; attempt++ {
+		err := client.StreamSyncFragments(ctx, nextAfterUSN, func(frag client.SyncFragment) error {
+			buf = append(buf, frag)
 
-		nextAfterUSN = frag.FragMaxUSN
+			if frag.FragMaxUSN != 0 {
+				nextAfterUSN = frag.FragMaxUSN
+			}
 
-		// if there is no more data, break
-		if nextAfterUSN == 0 {
+			return nil
+		})
+		if err == nil {
 			break
 		}
+		if errors.Cause(err) != client.ErrSyncStreamInterrupted || attempt == maxSyncStreamAttempts {
+			return buf, errors.Wrap(err, "streaming sync fragments")
+		}
+
+		log.Debug("resuming the sync stream after usn %d: %s\n", nextAfterUSN, err.Error())
 	}
 
 	log.Debug("received sync fragments: %+v\n", buf)
@@ -195,27 +213,6 @@ func getSyncFragments(ctx infra.DnoteCtx, afterUSN int) ([]client.SyncFragment,
 	return buf, nil
 }
 
-// resolveLabel resolves a book label conflict by repeatedly appending an increasing integer
-// to the label until it finds a unique label. It returns the first non-conflicting label.
-func resolveLabel(tx *infra.DB, label string) (string, error) {
-	var ret string
-
-	for i := 2; ; i++ {
-		ret = fmt.Sprintf("%s (%d)", label, i)
-
-		var cnt int
-		if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", ret).Scan(&cnt); err != nil {
-			return "", errors.Wrapf(err, "checking availability of label %s", ret)
-		}
-
-		if cnt == 0 {
-			break
-		}
-	}
-
-	return ret, nil
-}
-
 // mergeBook inserts or updates the given book in the local database.
 // If a book with a duplicate label exists locally, it renames the duplicate by appending a number.
 func mergeBook(tx *infra.DB, b client.SyncFragBook, mode int) error {
@@ -226,7 +223,7 @@ func mergeBook(tx *infra.DB, b client.SyncFragBook, mode int) error {
 
 	// if duplicate exists locally, rename it and mark it dirty
 	if count > 0 {
-		newLabel, err := resolveLabel(tx, b.Label)
+		newLabel, err := core.ResolveBookLabel(tx, b.Label)
 		if err != nil {
 			return errors.Wrap(err, "getting a new book label for conflict resolution")
 		}
@@ -637,6 +634,83 @@ func stepSync(ctx infra.DnoteCtx, tx *infra.DB, afterUSN int) error {
 	return nil
 }
 
+// pushBatchSize is the maximum number of changes pushed to the server in a request
+const pushBatchSize = 100
+
+// pushChanges pushes the given changes to the server in batches, and calls the given
+// function with the index of each change and its result, in order
+func pushChanges(ctx infra.DnoteCtx, changes []client.SyncPushChange, handle func(int, client.SyncPushResult) error) error {
+	for start := 0; start < len(changes); start += pushBatchSize {
+		end := start + pushBatchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		resp, err := client.PushSync(ctx, changes[start:end])
+		if err != nil {
+			return errors.Wrap(err, "pushing changes")
+		}
+		if len(resp.Results) != end-start {
+			return errors.Errorf("expected %d results but got %d", end-start, len(resp.Results))
+		}
+
+		for idx, result := range resp.Results {
+			if err := handle(start+idx, result); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// processRespUSN advances the last max usn if the given usn from the server immediately
+// follows it. Otherwise it returns true, indicating that the client is behind the server.
+func processRespUSN(tx *infra.DB, respUSN int) (bool, error) {
+	lastMaxUSN, err := getLastMaxUSN(tx)
+	if err != nil {
+		return false, errors.Wrap(err, "getting last max usn")
+	}
+
+	log.Debug("response USN %d. last max usn: %d\n", respUSN, lastMaxUSN)
+
+	if respUSN != lastMaxUSN+1 {
+		return true, nil
+	}
+
+	if err := updateLastMaxUSN(tx, lastMaxUSN+1); err != nil {
+		return false, errors.Wrap(err, "updating last max usn")
+	}
+
+	return false, nil
+}
+
+func newBookChange(ctx infra.DnoteCtx, book core.Book) (client.SyncPushChange, error) {
+	change := client.SyncPushChange{
+		Type:       client.SyncTypeBook,
+		ClientUUID: book.UUID,
+	}
+
+	if book.Deleted {
+		change.Action = client.SyncActionDelete
+		return change, nil
+	}
+
+	encLabel, err := crypt.AesGcmEncrypt(ctx.CipherKey, []byte(book.Label))
+	if err != nil {
+		return change, errors.Wrap(err, "encrypting the label")
+	}
+	change.Label = &encLabel
+
+	if book.USN == 0 {
+		change.Action = client.SyncActionCreate
+	} else {
+		change.Action = client.SyncActionUpdate
+	}
+
+	return change, nil
+}
+
 func sendBooks(ctx infra.DnoteCtx, tx *infra.DB) (bool, error) {
 	isBehind := false
 
@@ -644,198 +718,181 @@ func sendBooks(ctx infra.DnoteCtx, tx *infra.DB) (bool, error) {
 	if err != nil {
 		return isBehind, errors.Wrap(err, "getting syncable books")
 	}
-	defer rows.Close()
 
+	var books []core.Book
 	for rows.Next() {
 		var book core.Book
 
 		if err = rows.Scan(&book.UUID, &book.Label, &book.USN, &book.Deleted); err != nil {
+			rows.Close()
 			return isBehind, errors.Wrap(err, "scanning a syncable book")
 		}
 
-		log.Debug("sending book %s\n", book.UUID)
+		books = append(books, book)
+	}
+	rows.Close()
 
-		var respUSN int
+	var pending []core.Book
+	var changes []client.SyncPushChange
+	for _, book := range books {
+		// if a book was added and deleted locally, simply expunge
+		if book.USN == 0 && book.Deleted {
+			if err := book.Expunge(tx); err != nil {
+				return isBehind, errors.Wrap(err, "expunging a book locally")
+			}
 
-		// if new, create it in the server, or else, update.
-		if book.USN == 0 {
-			if book.Deleted {
-				err = book.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a book locally")
-				}
+			continue
+		}
 
-				continue
-			} else {
-				resp, err := client.CreateBook(ctx, book.Label)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "creating a book")
-				}
+		change, err := newBookChange(ctx, book)
+		if err != nil {
+			return isBehind, errors.Wrapf(err, "preparing a change for book %s", book.UUID)
+		}
 
-				_, err = tx.Exec("UPDATE notes SET book_uuid = ? WHERE book_uuid = ?", resp.Book.UUID, book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating book_uuids of notes")
-				}
+		pending = append(pending, book)
+		changes = append(changes, change)
+	}
 
-				book.Dirty = false
-				book.USN = resp.Book.USN
-				err = book.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking book dirty")
-				}
+	err = pushChanges(ctx, changes, func(idx int, result client.SyncPushResult) error {
+		book := pending[idx]
 
-				err = book.UpdateUUID(tx, resp.Book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating book uuid")
-				}
+		log.Debug("sent book %s\n", book.UUID)
 
-				respUSN = resp.Book.USN
+		// the server creates items with the uuids generated locally, so they need not be updated
+		switch changes[idx].Action {
+		case client.SyncActionDelete:
+			if err := book.Expunge(tx); err != nil {
+				return errors.Wrap(err, "expunging a book locally")
 			}
-		} else {
-			if book.Deleted {
-				resp, err := client.DeleteBook(ctx, book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "deleting a book")
-				}
-
-				err = book.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a book locally")
-				}
-
-				respUSN = resp.Book.USN
-			} else {
-				resp, err := client.UpdateBook(ctx, book.Label, book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating a book")
-				}
-
-				book.Dirty = false
-				book.USN = resp.Book.USN
-				err = book.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking book dirty")
-				}
-
-				respUSN = resp.Book.USN
+		case client.SyncActionCreate, client.SyncActionUpdate:
+			book.Dirty = false
+			book.USN = result.USN
+			if err := book.Update(tx); err != nil {
+				return errors.Wrap(err, "marking book dirty")
 			}
 		}
 
-		lastMaxUSN, err := getLastMaxUSN(tx)
+		behind, err := processRespUSN(tx, result.USN)
 		if err != nil {
-			return isBehind, errors.Wrap(err, "getting last max usn")
+			return errors.Wrap(err, "processing response usn")
 		}
-
-		log.Debug("sent book %s. response USN %d. last max usn: %d\n", book.UUID, respUSN, lastMaxUSN)
-
-		if respUSN == lastMaxUSN+1 {
-			err = updateLastMaxUSN(tx, lastMaxUSN+1)
-			if err != nil {
-				return isBehind, errors.Wrap(err, "updating last max usn")
-			}
-		} else {
+		if behind {
 			isBehind = true
 		}
+
+		return nil
+	})
+	if err != nil {
+		return isBehind, errors.Wrap(err, "pushing books")
 	}
 
 	return isBehind, nil
 }
 
+func newNoteChange(ctx infra.DnoteCtx, note core.Note) (client.SyncPushChange, error) {
+	change := client.SyncPushChange{
+		Type:       client.SyncTypeNote,
+		ClientUUID: note.UUID,
+	}
+
+	if note.Deleted {
+		change.Action = client.SyncActionDelete
+		return change, nil
+	}
+
+	encBody, err := crypt.AesGcmEncrypt(ctx.CipherKey, []byte(note.Body))
+	if err != nil {
+		return change, errors.Wrap(err, "encrypting the content")
+	}
+	change.BookUUID = &note.BookUUID
+	change.Content = &encBody
+	change.Public = &note.Public
+
+	if note.USN == 0 {
+		change.Action = client.SyncActionCreate
+		change.AddedOn = &note.AddedOn
+		if note.EditedOn != 0 {
+			change.EditedOn = &note.EditedOn
+		}
+	} else {
+		change.Action = client.SyncActionUpdate
+	}
+
+	return change, nil
+}
+
 func sendNotes(ctx infra.DnoteCtx, tx *infra.DB) (bool, error) {
 	isBehind := false
 
-	rows, err := tx.Query("SELECT uuid, book_uuid, body, public, deleted, usn, added_on FROM notes WHERE dirty")
+	rows, err := tx.Query("SELECT uuid, book_uuid, body, public, deleted, usn, added_on, edited_on FROM notes WHERE dirty")
 	if err != nil {
 		return isBehind, errors.Wrap(err, "getting syncable notes")
 	}
-	defer rows.Close()
 
+	var notes []core.Note
 	for rows.Next() {
 		var note core.Note
 
-		if err = rows.Scan(&note.UUID, &note.BookUUID, &note.Body, &note.Public, &note.Deleted, &note.USN, &note.AddedOn); err != nil {
+		if err = rows.Scan(&note.UUID, &note.BookUUID, &note.Body, &note.Public, &note.Deleted, &note.USN, &note.AddedOn, &note.EditedOn); err != nil {
+			rows.Close()
 			return isBehind, errors.Wrap(err, "scanning a syncable note")
 		}
 
-		log.Debug("sending note %s\n", note.UUID)
+		notes = append(notes, note)
+	}
+	rows.Close()
+
+	var pending []core.Note
+	var changes []client.SyncPushChange
+	for _, note := range notes {
+		// if a note was added and deleted locally, simply expunge
+		if note.USN == 0 && note.Deleted {
+			if err := note.Expunge(tx); err != nil {
+				return isBehind, errors.Wrap(err, "expunging a note locally")
+			}
 
-		var respUSN int
+			continue
+		}
 
-		// if new, create it in the server, or else, update.
-		if note.USN == 0 {
-			if note.Deleted {
-				// if a note was added and deleted locally, simply expunge
-				err = note.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a note locally")
-				}
+		change, err := newNoteChange(ctx, note)
+		if err != nil {
+			return isBehind, errors.Wrapf(err, "preparing a change for note %s", note.UUID)
+		}
 
-				continue
-			} else {
-				resp, err := client.CreateNote(ctx, note.BookUUID, note.Body)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "creating a note")
-				}
+		pending = append(pending, note)
+		changes = append(changes, change)
+	}
 
-				note.Dirty = false
-				note.USN = resp.Result.USN
-				err = note.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking note dirty")
-				}
+	err = pushChanges(ctx, changes, func(idx int, result client.SyncPushResult) error {
+		note := pending[idx]
 
-				err = note.UpdateUUID(tx, resp.Result.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating note uuid")
-				}
+		log.Debug("sent note %s\n", note.UUID)
 
-				respUSN = resp.Result.USN
+		switch changes[idx].Action {
+		case client.SyncActionDelete:
+			if err := note.Expunge(tx); err != nil {
+				return errors.Wrap(err, "expunging a note locally")
 			}
-		} else {
-			if note.Deleted {
-				resp, err := client.DeleteNote(ctx, note.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "deleting a note")
-				}
-
-				err = note.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a note locally")
-				}
-
-				respUSN = resp.Result.USN
-			} else {
-				resp, err := client.UpdateNote(ctx, note.UUID, note.BookUUID, note.Body, note.Public)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating a note")
-				}
-
-				note.Dirty = false
-				note.USN = resp.Result.USN
-				err = note.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking note dirty")
-				}
-
-				respUSN = resp.Result.USN
+		case client.SyncActionCreate, client.SyncActionUpdate:
+			note.Dirty = false
+			note.USN = result.USN
+			if err := note.Update(tx); err != nil {
+				return errors.Wrap(err, "marking note dirty")
 			}
 		}
 
-		lastMaxUSN, err := getLastMaxUSN(tx)
+		behind, err := processRespUSN(tx, result.USN)
 		if err != nil {
-			return isBehind, errors.Wrap(err, "getting last max usn")
+			return errors.Wrap(err, "processing response usn")
 		}
-
-		log.Debug("sent note %s. response USN %d. last max usn: %d\n", note.UUID, respUSN, lastMaxUSN)
-
-		if respUSN == lastMaxUSN+1 {
-			err = updateLastMaxUSN(tx, lastMaxUSN+1)
-			if err != nil {
-				return isBehind, errors.Wrap(err, "updating last max usn")
-			}
-		} else {
+		if behind {
 			isBehind = true
 		}
+
+		return nil
+	})
+	if err != nil {
+		return isBehind, errors.Wrap(err, "pushing notes")
 	}
 
 	return isBehind, nil
@@ -893,77 +950,122 @@ func saveSyncState(tx *infra.DB, serverTime int64, serverMaxUSN int) error {
 	return nil
 }
 
-func newRun(ctx infra.DnoteCtx) core.RunEFunc {
-	return func(cmd *cobra.Command, args []string) error {
-		if ctx.SessionKey == "" || ctx.CipherKey == nil {
-			return errors.New("not logged in")
-		}
+// Do syncs the data with the server. If full is true, it performs a full sync.
+func Do(ctx infra.DnoteCtx, full bool) error {
+	if ctx.SessionKey == "" || ctx.CipherKey == nil {
+		return errors.New("not logged in")
+	}
 
-		if err := migrate.Run(ctx, migrate.RemoteSequence, migrate.RemoteMode); err != nil {
-			return errors.Wrap(err, "running remote migrations")
-		}
+	if err := migrate.Run(ctx, migrate.RemoteSequence, migrate.RemoteMode); err != nil {
+		return errors.Wrap(err, "running remote migrations")
+	}
 
-		tx, err := ctx.DB.Begin()
-		if err != nil {
-			return errors.Wrap(err, "beginning a transaction")
-		}
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
 
-		syncState, err := client.GetSyncState(ctx)
-		if err != nil {
-			return errors.Wrap(err, "getting the sync state from the server")
-		}
-		lastSyncAt, err := getLastSyncAt(tx)
-		if err != nil {
-			return errors.Wrap(err, "getting the last sync time")
-		}
-		lastMaxUSN, err := getLastMaxUSN(tx)
+	syncState, err := client.GetSyncState(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting the sync state from the server")
+	}
+	lastSyncAt, err := getLastSyncAt(tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the last sync time")
+	}
+	lastMaxUSN, err := getLastMaxUSN(tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the last max_usn")
+	}
+
+	log.Debug("lastSyncAt: %d, lastMaxUSN: %d, syncState: %+v\n", lastSyncAt, lastMaxUSN, syncState)
+
+	var syncErr error
+	if full || lastSyncAt < syncState.FullSyncBefore {
+		syncErr = fullSync(ctx, tx)
+	} else if lastMaxUSN != syncState.MaxUSN {
+		syncErr = stepSync(ctx, tx, lastMaxUSN)
+	} else {
+		// if no need to sync from the server, simply update the last sync timestamp and proceed to send changes
+		err = updateLastSyncAt(tx, syncState.CurrentTime)
 		if err != nil {
-			return errors.Wrap(err, "getting the last max_usn")
+			return errors.Wrap(err, "updating last sync at")
 		}
+	}
+	if syncErr != nil {
+		tx.Rollback()
+		return errors.Wrap(syncErr, "syncing changes from the server")
+	}
 
-		log.Debug("lastSyncAt: %d, lastMaxUSN: %d, syncState: %+v\n", lastSyncAt, lastMaxUSN, syncState)
+	isBehind, err := sendChanges(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "sending changes")
+	}
 
-		var syncErr error
-		if isFullSync || lastSyncAt < syncState.FullSyncBefore {
-			syncErr = fullSync(ctx, tx)
-		} else if lastMaxUSN != syncState.MaxUSN {
-			syncErr = stepSync(ctx, tx, lastMaxUSN)
-		} else {
-			// if no need to sync from the server, simply update the last sync timestamp and proceed to send changes
-			err = updateLastSyncAt(tx, syncState.CurrentTime)
-			if err != nil {
-				return errors.Wrap(err, "updating last sync at")
-			}
-		}
-		if syncErr != nil {
+	// if server state gets ahead of that of client during the sync, do an additional step sync
+	if isBehind {
+		log.Debug("performing another step sync because client is behind\n")
+
+		updatedLastMaxUSN, err := getLastMaxUSN(tx)
+		if err != nil {
 			tx.Rollback()
-			return errors.Wrap(syncErr, "syncing changes from the server")
+			return errors.Wrap(err, "getting the new last max_usn")
 		}
 
-		isBehind, err := sendChanges(ctx, tx)
+		err = stepSync(ctx, tx, updatedLastMaxUSN)
 		if err != nil {
 			tx.Rollback()
-			return errors.Wrap(err, "sending changes")
+			return errors.Wrap(err, "performing the follow-up step sync")
 		}
+	}
 
-		// if server state gets ahead of that of client during the sync, do an additional step sync
-		if isBehind {
-			log.Debug("performing another step sync because client is behind\n")
+	tx.Commit()
 
-			updatedLastMaxUSN, err := getLastMaxUSN(tx)
-			if err != nil {
-				tx.Rollback()
-				return errors.Wrap(err, "getting the new last max_usn")
-			}
+	return nil
+}
 
-			err = stepSync(ctx, tx, updatedLastMaxUSN)
-			if err != nil {
-				tx.Rollback()
-				return errors.Wrap(err, "performing the follow-up step sync")
-			}
+// AutoSync syncs if the sync interval of the profile has passed since the last sync. It does
+// nothing if no interval is set or the user is not logged in.
+func AutoSync(ctx infra.DnoteCtx) error {
+	interval, err := ctx.Settings.GetSyncInterval()
+	if err != nil {
+		return errors.Wrap(err, "getting the sync interval")
+	}
+	if interval == 0 || ctx.SessionKey == "" || ctx.CipherKey == nil {
+		return nil
+	}
+
+	var lastSyncAt int64
+	if err := core.GetSystem(ctx.DB, infra.SystemLastSyncAt, &lastSyncAt); err != nil {
+		return errors.Wrap(err, "getting the last sync time")
+	}
+	if time.Since(time.Unix(lastSyncAt, 0)) < interval {
+		return nil
+	}
+
+	log.Info("syncing\n")
+	if err := Do(ctx, false); err != nil {
+		return errors.Wrap(err, "syncing")
+	}
+	log.Success("synced\n")
+
+	return nil
+}
+
+func newRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if ctx.SessionKey == "" || ctx.CipherKey == nil {
+			return errors.New("not logged in")
 		}
 
-		tx.Commit()
+		if isDryRun {
+			return dryRun(ctx)
+		}
+
+		if err := Do(ctx, isFullSync); err != nil {
+			return err
+		}
 
 		log.Success("success\n")
 
@@ -19,6 +19,7 @@
 package sync
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -110,6 +111,174 @@ func TestProcessFragments(t *testing.T) {
 	testutils.AssertDeepEqual(t, sl, expected, "syncList mismatch")
 }
 
+func TestGetSyncFragments(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	testutils.Login(t, &ctx)
+	defer testutils.TeardownEnv(ctx)
+
+	var afterUSNs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		afterUSNs = append(afterUSNs, r.URL.Query().Get("after_usn"))
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+
+		enc := json.NewEncoder(gz)
+		enc.Encode(client.SyncFragment{FragMaxUSN: 5, UserMaxUSN: 7, ExpungedNotes: []string{"n1-uuid"}})
+		enc.Encode(client.SyncFragment{FragMaxUSN: 7, UserMaxUSN: 7, ExpungedBooks: []string{"b1-uuid"}})
+		enc.Encode(client.SyncFragment{FragMaxUSN: 0, UserMaxUSN: 7})
+	}))
+	defer ts.Close()
+
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	fragments, err := getSyncFragments(ctx, 3)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	testutils.AssertDeepEqual(t, afterUSNs, []string{"3"}, "after_usn mismatch")
+	testutils.AssertEqual(t, len(fragments), 3, "fragment count mismatch")
+	testutils.AssertEqual(t, fragments[0].FragMaxUSN, 5, "fragments[0] FragMaxUSN mismatch")
+	testutils.AssertEqual(t, fragments[1].FragMaxUSN, 7, "fragments[1] FragMaxUSN mismatch")
+	testutils.AssertEqual(t, fragments[2].FragMaxUSN, 0, "fragments[2] FragMaxUSN mismatch")
+}
+
+func TestGetSyncFragments_resume(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	testutils.Login(t, &ctx)
+	defer testutils.TeardownEnv(ctx)
+
+	var afterUSNs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		afterUSN := r.URL.Query().Get("after_usn")
+		afterUSNs = append(afterUSNs, afterUSN)
+
+		enc := json.NewEncoder(w)
+		if afterUSN == "0" {
+			// end the stream before the final fragment
+			enc.Encode(client.SyncFragment{FragMaxUSN: 5, UserMaxUSN: 7})
+			return
+		}
+
+		enc.Encode(client.SyncFragment{FragMaxUSN: 7, UserMaxUSN: 7})
+		enc.Encode(client.SyncFragment{FragMaxUSN: 0, UserMaxUSN: 7})
+	}))
+	defer ts.Close()
+
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	fragments, err := getSyncFragments(ctx, 0)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	testutils.AssertDeepEqual(t, afterUSNs, []string{"0", "5"}, "after_usn mismatch")
+	testutils.AssertEqual(t, len(fragments), 3, "fragment count mismatch")
+	testutils.AssertEqual(t, fragments[0].FragMaxUSN, 5, "fragments[0] FragMaxUSN mismatch")
+	testutils.AssertEqual(t, fragments[1].FragMaxUSN, 7, "fragments[1] FragMaxUSN mismatch")
+}
+
+func TestGetSyncFragments_notModified(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	testutils.Login(t, &ctx)
+	defer testutils.TeardownEnv(ctx)
+
+	var ifNoneMatches []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatches = append(ifNoneMatches, r.Header.Get("If-None-Match"))
+
+		w.Header().Set("ETag", `W/"7-7"`)
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer ts.Close()
+
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	fragments, err := getSyncFragments(ctx, 7)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	testutils.AssertDeepEqual(t, ifNoneMatches, []string{`W/"7-7"`}, "If-None-Match mismatch")
+	testutils.AssertEqual(t, len(fragments), 1, "fragment count mismatch")
+	testutils.AssertEqual(t, fragments[0].FragMaxUSN, 0, "FragMaxUSN mismatch")
+	testutils.AssertEqual(t, fragments[0].UserMaxUSN, 7, "UserMaxUSN mismatch")
+	if fragments[0].CurrentTime == 0 {
+		t.Error("CurrentTime should be set from the response")
+	}
+
+	sl, err := processFragments(fragments, cipherKey)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "processing fragments"))
+	}
+	testutils.AssertEqual(t, sl.MaxUSN, 7, "MaxUSN mismatch")
+	testutils.AssertEqual(t, sl.getLength(), 0, "length mismatch")
+}
+
+func TestGetSyncFragments_interrupted(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	testutils.Login(t, &ctx)
+	defer testutils.TeardownEnv(ctx)
+
+	var requestCount int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+	}))
+	defer ts.Close()
+
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	_, err := getSyncFragments(ctx, 0)
+
+	// test
+	testutils.AssertEqual(t, errors.Cause(err), client.ErrSyncStreamInterrupted, "error mismatch")
+	testutils.AssertEqual(t, requestCount, maxSyncStreamAttempts, "request count mismatch")
+}
+
+func TestGetSyncFragments_upgradeRequired(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	testutils.Login(t, &ctx)
+	defer testutils.TeardownEnv(ctx)
+
+	var requestCount int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+
+		w.WriteHeader(http.StatusUpgradeRequired)
+		w.Write([]byte(`{"error":"upgrade_required","client_version":"0.4.0","minimum_version":"0.5.0"}`))
+	}))
+	defer ts.Close()
+
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	_, err := getSyncFragments(ctx, 0)
+
+	// test
+	e, ok := errors.Cause(err).(*utils.UpgradeRequiredError)
+	if !ok {
+		t.Fatalf("expected an upgrade required error but got %+v", err)
+	}
+
+	testutils.AssertEqual(t, e.ClientVersion, "0.4.0", "client version mismatch")
+	testutils.AssertEqual(t, e.MinimumVersion, "0.5.0", "minimum version mismatch")
+	testutils.AssertEqual(t, requestCount, 1, "request count mismatch")
+}
+
 func TestGetLastSyncAt(t *testing.T) {
 	// set up
 	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
@@ -160,56 +329,6 @@ func TestGetLastMaxUSN(t *testing.T) {
 	testutils.AssertEqual(t, got, 20001, "last_max_usn mismatch")
 }
 
-func TestResolveLabel(t *testing.T) {
-	testCases := []struct {
-		input    string
-		expected string
-	}{
-		{
-			input:    "js",
-			expected: "js (2)",
-		},
-		{
-			input:    "css",
-			expected: "css (3)",
-		},
-		{
-			input:    "linux",
-			expected: "linux (4)",
-		},
-	}
-
-	for idx, tc := range testCases {
-		func() {
-			// set up
-			ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
-			defer testutils.TeardownEnv(ctx)
-
-			db := ctx.DB
-
-			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
-			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "css (2)")
-			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b3-uuid", "linux (1)")
-			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b4-uuid", "linux (2)")
-			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b5-uuid", "linux (3)")
-
-			// execute
-			tx, err := db.Begin()
-			if err != nil {
-				t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
-			}
-
-			got, err := resolveLabel(tx, tc.input)
-			if err != nil {
-				t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
-			}
-			tx.Rollback()
-
-			testutils.AssertEqual(t, got, tc.expected, fmt.Sprintf("output mismatch for test case %d", idx))
-		}()
-	}
-}
-
 func TestSyncDeleteNote(t *testing.T) {
 	t.Run("exists on server only", func(t *testing.T) {
 		// set up
@@ -1903,6 +2022,44 @@ func TestSaveServerState(t *testing.T) {
 // TestSendBooks tests that books are put to correct 'buckets' by running a test server and recording the
 // uuid from the incoming data. It also tests that the uuid of the created books and book_uuids of their notes
 // are updated accordingly based on the server response.
+// newPushServer returns a test server for the sync push endpoint. It responds to each
+// change in the payload with the result from the given function.
+func newPushServer(t *testing.T, handle func(client.SyncPushChange) client.SyncPushResult) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.String() != "/v2/sync/push" || r.Method != "POST" {
+			t.Errorf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Idempotency-Key") == "" {
+			t.Error("Idempotency-Key header is missing")
+		}
+
+		var payload client.SyncPushPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf(errors.Wrap(err, "decoding payload in the test server").Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := client.SyncPushResp{
+			Results: []client.SyncPushResult{},
+		}
+		for _, change := range payload.Changes {
+			result := handle(change)
+			result.ClientUUID = change.ClientUUID
+
+			resp.Results = append(resp.Results, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+}
+
 func TestSendBooks(t *testing.T) {
 	// set up
 	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
@@ -1932,7 +2089,7 @@ func TestSendBooks(t *testing.T) {
 	testutils.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b5-uuid", 10, "n2 body", 1541108743, false, false)
 	testutils.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "b6-uuid", 10, "n3 body", 1541108743, false, false)
 	testutils.MustExec(t, "inserting n4", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n4-uuid", "b7-uuid", 10, "n4 body", 1541108743, false, false)
-	// notes that belong to the created book. Their book_uuid should be updated.
+	// notes that belong to the created book. Their book_uuid should not change.
 	testutils.MustExec(t, "inserting n5", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n5-uuid", "b3-uuid", 10, "n5 body", 1541108743, false, false)
 	testutils.MustExec(t, "inserting n6", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n6-uuid", "b3-uuid", 10, "n6 body", 1541108743, false, false)
 	testutils.MustExec(t, "inserting n7", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n7-uuid", "b4-uuid", 10, "n7 body", 1541108743, false, false)
@@ -1942,60 +2099,29 @@ func TestSendBooks(t *testing.T) {
 	var deletedUUIDs []string
 
 	// fire up a test server. It decrypts the payload for test purposes.
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.String() == "/v2/books" && r.Method == "POST" {
-			var payload client.CreateBookPayload
-
-			err := json.NewDecoder(r.Body).Decode(&payload)
-			if err != nil {
-				t.Fatalf(errors.Wrap(err, "decoding payload in the test server").Error())
-				return
-			}
+	ts := newPushServer(t, func(change client.SyncPushChange) client.SyncPushResult {
+		if change.Type != client.SyncTypeBook {
+			t.Errorf("unexpected type %s", change.Type)
+		}
 
-			labelDec, err := crypt.AesGcmDecrypt(cipherKey, payload.Name)
+		switch change.Action {
+		case client.SyncActionCreate:
+			labelDec, err := crypt.AesGcmDecrypt(cipherKey, *change.Label)
 			if err != nil {
-				t.Fatalf(errors.Wrap(err, "decrypting label").Error())
-			}
-
-			labelDecStr := string(labelDec)
-
-			createdLabels = append(createdLabels, labelDecStr)
-
-			resp := client.CreateBookResp{
-				Book: client.RespBook{
-					UUID: fmt.Sprintf("server-%s-uuid", labelDecStr),
-				},
+				t.Errorf(errors.Wrap(err, "decrypting label").Error())
 			}
 
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(resp); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			return
+			createdLabels = append(createdLabels, string(labelDec))
+		case client.SyncActionUpdate:
+			updatesUUIDs = append(updatesUUIDs, change.ClientUUID)
+		case client.SyncActionDelete:
+			deletedUUIDs = append(deletedUUIDs, change.ClientUUID)
 		}
 
-		p := strings.Split(r.URL.Path, "/")
-		if len(p) == 4 && p[0] == "" && p[1] == "v1" && p[2] == "books" {
-			if r.Method == "PATCH" {
-				uuid := p[3]
-				updatesUUIDs = append(updatesUUIDs, uuid)
-
-				w.Header().Set("Content-Type", "application/json")
-				w.Write([]byte("{}"))
-				return
-			} else if r.Method == "DELETE" {
-				uuid := p[3]
-				deletedUUIDs = append(deletedUUIDs, uuid)
-
-				w.Header().Set("Content-Type", "application/json")
-				w.Write([]byte("{}"))
-				return
-			}
+		return client.SyncPushResult{
+			UUID: change.ClientUUID,
 		}
-
-		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
-	}))
+	})
 	defer ts.Close()
 
 	ctx.APIEndpoint = ts.URL
@@ -2044,9 +2170,9 @@ func TestSendBooks(t *testing.T) {
 	testutils.AssertEqual(t, b8.Dirty, false, "b8 Dirty mismatch")
 	testutils.AssertEqual(t, b1.UUID, "b1-uuid", "b1 UUID mismatch")
 	testutils.AssertEqual(t, b2.UUID, "b2-uuid", "b2 UUID mismatch")
-	// uuids of created books should have been updated
-	testutils.AssertEqual(t, b3.UUID, "server-b3-label-uuid", "b3 UUID mismatch")
-	testutils.AssertEqual(t, b4.UUID, "server-b4-label-uuid", "b4 UUID mismatch")
+	// created books should keep the uuids generated locally
+	testutils.AssertEqual(t, b3.UUID, "b3-uuid", "b3 UUID mismatch")
+	testutils.AssertEqual(t, b4.UUID, "b4-uuid", "b4 UUID mismatch")
 	testutils.AssertEqual(t, b7.UUID, "b7-uuid", "b7 UUID mismatch")
 	testutils.AssertEqual(t, b8.UUID, "b8-uuid", "b8 UUID mismatch")
 
@@ -2062,69 +2188,18 @@ func TestSendBooks(t *testing.T) {
 	testutils.AssertEqual(t, n2.BookUUID, "b5-uuid", "n2 bookUUID mismatch")
 	testutils.AssertEqual(t, n3.BookUUID, "b6-uuid", "n3 bookUUID mismatch")
 	testutils.AssertEqual(t, n4.BookUUID, "b7-uuid", "n4 bookUUID mismatch")
-	testutils.AssertEqual(t, n5.BookUUID, "server-b3-label-uuid", "n5 bookUUID mismatch")
-	testutils.AssertEqual(t, n6.BookUUID, "server-b3-label-uuid", "n6 bookUUID mismatch")
-	testutils.AssertEqual(t, n7.BookUUID, "server-b4-label-uuid", "n7 bookUUID mismatch")
+	testutils.AssertEqual(t, n5.BookUUID, "b3-uuid", "n5 bookUUID mismatch")
+	testutils.AssertEqual(t, n6.BookUUID, "b3-uuid", "n6 bookUUID mismatch")
+	testutils.AssertEqual(t, n7.BookUUID, "b4-uuid", "n7 bookUUID mismatch")
 }
 
 func TestSendBooks_isBehind(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.String() == "/v2/books" && r.Method == "POST" {
-			var payload client.CreateBookPayload
-
-			err := json.NewDecoder(r.Body).Decode(&payload)
-			if err != nil {
-				t.Fatalf(errors.Wrap(err, "decoding payload in the test server").Error())
-				return
-			}
-
-			resp := client.CreateBookResp{
-				Book: client.RespBook{
-					USN: 11,
-				},
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(resp); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			return
+	ts := newPushServer(t, func(change client.SyncPushChange) client.SyncPushResult {
+		return client.SyncPushResult{
+			UUID: change.ClientUUID,
+			USN:  11,
 		}
-
-		p := strings.Split(r.URL.Path, "/")
-		if len(p) == 4 && p[0] == "" && p[1] == "v1" && p[2] == "books" {
-			if r.Method == "PATCH" {
-				resp := client.UpdateBookResp{
-					Book: client.RespBook{
-						USN: 11,
-					},
-				}
-
-				w.Header().Set("Content-Type", "application/json")
-				if err := json.NewEncoder(w).Encode(resp); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				return
-			} else if r.Method == "DELETE" {
-				resp := client.DeleteBookResp{
-					Book: client.RespBook{
-						USN: 11,
-					},
-				}
-
-				w.Header().Set("Content-Type", "application/json")
-				if err := json.NewEncoder(w).Encode(resp); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				return
-			}
-		}
-
-		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
-	}))
+	})
 	defer ts.Close()
 
 	t.Run("create book", func(t *testing.T) {
@@ -2313,59 +2388,29 @@ func TestSendNotes(t *testing.T) {
 	var deletedUUIDs []string
 
 	// fire up a test server. It decrypts the payload for test purposes.
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.String() == "/v2/notes" && r.Method == "POST" {
-			var payload client.CreateNotePayload
-
-			err := json.NewDecoder(r.Body).Decode(&payload)
-			if err != nil {
-				t.Fatalf(errors.Wrap(err, "decoding payload in the test server").Error())
-				return
-			}
+	ts := newPushServer(t, func(change client.SyncPushChange) client.SyncPushResult {
+		if change.Type != client.SyncTypeNote {
+			t.Errorf("unexpected type %s", change.Type)
+		}
 
-			bodyDec, err := crypt.AesGcmDecrypt(cipherKey, payload.Body)
+		switch change.Action {
+		case client.SyncActionCreate:
+			bodyDec, err := crypt.AesGcmDecrypt(cipherKey, *change.Content)
 			if err != nil {
-				t.Fatalf(errors.Wrap(err, "decrypting body").Error())
-			}
-			bodyDecStr := string(bodyDec)
-
-			createdBodys = append(createdBodys, bodyDecStr)
-
-			resp := client.CreateNoteResp{
-				Result: client.RespNote{
-					UUID: fmt.Sprintf("server-%s-uuid", bodyDecStr),
-				},
+				t.Errorf(errors.Wrap(err, "decrypting body").Error())
 			}
 
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(resp); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			return
+			createdBodys = append(createdBodys, string(bodyDec))
+		case client.SyncActionUpdate:
+			updatedUUIDs = append(updatedUUIDs, change.ClientUUID)
+		case client.SyncActionDelete:
+			deletedUUIDs = append(deletedUUIDs, change.ClientUUID)
 		}
 
-		p := strings.Split(r.URL.Path, "/")
-		if len(p) == 4 && p[0] == "" && p[1] == "v1" && p[2] == "notes" {
-			if r.Method == "PATCH" {
-				uuid := p[3]
-				updatedUUIDs = append(updatedUUIDs, uuid)
-
-				w.Header().Set("Content-Type", "application/json")
-				w.Write([]byte("{}"))
-				return
-			} else if r.Method == "DELETE" {
-				uuid := p[3]
-				deletedUUIDs = append(deletedUUIDs, uuid)
-
-				w.Header().Set("Content-Type", "application/json")
-				w.Write([]byte("{}"))
-				return
-			}
+		return client.SyncPushResult{
+			UUID: change.ClientUUID,
 		}
-
-		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
-	}))
+	})
 	defer ts.Close()
 
 	ctx.APIEndpoint = ts.URL
@@ -2423,14 +2468,14 @@ func TestSendNotes(t *testing.T) {
 	testutils.AssertEqual(t, n8.AddedOn, int64(1541108743), "n8 AddedOn mismatch")
 	testutils.AssertEqual(t, n10.AddedOn, int64(1541108743), "n10 AddedOn mismatch")
 
-	// UUIDs of created notes should have been updated with those from the server response
+	// created notes should keep the uuids generated locally
 	testutils.AssertEqual(t, n1.UUID, "n1-uuid", "n1 UUID mismatch")
-	testutils.AssertEqual(t, n2.UUID, "server-n2-body-uuid", "n2 UUID mismatch")
+	testutils.AssertEqual(t, n2.UUID, "n2-uuid", "n2 UUID mismatch")
 	testutils.AssertEqual(t, n3.UUID, "n3-uuid", "n3 UUID mismatch")
-	testutils.AssertEqual(t, n6.UUID, "server-n6-body-uuid", "n6 UUID mismatch")
+	testutils.AssertEqual(t, n6.UUID, "n6-uuid", "n6 UUID mismatch")
 	testutils.AssertEqual(t, n7.UUID, "n7-uuid", "n7 UUID mismatch")
 	testutils.AssertEqual(t, n8.UUID, "n8-uuid", "n8 UUID mismatch")
-	testutils.AssertEqual(t, n10.UUID, "server-n10-body-uuid", "n10 UUID mismatch")
+	testutils.AssertEqual(t, n10.UUID, "n10-uuid", "n10 UUID mismatch")
 }
 
 func TestSendNotes_addedOn(t *testing.T) {
@@ -2447,25 +2492,18 @@ func TestSendNotes_addedOn(t *testing.T) {
 	b1UUID := "b1-uuid"
 	testutils.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, 0, "n1-body", 1541108743, false, true)
 
-	// fire up a test server. It decrypts the payload for test purposes.
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.String() == "/v2/notes" && r.Method == "POST" {
-			resp := client.CreateNoteResp{
-				Result: client.RespNote{
-					UUID: utils.GenerateUUID(),
-				},
-			}
+	var pushedAddedOn int64
 
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(resp); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			return
+	// fire up a test server
+	ts := newPushServer(t, func(change client.SyncPushChange) client.SyncPushResult {
+		if change.AddedOn != nil {
+			pushedAddedOn = *change.AddedOn
 		}
 
-		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
-	}))
+		return client.SyncPushResult{
+			UUID: utils.GenerateUUID(),
+		}
+	})
 	defer ts.Close()
 
 	ctx.APIEndpoint = ts.URL
@@ -2487,66 +2525,80 @@ func TestSendNotes_addedOn(t *testing.T) {
 	var n1 core.Note
 	testutils.MustScan(t, "getting n1", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n1-body"), &n1.UUID, &n1.AddedOn, &n1.Dirty)
 	testutils.AssertEqual(t, n1.AddedOn, int64(1541108743), "n1 AddedOn mismatch")
+	testutils.AssertEqual(t, pushedAddedOn, int64(1541108743), "pushed AddedOn mismatch")
 }
 
-func TestSendNotes_isBehind(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.String() == "/v1/notes" && r.Method == "POST" {
-			var payload client.CreateBookPayload
+func TestSendNotes_batch(t *testing.T) {
+	// set up
+	ctx := testutils.InitEnv(t, "../../tmp", "../../testutils/fixtures/schema.sql", true)
+	testutils.Login(t, &ctx)
+	defer testutils.TeardownEnv(ctx)
 
-			err := json.NewDecoder(r.Body).Decode(&payload)
-			if err != nil {
-				t.Fatalf(errors.Wrap(err, "decoding payload in the test server").Error())
-				return
-			}
+	db := ctx.DB
 
-			resp := client.CreateNoteResp{
-				Result: client.RespNote{
-					USN: 11,
-				},
-			}
+	testutils.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", infra.SystemLastMaxUSN, 0)
+	testutils.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
 
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(resp); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			return
+	noteCount := pushBatchSize + 5
+	for i := 0; i < noteCount; i++ {
+		testutils.MustExec(t, fmt.Sprintf("inserting note %d", i), db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", fmt.Sprintf("n%d-uuid", i), "b1-uuid", 0, fmt.Sprintf("n%d-body", i), 1541108743, false, true)
+	}
+
+	var requestCount int
+	idempotencyKeys := map[string]bool{}
+	usn := 0
+	handle := func(change client.SyncPushChange) client.SyncPushResult {
+		usn++
+
+		return client.SyncPushResult{
+			UUID: utils.GenerateUUID(),
+			USN:  usn,
 		}
+	}
+	pushServer := newPushServer(t, handle)
+	defer pushServer.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		idempotencyKeys[r.Header.Get("Idempotency-Key")] = true
+		pushServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
 
-		p := strings.Split(r.URL.Path, "/")
-		if len(p) == 4 && p[0] == "" && p[1] == "v1" && p[2] == "notes" {
-			if r.Method == "PATCH" {
-				resp := client.UpdateNoteResp{
-					Result: client.RespNote{
-						USN: 11,
-					},
-				}
+	ctx.APIEndpoint = ts.URL
 
-				w.Header().Set("Content-Type", "application/json")
-				if err := json.NewEncoder(w).Encode(resp); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				return
-			} else if r.Method == "DELETE" {
-				resp := client.DeleteNoteResp{
-					Result: client.RespNote{
-						USN: 11,
-					},
-				}
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
 
-				w.Header().Set("Content-Type", "application/json")
-				if err := json.NewEncoder(w).Encode(resp); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				return
-			}
-		}
+	isBehind, err := sendNotes(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
 
-		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
-	}))
+	tx.Commit()
+
+	// test
+	var dirtyCount, lastMaxUSN int
+	testutils.MustScan(t, "counting dirty notes", db.QueryRow("SELECT count(*) FROM notes WHERE dirty"), &dirtyCount)
+	testutils.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", infra.SystemLastMaxUSN), &lastMaxUSN)
+
+	testutils.AssertEqual(t, requestCount, 2, "request count mismatch")
+	testutils.AssertEqual(t, len(idempotencyKeys), 2, "each batch should have a distinct idempotency key")
+	testutils.AssertEqual(t, dirtyCount, 0, "dirty note count mismatch")
+	testutils.AssertEqual(t, lastMaxUSN, noteCount, "last max usn mismatch")
+	testutils.AssertEqual(t, isBehind, false, "isBehind mismatch")
+}
+
+func TestSendNotes_isBehind(t *testing.T) {
+	ts := newPushServer(t, func(change client.SyncPushChange) client.SyncPushResult {
+		return client.SyncPushResult{
+			UUID: change.ClientUUID,
+			USN:  11,
+		}
+	})
 	defer ts.Close()
 
 	t.Run("create note", func(t *testing.T) {
@@ -0,0 +1,91 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package template
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var editExample = `
+ * Edit a template. Variables are written as {{.Date}}, {{.Book}} and {{.GitBranch}}
+ dnote template edit incident`
+
+func newEditCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "edit <name>",
+		Short:   "Create or edit a template",
+		Aliases: []string{"e"},
+		Example: editExample,
+		Args:    cobra.ExactArgs(1),
+		RunE:    newEditRun(ctx),
+	}
+
+	return cmd
+}
+
+func newEditRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+
+		path, err := core.GetTemplatePath(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		var oldContent []byte
+		if utils.FileExists(path) {
+			oldContent, err = ioutil.ReadFile(path)
+			if err != nil {
+				return errors.Wrap(err, "reading the template")
+			}
+		}
+
+		fpath := core.GetDnoteTmpContentPath(ctx)
+		if err := ioutil.WriteFile(fpath, oldContent, 0644); err != nil {
+			return errors.Wrap(err, "preparing tmp content file")
+		}
+
+		var content string
+		if err := core.GetEditorInput(ctx, fpath, &content); err != nil {
+			return errors.Wrap(err, "getting editor input")
+		}
+		if content == "" {
+			return errors.New("Empty content")
+		}
+
+		if err := os.MkdirAll(core.GetTemplatesDir(ctx), 0755); err != nil {
+			return errors.Wrap(err, "creating the templates directory")
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			return errors.Wrap(err, "writing the template")
+		}
+
+		log.Successf("saved the template %s\n", name)
+
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package template
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newLsCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Short:   "List the templates",
+		Aliases: []string{"l"},
+		Args:    cobra.NoArgs,
+		RunE:    newLsRun(ctx),
+	}
+
+	return cmd
+}
+
+// getTemplateBooks returns the books using each template by default
+func getTemplateBooks(ctx infra.DnoteCtx) map[string][]string {
+	ret := map[string][]string{}
+
+	for book, name := range ctx.Settings.BookTemplates {
+		ret[name] = append(ret[name], book)
+	}
+	for _, books := range ret {
+		sort.Strings(books)
+	}
+
+	return ret
+}
+
+func newLsRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		names, err := core.ListTemplates(ctx)
+		if err != nil {
+			return errors.Wrap(err, "listing templates")
+		}
+
+		if len(names) == 0 {
+			log.Info("no templates. Create one by running 'dnote template edit <name>'\n")
+			return nil
+		}
+
+		templateBooks := getTemplateBooks(ctx)
+
+		for _, name := range names {
+			var books string
+			if b, ok := templateBooks[name]; ok {
+				books = log.ColorYellow.Sprintf(" (default for %s)", strings.Join(b, ", "))
+			}
+
+			log.Plainf("%s%s\n", name, books)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package template
+
+import (
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * List the templates
+ dnote template ls
+
+ * Create or edit a template
+ dnote template edit incident`
+
+// NewCmd returns a new template command
+func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "template",
+		Short:   "Manage the templates for new notes",
+		Example: example,
+	}
+
+	cmd.AddCommand(newLsCmd(ctx))
+	cmd.AddCommand(newEditCmd(ctx))
+
+	return cmd
+}
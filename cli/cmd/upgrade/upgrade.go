@@ -0,0 +1,146 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// releasePublicKey is the base64 encoded ed25519 public key used to verify the releases.
+// It is populated during link time.
+var releasePublicKey string
+
+var example = `
+  * Upgrade to the latest release
+  dnote upgrade
+
+  * Upgrade to the latest beta release
+  dnote upgrade --channel beta
+
+  * Upgrade using a local mirror of the releases
+  dnote upgrade --source file:///srv/dnote/releases.json`
+
+var channel, source string
+
+// NewCmd returns a new upgrade command
+func NewCmd(ctx infra.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "upgrade",
+		Short:   "Upgrade dnote to the latest release",
+		Example: example,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&channel, "channel", "", "", "the release channel, such as 'stable' or 'beta'. Defaults to upgrade_channel in the config or 'stable'")
+	f.StringVarP(&source, "source", "", "", "the location of the release list. Defaults to upgrade_source in the config or GitHub")
+
+	return cmd
+}
+
+// getExecutablePath returns the path to the running dnote binary
+func getExecutablePath() (string, error) {
+	p, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "finding the executable")
+	}
+
+	ret, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", errors.Wrap(err, "resolving symlinks")
+	}
+
+	return ret, nil
+}
+
+func newRun(ctx infra.DnoteCtx) core.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		src := source
+		if src == "" {
+			src = core.GetUpgradeSource(ctx.Settings)
+		}
+		ch := channel
+		if ch == "" {
+			ch = core.GetUpgradeChannel(ctx.Settings)
+		}
+
+		exePath, err := getExecutablePath()
+		if err != nil {
+			return errors.Wrap(err, "getting the executable path")
+		}
+		// Homebrew manages its own files
+		if strings.Contains(exePath, "/Cellar/") {
+			log.Infof("dnote was installed with Homebrew. Please run 'brew upgrade dnote'\n")
+			return nil
+		}
+
+		releases, err := core.GetReleases(src)
+		if err != nil {
+			return errors.Wrap(err, "getting releases")
+		}
+		latest, ok := core.SelectRelease(releases, ch)
+		if !ok {
+			return errors.Errorf("no release found in the %s channel", ch)
+		}
+
+		log.Infof("current version is %s\n", ctx.Version)
+		log.Infof("latest version in the %s channel is %s\n", ch, latest.Version())
+
+		isNewer, err := core.IsNewerRelease(latest, ctx.Version)
+		if err != nil {
+			// development builds do not have a version to compare
+			log.Debug("comparing versions: %s\n", err.Error())
+		} else if !isNewer {
+			log.Success("you are up-to-date\n")
+			return nil
+		}
+
+		ok, err = utils.AskConfirmation(fmt.Sprintf("upgrade to %s?", latest.Version()), true)
+		if err != nil {
+			return errors.Wrap(err, "getting confirmation")
+		}
+		if !ok {
+			return nil
+		}
+
+		bin, err := core.DownloadRelease(src, latest, runtime.GOOS, runtime.GOARCH, releasePublicKey)
+		if err != nil {
+			return errors.Wrap(err, "downloading the release")
+		}
+
+		if err := core.ReplaceExecutable(exePath, bin); err != nil {
+			return errors.Wrap(err, "installing the release")
+		}
+
+		log.Successf("upgraded to %s\n", latest.Version())
+
+		return nil
+	}
+}
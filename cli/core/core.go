@@ -39,7 +39,7 @@ import (
 
 var (
 	// ConfigFilename is the name of the config file
-	ConfigFilename = "dnoterc"
+	ConfigFilename = infra.ConfigFilename
 	// TmpContentFilename is the name of the temporary file that holds editor input
 	TmpContentFilename = "DNOTE_TMPCONTENT.md"
 )
@@ -49,13 +49,13 @@ type RunEFunc func(*cobra.Command, []string) error
 
 // GetConfigPath returns the path to the dnote config file
 func GetConfigPath(ctx infra.DnoteCtx) string {
-	return fmt.Sprintf("%s/%s", ctx.DnoteDir, ConfigFilename)
+	return infra.GetConfigPath(ctx.ConfigDir)
 }
 
 // GetDnoteTmpContentPath returns the path to the temporary file containing
 // content being added or edited
 func GetDnoteTmpContentPath(ctx infra.DnoteCtx) string {
-	return fmt.Sprintf("%s/%s", ctx.DnoteDir, TmpContentFilename)
+	return fmt.Sprintf("%s/%s", ctx.CacheDir, TmpContentFilename)
 }
 
 // GetBookUUID returns a uuid of a book given a label
@@ -125,7 +125,9 @@ func initConfigFile(ctx infra.DnoteCtx) error {
 	editor := getEditorCommand()
 
 	config := infra.Config{
-		Editor: editor,
+		Settings: infra.Settings{
+			Editor: editor,
+		},
 	}
 
 	b, err := yaml.Marshal(config)
@@ -141,16 +143,17 @@ func initConfigFile(ctx infra.DnoteCtx) error {
 	return nil
 }
 
-// initDnoteDir initializes dnote directory if it does not exist yet
+// initDnoteDir initializes the dnote directories and the directory of the profile if they do
+// not exist yet
 func initDnoteDir(ctx infra.DnoteCtx) error {
-	path := ctx.DnoteDir
-
-	if utils.FileExists(path) {
-		return nil
-	}
+	for _, path := range []string{ctx.ConfigDir, ctx.DataDir, ctx.CacheDir, ctx.ProfileDir} {
+		if path == "" || utils.FileExists(path) {
+			continue
+		}
 
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return errors.Wrap(err, "Failed to create dnote directory")
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return errors.Wrap(err, "Failed to create dnote directory")
+		}
 	}
 
 	return nil
@@ -193,20 +196,7 @@ func LogAction(tx *sql.Tx, schema int, actionType, data string, timestamp int64)
 
 // ReadConfig reads the config file
 func ReadConfig(ctx infra.DnoteCtx) (infra.Config, error) {
-	var ret infra.Config
-
-	configPath := GetConfigPath(ctx)
-	b, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		return ret, errors.Wrap(err, "reading config file")
-	}
-
-	err = yaml.Unmarshal(b, &ret)
-	if err != nil {
-		return ret, errors.Wrap(err, "unmarshalling config")
-	}
-
-	return ret, nil
+	return infra.ReadConfig(ctx.ConfigDir)
 }
 
 // SanitizeContent sanitizes note content
@@ -224,12 +214,13 @@ func SanitizeContent(s string) string {
 }
 
 func newEditorCmd(ctx infra.DnoteCtx, fpath string) (*exec.Cmd, error) {
-	config, err := ReadConfig(ctx)
-	if err != nil {
-		return nil, errors.Wrap(err, "reading config")
+	editor := ctx.Settings.Editor
+	// the config file is not read yet on the first run
+	if editor == "" {
+		editor = getEditorCommand()
 	}
 
-	args := strings.Fields(config.Editor)
+	args := strings.Fields(editor)
 	args = append(args, fpath)
 
 	return exec.Command(args[0], args[1:]...), nil
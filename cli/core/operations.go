@@ -20,6 +20,7 @@ package core
 
 import (
 	"encoding/base64"
+	"fmt"
 
 	"github.com/dnote/dnote/cli/infra"
 	"github.com/pkg/errors"
@@ -101,3 +102,24 @@ func GetCipherKey(ctx infra.DnoteCtx) ([]byte, error) {
 
 	return cipherKey, nil
 }
+
+// ResolveBookLabel resolves a book label conflict by repeatedly appending an increasing integer
+// to the label until it finds a unique label. It returns the first non-conflicting label.
+func ResolveBookLabel(tx *infra.DB, label string) (string, error) {
+	var ret string
+
+	for i := 2; ; i++ {
+		ret = fmt.Sprintf("%s (%d)", label, i)
+
+		var cnt int
+		if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", ret).Scan(&cnt); err != nil {
+			return "", errors.Wrapf(err, "checking availability of label %s", ret)
+		}
+
+		if cnt == 0 {
+			break
+		}
+	}
+
+	return ret, nil
+}
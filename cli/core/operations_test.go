@@ -237,3 +237,53 @@ func TestUpdateSystem(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveBookLabel(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "js",
+			expected: "js (2)",
+		},
+		{
+			input:    "css",
+			expected: "css (3)",
+		},
+		{
+			input:    "linux",
+			expected: "linux (4)",
+		},
+	}
+
+	for idx, tc := range testCases {
+		func() {
+			// set up
+			ctx := testutils.InitEnv(t, "../tmp", "../testutils/fixtures/schema.sql", true)
+			defer testutils.TeardownEnv(ctx)
+
+			db := ctx.DB
+
+			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "css (2)")
+			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b3-uuid", "linux (1)")
+			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b4-uuid", "linux (2)")
+			testutils.MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b5-uuid", "linux (3)")
+
+			// execute
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+			}
+
+			got, err := ResolveBookLabel(tx, tc.input)
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+			}
+			tx.Rollback()
+
+			testutils.AssertEqual(t, got, tc.expected, fmt.Sprintf("output mismatch for test case %d", idx))
+		}()
+	}
+}
@@ -0,0 +1,75 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package core
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dnote/color"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// StartPager sends the standard output through the pager of the profile until the returned
+// function is called. The returned function waits for the pager to exit. If no pager is set
+// or the output is not a terminal, the output is not changed.
+func StartPager(ctx infra.DnoteCtx) (func(), error) {
+	noop := func() {}
+
+	if ctx.Settings.Pager == "" || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return noop, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return noop, errors.Wrap(err, "creating a pipe")
+	}
+
+	args := strings.Fields(ctx.Settings.Pager)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return noop, errors.Wrap(err, "launching the pager")
+	}
+
+	stdout := os.Stdout
+	var colorOutput io.Writer = color.Output
+	os.Stdout = w
+	color.Output = w
+
+	stop := func() {
+		w.Close()
+		cmd.Wait()
+		r.Close()
+
+		os.Stdout = stdout
+		color.Output = colorOutput
+	}
+
+	return stop, nil
+}
@@ -0,0 +1,480 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultReleaseSource is the list of releases used if no source is configured
+	DefaultReleaseSource = "https://api.github.com/repos/dnote/dnote/releases?per_page=100"
+	// StableChannel is the release channel that does not include pre-releases
+	StableChannel = "stable"
+
+	// releaseTagPrefix is the prefix of the git tags of the cli releases
+	releaseTagPrefix = "cli-v"
+)
+
+// Release is a release of the cli in a release list. A release list has the format of the
+// GitHub releases API so that a mirror can serve a static copy of it.
+type Release struct {
+	TagName string         `json:"tag_name"`
+	Draft   bool           `json:"draft"`
+	Assets  []ReleaseAsset `json:"assets"`
+}
+
+// ReleaseAsset is a file attached to a release
+type ReleaseAsset struct {
+	Name string `json:"name"`
+	// URL is the location of the asset. It can be relative to the release list.
+	URL string `json:"browser_download_url"`
+}
+
+// Version returns the version of the release
+func (r Release) Version() string {
+	return strings.TrimPrefix(r.TagName, releaseTagPrefix)
+}
+
+// GetUpgradeSource returns the release list configured in the given settings
+func GetUpgradeSource(settings infra.Settings) string {
+	if settings.UpgradeSource != "" {
+		return settings.UpgradeSource
+	}
+
+	return DefaultReleaseSource
+}
+
+// GetUpgradeChannel returns the release channel configured in the given settings
+func GetUpgradeChannel(settings infra.Settings) string {
+	if settings.UpgradeChannel != "" {
+		return settings.UpgradeChannel
+	}
+
+	return StableChannel
+}
+
+type version struct {
+	major int
+	minor int
+	patch int
+	// pre is the pre-release identifier, such as 'beta.1', if any
+	pre string
+}
+
+var versionRegex = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?$`)
+
+func parseVersion(s string) (version, error) {
+	match := versionRegex.FindStringSubmatch(s)
+	if match == nil {
+		return version{}, errors.Errorf("invalid version %s", s)
+	}
+
+	// the regex guarantees that the parts are numeric
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	patch, _ := strconv.Atoi(match[3])
+
+	return version{major: major, minor: minor, patch: patch, pre: match[4]}, nil
+}
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+
+	return 0
+}
+
+// comparePre compares pre-release identifiers with the semver precedence rules
+func comparePre(a, b string) int {
+	if a == b {
+		return 0
+	}
+	// a release takes precedence over its pre-releases
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInt(aNum, bNum)
+		case aErr == nil:
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(aParts[i], bParts[i])
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return compareInt(len(aParts), len(bParts))
+}
+
+// compare returns -1 if v is lower than o, 1 if v is higher than o, and 0 if they are equal
+func (v version) compare(o version) int {
+	if c := compareInt(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.patch, o.patch); c != 0 {
+		return c
+	}
+
+	return comparePre(v.pre, o.pre)
+}
+
+// inChannel checks if a version is distributed in the given channel. The stable channel only
+// has releases, and any other channel, such as 'beta', also has the pre-releases whose
+// identifier starts with the channel name.
+func (v version) inChannel(channel string) bool {
+	if v.pre == "" {
+		return true
+	}
+	if channel == StableChannel {
+		return false
+	}
+
+	return strings.HasPrefix(v.pre, channel)
+}
+
+// IsNewerRelease checks if the given release is newer than the current version. It returns an
+// error if the current version cannot be compared, as in development builds.
+func IsNewerRelease(r Release, current string) (bool, error) {
+	rv, err := parseVersion(r.Version())
+	if err != nil {
+		return false, errors.Wrap(err, "parsing the release version")
+	}
+	cv, err := parseVersion(current)
+	if err != nil {
+		return false, errors.Wrap(err, "parsing the current version")
+	}
+
+	return rv.compare(cv) > 0, nil
+}
+
+// openSource opens the file at the given location, which is either a http(s) URL, or a file URL
+// or a path to a local file
+func openSource(u *url.URL) (io.ReadCloser, error) {
+	if u.Scheme == "" || u.Scheme == "file" {
+		f, err := os.Open(filepath.FromSlash(u.Path))
+		if err != nil {
+			return nil, errors.Wrap(err, "opening file")
+		}
+
+		return f, nil
+	}
+
+	res, err := http.Get(u.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "making http request")
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.Errorf("%s responded with %d", u.String(), res.StatusCode)
+	}
+
+	return res.Body, nil
+}
+
+// GetReleases fetches the list of releases from the given source
+func GetReleases(source string) ([]Release, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing source")
+	}
+
+	r, err := openSource(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching the release list")
+	}
+	defer r.Close()
+
+	var ret []Release
+	if err := json.NewDecoder(r).Decode(&ret); err != nil {
+		return nil, errors.Wrap(err, "decoding the release list")
+	}
+
+	return ret, nil
+}
+
+// SelectRelease returns the latest cli release in the given channel. The release list can
+// include drafts, and releases of other parts of the project, in any order.
+func SelectRelease(releases []Release, channel string) (Release, bool) {
+	var ret Release
+	var latest version
+	var found bool
+
+	for _, r := range releases {
+		if r.Draft || !strings.HasPrefix(r.TagName, releaseTagPrefix) {
+			continue
+		}
+
+		v, err := parseVersion(r.Version())
+		if err != nil || !v.inChannel(channel) {
+			continue
+		}
+
+		if !found || v.compare(latest) > 0 {
+			ret = r
+			latest = v
+			found = true
+		}
+	}
+
+	return ret, found
+}
+
+// downloadAsset downloads the asset with the given name in the release
+func downloadAsset(source string, r Release, name string) ([]byte, error) {
+	var asset *ReleaseAsset
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			asset = &r.Assets[i]
+		}
+	}
+	if asset == nil {
+		return nil, errors.Errorf("release %s does not have %s", r.Version(), name)
+	}
+
+	base, err := url.Parse(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing source")
+	}
+	ref, err := url.Parse(asset.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing asset url")
+	}
+
+	rc, err := openSource(base.ResolveReference(ref))
+	if err != nil {
+		return nil, errors.Wrapf(err, "downloading %s", name)
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", name)
+	}
+
+	return b, nil
+}
+
+// verifySignature verifies the base64 encoded ed25519 signature of the message
+func verifySignature(publicKey string, message, sig []byte) error {
+	if publicKey == "" {
+		return errors.New("this build does not have a key to verify releases")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	sigDec, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return errors.Wrap(err, "decoding signature")
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), message, sigDec) {
+		return errors.New("signature does not match")
+	}
+
+	return nil
+}
+
+// findChecksum finds the hex encoded sha256 checksum of the file with the given name in a
+// checksum file with the format of the shasum command
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.Wrap(err, "reading checksums")
+	}
+
+	return "", errors.Errorf("checksum for %s not found", name)
+}
+
+// extractBinary reads the file with the given name from a gzipped tarball
+func extractBinary(tarball []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing")
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "reading archive")
+		}
+
+		if h.Typeflag == tar.TypeReg && path.Base(h.Name) == name {
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading %s", h.Name)
+			}
+
+			return b, nil
+		}
+	}
+
+	return nil, errors.Errorf("%s not found in the archive", name)
+}
+
+// DownloadRelease downloads the binary of the given release for the platform, and verifies it
+// against the checksum file, whose signature is in turn verified with the given public key.
+func DownloadRelease(source string, r Release, goos, goarch, publicKey string) ([]byte, error) {
+	v := r.Version()
+	buildName := fmt.Sprintf("dnote_%s_%s_%s", v, goos, goarch)
+	checksumName := fmt.Sprintf("dnote_%s_checksums.txt", v)
+
+	checksums, err := downloadAsset(source, r, checksumName)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting checksums")
+	}
+	sig, err := downloadAsset(source, r, checksumName+".sig")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the signature of checksums")
+	}
+	if err := verifySignature(publicKey, checksums, sig); err != nil {
+		return nil, errors.Wrap(err, "verifying checksums")
+	}
+
+	tarball, err := downloadAsset(source, r, buildName+".tar.gz")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the archive")
+	}
+
+	binName := "dnote"
+	if goos == "windows" {
+		binName = "dnote.exe"
+	}
+	bin, err := extractBinary(tarball, binName)
+	if err != nil {
+		return nil, errors.Wrap(err, "extracting the binary")
+	}
+
+	want, err := findChecksum(checksums, buildName)
+	if err != nil {
+		return nil, err
+	}
+	got := sha256.Sum256(bin)
+	if hex.EncodeToString(got[:]) != strings.ToLower(want) {
+		return nil, errors.Errorf("checksum mismatch for %s", buildName)
+	}
+
+	return bin, nil
+}
+
+// ReplaceExecutable replaces the executable at the given path with the given binary. The
+// binary is written next to the executable and renamed over it so that the executable is
+// never partially written.
+func ReplaceExecutable(exePath string, bin []byte) error {
+	dir := filepath.Dir(exePath)
+
+	tmp, err := ioutil.TempFile(dir, ".dnote-upgrade-")
+	if err != nil {
+		return errors.Wrap(err, "creating a temporary file")
+	}
+	tmpPath := tmp.Name()
+	// a no-op once the file is renamed
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "writing the binary")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "flushing the binary to disk")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "closing the temporary file")
+	}
+	if err := os.Chmod(tmpPath, 0755); err != nil {
+		return errors.Wrap(err, "making the binary executable")
+	}
+
+	// Windows does not allow replacing a running executable, but allows renaming it
+	if runtime.GOOS == "windows" {
+		oldPath := exePath + ".old"
+		os.Remove(oldPath)
+
+		if err := os.Rename(exePath, oldPath); err != nil {
+			return errors.Wrap(err, "moving the current executable")
+		}
+	}
+
+	if err := os.Rename(tmpPath, exePath); err != nil {
+		return errors.Wrap(err, "replacing the executable")
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestVersionCompare(t *testing.T) {
+	testCases := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"0.4.8", "0.4.8", 0},
+		{"0.4.8", "0.4.9", -1},
+		{"0.10.0", "0.9.1", 1},
+		{"0.5.0", "0.5.0-beta.1", 1},
+		{"0.5.0-beta.1", "0.5.0-beta.2", -1},
+		{"0.5.0-beta.2", "0.5.0-beta.10", -1},
+		{"0.5.0-alpha", "0.5.0-beta", -1},
+		{"0.5.0-beta", "0.5.0-beta.1", -1},
+		{"0.5.0-rc.1", "0.5.0-beta.3", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.a, tc.b), func(t *testing.T) {
+			a, err := parseVersion(tc.a)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "parsing a"))
+			}
+			b, err := parseVersion(tc.b)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "parsing b"))
+			}
+
+			testutils.AssertEqual(t, a.compare(b), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestSelectRelease(t *testing.T) {
+	releases := []Release{
+		{TagName: "cli-v0.4.9"},
+		{TagName: "server-v1.2.0"},
+		{TagName: "cli-v0.5.0-beta.1"},
+		{TagName: "cli-v0.6.0", Draft: true},
+		{TagName: "cli-v0.4.10"},
+		{TagName: "cli-v0.5.0-rc.1"},
+		{TagName: "cli-vfoo"},
+	}
+
+	testCases := []struct {
+		channel  string
+		expected string
+	}{
+		{
+			channel:  StableChannel,
+			expected: "0.4.10",
+		},
+		{
+			channel:  "beta",
+			expected: "0.5.0-beta.1",
+		},
+		{
+			channel:  "rc",
+			expected: "0.5.0-rc.1",
+		},
+		{
+			channel:  "alpha",
+			expected: "0.4.10",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.channel, func(t *testing.T) {
+			got, ok := SelectRelease(releases, tc.channel)
+
+			testutils.AssertEqual(t, ok, true, "ok mismatch")
+			testutils.AssertEqual(t, got.Version(), tc.expected, "version mismatch")
+		})
+	}
+
+	t.Run("no release", func(t *testing.T) {
+		_, ok := SelectRelease([]Release{{TagName: "server-v1.2.0"}}, StableChannel)
+
+		testutils.AssertEqual(t, ok, false, "ok mismatch")
+	})
+}
+
+func TestIsNewerRelease(t *testing.T) {
+	r := Release{TagName: "cli-v0.5.0"}
+
+	isNewer, err := IsNewerRelease(r, "0.4.9")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "comparing with an older version"))
+	}
+	testutils.AssertEqual(t, isNewer, true, "result mismatch for an older version")
+
+	isNewer, err = IsNewerRelease(r, "0.5.0")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "comparing with the same version"))
+	}
+	testutils.AssertEqual(t, isNewer, false, "result mismatch for the same version")
+
+	if _, err := IsNewerRelease(r, "master"); err == nil {
+		t.Error("expected an error for a development build")
+	}
+}
+
+// releaseMirror is a local mirror of a release used in tests
+type releaseMirror struct {
+	dir       string
+	source    string
+	publicKey string
+}
+
+func makeTarball(t *testing.T, name string, content []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	files := map[string][]byte{
+		"./README.md": []byte("readme"),
+		name:          content,
+	}
+	for fname, b := range files {
+		h := &tar.Header{Name: fname, Mode: 0755, Size: int64(len(b)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(errors.Wrap(err, "writing tar header"))
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(errors.Wrap(err, "writing tar content"))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing tar"))
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing gzip"))
+	}
+
+	return buf.Bytes()
+}
+
+// setupReleaseMirror writes a release list with a release of the given version for linux/amd64
+// into a temporary directory. checksumBin is the binary whose checksum is published.
+func setupReleaseMirror(t *testing.T, v string, bin, checksumBin []byte) releaseMirror {
+	dir, err := ioutil.TempDir("", "dnote-release")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating a temporary directory"))
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "generating a key"))
+	}
+
+	buildName := fmt.Sprintf("dnote_%s_linux_amd64", v)
+	checksumName := fmt.Sprintf("dnote_%s_checksums.txt", v)
+
+	sum := sha256.Sum256(checksumBin)
+	checksums := []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), buildName))
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, checksums))
+
+	files := map[string][]byte{
+		buildName + ".tar.gz": makeTarball(t, "./dnote", bin),
+		checksumName:          checksums,
+		checksumName + ".sig": []byte(sig + "\n"),
+	}
+
+	var assets []ReleaseAsset
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(errors.Wrapf(err, "writing %s", name))
+		}
+
+		assets = append(assets, ReleaseAsset{Name: name, URL: name})
+	}
+
+	releases := []Release{{TagName: releaseTagPrefix + v, Assets: assets}}
+	if err := ioutil.WriteFile(filepath.Join(dir, "releases.json"), testutils.MustMarshalJSON(t, releases), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the release list"))
+	}
+
+	return releaseMirror{
+		dir:       dir,
+		source:    "file://" + filepath.ToSlash(filepath.Join(dir, "releases.json")),
+		publicKey: base64.StdEncoding.EncodeToString(publicKey),
+	}
+}
+
+func TestDownloadRelease(t *testing.T) {
+	bin := []byte("dnote 0.5.0")
+
+	t.Run("valid", func(t *testing.T) {
+		m := setupReleaseMirror(t, "0.5.0", bin, bin)
+		defer os.RemoveAll(m.dir)
+
+		releases, err := GetReleases(m.source)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting releases"))
+		}
+		r, ok := SelectRelease(releases, StableChannel)
+		testutils.AssertEqual(t, ok, true, "ok mismatch")
+
+		got, err := DownloadRelease(m.source, r, "linux", "amd64", m.publicKey)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "downloading"))
+		}
+
+		testutils.AssertDeepEqual(t, got, bin, "binary mismatch")
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		m := setupReleaseMirror(t, "0.5.0", bin, []byte("something else"))
+		defer os.RemoveAll(m.dir)
+
+		releases, err := GetReleases(m.source)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting releases"))
+		}
+
+		if _, err := DownloadRelease(m.source, releases[0], "linux", "amd64", m.publicKey); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("signature mismatch", func(t *testing.T) {
+		m := setupReleaseMirror(t, "0.5.0", bin, bin)
+		defer os.RemoveAll(m.dir)
+
+		otherKey, _, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "generating a key"))
+		}
+		releases, err := GetReleases(m.source)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting releases"))
+		}
+
+		if _, err := DownloadRelease(m.source, releases[0], "linux", "amd64", base64.StdEncoding.EncodeToString(otherKey)); err == nil {
+			t.Error("expected an error for a wrong key")
+		}
+		if _, err := DownloadRelease(m.source, releases[0], "linux", "amd64", ""); err == nil {
+			t.Error("expected an error for a missing key")
+		}
+	})
+
+	t.Run("unsupported platform", func(t *testing.T) {
+		m := setupReleaseMirror(t, "0.5.0", bin, bin)
+		defer os.RemoveAll(m.dir)
+
+		releases, err := GetReleases(m.source)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting releases"))
+		}
+
+		if _, err := DownloadRelease(m.source, releases[0], "plan9", "amd64", m.publicKey); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	// set up
+	dir, err := ioutil.TempDir("", "dnote-upgrade")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating a temporary directory"))
+	}
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "dnote")
+	if err := ioutil.WriteFile(exePath, []byte("old"), 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the executable"))
+	}
+
+	// execute
+	if err := ReplaceExecutable(exePath, []byte("new")); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	b, err := ioutil.ReadFile(exePath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the executable"))
+	}
+	testutils.AssertEqual(t, string(b), "new", "content mismatch")
+
+	fi, err := os.Stat(exePath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting file info"))
+	}
+	testutils.AssertEqual(t, fi.Mode().Perm(), os.FileMode(0755), "mode mismatch")
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the directory"))
+	}
+	testutils.AssertEqual(t, len(files), 1, "temporary files should be removed")
+}
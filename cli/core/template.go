@@ -0,0 +1,147 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/pkg/errors"
+)
+
+var (
+	// TemplatesDirName is the name of the directory in the config directory that has the templates
+	TemplatesDirName = "templates"
+	// templateExt is the extension of the template files
+	templateExt = ".md"
+)
+
+var templateNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TemplateData is the data available to the templates
+type TemplateData struct {
+	// Date is the current date in the format of 2006-01-02
+	Date string
+	// Book is the book to which the note is added
+	Book string
+	// GitBranch is the git branch of the working directory. It is empty if the working
+	// directory is not in a git repository.
+	GitBranch string
+}
+
+// NewTemplateData returns the data for the templates of a note added to the given book
+func NewTemplateData(book string) TemplateData {
+	return TemplateData{
+		Date:      time.Now().Format("2006-01-02"),
+		Book:      book,
+		GitBranch: getGitBranch(),
+	}
+}
+
+func getGitBranch() string {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// GetTemplatesDir returns the path to the directory having the templates
+func GetTemplatesDir(ctx infra.DnoteCtx) string {
+	return fmt.Sprintf("%s/%s", ctx.ConfigDir, TemplatesDirName)
+}
+
+// GetTemplatePath returns the path to the template with the given name
+func GetTemplatePath(ctx infra.DnoteCtx, name string) (string, error) {
+	if !templateNameRegex.MatchString(name) {
+		return "", errors.Errorf("invalid template name '%s'", name)
+	}
+
+	return fmt.Sprintf("%s/%s%s", GetTemplatesDir(ctx), name, templateExt), nil
+}
+
+// ListTemplates returns the sorted names of the templates
+func ListTemplates(ctx infra.DnoteCtx) ([]string, error) {
+	ret := []string{}
+
+	files, err := ioutil.ReadDir(GetTemplatesDir(ctx))
+	if os.IsNotExist(err) {
+		return ret, nil
+	} else if err != nil {
+		return ret, errors.Wrap(err, "reading the templates directory")
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || filepath.Ext(name) != templateExt {
+			continue
+		}
+
+		ret = append(ret, strings.TrimSuffix(name, templateExt))
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+// GetBookTemplate returns the name of the default template of the given book. It returns an
+// empty string if the book has none.
+func GetBookTemplate(ctx infra.DnoteCtx, book string) string {
+	return ctx.Settings.BookTemplates[book]
+}
+
+// ExpandTemplate returns the content of the template with the given name, having the
+// variables replaced with the given data
+func ExpandTemplate(ctx infra.DnoteCtx, name string, data TemplateData) (string, error) {
+	path, err := GetTemplatePath(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", errors.Errorf("template '%s' not found", name)
+	} else if err != nil {
+		return "", errors.Wrap(err, "reading the template")
+	}
+
+	tmpl, err := template.New(name).Parse(string(b))
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing the template '%s'", name)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "expanding the template '%s'", name)
+	}
+
+	return buf.String(), nil
+}
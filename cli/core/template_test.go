@@ -0,0 +1,93 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/testutils"
+)
+
+func writeTemplate(t *testing.T, ctx infra.DnoteCtx, filename, content string) {
+	dir := GetTemplatesDir(ctx)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, filename), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListTemplates(t *testing.T) {
+	// Setup
+	ctx := testutils.InitEnv(t, "../tmp", "../testutils/fixtures/schema.sql", true)
+	defer testutils.TeardownEnv(ctx)
+
+	names, err := ListTemplates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertDeepEqual(t, names, []string{}, "names mismatch without the templates directory")
+
+	writeTemplate(t, ctx, "til.md", "")
+	writeTemplate(t, ctx, "incident.md", "")
+	writeTemplate(t, ctx, "notes.txt", "")
+
+	// Execute
+	names, err = ListTemplates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test
+	testutils.AssertDeepEqual(t, names, []string{"incident", "til"}, "names mismatch")
+}
+
+func TestExpandTemplate(t *testing.T) {
+	// Setup
+	ctx := testutils.InitEnv(t, "../tmp", "../testutils/fixtures/schema.sql", true)
+	defer testutils.TeardownEnv(ctx)
+
+	writeTemplate(t, ctx, "incident.md", "# Incident {{.Date}}\n\nbook: {{.Book}}, branch: {{.GitBranch}}")
+	writeTemplate(t, ctx, "broken.md", "{{.Unknown}}")
+
+	data := TemplateData{Date: "2019-01-02", Book: "postmortems", GitBranch: "master"}
+
+	t.Run("valid", func(t *testing.T) {
+		got, err := ExpandTemplate(ctx, "incident", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutils.AssertEqual(t, got, "# Incident 2019-01-02\n\nbook: postmortems, branch: master", "content mismatch")
+	})
+
+	for _, name := range []string{"broken", "nonexistent", "../incident"} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ExpandTemplate(ctx, name, data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
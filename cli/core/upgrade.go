@@ -19,14 +19,12 @@
 package core
 
 import (
-	"context"
 	"fmt"
 	"time"
 
 	"github.com/dnote/dnote/cli/infra"
 	"github.com/dnote/dnote/cli/log"
 	"github.com/dnote/dnote/cli/utils"
-	"github.com/google/go-github/github"
 	"github.com/pkg/errors"
 )
 
@@ -64,21 +62,22 @@ func checkVersion(ctx infra.DnoteCtx) error {
 	log.Infof("current version is %s\n", ctx.Version)
 
 	// Fetch the latest version
-	gh := github.NewClient(nil)
-	releases, _, err := gh.Repositories.ListReleases(context.Background(), "dnote", "cli", nil)
+	releases, err := GetReleases(GetUpgradeSource(ctx.Settings))
 	if err != nil {
 		return errors.Wrap(err, "fetching releases")
 	}
+	latest, ok := SelectRelease(releases, GetUpgradeChannel(ctx.Settings))
+	if !ok {
+		return errors.New("no release found")
+	}
 
-	latest := releases[0]
-	latestVersion := (*latest.TagName)[1:]
-
-	log.Infof("latest version is %s\n", latestVersion)
+	log.Infof("latest version is %s\n", latest.Version())
 
-	if latestVersion == ctx.Version {
+	isNewer, err := IsNewerRelease(latest, ctx.Version)
+	if err == nil && !isNewer {
 		log.Success("you are up-to-date\n\n")
 	} else {
-		log.Infof("to upgrade, see https://github.com/dnote/dnote/cli/blob/master/README.md\n")
+		log.Infof("to upgrade, run 'dnote upgrade'\n")
 	}
 
 	return nil
@@ -24,8 +24,10 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base32"
 	"encoding/base64"
 	"io"
+	"strings"
 
 	"github.com/dnote/dnote/cli/log"
 	"github.com/pkg/errors"
@@ -61,6 +63,89 @@ func MakeKeys(password, email []byte, iteration int) ([]byte, []byte, error) {
 	return masterKey, authKey, nil
 }
 
+// MakeCipherKey generates a random cipher key used for encrypting the user data
+func MakeCipherKey() ([]byte, error) {
+	ret := make([]byte, 32)
+	if _, err := rand.Read(ret); err != nil {
+		return nil, errors.Wrap(err, "reading random bytes")
+	}
+
+	return ret, nil
+}
+
+// recoveryKeyEncoding is the encoding used for presenting recovery keys to the user
+var recoveryKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// recoveryKeyGroupSize is the number of characters in each group of an encoded recovery key
+var recoveryKeyGroupSize = 4
+
+// MakeRecoveryKey generates a random recovery key
+func MakeRecoveryKey() ([]byte, error) {
+	ret := make([]byte, 32)
+	if _, err := rand.Read(ret); err != nil {
+		return nil, errors.Wrap(err, "reading random bytes")
+	}
+
+	return ret, nil
+}
+
+// EncodeRecoveryKey encodes the recovery key in a form that is easy to write down,
+// comprising of groups of base32 characters separated by dashes
+func EncodeRecoveryKey(key []byte) string {
+	encoded := recoveryKeyEncoding.EncodeToString(key)
+
+	var groups []string
+	for i := 0; i < len(encoded); i += recoveryKeyGroupSize {
+		end := i + recoveryKeyGroupSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+
+		groups = append(groups, encoded[i:end])
+	}
+
+	return strings.Join(groups, "-")
+}
+
+// DecodeRecoveryKey decodes the recovery key encoded by EncodeRecoveryKey. It tolerates
+// lowercase letters and whitespaces.
+func DecodeRecoveryKey(s string) ([]byte, error) {
+	s = strings.ToUpper(s)
+	s = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\n' {
+			return -1
+		}
+
+		return r
+	}, s)
+
+	ret, err := recoveryKeyEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding base32")
+	}
+	if len(ret) != 32 {
+		return nil, errors.Errorf("invalid recovery key length %d", len(ret))
+	}
+
+	return ret, nil
+}
+
+// MakeRecoveryKeys derives, from the recovery key, a key set comprising of an encryption key
+// used for wrapping the cipher key and an authentication key
+func MakeRecoveryKeys(recoveryKey []byte) ([]byte, []byte, error) {
+	encKey, err := runHkdf(recoveryKey, nil, []byte("recovery-enc"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "deriving encryption key")
+	}
+
+	authKey, err := runHkdf(recoveryKey, nil, []byte("recovery-auth"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "deriving auth key")
+	}
+
+	return encKey, authKey, nil
+}
+
 // AesGcmEncrypt encrypts the plaintext using AES in a GCM mode. It returns
 // a ciphertext prepended by a 12 byte pseudo-random nonce, encoded in base64.
 func AesGcmEncrypt(key, plaintext []byte) (string, error) {
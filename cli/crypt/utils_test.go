@@ -23,6 +23,7 @@ import (
 	"crypto/cipher"
 	"encoding/base64"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/dnote/dnote/cli/testutils"
@@ -116,3 +117,66 @@ func TestAesGcmDecrypt(t *testing.T) {
 		})
 	}
 }
+
+func TestRecoveryKeyEncoding(t *testing.T) {
+	key := []byte("AES256Key-32Characters1234567890")
+	encoded := EncodeRecoveryKey(key)
+
+	testCases := []struct {
+		input string
+	}{
+		{
+			input: encoded,
+		},
+		{
+			input: strings.ToLower(encoded),
+		},
+		{
+			input: strings.Replace(encoded, "-", " ", -1),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %s", tc.input), func(t *testing.T) {
+			decoded, err := DecodeRecoveryKey(tc.input)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "decoding recovery key"))
+			}
+
+			testutils.AssertDeepEqual(t, decoded, key, "decoded key mismatch")
+		})
+	}
+
+	t.Run("invalid length", func(t *testing.T) {
+		_, err := DecodeRecoveryKey("ABCD-EFGH")
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestMakeRecoveryKeys(t *testing.T) {
+	key := []byte("AES256Key-32Characters1234567890")
+
+	encKey, authKey, err := MakeRecoveryKeys(key)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making recovery keys"))
+	}
+
+	testutils.AssertEqual(t, len(encKey), 32, "encKey length mismatch")
+	testutils.AssertEqual(t, len(authKey), 32, "authKey length mismatch")
+	testutils.AssertNotEqual(t, string(encKey), string(authKey), "keys should differ")
+
+	// wrap and unwrap a cipher key
+	cipherKey := []byte("AES256Key-32Charactersabcdefghij")
+	wrapped, err := AesGcmEncrypt(encKey, cipherKey)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "wrapping cipher key"))
+	}
+	unwrapped, err := AesGcmDecrypt(encKey, wrapped)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "unwrapping cipher key"))
+	}
+
+	testutils.AssertDeepEqual(t, unwrapped, cipherKey, "unwrapped cipher key mismatch")
+}
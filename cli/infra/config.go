@@ -0,0 +1,224 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ConfigFilename is the name of the config file in the config directory
+	ConfigFilename = "dnoterc"
+	// DefaultProfile is the profile whose data is stored at the root of the data directory
+	DefaultProfile = "default"
+	// ProfilesDirName is the name of the directory in the data directory that has the data
+	// of the profiles other than the default profile
+	ProfilesDirName = "profiles"
+)
+
+var profileNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Settings are options that can be set for all profiles and overridden for each profile
+type Settings struct {
+	Editor      string `yaml:"editor,omitempty"`
+	APIEndpoint string `yaml:"api_endpoint,omitempty"`
+	// Color enables or disables colored output. If not set, it is enabled for terminals.
+	Color *bool `yaml:"color,omitempty"`
+	// Pager is the command through which long outputs are shown
+	Pager string `yaml:"pager,omitempty"`
+	// DefaultBook is the book to which notes are added if no book is given
+	DefaultBook string `yaml:"default_book,omitempty"`
+	// BookTemplates maps books to the templates used for the notes added to them
+	BookTemplates map[string]string `yaml:"book_templates,omitempty"`
+	// SyncInterval is a duration, such as '1h', after which a change triggers a sync
+	SyncInterval string `yaml:"sync_interval,omitempty"`
+	// UpgradeSource is the location of the release list used to upgrade dnote
+	UpgradeSource string `yaml:"upgrade_source,omitempty"`
+	// UpgradeChannel is the release channel, such as 'stable' or 'beta', used to upgrade dnote
+	UpgradeChannel string `yaml:"upgrade_channel,omitempty"`
+}
+
+// Config holds dnote configuration
+type Config struct {
+	Settings `yaml:",inline"`
+	// Profile is the profile used if none is given
+	Profile  string              `yaml:"profile,omitempty"`
+	Profiles map[string]Settings `yaml:"profiles,omitempty"`
+}
+
+// GetSyncInterval returns the parsed sync interval. It returns 0 if no interval is set.
+func (s Settings) GetSyncInterval() (time.Duration, error) {
+	if s.SyncInterval == "" {
+		return 0, nil
+	}
+
+	ret, err := time.ParseDuration(s.SyncInterval)
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing sync_interval")
+	}
+
+	return ret, nil
+}
+
+// merge returns the settings overridden by the non-empty options in o
+func (s Settings) merge(o Settings) Settings {
+	ret := s
+
+	strs := []struct {
+		dest *string
+		val  string
+	}{
+		{&ret.Editor, o.Editor},
+		{&ret.APIEndpoint, o.APIEndpoint},
+		{&ret.Pager, o.Pager},
+		{&ret.DefaultBook, o.DefaultBook},
+		{&ret.SyncInterval, o.SyncInterval},
+		{&ret.UpgradeSource, o.UpgradeSource},
+		{&ret.UpgradeChannel, o.UpgradeChannel},
+	}
+	for _, f := range strs {
+		if f.val != "" {
+			*f.dest = f.val
+		}
+	}
+
+	if o.Color != nil {
+		ret.Color = o.Color
+	}
+
+	if len(o.BookTemplates) > 0 {
+		templates := map[string]string{}
+		for book, name := range ret.BookTemplates {
+			templates[book] = name
+		}
+		for book, name := range o.BookTemplates {
+			templates[book] = name
+		}
+
+		ret.BookTemplates = templates
+	}
+
+	return ret
+}
+
+// fromEnv returns the settings overridden by the environment variables
+func (s Settings) fromEnv() (Settings, error) {
+	ret := s.merge(Settings{
+		Editor:         os.Getenv("DNOTE_EDITOR"),
+		APIEndpoint:    os.Getenv("DNOTE_API_ENDPOINT"),
+		Pager:          os.Getenv("DNOTE_PAGER"),
+		DefaultBook:    os.Getenv("DNOTE_DEFAULT_BOOK"),
+		SyncInterval:   os.Getenv("DNOTE_SYNC_INTERVAL"),
+		UpgradeSource:  os.Getenv("DNOTE_UPGRADE_SOURCE"),
+		UpgradeChannel: os.Getenv("DNOTE_UPGRADE_CHANNEL"),
+	})
+
+	if v := os.Getenv("DNOTE_COLOR"); v != "" {
+		color, err := strconv.ParseBool(v)
+		if err != nil {
+			return ret, errors.Wrap(err, "parsing DNOTE_COLOR")
+		}
+
+		ret.Color = &color
+	}
+	// https://no-color.org
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		color := false
+		ret.Color = &color
+	}
+
+	return ret, nil
+}
+
+// Resolve returns the name of the profile to use and its settings. If the given profile is
+// empty, the profile in the config is used. The settings of the profile override the ones
+// for all profiles, and the environment variables override both.
+func (c Config) Resolve(profile string) (string, Settings, error) {
+	if profile == "" {
+		profile = c.Profile
+	}
+	if profile == "" {
+		profile = DefaultProfile
+	}
+
+	ret := c.Settings
+
+	if profile != DefaultProfile {
+		if !profileNameRegex.MatchString(profile) {
+			return "", ret, errors.Errorf("invalid profile name %q", profile)
+		}
+
+		p, ok := c.Profiles[profile]
+		if !ok {
+			return "", ret, errors.Errorf("profile %q is not defined in the config", profile)
+		}
+
+		ret = ret.merge(p)
+	}
+
+	ret, err := ret.fromEnv()
+	if err != nil {
+		return "", ret, errors.Wrap(err, "reading environment variables")
+	}
+
+	if _, err := ret.GetSyncInterval(); err != nil {
+		return "", ret, err
+	}
+
+	return profile, ret, nil
+}
+
+// GetConfigPath returns the path to the config file in the given config directory
+func GetConfigPath(configDir string) string {
+	return fmt.Sprintf("%s/%s", configDir, ConfigFilename)
+}
+
+// GetProfileDir returns the directory in the given data directory that has the data of the
+// given profile
+func GetProfileDir(dataDir, profile string) string {
+	if profile == DefaultProfile {
+		return dataDir
+	}
+
+	return fmt.Sprintf("%s/%s/%s", dataDir, ProfilesDirName, profile)
+}
+
+// ReadConfig reads the config file in the given config directory
+func ReadConfig(configDir string) (Config, error) {
+	var ret Config
+
+	b, err := ioutil.ReadFile(GetConfigPath(configDir))
+	if err != nil {
+		return ret, errors.Wrap(err, "reading config file")
+	}
+
+	if err := yaml.Unmarshal(b, &ret); err != nil {
+		return ret, errors.Wrap(err, "unmarshalling config")
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,194 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestConfigResolve(t *testing.T) {
+	content := `
+editor: vim
+api_endpoint: https://api.dnote.io
+profile: work
+profiles:
+  work:
+    api_endpoint: https://dnote.example.com/api
+    default_book: work
+    color: false
+  personal:
+    sync_interval: 1h
+`
+	var config Config
+	if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		profile          string
+		expectedProfile  string
+		expectedSettings Settings
+	}{
+		{
+			profile:         "",
+			expectedProfile: "work",
+			expectedSettings: Settings{
+				Editor:      "vim",
+				APIEndpoint: "https://dnote.example.com/api",
+				DefaultBook: "work",
+				Color:       boolPtr(false),
+			},
+		},
+		{
+			profile:         "personal",
+			expectedProfile: "personal",
+			expectedSettings: Settings{
+				Editor:       "vim",
+				APIEndpoint:  "https://api.dnote.io",
+				SyncInterval: "1h",
+			},
+		},
+		{
+			profile:         "default",
+			expectedProfile: "default",
+			expectedSettings: Settings{
+				Editor:      "vim",
+				APIEndpoint: "https://api.dnote.io",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.profile, func(t *testing.T) {
+			profile, settings, err := config.Resolve(tc.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if profile != tc.expectedProfile {
+				t.Errorf("profile mismatch. Expected %s. Got %s", tc.expectedProfile, profile)
+			}
+			if !reflect.DeepEqual(settings, tc.expectedSettings) {
+				t.Errorf("settings mismatch. Expected %+v. Got %+v", tc.expectedSettings, settings)
+			}
+		})
+	}
+}
+
+func TestConfigResolve_env(t *testing.T) {
+	os.Setenv("DNOTE_EDITOR", "nano")
+	os.Setenv("NO_COLOR", "")
+	defer os.Unsetenv("DNOTE_EDITOR")
+	defer os.Unsetenv("NO_COLOR")
+
+	config := Config{Settings: Settings{Editor: "vim", Color: boolPtr(true)}}
+
+	_, settings, err := config.Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Settings{Editor: "nano", Color: boolPtr(false)}
+	if !reflect.DeepEqual(settings, expected) {
+		t.Errorf("settings mismatch. Expected %+v. Got %+v", expected, settings)
+	}
+}
+
+func TestConfigResolve_invalid(t *testing.T) {
+	config := Config{
+		Profiles: map[string]Settings{
+			"work":  {},
+			"other": {SyncInterval: "soon"},
+		},
+	}
+
+	for _, profile := range []string{"personal", "../work", "other"} {
+		t.Run(profile, func(t *testing.T) {
+			if _, _, err := config.Resolve(profile); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestGetProfileDir(t *testing.T) {
+	if got := GetProfileDir("/home/user/.dnote", DefaultProfile); got != "/home/user/.dnote" {
+		t.Errorf("unexpected default profile dir %s", got)
+	}
+	if got := GetProfileDir("/home/user/.dnote", "work"); got != "/home/user/.dnote/profiles/work" {
+		t.Errorf("unexpected profile dir %s", got)
+	}
+}
+
+func TestGetDirs(t *testing.T) {
+	os.Setenv("DNOTE_HOME_DIR", "/home/user")
+	os.Setenv("XDG_CONFIG_HOME", "/xdg/config")
+	os.Setenv("XDG_DATA_HOME", "relative/data")
+	os.Unsetenv("XDG_CACHE_HOME")
+	defer os.Unsetenv("DNOTE_HOME_DIR")
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	t.Run("xdg", func(t *testing.T) {
+		dirs, err := GetDirs()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := Dirs{
+			Home:   "/home/user",
+			Legacy: "/home/user/.dnote",
+			Config: "/xdg/config/dnote",
+			Data:   "/home/user/.local/share/dnote",
+			Cache:  "/home/user/.cache/dnote",
+		}
+		if !reflect.DeepEqual(dirs, expected) {
+			t.Errorf("dirs mismatch. Expected %+v. Got %+v", expected, dirs)
+		}
+	})
+
+	t.Run("DNOTE_DIR", func(t *testing.T) {
+		os.Setenv("DNOTE_DIR", "/dnote")
+		defer os.Unsetenv("DNOTE_DIR")
+
+		dirs, err := GetDirs()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := Dirs{
+			Home:   "/home/user",
+			Legacy: "/dnote",
+			Config: "/dnote",
+			Data:   "/dnote",
+			Cache:  "/dnote",
+		}
+		if !reflect.DeepEqual(dirs, expected) {
+			t.Errorf("dirs mismatch. Expected %+v. Got %+v", expected, dirs)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// DnoteDirName is the name of the directory in the home directory that had all dnote
+	// files before the XDG base directories were used
+	DnoteDirName = ".dnote"
+	// XDGDirName is the name of the directory for dnote in each XDG base directory
+	XDGDirName = "dnote"
+)
+
+// Dirs are the directories in which dnote keeps its files
+type Dirs struct {
+	Home string
+	// Legacy is the directory that had all dnote files before the XDG base directories
+	// were used
+	Legacy string
+	Config string
+	Data   string
+	Cache  string
+}
+
+// GetDirs returns the directories of dnote. If DNOTE_DIR is set, all files are kept in it.
+// Otherwise, the directories follow the XDG base directory specification.
+func GetDirs() (Dirs, error) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return Dirs{}, errors.Wrap(err, "Failed to get home dir")
+	}
+
+	if dnoteDirEnv := os.Getenv("DNOTE_DIR"); dnoteDirEnv != "" {
+		ret := Dirs{
+			Home:   homeDir,
+			Legacy: dnoteDirEnv,
+			Config: dnoteDirEnv,
+			Data:   dnoteDirEnv,
+			Cache:  dnoteDirEnv,
+		}
+
+		return ret, nil
+	}
+
+	ret := Dirs{
+		Home:   homeDir,
+		Legacy: fmt.Sprintf("%s/%s", homeDir, DnoteDirName),
+		Config: getXDGDir("XDG_CONFIG_HOME", fmt.Sprintf("%s/.config", homeDir)),
+		Data:   getXDGDir("XDG_DATA_HOME", fmt.Sprintf("%s/.local/share", homeDir)),
+		Cache:  getXDGDir("XDG_CACHE_HOME", fmt.Sprintf("%s/.cache", homeDir)),
+	}
+
+	return ret, nil
+}
+
+// getXDGDir returns the dnote directory in the base directory given by the environment
+// variable. The specification requires the base directory to be absolute, and a relative
+// one is ignored in favor of the default.
+func getXDGDir(env, defaultBase string) string {
+	base := os.Getenv(env)
+	if base == "" || !filepath.IsAbs(base) {
+		base = defaultBase
+	}
+
+	return fmt.Sprintf("%s/%s", base, XDGDirName)
+}
+
+func getHomeDir() (string, error) {
+	homeDirEnv := os.Getenv("DNOTE_HOME_DIR")
+	if homeDirEnv != "" {
+		return homeDirEnv, nil
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to get current user")
+	}
+
+	return usr.HomeDir, nil
+}
@@ -24,7 +24,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
-	"os/user"
 
 	// use sqlite
 	_ "github.com/mattn/go-sqlite3"
@@ -33,9 +32,6 @@ import (
 )
 
 var (
-	// DnoteDirName is the name of the directory containing dnote files
-	DnoteDirName = ".dnote"
-
 	// SystemSchema is the key for schema in the system table
 	SystemSchema = "schema"
 	// SystemRemoteSchema is the key for remote schema in the system table
@@ -52,45 +48,77 @@ var (
 	SystemSessionKey = "session_token"
 	// SystemSessionKeyExpiry is the timestamp at which the session key will expire
 	SystemSessionKeyExpiry = "session_token_expiry"
+	// SystemRefreshToken is the token used to renew the session when it expires
+	SystemRefreshToken = "refresh_token"
 )
 
 // DnoteCtx is a context holding the information of the current runtime
 type DnoteCtx struct {
-	HomeDir          string
-	DnoteDir         string
+	HomeDir string
+	// ConfigDir is the directory having the config file
+	ConfigDir string
+	// DataDir is the directory having the databases
+	DataDir string
+	// CacheDir is the directory having temporary files
+	CacheDir         string
 	APIEndpoint      string
 	Version          string
 	DB               *DB
 	SessionKey       string
 	SessionKeyExpiry int64
 	CipherKey        []byte
+	// Profile is the name of the profile in use
+	Profile string
+	// ProfileDir is the directory having the database of the profile
+	ProfileDir string
+	// Settings are the settings of the profile
+	Settings Settings
 }
 
-// Config holds dnote configuration
-type Config struct {
-	Editor string
-}
+// NewCtx returns a new dnote context for the given profile. If the profile is empty, the
+// profile in the config is used.
+func NewCtx(apiEndpoint, versionTag, profile string) (DnoteCtx, error) {
+	dirs, err := GetDirs()
+	if err != nil {
+		return DnoteCtx{}, errors.Wrap(err, "getting the directories")
+	}
+
+	// the config file does not exist before the first run
+	var config Config
+	if _, err := os.Stat(GetConfigPath(dirs.Config)); err == nil {
+		config, err = ReadConfig(dirs.Config)
+		if err != nil {
+			return DnoteCtx{}, errors.Wrap(err, "reading config")
+		}
+	}
 
-// NewCtx returns a new dnote context
-func NewCtx(apiEndpoint, versionTag string) (DnoteCtx, error) {
-	homeDir, err := getHomeDir()
+	profile, settings, err := config.Resolve(profile)
 	if err != nil {
-		return DnoteCtx{}, errors.Wrap(err, "Failed to get home dir")
+		return DnoteCtx{}, errors.Wrap(err, "resolving profile")
 	}
-	dnoteDir := getDnoteDir(homeDir)
+	if settings.APIEndpoint != "" {
+		apiEndpoint = settings.APIEndpoint
+	}
+
+	profileDir := GetProfileDir(dirs.Data, profile)
 
-	dnoteDBPath := fmt.Sprintf("%s/dnote.db", dnoteDir)
+	dnoteDBPath := fmt.Sprintf("%s/dnote.db", profileDir)
 	db, err := OpenDB(dnoteDBPath)
 	if err != nil {
 		return DnoteCtx{}, errors.Wrap(err, "conntecting to db")
 	}
 
 	ret := DnoteCtx{
-		HomeDir:     homeDir,
-		DnoteDir:    dnoteDir,
+		HomeDir:     dirs.Home,
+		ConfigDir:   dirs.Config,
+		DataDir:     dirs.Data,
+		CacheDir:    dirs.Cache,
 		APIEndpoint: apiEndpoint,
 		Version:     versionTag,
 		DB:          db,
+		Profile:     profile,
+		ProfileDir:  profileDir,
+		Settings:    settings,
 	}
 
 	return ret, nil
@@ -121,47 +149,14 @@ func SetupCtx(ctx DnoteCtx) (DnoteCtx, error) {
 		return ctx, errors.Wrap(err, "decoding cipherKey from base64")
 	}
 
-	ret := DnoteCtx{
-		HomeDir:          ctx.HomeDir,
-		DnoteDir:         ctx.DnoteDir,
-		APIEndpoint:      ctx.APIEndpoint,
-		Version:          ctx.Version,
-		DB:               ctx.DB,
-		SessionKey:       sessionKey,
-		SessionKeyExpiry: sessionKeyExpiry,
-		CipherKey:        cipherKey,
-	}
+	ret := ctx
+	ret.SessionKey = sessionKey
+	ret.SessionKeyExpiry = sessionKeyExpiry
+	ret.CipherKey = cipherKey
 
 	return ret, nil
 }
 
-func getDnoteDir(homeDir string) string {
-	var ret string
-
-	dnoteDirEnv := os.Getenv("DNOTE_DIR")
-	if dnoteDirEnv == "" {
-		ret = fmt.Sprintf("%s/%s", homeDir, DnoteDirName)
-	} else {
-		ret = dnoteDirEnv
-	}
-
-	return ret
-}
-
-func getHomeDir() (string, error) {
-	homeDirEnv := os.Getenv("DNOTE_HOME_DIR")
-	if homeDirEnv != "" {
-		return homeDirEnv, nil
-	}
-
-	usr, err := user.Current()
-	if err != nil {
-		return "", errors.Wrap(err, "Failed to get current user")
-	}
-
-	return usr.HomeDir, nil
-}
-
 // InitDB initializes the database.
 // Ideally this process must be a part of migration sequence. But it is performed
 // seaprately because it is a prerequisite for legacy migration.
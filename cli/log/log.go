@@ -39,6 +39,11 @@ var (
 
 var indent = "  "
 
+// SetColor enables or disables colored output
+func SetColor(enabled bool) {
+	color.NoColor = !enabled
+}
+
 // Info prints information
 func Info(msg string) {
 	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorBlue.Sprint("•"), msg)
@@ -24,19 +24,28 @@ import (
 	"github.com/dnote/dnote/cli/cmd/root"
 	"github.com/dnote/dnote/cli/infra"
 	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/migrate"
+	"github.com/dnote/dnote/cli/utils"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 
 	// commands
+	"github.com/dnote/dnote/cli/cmd/account"
 	"github.com/dnote/dnote/cli/cmd/add"
 	"github.com/dnote/dnote/cli/cmd/cat"
+	"github.com/dnote/dnote/cli/cmd/crypt"
+	"github.com/dnote/dnote/cli/cmd/doctor"
 	"github.com/dnote/dnote/cli/cmd/edit"
 	"github.com/dnote/dnote/cli/cmd/find"
 	"github.com/dnote/dnote/cli/cmd/login"
 	"github.com/dnote/dnote/cli/cmd/logout"
 	"github.com/dnote/dnote/cli/cmd/ls"
 	"github.com/dnote/dnote/cli/cmd/remove"
+	"github.com/dnote/dnote/cli/cmd/reset"
+	"github.com/dnote/dnote/cli/cmd/sessions"
 	"github.com/dnote/dnote/cli/cmd/sync"
+	"github.com/dnote/dnote/cli/cmd/template"
+	"github.com/dnote/dnote/cli/cmd/upgrade"
 	"github.com/dnote/dnote/cli/cmd/version"
 	"github.com/dnote/dnote/cli/cmd/view"
 )
@@ -46,12 +55,28 @@ var apiEndpoint string
 var versionTag = "master"
 
 func main() {
-	ctx, err := infra.NewCtx(apiEndpoint, versionTag)
+	// the files are moved before the context opens the database in them
+	dirs, err := infra.GetDirs()
 	if err != nil {
-		panic(errors.Wrap(err, "initializing context"))
+		log.Errorf("%s\n", errors.Wrap(err, "getting the directories").Error())
+		os.Exit(1)
+	}
+	if err := migrate.XDG(dirs); err != nil {
+		log.Errorf("%s\n", errors.Wrap(err, "moving to the XDG directories").Error())
+		os.Exit(1)
+	}
+
+	ctx, err := infra.NewCtx(apiEndpoint, versionTag, root.GetProfile(os.Args[1:]))
+	if err != nil {
+		log.Errorf("%s\n", errors.Wrap(err, "initializing context").Error())
+		os.Exit(1)
 	}
 	defer ctx.DB.Close()
 
+	if ctx.Settings.Color != nil {
+		log.SetColor(*ctx.Settings.Color)
+	}
+
 	if err := root.Prepare(ctx); err != nil {
 		panic(errors.Wrap(err, "preparing dnote run"))
 	}
@@ -65,15 +90,27 @@ func main() {
 	root.Register(edit.NewCmd(ctx))
 	root.Register(login.NewCmd(ctx))
 	root.Register(logout.NewCmd(ctx))
+	root.Register(reset.NewCmd(ctx))
+	root.Register(crypt.NewCmd(ctx))
+	root.Register(sessions.NewCmd(ctx))
+	root.Register(account.NewCmd(ctx))
+	root.Register(doctor.NewCmd(ctx))
 	root.Register(add.NewCmd(ctx))
 	root.Register(ls.NewCmd(ctx))
 	root.Register(sync.NewCmd(ctx))
 	root.Register(version.NewCmd(ctx))
+	root.Register(upgrade.NewCmd(ctx))
 	root.Register(cat.NewCmd(ctx))
 	root.Register(view.NewCmd(ctx))
 	root.Register(find.NewCmd(ctx))
+	root.Register(template.NewCmd(ctx))
 
 	if err := root.Execute(); err != nil {
+		// an outdated client is not a bug, and the wrapped context is not helpful
+		if e, ok := errors.Cause(err).(*utils.UpgradeRequiredError); ok {
+			err = e
+		}
+
 		log.Errorf("%s\n", err.Error())
 		os.Exit(1)
 	}
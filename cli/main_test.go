@@ -52,10 +52,10 @@ func TestInit(t *testing.T) {
 	testutils.RunDnoteCmd(t, ctx, binaryName)
 
 	// Test
-	if !utils.FileExists(fmt.Sprintf("%s", ctx.DnoteDir)) {
+	if !utils.FileExists(fmt.Sprintf("%s", ctx.DataDir)) {
 		t.Errorf("dnote directory was not initialized")
 	}
-	if !utils.FileExists(fmt.Sprintf("%s/%s", ctx.DnoteDir, core.ConfigFilename)) {
+	if !utils.FileExists(fmt.Sprintf("%s/%s", ctx.ConfigDir, core.ConfigFilename)) {
 		t.Errorf("config file was not initialized")
 	}
 
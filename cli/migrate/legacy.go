@@ -117,7 +117,8 @@ func performMigration(ctx infra.DnoteCtx, migrationID int) error {
 		return nil
 	}
 
-	if err := backupDnoteDir(ctx); err != nil {
+	backupPath := getBackupPath(ctx.HomeDir)
+	if err := backupDir(ctx.DataDir, backupPath); err != nil {
 		return errors.Wrap(err, "Failed to back up dnote directory")
 	}
 
@@ -143,14 +144,14 @@ func performMigration(ctx infra.DnoteCtx, migrationID int) error {
 	}
 
 	if migrationError != nil {
-		if err := restoreBackup(ctx); err != nil {
+		if err := restoreBackup(ctx.DataDir, backupPath); err != nil {
 			panic(errors.Wrap(err, "Failed to restore backup for a failed migration"))
 		}
 
 		return errors.Wrapf(migrationError, "Failed to perform migration #%d", migrationID)
 	}
 
-	if err := clearBackup(ctx); err != nil {
+	if err := clearBackup(backupPath); err != nil {
 		return errors.Wrap(err, "Failed to clear backup")
 	}
 
@@ -161,19 +162,22 @@ func performMigration(ctx infra.DnoteCtx, migrationID int) error {
 	return nil
 }
 
-// backupDnoteDir backs up the dnote directory to a temporary backup directory
-func backupDnoteDir(ctx infra.DnoteCtx) error {
-	srcPath := fmt.Sprintf("%s/.dnote", ctx.HomeDir)
-	tmpPath := fmt.Sprintf("%s/%s", ctx.HomeDir, backupDirName)
+// getBackupPath returns the path to the temporary backup directory in the given home directory
+func getBackupPath(homeDir string) string {
+	return fmt.Sprintf("%s/%s", homeDir, backupDirName)
+}
 
-	if err := utils.CopyDir(srcPath, tmpPath); err != nil {
-		return errors.Wrap(err, "Failed to copy the .dnote directory")
+// backupDir backs up the directory at srcPath to a temporary backup directory
+func backupDir(srcPath, backupPath string) error {
+	if err := utils.CopyDir(srcPath, backupPath); err != nil {
+		return errors.Wrapf(err, "Failed to copy %s", srcPath)
 	}
 
 	return nil
 }
 
-func restoreBackup(ctx infra.DnoteCtx) error {
+// restoreBackup replaces the directory at srcPath with its backup
+func restoreBackup(srcPath, backupPath string) error {
 	var err error
 
 	defer func() {
@@ -184,11 +188,8 @@ func restoreBackup(ctx infra.DnoteCtx) error {
 		}
 	}()
 
-	srcPath := fmt.Sprintf("%s/.dnote", ctx.HomeDir)
-	backupPath := fmt.Sprintf("%s/%s", ctx.HomeDir, backupDirName)
-
 	if err = os.RemoveAll(srcPath); err != nil {
-		return errors.Wrapf(err, "Failed to clear current dnote data at %s", backupPath)
+		return errors.Wrapf(err, "Failed to clear current dnote data at %s", srcPath)
 	}
 
 	if err = os.Rename(backupPath, srcPath); err != nil {
@@ -198,9 +199,7 @@ func restoreBackup(ctx infra.DnoteCtx) error {
 	return nil
 }
 
-func clearBackup(ctx infra.DnoteCtx) error {
-	backupPath := fmt.Sprintf("%s/%s", ctx.HomeDir, backupDirName)
-
+func clearBackup(backupPath string) error {
 	if err := os.RemoveAll(backupPath); err != nil {
 		return errors.Wrapf(err, "Failed to remove backup at %s", backupPath)
 	}
@@ -210,7 +209,7 @@ func clearBackup(ctx infra.DnoteCtx) error {
 
 // getSchemaPath returns the path to the file containing schema info
 func getSchemaPath(ctx infra.DnoteCtx) string {
-	return fmt.Sprintf("%s/%s", ctx.DnoteDir, schemaFilename)
+	return fmt.Sprintf("%s/%s", ctx.DataDir, schemaFilename)
 }
 
 func readSchema(ctx infra.DnoteCtx) (schema, error) {
@@ -484,7 +483,7 @@ func migrateToV1(ctx infra.DnoteCtx) error {
 }
 
 func migrateToV2(ctx infra.DnoteCtx) error {
-	notePath := fmt.Sprintf("%s/dnote", ctx.DnoteDir)
+	notePath := fmt.Sprintf("%s/dnote", ctx.DataDir)
 
 	b, err := ioutil.ReadFile(notePath)
 	if err != nil {
@@ -535,8 +534,8 @@ func migrateToV2(ctx infra.DnoteCtx) error {
 
 // migrateToV3 generates actions for existing dnote
 func migrateToV3(ctx infra.DnoteCtx) error {
-	notePath := fmt.Sprintf("%s/dnote", ctx.DnoteDir)
-	actionsPath := fmt.Sprintf("%s/actions", ctx.DnoteDir)
+	notePath := fmt.Sprintf("%s/dnote", ctx.DataDir)
+	actionsPath := fmt.Sprintf("%s/actions", ctx.DataDir)
 
 	b, err := ioutil.ReadFile(notePath)
 	if err != nil {
@@ -622,7 +621,7 @@ func getEditorCommand() string {
 }
 
 func migrateToV4(ctx infra.DnoteCtx) error {
-	configPath := fmt.Sprintf("%s/dnoterc", ctx.DnoteDir)
+	configPath := infra.GetConfigPath(ctx.ConfigDir)
 
 	b, err := ioutil.ReadFile(configPath)
 	if err != nil {
@@ -655,7 +654,7 @@ func migrateToV4(ctx infra.DnoteCtx) error {
 
 // migrateToV5 migrates actions
 func migrateToV5(ctx infra.DnoteCtx) error {
-	actionsPath := fmt.Sprintf("%s/actions", ctx.DnoteDir)
+	actionsPath := fmt.Sprintf("%s/actions", ctx.DataDir)
 
 	b, err := ioutil.ReadFile(actionsPath)
 	if err != nil {
@@ -720,7 +719,7 @@ func migrateToV5(ctx infra.DnoteCtx) error {
 
 // migrateToV6 adds a 'public' field to notes
 func migrateToV6(ctx infra.DnoteCtx) error {
-	notePath := fmt.Sprintf("%s/dnote", ctx.DnoteDir)
+	notePath := fmt.Sprintf("%s/dnote", ctx.DataDir)
 
 	b, err := ioutil.ReadFile(notePath)
 	if err != nil {
@@ -775,7 +774,7 @@ func migrateToV6(ctx infra.DnoteCtx) error {
 // EditNoteDataV2. Due to a bug, edit logged actions with schema version '2'
 // but with a data of EditNoteDataV1. https://github.com/dnote/dnote/cli/issues/107
 func migrateToV7(ctx infra.DnoteCtx) error {
-	actionPath := fmt.Sprintf("%s/actions", ctx.DnoteDir)
+	actionPath := fmt.Sprintf("%s/actions", ctx.DataDir)
 
 	b, err := ioutil.ReadFile(actionPath)
 	if err != nil {
@@ -845,7 +844,7 @@ func migrateToV8(ctx infra.DnoteCtx) error {
 	}
 
 	// 1. Migrate the the dnote file
-	dnoteFilePath := fmt.Sprintf("%s/dnote", ctx.DnoteDir)
+	dnoteFilePath := fmt.Sprintf("%s/dnote", ctx.DataDir)
 	b, err := ioutil.ReadFile(dnoteFilePath)
 	if err != nil {
 		return errors.Wrap(err, "reading the notes")
@@ -879,7 +878,7 @@ func migrateToV8(ctx infra.DnoteCtx) error {
 	}
 
 	// 2. Migrate the actions file
-	actionsPath := fmt.Sprintf("%s/actions", ctx.DnoteDir)
+	actionsPath := fmt.Sprintf("%s/actions", ctx.DataDir)
 	b, err = ioutil.ReadFile(actionsPath)
 	if err != nil {
 		return errors.Wrap(err, "reading the actions")
@@ -904,7 +903,7 @@ func migrateToV8(ctx infra.DnoteCtx) error {
 	}
 
 	// 3. Migrate the timestamps file
-	timestampsPath := fmt.Sprintf("%s/timestamps", ctx.DnoteDir)
+	timestampsPath := fmt.Sprintf("%s/timestamps", ctx.DataDir)
 	b, err = ioutil.ReadFile(timestampsPath)
 	if err != nil {
 		return errors.Wrap(err, "reading the timestamps")
@@ -946,7 +945,7 @@ func migrateToV8(ctx infra.DnoteCtx) error {
 	if err := os.RemoveAll(timestampsPath); err != nil {
 		return errors.Wrap(err, "removing the timestamps file")
 	}
-	schemaPath := fmt.Sprintf("%s/schema", ctx.DnoteDir)
+	schemaPath := fmt.Sprintf("%s/schema", ctx.DataDir)
 	if err := os.RemoveAll(schemaPath); err != nil {
 		return errors.Wrap(err, "removing the schema file")
 	}
@@ -352,10 +352,10 @@ func TestMigrateToV8(t *testing.T) {
 	// test
 
 	// 1. test if files are migrated
-	dnoteFilePath := fmt.Sprintf("%s/dnote", ctx.DnoteDir)
-	dnotercPath := fmt.Sprintf("%s/dnoterc", ctx.DnoteDir)
-	schemaFilePath := fmt.Sprintf("%s/schema", ctx.DnoteDir)
-	timestampFilePath := fmt.Sprintf("%s/timestamps", ctx.DnoteDir)
+	dnoteFilePath := fmt.Sprintf("%s/dnote", ctx.DataDir)
+	dnotercPath := fmt.Sprintf("%s/dnoterc", ctx.DataDir)
+	schemaFilePath := fmt.Sprintf("%s/schema", ctx.DataDir)
+	timestampFilePath := fmt.Sprintf("%s/timestamps", ctx.DataDir)
 	if ok := utils.FileExists(dnoteFilePath); ok {
 		t.Errorf("%s still exists", dnoteFilePath)
 	}
@@ -0,0 +1,110 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dnote/dnote/cli/core"
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/log"
+	"github.com/dnote/dnote/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// XDG moves the files in the dnote directory used before the XDG base directories to the
+// XDG directories. It does nothing if the directory does not exist, or if the data directory
+// already exists. The directory is backed up and restored if the move fails.
+func XDG(dirs infra.Dirs) error {
+	if dirs.Legacy == dirs.Data || !utils.FileExists(dirs.Legacy) || utils.FileExists(dirs.Data) {
+		return nil
+	}
+
+	log.Debug("moving %s to the XDG directories\n", dirs.Legacy)
+
+	backupPath := getBackupPath(dirs.Home)
+	if err := backupDir(dirs.Legacy, backupPath); err != nil {
+		return errors.Wrap(err, "backing up the dnote directory")
+	}
+
+	configPath := infra.GetConfigPath(dirs.Config)
+	configExisted := utils.FileExists(configPath)
+
+	if err := moveToXDG(dirs); err != nil {
+		// undo the partial move
+		os.RemoveAll(dirs.Data)
+		if !configExisted {
+			os.Remove(configPath)
+		}
+
+		if err := restoreBackup(dirs.Legacy, backupPath); err != nil {
+			panic(errors.Wrap(err, "Failed to restore backup for a failed move to the XDG directories"))
+		}
+
+		return errors.Wrap(err, "moving to the XDG directories")
+	}
+
+	if err := clearBackup(backupPath); err != nil {
+		return errors.Wrap(err, "clearing the backup")
+	}
+
+	return nil
+}
+
+func moveToXDG(dirs infra.Dirs) error {
+	if err := os.MkdirAll(filepath.Dir(dirs.Data), 0755); err != nil {
+		return errors.Wrap(err, "creating the parent of the data directory")
+	}
+	if err := utils.CopyDir(dirs.Legacy, dirs.Data); err != nil {
+		return errors.Wrap(err, "copying the data")
+	}
+
+	// the temporary content belongs in the cache directory and need not be kept
+	if err := os.Remove(fmt.Sprintf("%s/%s", dirs.Data, core.TmpContentFilename)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing the temporary content")
+	}
+
+	// the config file is moved unless there already is one in the config directory
+	legacyConfigPath := infra.GetConfigPath(dirs.Data)
+	configPath := infra.GetConfigPath(dirs.Config)
+	if utils.FileExists(legacyConfigPath) {
+		if utils.FileExists(configPath) {
+			log.Warnf("%s already exists. The config in %s is not used.\n", configPath, dirs.Legacy)
+		} else {
+			if err := os.MkdirAll(dirs.Config, 0755); err != nil {
+				return errors.Wrap(err, "creating the config directory")
+			}
+			if err := utils.CopyFile(legacyConfigPath, configPath); err != nil {
+				return errors.Wrap(err, "copying the config file")
+			}
+		}
+
+		if err := os.Remove(legacyConfigPath); err != nil {
+			return errors.Wrap(err, "removing the config file from the data directory")
+		}
+	}
+
+	if err := os.RemoveAll(dirs.Legacy); err != nil {
+		return errors.Wrap(err, "removing the dnote directory")
+	}
+
+	return nil
+}
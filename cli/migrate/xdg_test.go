@@ -0,0 +1,149 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote CLI.
+ *
+ * Dnote CLI is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote CLI is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote CLI.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dnote/dnote/cli/infra"
+	"github.com/dnote/dnote/cli/testutils"
+	"github.com/dnote/dnote/cli/utils"
+)
+
+func setupXDGDirs(t *testing.T) infra.Dirs {
+	homeDir, err := ioutil.TempDir("", "dnote-xdg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return infra.Dirs{
+		Home:   homeDir,
+		Legacy: fmt.Sprintf("%s/.dnote", homeDir),
+		Config: fmt.Sprintf("%s/.config/dnote", homeDir),
+		Data:   fmt.Sprintf("%s/.local/share/dnote", homeDir),
+		Cache:  fmt.Sprintf("%s/.cache/dnote", homeDir),
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := fmt.Sprintf("%s/%s", dir, name)
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestXDG(t *testing.T) {
+	dirs := setupXDGDirs(t)
+	defer os.RemoveAll(dirs.Home)
+
+	writeFiles(t, dirs.Legacy, map[string]string{
+		"dnote.db":            "db",
+		"dnoterc":             "editor: vim",
+		"DNOTE_TMPCONTENT.md": "draft",
+	})
+	writeFiles(t, fmt.Sprintf("%s/profiles/work", dirs.Legacy), map[string]string{
+		"dnote.db": "work db",
+	})
+
+	// execute
+	if err := XDG(dirs); err != nil {
+		t.Fatal(err)
+	}
+
+	// test
+	testutils.AssertEqual(t, utils.FileExists(dirs.Legacy), false, "legacy dir should be removed")
+	testutils.AssertEqual(t, utils.FileExists(getBackupPath(dirs.Home)), false, "backup should be cleared")
+	testutils.AssertEqual(t, readFile(t, fmt.Sprintf("%s/dnote.db", dirs.Data)), "db", "db mismatch")
+	testutils.AssertEqual(t, readFile(t, fmt.Sprintf("%s/profiles/work/dnote.db", dirs.Data)), "work db", "profile db mismatch")
+	testutils.AssertEqual(t, readFile(t, fmt.Sprintf("%s/dnoterc", dirs.Config)), "editor: vim", "config mismatch")
+	testutils.AssertEqual(t, utils.FileExists(fmt.Sprintf("%s/dnoterc", dirs.Data)), false, "config should not be in the data dir")
+	testutils.AssertEqual(t, utils.FileExists(fmt.Sprintf("%s/DNOTE_TMPCONTENT.md", dirs.Data)), false, "temporary content should not be moved")
+}
+
+func TestXDG_configExists(t *testing.T) {
+	dirs := setupXDGDirs(t)
+	defer os.RemoveAll(dirs.Home)
+
+	writeFiles(t, dirs.Legacy, map[string]string{
+		"dnote.db": "db",
+		"dnoterc":  "editor: vim",
+	})
+	writeFiles(t, dirs.Config, map[string]string{
+		"dnoterc": "editor: nano",
+	})
+
+	// execute
+	if err := XDG(dirs); err != nil {
+		t.Fatal(err)
+	}
+
+	// test
+	testutils.AssertEqual(t, readFile(t, fmt.Sprintf("%s/dnote.db", dirs.Data)), "db", "db mismatch")
+	testutils.AssertEqual(t, readFile(t, fmt.Sprintf("%s/dnoterc", dirs.Config)), "editor: nano", "config mismatch")
+}
+
+func TestXDG_noop(t *testing.T) {
+	t.Run("data dir exists", func(t *testing.T) {
+		dirs := setupXDGDirs(t)
+		defer os.RemoveAll(dirs.Home)
+
+		writeFiles(t, dirs.Legacy, map[string]string{"dnote.db": "old db"})
+		writeFiles(t, dirs.Data, map[string]string{"dnote.db": "new db"})
+
+		// execute
+		if err := XDG(dirs); err != nil {
+			t.Fatal(err)
+		}
+
+		// test
+		testutils.AssertEqual(t, readFile(t, fmt.Sprintf("%s/dnote.db", dirs.Legacy)), "old db", "legacy db mismatch")
+		testutils.AssertEqual(t, readFile(t, fmt.Sprintf("%s/dnote.db", dirs.Data)), "new db", "db mismatch")
+	})
+
+	t.Run("legacy dir does not exist", func(t *testing.T) {
+		dirs := setupXDGDirs(t)
+		defer os.RemoveAll(dirs.Home)
+
+		// execute
+		if err := XDG(dirs); err != nil {
+			t.Fatal(err)
+		}
+
+		// test
+		testutils.AssertEqual(t, utils.FileExists(dirs.Data), false, "data dir should not be created")
+	})
+}
@@ -20,6 +20,10 @@ if ! command_exists shasum; then
   echo "please install shasum"
   exit 1
 fi
+if ! command_exists openssl; then
+  echo "please install openssl"
+  exit 1
+fi
 if [ $# -eq 0 ]; then
   echo "no version specified."
   exit 1
@@ -29,6 +33,12 @@ if [[ $1 == v* ]]; then
   exit 1
 fi
 
+# releasePublicKey is the base64 encoded ed25519 public key embedded in the binary to
+# verify upgrades, and signingKey is the path to the PEM encoded private key.
+releasePublicKey="${DNOTE_RELEASE_PUBLIC_KEY:?please set DNOTE_RELEASE_PUBLIC_KEY}"
+signingKey="${DNOTE_RELEASE_SIGNING_KEY:?please set DNOTE_RELEASE_SIGNING_KEY}"
+ldflags="-X main.apiEndpoint=https://api.dnote.io -X main.versionTag=$version -X github.com/dnote/dnote/cli/cmd/upgrade.releasePublicKey=$releasePublicKey"
+
 build() {
   # init build dir
   rm -rf "$TMP"
@@ -42,21 +52,21 @@ build() {
   # build linux
   xgo --targets="linux/amd64"\
     --tags "linux fts5"\
-    -ldflags "-X main.apiEndpoint=https://api.dnote.io -X main.versionTag=$version" .
+    -ldflags "$ldflags" .
   mkdir "$TMP/linux"
   mv cli-linux-amd64 "$TMP/linux/dnote"
 
   # build darwin
   xgo --targets="darwin/amd64"\
     --tags "darwin fts5"\
-    -ldflags "-X main.apiEndpoint=https://api.dnote.io -X main.versionTag=$version" .
+    -ldflags "$ldflags" .
   mkdir "$TMP/darwin"
   mv cli-darwin-10.6-amd64 "$TMP/darwin/dnote"
 
   # build windows
   xgo --targets="windows/amd64"\
     --tags "fts5"\
-    -ldflags "-X main.apiEndpoint=https://api.dnote.io -X main.versionTag=$version" .
+    -ldflags "$ldflags" .
   mkdir "$TMP/windows"
   mv cli-windows-4.0-amd64.exe "$TMP/windows/dnote.exe"
 
@@ -71,17 +81,25 @@ get_buildname() {
 
 calc_checksum() {
   os=$1
+  binary=$2
 
   pushd "$TMP/$os"
 
   buildname=$(get_buildname "$os")
-  mv dnote "$buildname"
+  mv "$binary" "$buildname"
   shasum -a 256 "$buildname" >> "$TMP/dnote_${version}_checksums.txt"
-  mv "$buildname" dnote
+  mv "$buildname" "$binary"
 
   popd
 }
 
+# sign_checksums signs the checksum file so that 'dnote upgrade' can verify the release
+sign_checksums() {
+  checksums="$TMP/dnote_${version}_checksums.txt"
+
+  openssl pkeyutl -sign -rawin -inkey "$signingKey" -in "$checksums" | openssl base64 -A > "$checksums.sig"
+}
+
 build_tarball() {
   os=$1
   buildname=$(get_buildname "$os")
@@ -97,8 +115,10 @@ build_tarball() {
 
 build
 
-calc_checksum darwin
-calc_checksum linux
+calc_checksum darwin dnote
+calc_checksum linux dnote
+calc_checksum windows dnote.exe
+sign_checksums
 
 build_tarball windows
 build_tarball darwin
@@ -40,7 +40,7 @@ git tag -a "$version_tag" -m "Release $version_tag"
 git push --tags
 
 # 2. release on GitHub
-files=(./build/*.tar.gz ./build/*.txt)
+files=(./build/*.tar.gz ./build/*.txt ./build/*.sig)
 file_args=()
 for file in "${files[@]}"; do
   file_args+=("--attach=$file")
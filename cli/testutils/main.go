@@ -42,13 +42,15 @@ import (
 // InitEnv sets up a test env and returns a new dnote context
 func InitEnv(t *testing.T, dnotehomePath string, fixturePath string, migrated bool) infra.DnoteCtx {
 	os.Setenv("DNOTE_HOME_DIR", dnotehomePath)
-	ctx, err := infra.NewCtx("", "")
+	// keep all files in one directory instead of the XDG directories of the user
+	os.Setenv("DNOTE_DIR", filepath.Join(dnotehomePath, infra.DnoteDirName))
+	ctx, err := infra.NewCtx("", "", "")
 	if err != nil {
 		t.Fatal(errors.Wrap(err, "getting new ctx"))
 	}
 
 	// set up directory
-	if err := os.MkdirAll(ctx.DnoteDir, 0755); err != nil {
+	if err := os.MkdirAll(ctx.DataDir, 0755); err != nil {
 		t.Fatal(err)
 	}
 
@@ -92,8 +94,10 @@ func Login(t *testing.T, ctx *infra.DnoteCtx) {
 func TeardownEnv(ctx infra.DnoteCtx) {
 	ctx.DB.Close()
 
-	if err := os.RemoveAll(ctx.DnoteDir); err != nil {
-		panic(err)
+	for _, dir := range []string{ctx.ConfigDir, ctx.DataDir, ctx.CacheDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			panic(err)
+		}
 	}
 }
 
@@ -103,7 +107,7 @@ func CopyFixture(ctx infra.DnoteCtx, fixturePath string, filename string) {
 	if err != nil {
 		panic(err)
 	}
-	dp, err := filepath.Abs(filepath.Join(ctx.DnoteDir, filename))
+	dp, err := filepath.Abs(filepath.Join(ctx.DataDir, filename))
 	if err != nil {
 		panic(err)
 	}
@@ -116,7 +120,7 @@ func CopyFixture(ctx infra.DnoteCtx, fixturePath string, filename string) {
 
 // WriteFile writes a file with the given content and  filename inside the dnote dir
 func WriteFile(ctx infra.DnoteCtx, content []byte, filename string) {
-	dp, err := filepath.Abs(filepath.Join(ctx.DnoteDir, filename))
+	dp, err := filepath.Abs(filepath.Join(ctx.DataDir, filename))
 	if err != nil {
 		panic(err)
 	}
@@ -128,7 +132,7 @@ func WriteFile(ctx infra.DnoteCtx, content []byte, filename string) {
 
 // ReadFile reads the content of the file with the given name in dnote dir
 func ReadFile(ctx infra.DnoteCtx, filename string) []byte {
-	path := filepath.Join(ctx.DnoteDir, filename)
+	path := filepath.Join(ctx.DataDir, filename)
 
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -264,7 +268,7 @@ func NewDnoteCmd(ctx infra.DnoteCtx, binaryName string, arg ...string) (*exec.Cm
 	}
 
 	cmd := exec.Command(binaryPath, arg...)
-	cmd.Env = []string{fmt.Sprintf("DNOTE_DIR=%s", ctx.DnoteDir), fmt.Sprintf("DNOTE_HOME_DIR=%s", ctx.HomeDir)}
+	cmd.Env = []string{fmt.Sprintf("DNOTE_DIR=%s", ctx.DataDir), fmt.Sprintf("DNOTE_HOME_DIR=%s", ctx.HomeDir)}
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
 
@@ -20,6 +20,7 @@ package utils
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -27,6 +28,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/dnote/dnote/cli/infra"
@@ -168,13 +170,54 @@ func getReq(ctx infra.DnoteCtx, path, method, body string) (*http.Request, error
 	}
 
 	req.Header.Set("CLI-Version", ctx.Version)
+	req.Header.Set("User-Agent", fmt.Sprintf("dnote-cli/%s", ctx.Version))
 
 	return req, nil
 }
 
+// UpgradeRequiredError is an error for a response from the server indicating that this version
+// of the client is no longer supported
+type UpgradeRequiredError struct {
+	ClientVersion      string `json:"client_version"`
+	MinimumVersion     string `json:"minimum_version"`
+	RecommendedVersion string `json:"recommended_version"`
+}
+
+func (e *UpgradeRequiredError) Error() string {
+	return fmt.Sprintf("dnote %s is no longer supported by the server. Please upgrade to %s or later by running 'dnote upgrade'", e.ClientVersion, e.MinimumVersion)
+}
+
+// deprecationWarning makes sure that the deprecation warning is printed only once per run
+var deprecationWarning sync.Once
+
+// checkVersionResp checks if the server rejected or deprecated this version of the client.
+// It returns an UpgradeRequiredError if the version is rejected, and prints a warning if
+// it is deprecated.
+func checkVersionResp(res *http.Response) error {
+	if res.StatusCode == http.StatusUpgradeRequired {
+		defer res.Body.Close()
+
+		var ret UpgradeRequiredError
+		if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+			return errors.Wrap(err, "decoding the upgrade required response")
+		}
+
+		return &ret
+	}
+
+	if msg := res.Header.Get("Deprecation-Warning"); msg != "" {
+		deprecationWarning.Do(func() {
+			log.Warnf("%s\n", msg)
+		})
+	}
+
+	return nil
+}
+
 // DoAuthorizedReq does a http request to the given path in the api endpoint as a user,
-// with the appropriate headers. The given path should include the preceding slash.
-func DoAuthorizedReq(ctx infra.DnoteCtx, hc http.Client, method, path, body string) (*http.Response, error) {
+// with the appropriate headers and the given extra headers. The given path should include
+// the preceding slash.
+func DoAuthorizedReq(ctx infra.DnoteCtx, hc http.Client, method, path, body string, header http.Header) (*http.Response, error) {
 	if ctx.SessionKey == "" {
 		return nil, errors.New("no session key found")
 	}
@@ -184,6 +227,12 @@ func DoAuthorizedReq(ctx infra.DnoteCtx, hc http.Client, method, path, body stri
 		return nil, errors.Wrap(err, "getting request")
 	}
 
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
 	credential := fmt.Sprintf("Bearer %s", ctx.SessionKey)
 	req.Header.Set("Authorization", credential)
 
@@ -192,6 +241,10 @@ func DoAuthorizedReq(ctx infra.DnoteCtx, hc http.Client, method, path, body stri
 		return res, errors.Wrap(err, "making http request")
 	}
 
+	if err := checkVersionResp(res); err != nil {
+		return nil, err
+	}
+
 	return res, nil
 }
 
@@ -208,6 +261,10 @@ func DoReq(ctx infra.DnoteCtx, method, path, body string) (*http.Response, error
 		return res, errors.Wrap(err, "making http request")
 	}
 
+	if err := checkVersionResp(res); err != nil {
+		return nil, err
+	}
+
 	return res, nil
 }
 
@@ -0,0 +1,126 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package billing provides the plans of the users and the features that the plans
+// are entitled to. The plans are managed by a provider, which can be a payment service
+// or a static configuration for self-hosted deployments.
+package billing
+
+import (
+	"os"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// Feature is a feature that is available only to some plans
+type Feature string
+
+const (
+	// FeatureSync is the sync of the notes with the clients such as the CLI
+	FeatureSync Feature = "sync"
+	// FeatureWeb is the access to the notes, the calendar and the digests on the web
+	FeatureWeb Feature = "web"
+)
+
+// AllFeatures is the list of all features
+var AllFeatures = []Feature{FeatureSync, FeatureWeb}
+
+// names of the providers
+const (
+	ProviderStripe     = "stripe"
+	ProviderSelfHosted = "self-hosted"
+	ProviderStatic     = "static"
+)
+
+// ErrUnsupported is an error indicating that the provider does not support the operation
+var ErrUnsupported = errors.New("The billing provider does not support the operation")
+
+// ErrAlreadySubscribed is an error indicating that the user already has a subscription
+var ErrAlreadySubscribed = errors.New("The user already has a subscription")
+
+// Plan is a plan of a user
+type Plan struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Features []Feature `json:"features"`
+}
+
+// Has returns whether the plan is entitled to the feature
+func (p Plan) Has(f Feature) bool {
+	for _, feature := range p.Features {
+		if feature == f {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Provider provides the plans of the users
+type Provider interface {
+	// Name returns the name of the provider
+	Name() string
+	// GetPlan returns the plan of the user
+	GetPlan(user database.User) (Plan, error)
+	// Subscribe subscribes the user to the paid plan using the given payment token and
+	// returns the updated user. Providers that take no payments return ErrUnsupported.
+	Subscribe(user database.User, email, token string) (database.User, error)
+}
+
+// HasFeature returns whether the plan of the user is entitled to the feature
+func HasFeature(p Provider, user database.User, f Feature) (bool, error) {
+	plan, err := p.GetPlan(user)
+	if err != nil {
+		return false, errors.Wrap(err, "getting the plan")
+	}
+
+	return plan.Has(f), nil
+}
+
+// FromEnv returns the provider configured by the BillingProvider environment variable.
+// It defaults to Stripe, which subscribes the users to the plan with the given id.
+func FromEnv(stripePlanID string) (Provider, error) {
+	name := os.Getenv("BillingProvider")
+
+	switch name {
+	case "", ProviderStripe:
+		return NewStripe(stripePlanID), nil
+	case ProviderSelfHosted:
+		return NewSelfHosted(), nil
+	case ProviderStatic:
+		f, err := ReadPlanFile(os.Getenv("BillingPlanFile"))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the plan file")
+		}
+
+		return NewStatic(f)
+	default:
+		return nil, errors.Errorf("unknown billing provider '%s'", name)
+	}
+}
+
+func isValidFeature(f Feature) bool {
+	for _, feature := range AllFeatures {
+		if feature == f {
+			return true
+		}
+	}
+
+	return false
+}
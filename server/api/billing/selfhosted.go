@@ -0,0 +1,50 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package billing
+
+import (
+	"github.com/dnote/dnote/server/database"
+)
+
+// SelfHostedPlan is the plan of all users of a self-hosted deployment
+var SelfHostedPlan = Plan{ID: "self-hosted", Name: "Self-hosted", Features: AllFeatures}
+
+// SelfHosted is a provider for self-hosted deployments, where all users have all
+// features without any payment
+type SelfHosted struct{}
+
+// NewSelfHosted returns a new self-hosted provider
+func NewSelfHosted() *SelfHosted {
+	return &SelfHosted{}
+}
+
+// Name returns the name of the provider
+func (s *SelfHosted) Name() string {
+	return ProviderSelfHosted
+}
+
+// GetPlan returns the plan of the user
+func (s *SelfHosted) GetPlan(user database.User) (Plan, error) {
+	return SelfHostedPlan, nil
+}
+
+// Subscribe returns ErrUnsupported because self-hosted deployments take no payments
+func (s *SelfHosted) Subscribe(user database.User, email, token string) (database.User, error) {
+	return user, ErrUnsupported
+}
@@ -0,0 +1,138 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package billing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// PlanFile is the content of a plan file, which assigns plans to the users by their email
+type PlanFile struct {
+	// DefaultPlan is the id of the plan of the users not in Users
+	DefaultPlan string `json:"default_plan"`
+	Plans       []Plan `json:"plans"`
+	// Users is a map from an email to the id of a plan
+	Users map[string]string `json:"users"`
+}
+
+// Static is a provider whose plans are given by a plan file
+type Static struct {
+	plans       map[string]Plan
+	defaultPlan string
+	users       map[string]string
+	// getEmail returns the email of the user with the given id
+	getEmail func(userID int) (string, error)
+}
+
+func getUserEmail(userID int) (string, error) {
+	db := database.DBConn
+
+	var account database.Account
+	conn := db.Where("user_id = ?", userID).First(&account)
+	if conn.RecordNotFound() {
+		return "", nil
+	} else if err := conn.Error; err != nil {
+		return "", errors.Wrap(err, "finding account")
+	}
+
+	return account.Email.String, nil
+}
+
+// ReadPlanFile reads the plan file at the given path
+func ReadPlanFile(path string) (PlanFile, error) {
+	var ret PlanFile
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ret, errors.Wrap(err, "reading the plan file")
+	}
+
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return ret, errors.Wrap(err, "unmarshalling the plan file")
+	}
+
+	return ret, nil
+}
+
+// NewStatic returns a new provider with the plans in the given plan file
+func NewStatic(f PlanFile) (*Static, error) {
+	plans := map[string]Plan{}
+	for _, p := range f.Plans {
+		if p.ID == "" {
+			return nil, errors.New("a plan has no id")
+		}
+		if _, ok := plans[p.ID]; ok {
+			return nil, errors.Errorf("duplicate plan '%s'", p.ID)
+		}
+		for _, feature := range p.Features {
+			if !isValidFeature(feature) {
+				return nil, errors.Errorf("unknown feature '%s' in the plan '%s'", feature, p.ID)
+			}
+		}
+
+		plans[p.ID] = p
+	}
+
+	if _, ok := plans[f.DefaultPlan]; !ok {
+		return nil, errors.Errorf("the default plan '%s' is not defined", f.DefaultPlan)
+	}
+	for email, planID := range f.Users {
+		if _, ok := plans[planID]; !ok {
+			return nil, errors.Errorf("the plan '%s' of %s is not defined", planID, email)
+		}
+	}
+
+	ret := &Static{
+		plans:       plans,
+		defaultPlan: f.DefaultPlan,
+		users:       f.Users,
+		getEmail:    getUserEmail,
+	}
+
+	return ret, nil
+}
+
+// Name returns the name of the provider
+func (s *Static) Name() string {
+	return ProviderStatic
+}
+
+// GetPlan returns the plan assigned to the email of the user, or the default plan
+func (s *Static) GetPlan(user database.User) (Plan, error) {
+	email, err := s.getEmail(user.ID)
+	if err != nil {
+		return Plan{}, errors.Wrap(err, "getting the email")
+	}
+
+	planID, ok := s.users[email]
+	if !ok || email == "" {
+		planID = s.defaultPlan
+	}
+
+	return s.plans[planID], nil
+}
+
+// Subscribe returns ErrUnsupported because the plans are assigned by the plan file
+func (s *Static) Subscribe(user database.User, email, token string) (database.User, error) {
+	return user, ErrUnsupported
+}
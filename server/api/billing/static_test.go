@@ -0,0 +1,151 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package billing
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+var testPlanFile = PlanFile{
+	DefaultPlan: "basic",
+	Plans: []Plan{
+		{ID: "basic", Name: "Basic", Features: []Feature{FeatureWeb}},
+		{ID: "pro", Name: "Pro", Features: []Feature{FeatureSync, FeatureWeb}},
+	},
+	Users: map[string]string{
+		"alice@example.com": "pro",
+	},
+}
+
+func TestNewStatic_Invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		f    PlanFile
+	}{
+		{
+			name: "missing id",
+			f: PlanFile{
+				DefaultPlan: "basic",
+				Plans:       []Plan{{ID: "basic"}, {Name: "Pro"}},
+			},
+		},
+		{
+			name: "duplicate plan",
+			f: PlanFile{
+				DefaultPlan: "basic",
+				Plans:       []Plan{{ID: "basic"}, {ID: "basic"}},
+			},
+		},
+		{
+			name: "unknown feature",
+			f: PlanFile{
+				DefaultPlan: "basic",
+				Plans:       []Plan{{ID: "basic", Features: []Feature{"teleport"}}},
+			},
+		},
+		{
+			name: "undefined default plan",
+			f: PlanFile{
+				DefaultPlan: "free",
+				Plans:       []Plan{{ID: "basic"}},
+			},
+		},
+		{
+			name: "undefined user plan",
+			f: PlanFile{
+				DefaultPlan: "basic",
+				Plans:       []Plan{{ID: "basic"}},
+				Users:       map[string]string{"alice@example.com": "pro"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewStatic(tc.f)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestStaticGetPlan(t *testing.T) {
+	testCases := []struct {
+		email       string
+		expectedID  string
+		expectsSync bool
+	}{
+		{
+			email:       "alice@example.com",
+			expectedID:  "pro",
+			expectsSync: true,
+		},
+		{
+			email:       "bob@example.com",
+			expectedID:  "basic",
+			expectsSync: false,
+		},
+		{
+			email:       "",
+			expectedID:  "basic",
+			expectsSync: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("email %s", tc.email), func(t *testing.T) {
+			s, err := NewStatic(testPlanFile)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "making provider"))
+			}
+			s.getEmail = func(userID int) (string, error) {
+				return tc.email, nil
+			}
+
+			plan, err := s.GetPlan(database.User{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "getting the plan"))
+			}
+
+			testutils.AssertEqual(t, plan.ID, tc.expectedID, "plan id mismatch")
+
+			ok, err := HasFeature(s, database.User{}, FeatureSync)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "checking the feature"))
+			}
+			testutils.AssertEqual(t, ok, tc.expectsSync, "sync mismatch")
+		})
+	}
+}
+
+func TestStaticSubscribe(t *testing.T) {
+	s, err := NewStatic(testPlanFile)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making provider"))
+	}
+
+	_, err = s.Subscribe(database.User{}, "alice@example.com", "tok_visa")
+	testutils.AssertEqual(t, err, ErrUnsupported, "error mismatch")
+}
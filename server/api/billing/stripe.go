@@ -0,0 +1,90 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package billing
+
+import (
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/customer"
+)
+
+var (
+	// StripeFreePlan is the plan of the users without a subscription
+	StripeFreePlan = Plan{ID: "free", Name: "Free", Features: []Feature{}}
+	// StripeProPlan is the plan of the users with a subscription
+	StripeProPlan = Plan{ID: "pro", Name: "Dnote Pro", Features: AllFeatures}
+)
+
+// Stripe is a provider whose users subscribe to the pro plan on Stripe. The Stripe
+// webhook keeps User.Cloud in sync with the subscriptions.
+type Stripe struct {
+	planID string
+}
+
+// NewStripe returns a new Stripe provider that subscribes the users to the Stripe
+// plan with the given id
+func NewStripe(planID string) *Stripe {
+	return &Stripe{planID: planID}
+}
+
+// Name returns the name of the provider
+func (s *Stripe) Name() string {
+	return ProviderStripe
+}
+
+// GetPlan returns the plan of the user
+func (s *Stripe) GetPlan(user database.User) (Plan, error) {
+	if user.Cloud {
+		return StripeProPlan, nil
+	}
+
+	return StripeFreePlan, nil
+}
+
+// Subscribe creates a Stripe customer subscribed to the plan for the user
+func (s *Stripe) Subscribe(user database.User, email, token string) (database.User, error) {
+	db := database.DBConn
+
+	if user.StripeCustomerID != "" {
+		return user, ErrAlreadySubscribed
+	}
+
+	customerParams := &stripe.CustomerParams{
+		Plan:  &s.planID,
+		Email: &email,
+	}
+	if err := customerParams.SetSource(token); err != nil {
+		return user, errors.Wrap(err, "setting source")
+	}
+
+	//TODO: if customer exists, update not create
+	c, err := customer.New(customerParams)
+	if err != nil {
+		return user, errors.Wrap(err, "creating customer")
+	}
+
+	user.StripeCustomerID = c.ID
+	user.Cloud = true
+	if err := db.Save(&user).Error; err != nil {
+		return user, errors.Wrap(err, "updating user")
+	}
+
+	return user, nil
+}
@@ -30,6 +30,9 @@ import (
 // ServerKDFIteration is the iteration count for PBKDF on the server
 var ServerKDFIteration = 100000
 
+// DefaultClientKDFIteration is the iteration count for PBKDF that the clients use by default
+var DefaultClientKDFIteration = 100000
+
 // getRandomBytes generates a cryptographically secure pseudorandom numbers of the
 // given size in byte
 func getRandomBytes(numBytes int) ([]byte, error) {
@@ -0,0 +1,144 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// TOTPPeriod is the duration of a time step for TOTP
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is the number of digits in a TOTP code
+	TOTPDigits = 6
+	// TOTPSkew is the number of time steps before and after the current one in which
+	// a code is accepted, to tolerate clock drift between the server and the client
+	TOTPSkew int64 = 1
+	// TOTPIssuer is the issuer shown in authenticator apps
+	TOTPIssuer = "Dnote"
+)
+
+// totpEncoding is the encoding for TOTP secrets understood by authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a random secret for TOTP, encoded in base32
+func GenerateTOTPSecret() (string, error) {
+	b, err := getRandomBytes(20)
+	if err != nil {
+		return "", errors.Wrap(err, "generating random bits")
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPProvisioningURI returns a URI that can be encoded in a QR code to be scanned by authenticator apps
+func TOTPProvisioningURI(secret, accountName string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", TOTPIssuer)
+	q.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	q.Set("period", fmt.Sprintf("%d", int(TOTPPeriod.Seconds())))
+
+	label := url.PathEscape(fmt.Sprintf("%s:%s", TOTPIssuer, accountName))
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, q.Encode())
+}
+
+// TOTPStep returns the time step that the given time belongs to
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// TOTPCode computes the code for the given secret at the given time step, as specified by RFC 6238
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "decoding secret")
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// ValidateTOTP checks the code against the secret at the given time. It returns the matched
+// time step, so that callers can reject the reuse of a code, and a boolean indicating if the
+// code is valid.
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool, error) {
+	current := TOTPStep(t)
+
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false, errors.Wrap(err, "computing code")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// GenerateBackupCode generates a random one-time backup code for two-factor authentication
+func GenerateBackupCode() (string, error) {
+	b, err := getRandomBytes(5)
+	if err != nil {
+		return "", errors.Wrap(err, "generating random bits")
+	}
+
+	s := strings.ToLower(totpEncoding.EncodeToString(b))
+
+	return fmt.Sprintf("%s-%s", s[:4], s[4:]), nil
+}
+
+// NormalizeBackupCode normalizes the backup code given by a user so that it can be compared
+// with the generated one regardless of letter cases and separators
+func NormalizeBackupCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+
+	return code
+}
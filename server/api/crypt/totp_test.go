@@ -0,0 +1,129 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+// rfcSecret is the secret used in the test vectors of RFC 6238, encoded in base32
+var rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{
+			unix:     59,
+			expected: "287082",
+		},
+		{
+			unix:     1111111109,
+			expected: "081804",
+		},
+		{
+			unix:     1234567890,
+			expected: "005924",
+		},
+		{
+			unix:     2000000000,
+			expected: "279037",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("unix %d", tc.unix), func(t *testing.T) {
+			code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tc.unix, 0)))
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "computing code"))
+			}
+
+			testutils.AssertEqual(t, code, tc.expected, "code mismatch")
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	testCases := []struct {
+		code         string
+		t            time.Time
+		expectedOK   bool
+		expectedStep int64
+	}{
+		{
+			code:         "081804",
+			t:            now,
+			expectedOK:   true,
+			expectedStep: TOTPStep(now),
+		},
+		{
+			code:         "081804",
+			t:            now.Add(TOTPPeriod),
+			expectedOK:   true,
+			expectedStep: TOTPStep(now),
+		},
+		{
+			code:         "081804",
+			t:            now.Add(-TOTPPeriod),
+			expectedOK:   true,
+			expectedStep: TOTPStep(now),
+		},
+		{
+			code:         "081804",
+			t:            now.Add(2 * TOTPPeriod),
+			expectedOK:   false,
+			expectedStep: 0,
+		},
+		{
+			code:         "000000",
+			t:            now,
+			expectedOK:   false,
+			expectedStep: 0,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			step, ok, err := ValidateTOTP(rfcSecret, tc.code, tc.t)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "validating code"))
+			}
+
+			testutils.AssertEqual(t, ok, tc.expectedOK, "ok mismatch")
+			testutils.AssertEqual(t, step, tc.expectedStep, "step mismatch")
+		})
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI(rfcSecret, "alice@example.com")
+
+	testutils.AssertEqual(t, strings.HasPrefix(uri, "otpauth://totp/Dnote:alice@example.com?"), true, "prefix mismatch")
+	testutils.AssertEqual(t, strings.Contains(uri, "secret="+rfcSecret), true, "secret missing")
+	testutils.AssertEqual(t, strings.Contains(uri, "issuer=Dnote"), true, "issuer missing")
+}
@@ -20,11 +20,12 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"time"
 
+	"github.com/dnote/dnote/server/api/billing"
 	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/logger"
 	"github.com/dnote/dnote/server/api/operations"
 	"github.com/dnote/dnote/server/database"
 	"github.com/jinzhu/gorm"
@@ -48,12 +49,20 @@ type Session struct {
 	Legacy          bool   `json:"legacy"`
 	Encrypted       bool   `json:"encrypted"`
 	CipherKeyEnc    string `json:"cipher_key_enc"`
+	Plan            string `json:"plan"`
+	// DeleteAt is the time at which the account will be deleted, if the deletion is scheduled
+	DeleteAt *time.Time `json:"delete_at"`
 }
 
-func makeSession(user database.User, account database.Account) Session {
+// makeSession makes a session for the user. Cloud reports whether the plan of the user
+// is entitled to sync, so that the clients can keep using it regardless of the provider.
+func (a *App) makeSession(user database.User, account database.Account) (Session, error) {
 	legacy := account.AuthKeyHash == ""
 
-	log.Printf("account is %+v", account)
+	plan, err := a.Billing.GetPlan(user)
+	if err != nil {
+		return Session{}, errors.Wrap(err, "getting the plan")
+	}
 
 	return Session{
 		// TODO: remove ID and use UUID
@@ -61,7 +70,7 @@ func makeSession(user database.User, account database.Account) Session {
 		GithubName:      account.Nickname,
 		GithubAccountID: account.AccountID,
 		APIKey:          user.APIKey,
-		Cloud:           user.Cloud,
+		Cloud:           plan.Has(billing.FeatureSync),
 		Email:           account.Email.String,
 		EmailVerified:   account.EmailVerified,
 		Name:            user.Name,
@@ -69,7 +78,9 @@ func makeSession(user database.User, account database.Account) Session {
 		Legacy:          legacy,
 		Encrypted:       user.Encrypted,
 		CipherKeyEnc:    account.CipherKeyEnc,
-	}
+		Plan:            plan.ID,
+		DeleteAt:        user.DeleteAt,
+	}, nil
 }
 
 func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +98,11 @@ func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session := makeSession(user, account)
+	session, err := a.makeSession(user, account)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "making session").Error(), http.StatusInternalServerError)
+		return
+	}
 
 	response := struct {
 		User Session `json:"user"`
@@ -99,7 +114,7 @@ func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
 	if err := operations.TouchLastLoginAt(user, tx); err != nil {
 		tx.Rollback()
 		// In case of an error, gracefully continue to avoid disturbing the service
-		log.Println("error touching last_login_at", err.Error())
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("touching last_login_at")
 	}
 	tx.Commit()
 
@@ -186,10 +201,14 @@ func (a *App) legacyPasswordLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if ok := a.checkSigninWait(w, params.Email); !ok {
+		return
+	}
+
 	var account database.Account
 	conn := db.Where("email = ?", params.Email).First(&account)
 	if conn.RecordNotFound() {
-		http.Error(w, "Wrong email and password combination", http.StatusUnauthorized)
+		a.handleSigninFailure(w, r, params.Email, nil)
 		return
 	} else if conn.Error != nil {
 		http.Error(w, errors.Wrap(err, "getting user").Error(), http.StatusInternalServerError)
@@ -199,10 +218,12 @@ func (a *App) legacyPasswordLogin(w http.ResponseWriter, r *http.Request) {
 	password := []byte(params.Password)
 	err = bcrypt.CompareHashAndPassword([]byte(account.Password.String), password)
 	if err != nil {
-		http.Error(w, "Wrong email and password combination", http.StatusUnauthorized)
+		a.handleSigninFailure(w, r, params.Email, &account)
 		return
 	}
 
+	clearSigninFailures(r, params.Email)
+
 	var user database.User
 	err = db.Where("id = ?", account.UserID).First(&user).Error
 	if err != nil {
@@ -221,7 +242,11 @@ func (a *App) legacyPasswordLogin(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
-	session := makeSession(user, account)
+	session, err := a.makeSession(user, account)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "making session").Error(), http.StatusInternalServerError)
+		return
+	}
 	response := struct {
 		User Session `json:"user"`
 	}{
@@ -296,7 +321,7 @@ func (a *App) legacyRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithSession(w, user.ID, account.CipherKeyEnc)
+	a.respondWithSession(w, r, user.ID, account.CipherKeyEnc)
 }
 
 func (a *App) legacyMigrate(w http.ResponseWriter, r *http.Request) {
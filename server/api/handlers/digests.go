@@ -117,14 +117,14 @@ func respondWithDigests(w http.ResponseWriter, r *http.Request, userID int) {
 	var digests []database.Digest
 	conn := db.Where("user_id = ?", userID).Order("created_at DESC").Offset(offset).Limit(perPage)
 	if err := conn.Find(&digests).Error; err != nil {
-		logger.Err("finding digests %s", err.Error())
+		logger.WithRequest(r).Err("finding digests %s", err.Error())
 		http.Error(w, "finding digests", http.StatusInternalServerError)
 		return
 	}
 
 	var total int
 	if err := db.Model(database.Digest{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
-		logger.Err("counting digests %s", err.Error())
+		logger.WithRequest(r).Err("counting digests %s", err.Error())
 		http.Error(w, "finding digests", http.StatusInternalServerError)
 		return
 	}
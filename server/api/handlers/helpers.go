@@ -21,6 +21,7 @@ package handlers
 import (
 	crand "crypto/rand"
 	"encoding/base64"
+	"net/http"
 	"strings"
 
 	"github.com/dnote/dnote/server/database"
@@ -104,3 +105,12 @@ func getClientType(origin string) string {
 
 	return "web"
 }
+
+// getRequestClientType returns the type of the client that made the request
+func getRequestClientType(r *http.Request) string {
+	if r.Header.Get("CLI-Version") != "" {
+		return "cli"
+	}
+
+	return getClientType(r.Header.Get("Origin"))
+}
@@ -0,0 +1,191 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// maxIdempotencyKeyLength is the maximum length of an idempotency key
+var maxIdempotencyKeyLength = 255
+
+// responseRecorder is an http.ResponseWriter that keeps a copy of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func isMutatingMethod(method string) bool {
+	return method == "POST" || method == "PATCH" || method == "PUT" || method == "DELETE"
+}
+
+func hashRequestBody(r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "reading body")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func replayResponse(w http.ResponseWriter, record database.IdempotencyKey) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// isNoStore checks if the response must not be stored, because it has secrets such as
+// the backup codes for two-factor authentication
+func isNoStore(h http.Header) bool {
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		if strings.TrimSpace(directive) == "no-store" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// idempotent makes a mutating request with an Idempotency-Key header safe to retry. The
+// response to the first request with a key is stored for the authenticated user, and is
+// replayed for any retries within operations.IdempotencyKeyTTL instead of handling them
+// again. Server errors and the responses with "Cache-Control: no-store" are not stored,
+// so that they can be retried.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || !isMutatingMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+		if !ok {
+			http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+			return
+		}
+
+		requestHash, err := hashRequestBody(r)
+		if err != nil {
+			http.Error(w, errors.Wrap(err, "hashing request").Error(), http.StatusInternalServerError)
+			return
+		}
+
+		db := database.DBConn
+		now := getClock(r).Now()
+
+		if err := operations.DeleteExpiredIdempotencyKeys(db.Where("user_id = ? AND key = ?", user.ID, key), now); err != nil {
+			http.Error(w, errors.Wrap(err, "deleting expired key").Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var record database.IdempotencyKey
+		conn := db.Where("user_id = ? AND key = ?", user.ID, key).First(&record)
+		if conn.RecordNotFound() {
+			record = database.IdempotencyKey{
+				UserID:      user.ID,
+				Key:         key,
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				RequestHash: requestHash,
+			}
+			record.CreatedAt = now
+
+			// a concurrent request with the same key violates the unique index
+			if err := db.Create(&record).Error; err != nil {
+				http.Error(w, "A request with the same Idempotency-Key is in progress", http.StatusConflict)
+				return
+			}
+		} else if err := conn.Error; err != nil {
+			http.Error(w, errors.Wrap(err, "finding idempotency key").Error(), http.StatusInternalServerError)
+			return
+		} else {
+			if record.Method != r.Method || record.Path != r.URL.Path || record.RequestHash != requestHash {
+				http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			if record.StatusCode == 0 {
+				http.Error(w, "A request with the same Idempotency-Key is in progress", http.StatusConflict)
+				return
+			}
+
+			replayResponse(w, record)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// the response is sent with 200 if the handler did not write anything
+		statusCode := rec.statusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+
+		if statusCode >= 500 || isNoStore(w.Header()) {
+			if err := db.Delete(&record).Error; err != nil {
+				logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("releasing idempotency key")
+			}
+			return
+		}
+
+		if err := db.Model(&record).Update(map[string]interface{}{
+			"status_code":  statusCode,
+			"content_type": w.Header().Get("Content-Type"),
+			"body":         rec.body.Bytes(),
+		}).Error; err != nil {
+			logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("saving response for idempotency key")
+		}
+	})
+}
@@ -0,0 +1,251 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestIdempotent(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	session := testutils.SetupSession(t, user)
+
+	callCount := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"count": %d}`, callCount)))
+	}
+	server := httptest.NewServer(auth(handler, nil))
+	defer server.Close()
+
+	do := func(method, path, body, key string) (*http.Response, string) {
+		req := testutils.MakeReq(server, method, path, body)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+
+		res := testutils.HTTPDo(t, req)
+		defer res.Body.Close()
+
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading body"))
+		}
+
+		return res, string(b)
+	}
+
+	// execute
+	res1, body1 := do("POST", "/", `{"a": 1}`, "key-1")
+	res2, body2 := do("POST", "/", `{"a": 1}`, "key-1")
+	res3, _ := do("POST", "/", `{"a": 2}`, "key-1")
+	res4, _ := do("PATCH", "/", `{"a": 1}`, "key-1")
+	_, body5 := do("POST", "/", `{"a": 1}`, "key-2")
+	_, body6 := do("POST", "/", `{"a": 1}`, "")
+
+	// test
+	testutils.AssertEqual(t, res1.StatusCode, http.StatusCreated, "res1 status code mismatch")
+	testutils.AssertEqual(t, body1, `{"count": 1}`, "body1 mismatch")
+	testutils.AssertEqual(t, res2.StatusCode, http.StatusCreated, "res2 status code mismatch")
+	testutils.AssertEqual(t, res2.Header.Get("Content-Type"), "application/json", "res2 content type mismatch")
+	testutils.AssertEqual(t, res2.Header.Get("Idempotent-Replayed"), "true", "res2 should have been replayed")
+	testutils.AssertEqual(t, body2, body1, "body2 mismatch")
+	testutils.AssertEqual(t, res3.StatusCode, http.StatusUnprocessableEntity, "res3 status code mismatch")
+	testutils.AssertEqual(t, res4.StatusCode, http.StatusUnprocessableEntity, "res4 status code mismatch")
+	testutils.AssertEqual(t, body5, `{"count": 2}`, "body5 mismatch")
+	testutils.AssertEqual(t, body6, `{"count": 3}`, "body6 mismatch")
+	testutils.AssertEqual(t, callCount, 3, "call count mismatch")
+
+	var keyCount int
+	testutils.MustExec(t, db.Model(&database.IdempotencyKey{}).Count(&keyCount), "counting idempotency keys")
+	testutils.AssertEqual(t, keyCount, 2, "idempotency key count mismatch")
+}
+
+func TestIdempotent_serverError(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	session := testutils.SetupSession(t, user)
+
+	callCount := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+	}
+	server := httptest.NewServer(auth(handler, nil))
+	defer server.Close()
+
+	// execute
+	for i := 0; i < 2; i++ {
+		req := testutils.MakeReq(server, "POST", "/", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		req.Header.Set("Idempotency-Key", "key-1")
+
+		res := testutils.HTTPDo(t, req)
+		testutils.AssertEqual(t, res.StatusCode, http.StatusInternalServerError, fmt.Sprintf("status code mismatch for request %d", i))
+	}
+
+	// test
+	var keyCount int
+	testutils.MustExec(t, db.Model(&database.IdempotencyKey{}).Count(&keyCount), "counting idempotency keys")
+
+	testutils.AssertEqual(t, callCount, 2, "server errors should not be replayed")
+	testutils.AssertEqual(t, keyCount, 0, "idempotency key count mismatch")
+}
+
+func TestIdempotent_noStore(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	session := testutils.SetupSession(t, user)
+
+	callCount := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"secret": "foo"}`))
+	}
+	server := httptest.NewServer(auth(handler, nil))
+	defer server.Close()
+
+	// execute
+	for i := 0; i < 2; i++ {
+		req := testutils.MakeReq(server, "POST", "/", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		req.Header.Set("Idempotency-Key", "key-1")
+
+		res := testutils.HTTPDo(t, req)
+		testutils.AssertEqual(t, res.StatusCode, http.StatusOK, fmt.Sprintf("status code mismatch for request %d", i))
+		testutils.AssertEqual(t, res.Header.Get("Idempotent-Replayed"), "", fmt.Sprintf("request %d should not have been replayed", i))
+	}
+
+	// test
+	var keyCount int
+	testutils.MustExec(t, db.Model(&database.IdempotencyKey{}).Count(&keyCount), "counting idempotency keys")
+
+	testutils.AssertEqual(t, callCount, 2, "call count mismatch")
+	testutils.AssertEqual(t, keyCount, 0, "the response should not have been stored")
+}
+
+func TestIdempotent_session(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+
+	// execute
+	dat := `{"old_auth_key": "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=", "new_auth_key": "new-auth-key", "new_cipher_key_enc": "new-cipher-key-enc", "new_kdf_iteration": 100000}`
+	req := testutils.MakeReq(server, "PATCH", "/account/password", dat)
+	req.Header.Set("Idempotency-Key", "key-1")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// test
+	testutils.AssertStatusCode(t, res, http.StatusOK, "")
+	testutils.AssertEqual(t, res.Header.Get("Cache-Control"), "no-store", "Cache-Control mismatch")
+
+	var keyCount int
+	testutils.MustExec(t, db.Model(&database.IdempotencyKey{}).Count(&keyCount), "counting idempotency keys")
+	testutils.AssertEqual(t, keyCount, 0, "the response with the session should not have been stored")
+}
+
+func TestIdempotent_abandoned(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	session := testutils.SetupSession(t, user)
+
+	callCount := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+
+		w.WriteHeader(http.StatusCreated)
+	}
+	server := httptest.NewServer(auth(handler, nil))
+	defer server.Close()
+
+	doReq := func() *http.Response {
+		req := testutils.MakeReq(server, "POST", "/", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		req.Header.Set("Idempotency-Key", "key-1")
+
+		return testutils.HTTPDo(t, req)
+	}
+
+	// a request that is still being processed
+	k := database.IdempotencyKey{
+		UserID: user.ID,
+		Key:    "key-1",
+		Method: "POST",
+		Path:   "/",
+		// the hash of an empty body
+		RequestHash: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	testutils.MustExec(t, db.Save(&k), "preparing idempotency key")
+
+	res1 := doReq()
+
+	// the request was abandoned
+	testutils.MustExec(t, db.Model(&k).Update("created_at", time.Now().Add(-operations.IdempotencyKeyLockTimeout-time.Minute)), "updating created_at")
+
+	res2 := doReq()
+
+	// test
+	testutils.AssertEqual(t, res1.StatusCode, http.StatusConflict, "res1 status code mismatch")
+	testutils.AssertEqual(t, res2.StatusCode, http.StatusCreated, "res2 status code mismatch")
+	testutils.AssertEqual(t, callCount, 1, "call count mismatch")
+
+	var record database.IdempotencyKey
+	testutils.MustExec(t, db.Where("key = ?", "key-1").First(&record), "finding idempotency key")
+	testutils.AssertEqual(t, record.StatusCode, http.StatusCreated, "status code mismatch")
+}
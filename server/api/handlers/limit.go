@@ -19,102 +19,188 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
-	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/ratelimit"
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
 )
 
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
-}
+// ParseTrustedProxies parses a comma-separated list of the IPs and the CIDR ranges of
+// the trusted proxies, such as "10.0.0.0/8,192.168.1.1"
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	ret := []*net.IPNet{}
 
-var visitors = make(map[string]*visitor)
-var mtx sync.RWMutex
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
 
-func init() {
-	go cleanupVisitors()
-}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return ret, errors.Errorf("invalid IP '%s'", part)
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			part = fmt.Sprintf("%s/%d", part, bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return ret, errors.Wrapf(err, "parsing '%s'", part)
+		}
+
+		ret = append(ret, ipNet)
+	}
 
-// addVisitor adds a new visitor to the map and returns a limiter for the visitor
-func addVisitor(identifier string) *rate.Limiter {
-	// initialize a token bucket
-	limiter := rate.NewLimiter(rate.Every(1*time.Second), 15)
+	return ret, nil
+}
 
-	mtx.Lock()
-	visitors[identifier] = &visitor{
-		limiter:  limiter,
-		lastSeen: time.Now()}
-	mtx.Unlock()
+func isTrustedProxy(ip net.IP, proxies []*net.IPNet) bool {
+	for _, p := range proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
 
-	return limiter
+	return false
 }
 
-// getVisitor returns a limiter for a visitor with the given identifier. It
-// adds the visitor to the map if not seen before.
-func getVisitor(identifier string) *rate.Limiter {
-	mtx.RLock()
-	v, exists := visitors[identifier]
+// lookupIP returns the request's IP. X-Forwarded-For and X-Real-IP are used only if
+// the request comes from a trusted proxy, because any client can set them.
+func lookupIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
 
-	if !exists {
-		mtx.RUnlock()
-		return addVisitor(identifier)
+	ip := net.ParseIP(remoteIP)
+	if ip == nil || !isTrustedProxy(ip, trustedProxies) {
+		return remoteIP
 	}
 
-	v.lastSeen = time.Now()
-	mtx.RUnlock()
+	// Each proxy appends the address it received the request from. Therefore the client
+	// is the rightmost address that is not a trusted proxy.
+	forwardedFor := strings.Join(r.Header["X-Forwarded-For"], ",")
+	if forwardedFor != "" {
+		ret := remoteIP
 
-	return v.limiter
-}
+		parts := strings.Split(forwardedFor, ",")
+		for i := len(parts) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(parts[i])
 
-// cleanupVisitors deletes visitors that has not been seen in a while from the
-// map of visitors
-func cleanupVisitors() {
-	for {
-		time.Sleep(time.Minute)
-		mtx.Lock()
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				break
+			}
 
-		for identifier, v := range visitors {
-			if time.Now().Sub(v.lastSeen) > 3*time.Minute {
-				delete(visitors, identifier)
+			ret = addr
+			if !isTrustedProxy(ip, trustedProxies) {
+				break
 			}
 		}
 
-		mtx.Unlock()
+		return ret
+	}
+
+	if realIP := r.Header.Get("X-Real-IP"); net.ParseIP(realIP) != nil {
+		return realIP
 	}
+
+	return remoteIP
 }
 
-// lookupIP returns the request's IP
-func lookupIP(r *http.Request) string {
-	realIP := r.Header.Get("X-Real-IP")
-	forwardedFor := r.Header.Get("X-Forwarded-For")
+// setRateLimitHeaders sets the RateLimit headers for the result, unless they already
+// report a result with fewer remaining requests
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	if v := w.Header().Get("RateLimit-Remaining"); v != "" {
+		if remaining, err := strconv.Atoi(v); err == nil && remaining < res.Remaining {
+			return
+		}
+	}
 
-	if forwardedFor != "" {
-		parts := strings.Split(forwardedFor, ",")
-		return parts[0]
+	reset := int(math.Ceil(res.Reset.Seconds()))
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(reset))
 	}
+}
 
-	if realIP != "" {
-		return realIP
+// takeRateLimit counts the request from the client with the given identifier against
+// the bucket, and responds with 429 if the limit is exceeded. It returns whether the
+// request can proceed.
+func takeRateLimit(w http.ResponseWriter, store ratelimit.Store, bucket ratelimit.Bucket, identifier string) bool {
+	key := fmt.Sprintf("%s:%s", bucket.Name, identifier)
+
+	res, err := store.Take(key, bucket.Limit)
+	if err != nil {
+		// Let the request through so that an unavailable store does not take down the API
+		logger.WithFields(logger.Fields{"error": err}).Err("counting the request for the rate limit")
+		return true
+	}
+
+	setRateLimitHeaders(w, res)
+
+	if !res.Allowed {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
 	}
 
-	return r.RemoteAddr
+	return true
+}
+
+// userRateLimit is the limit for the authenticated users of a route
+type userRateLimit struct {
+	store  ratelimit.Store
+	bucket ratelimit.Bucket
 }
 
-// limit is a middleware to rate limit the handler
-func limit(next http.Handler) http.HandlerFunc {
+// limit is a middleware to rate limit the requests to the route by the client IP. It
+// puts the limit for the authenticated users of the route in the context, so that the
+// auth middleware can apply it once the user is known.
+func (a *App) limit(next http.Handler, method, pattern string) http.Handler {
+	ipBucket, userBucket := a.RateLimit.Buckets(method, pattern)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		identifier := lookupIP(r)
-		limiter := getVisitor(identifier)
+		if ipBucket != nil {
+			if ok := takeRateLimit(w, a.RateLimitStore, *ipBucket, "ip:"+lookupIP(r, a.TrustedProxies)); !ok {
+				return
+			}
+		}
 
-		if !limiter.Allow() {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
-			return
+		if userBucket != nil {
+			l := userRateLimit{store: a.RateLimitStore, bucket: *userBucket}
+			ctx := context.WithValue(r.Context(), helpers.KeyUserRateLimit, l)
+			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// limitUser rate limits the request by the authenticated user if the route has a limit
+// for the users. It returns whether the request can proceed.
+func limitUser(w http.ResponseWriter, r *http.Request, user database.User) bool {
+	l, ok := r.Context().Value(helpers.KeyUserRateLimit).(userRateLimit)
+	if !ok {
+		return true
+	}
+
+	return takeRateLimit(w, l.store, l.bucket, fmt.Sprintf("user:%d", user.ID))
+}
@@ -0,0 +1,152 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/ratelimit"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1,::1")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing"))
+	}
+
+	expected := []string{"10.0.0.0/8", "192.168.1.1/32", "::1/128"}
+	testutils.AssertEqual(t, len(got), len(expected), "length mismatch")
+	for idx, e := range expected {
+		testutils.AssertEqual(t, got[idx].String(), e, "proxy mismatch")
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/8,foo"); err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+}
+
+func TestLookupIP(t *testing.T) {
+	trustedProxies := []*net.IPNet{
+		{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)},
+	}
+
+	testCases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		realIP       string
+		expectedIP   string
+	}{
+		{
+			name:         "untrusted remote with X-Forwarded-For",
+			remoteAddr:   "1.2.3.4:5000",
+			forwardedFor: "5.6.7.8",
+			expectedIP:   "1.2.3.4",
+		},
+		{
+			name:       "untrusted remote with X-Real-IP",
+			remoteAddr: "1.2.3.4:5000",
+			realIP:     "5.6.7.8",
+			expectedIP: "1.2.3.4",
+		},
+		{
+			name:         "trusted remote",
+			remoteAddr:   "10.0.0.1:5000",
+			forwardedFor: "5.6.7.8",
+			expectedIP:   "5.6.7.8",
+		},
+		{
+			name:         "spoofed X-Forwarded-For through a trusted proxy",
+			remoteAddr:   "10.0.0.1:5000",
+			forwardedFor: "9.9.9.9, 5.6.7.8, 10.0.0.2",
+			expectedIP:   "5.6.7.8",
+		},
+		{
+			name:       "trusted remote with X-Real-IP",
+			remoteAddr: "10.0.0.1:5000",
+			realIP:     "5.6.7.8",
+			expectedIP: "5.6.7.8",
+		},
+		{
+			name:         "invalid X-Forwarded-For",
+			remoteAddr:   "10.0.0.1:5000",
+			forwardedFor: "foo",
+			expectedIP:   "10.0.0.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+
+			testutils.AssertEqual(t, lookupIP(r, trustedProxies), tc.expectedIP, "ip mismatch")
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC))
+
+	app := App{
+		RateLimit: ratelimit.Config{
+			Default: ratelimit.Rule{IP: &ratelimit.Limit{Requests: 2, Period: time.Minute}},
+		},
+		RateLimitStore: ratelimit.NewMemoryStore(c),
+	}
+
+	handler := app.limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "GET", "/")
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := do("1.2.3.4:5000")
+	testutils.AssertEqual(t, w.Code, http.StatusOK, "status mismatch for the first request")
+	testutils.AssertEqual(t, w.Header().Get("RateLimit-Limit"), "2", "RateLimit-Limit mismatch")
+	testutils.AssertEqual(t, w.Header().Get("RateLimit-Remaining"), "1", "RateLimit-Remaining mismatch")
+	testutils.AssertEqual(t, w.Header().Get("RateLimit-Reset"), "60", "RateLimit-Reset mismatch")
+
+	do("1.2.3.4:5000")
+	w = do("1.2.3.4:5000")
+	testutils.AssertEqual(t, w.Code, http.StatusTooManyRequests, "status mismatch for the exceeding request")
+	testutils.AssertEqual(t, w.Header().Get("Retry-After"), "60", "Retry-After mismatch")
+
+	w = do("5.6.7.8:5000")
+	testutils.AssertEqual(t, w.Code, http.StatusOK, "status mismatch for another client")
+}
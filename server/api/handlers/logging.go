@@ -0,0 +1,129 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/logger"
+)
+
+// requestIDPattern matches the request IDs given by the clients or the proxies that
+// can be used as they are
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9\-_.]{1,64}$`)
+
+func generateRequestID() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// withRequestID is a middleware that identifies the request by the X-Request-ID header,
+// or by a newly generated ID. The ID is put in the context for the logs, and is sent back
+// in the response so that a problem reported by a client can be found in the logs.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = generateRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+
+		ctx := context.WithValue(r.Context(), helpers.KeyRequestID, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder is an http.ResponseWriter that keeps the status code and the size of
+// the response
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	size       int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+
+	return n, err
+}
+
+// Flush flushes the response if the underlying writer supports it, so that the streamed
+// responses are not buffered by the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logging is a middleware that logs the status and the latency of the request. Only the
+// path is logged, because the query can have tokens and emails.
+func (a *App) logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// the response is sent with 200 if the handler did not write anything
+		statusCode := rec.statusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+
+		l := logger.WithRequest(r).WithFields(logger.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      statusCode,
+			"size":        rec.size,
+			"duration_ms": float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+			"ip":          lookupIP(r, a.TrustedProxies),
+			"user_agent":  r.UserAgent(),
+		})
+
+		if statusCode >= 500 {
+			l.Err("%s %s", r.Method, r.URL.Path)
+		} else {
+			l.Info("%s %s", r.Method, r.URL.Path)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/testutils"
+)
+
+func TestWithRequestID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{
+			name:     "valid header",
+			header:   "abc-123",
+			expected: "abc-123",
+		},
+		{
+			name:   "no header",
+			header: "",
+		},
+		{
+			name:   "invalid header",
+			header: "foo bar\nbaz",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = r.Context().Value(helpers.KeyRequestID).(string)
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.header != "" {
+				r.Header.Set("X-Request-ID", tc.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tc.expected != "" {
+				testutils.AssertEqual(t, got, tc.expected, "request id mismatch")
+			} else {
+				testutils.AssertEqual(t, len(got), 32, "a request id should have been generated")
+			}
+			testutils.AssertEqual(t, w.Header().Get("X-Request-ID"), got, "response header mismatch")
+		})
+	}
+}
+
+func TestLoggingStatus(t *testing.T) {
+	app := App{}
+
+	handler := app.logging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+
+	r := httptest.NewRequest("GET", "/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	testutils.AssertEqual(t, w.Code, http.StatusNotFound, "status mismatch")
+}
@@ -0,0 +1,68 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/metrics"
+)
+
+// instrument is a middleware that records the latency of the requests to the route. The
+// route is identified by its pattern rather than the path, so that the ids in the paths
+// do not make a series per resource.
+func instrument(next http.Handler, route Route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		statusCode := rec.statusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+
+		metrics.ObserveHTTPRequest(route.Method, route.Pattern, statusCode, time.Since(start))
+	})
+}
+
+// observeSyncFragment records the items in a fragment pulled by a client
+func observeSyncFragment(frag SyncFragment) {
+	notes := len(frag.Notes) + len(frag.ExpungedNotes)
+	books := len(frag.Books) + len(frag.ExpungedBooks)
+
+	metrics.AddSyncItems(metrics.SyncDirectionPull, operations.SyncTypeNote, notes)
+	metrics.AddSyncItems(metrics.SyncDirectionPull, operations.SyncTypeBook, books)
+	metrics.ObserveSyncFragment(notes + books)
+}
+
+// observeSyncPush records the items in the changes pushed by a client
+func observeSyncPush(changes []syncPushChange) {
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Type]++
+	}
+
+	for itemType, n := range counts {
+		metrics.AddSyncItems(metrics.SyncDirectionPush, itemType, n)
+	}
+}
@@ -20,15 +20,19 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/dnote/dnote/server/api/billing"
 	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/crypt"
 	"github.com/dnote/dnote/server/api/helpers"
 	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/api/ratelimit"
 	"github.com/dnote/dnote/server/database"
 	"github.com/gorilla/mux"
 	"github.com/markbates/goth/gothic"
@@ -147,13 +151,32 @@ func getCredential(r *http.Request) (string, error) {
 	return ret, nil
 }
 
+// withClock is a middleware that makes the clock of the app available to the
+// middlewares that do not have access to the app
+func withClock(next http.Handler, c clock.Clock) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), helpers.KeyClock, c)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getClock returns the clock of the app that handles the request, or the real clock
+// if the request did not go through withClock
+func getClock(r *http.Request) clock.Clock {
+	if c, ok := r.Context().Value(helpers.KeyClock).(clock.Clock); ok {
+		return c
+	}
+
+	return clock.New()
+}
+
 func authWithSession(r *http.Request) (database.User, bool, error) {
 	db := database.DBConn
 	var user database.User
 
 	sessionKey, err := getCredential(r)
 	if err != nil {
-		logger.Err(errors.Wrap(err, "getting credential").Error())
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("getting credential")
 		return user, false, err
 	}
 
@@ -167,11 +190,13 @@ func authWithSession(r *http.Request) (database.User, bool, error) {
 	if conn.RecordNotFound() {
 		return user, false, nil
 	} else if err := conn.Error; err != nil {
-		logger.Err(errors.Wrap(err, "finding session").Error())
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("finding session")
 		return user, false, err
 	}
 
-	if session.ExpiresAt.Before(time.Now()) {
+	c := getClock(r)
+	now := c.Now()
+	if session.ExpiresAt.Before(now) || operations.SessionDeadline(session).Before(now) {
 		return user, false, nil
 	}
 
@@ -180,10 +205,15 @@ func authWithSession(r *http.Request) (database.User, bool, error) {
 	if conn.RecordNotFound() {
 		return user, false, nil
 	} else if err := conn.Error; err != nil {
-		logger.Err(errors.Wrap(err, "finding user from token").Error())
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("finding user from token")
 		return user, false, err
 	}
 
+	if err := operations.RenewSession(db, c, &session); err != nil {
+		// log the error and continue
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("renewing session")
+	}
+
 	return user, true, nil
 }
 
@@ -202,7 +232,7 @@ func authWithToken(r *http.Request, tokenType string) (database.User, database.T
 	if conn.RecordNotFound() {
 		return user, token, false, nil
 	} else if err := conn.Error; err != nil {
-		logger.Err(errors.Wrap(err, "finding token").Error())
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("finding token")
 		return user, token, false, err
 	}
 
@@ -211,7 +241,7 @@ func authWithToken(r *http.Request, tokenType string) (database.User, database.T
 	}
 
 	if err := db.Where("id = ?", token.UserID).First(&user).Error; err != nil {
-		logger.Err(errors.Wrap(err, "finding user").Error())
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("finding user")
 		return user, token, false, err
 	}
 
@@ -219,7 +249,9 @@ func authWithToken(r *http.Request, tokenType string) (database.User, database.T
 }
 
 type authMiddlewareParams struct {
-	ProOnly bool
+	// Billing and Feature restrict the access to the users whose plan has the feature
+	Billing billing.Provider
+	Feature billing.Feature
 }
 
 func auth(next http.HandlerFunc, p *authMiddlewareParams) http.HandlerFunc {
@@ -230,15 +262,24 @@ func auth(next http.HandlerFunc, p *authMiddlewareParams) http.HandlerFunc {
 			return
 		}
 
-		if p != nil && p.ProOnly {
-			if !user.Cloud {
+		if ok := limitUser(w, r, user); !ok {
+			return
+		}
+
+		if p != nil && p.Billing != nil {
+			ok, err := billing.HasFeature(p.Billing, user, p.Feature)
+			if err != nil {
+				http.Error(w, errors.Wrap(err, "checking the plan").Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
 				http.Error(w, "forbidden", http.StatusForbidden)
 				return
 			}
 		}
 
 		ctx := context.WithValue(r.Context(), helpers.KeyUser, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		idempotent(next).ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -247,7 +288,7 @@ func tokenAuth(next http.HandlerFunc, tokenType string) http.HandlerFunc {
 		user, token, ok, err := authWithToken(r, tokenType)
 		if err != nil {
 			// log the error and continue
-			logger.Err(errors.Wrap(err, "authenticating with token").Error())
+			logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("authenticating with token")
 		}
 
 		ctx := r.Context()
@@ -259,7 +300,7 @@ func tokenAuth(next http.HandlerFunc, tokenType string) http.HandlerFunc {
 			user, ok, err = authWithSession(r)
 			if err != nil {
 				// log the error and continue
-				logger.Err(errors.Wrap(err, "authenticating with session").Error())
+				logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("authenticating with session")
 			}
 
 			if !ok {
@@ -280,35 +321,39 @@ func cors(next http.HandlerFunc) http.HandlerFunc {
 		// Allow browser extensions
 		if strings.HasPrefix(origin, "moz-extension://") || strings.HasPrefix(origin, "chrome-extension://") {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "Deprecation-Warning, X-Request-ID")
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-func logging(inner http.Handler) http.Handler {
+// limitBodySize is a middleware that stops reading the request body beyond the given
+// size so that a client cannot hold the server with an unbounded body
+func limitBodySize(next http.Handler, size int64) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		inner.ServeHTTP(w, r)
+		if r.ContentLength > size {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 
-		log.Printf(
-			"%s\t%s\t%s",
-			r.Method,
-			r.RequestURI,
-			time.Since(start),
-		)
+		r.Body = http.MaxBytesReader(w, r.Body, size)
+		next.ServeHTTP(w, r)
 	})
 }
 
-func applyMiddleware(h http.Handler, rateLimit bool) http.Handler {
-	ret := h
-	ret = logging(ret)
+func (a *App) applyMiddleware(h http.Handler, route Route) http.Handler {
+	ret := limitBodySize(h, a.MaxBodySize)
+	ret = withClock(ret, a.Clock)
 
-	if rateLimit && os.Getenv("GO_ENV") == "PRODUCTION" {
-		ret = limit(ret)
+	if route.RateLimit && a.RateLimitStore != nil {
+		ret = a.limit(ret, route.Method, route.Pattern)
 	}
 
+	ret = a.logging(ret)
+	ret = instrument(ret, route)
+	ret = withRequestID(ret)
+
 	return ret
 }
 
@@ -316,22 +361,66 @@ func applyMiddleware(h http.Handler, rateLimit bool) http.Handler {
 type App struct {
 	Clock            clock.Clock
 	StripeAPIBackend *stripe.BackendImplementation
+	// ClientVersions is a map from a client type to its version requirement
+	ClientVersions map[string]ClientVersionPolicy
+	// Billing provides the plans of the users. It defaults to Stripe.
+	Billing billing.Provider
+	// RateLimit is the configuration of the rate limits. It defaults to ratelimit.DefaultConfig.
+	RateLimit ratelimit.Config
+	// RateLimitStore keeps the counts of the requests for the rate limits. The requests
+	// are not rate limited if it is nil.
+	RateLimitStore ratelimit.Store
+	// TrustedProxies are the proxies whose X-Forwarded-For and X-Real-IP headers are used
+	// to find the client IP
+	TrustedProxies []*net.IPNet
+	// MaxBodySize is the maximum size of the request bodies in bytes. It defaults to
+	// DefaultMaxBodySize.
+	MaxBodySize int64
+	// PresigninSecret is the key with which the presignin parameters are derived for the
+	// emails that no account has. It is generated randomly if not configured, in which
+	// case the parameters change when the server restarts.
+	PresigninSecret []byte
 }
 
+// DefaultMaxBodySize is the maximum size of the request bodies if not configured
+const DefaultMaxBodySize = 10 << 20
+
 // init sets up the application based on the configuration
 func (a *App) init() {
 	stripe.Key = os.Getenv("StripeSecretKey")
 
+	if a.Clock == nil {
+		a.Clock = clock.New()
+	}
+
 	if a.StripeAPIBackend != nil {
 		stripe.SetBackend(stripe.APIBackend, a.StripeAPIBackend)
 	}
+	if a.Billing == nil {
+		a.Billing = billing.NewStripe(StripePlanID)
+	}
+	if a.RateLimit.Routes == nil {
+		a.RateLimit = ratelimit.DefaultConfig()
+	}
+	if a.MaxBodySize == 0 {
+		a.MaxBodySize = DefaultMaxBodySize
+	}
+	if len(a.PresigninSecret) == 0 {
+		secret, err := crypt.GetRandomStr(32)
+		if err != nil {
+			panic(errors.Wrap(err, "generating the presignin secret"))
+		}
+
+		a.PresigninSecret = []byte(secret)
+	}
 }
 
 // NewRouter creates and returns a new router
 func NewRouter(app *App) *mux.Router {
 	app.init()
 
-	proOnly := authMiddlewareParams{ProOnly: true}
+	syncOnly := authMiddlewareParams{Billing: app.Billing, Feature: billing.FeatureSync}
+	webOnly := authMiddlewareParams{Billing: app.Billing, Feature: billing.FeatureWeb}
 
 	var routes = []Route{
 		// internal
@@ -347,19 +436,15 @@ func NewRouter(app *App) *mux.Router {
 		Route{"GET", "/account/email-preference", tokenAuth(app.getEmailPreference, database.TokenTypeEmailPreference), true},
 		Route{"PATCH", "/account/email-preference", tokenAuth(app.updateEmailPreference, database.TokenTypeEmailPreference), true},
 		Route{"POST", "/subscriptions", auth(app.createSub, nil), true},
-		Route{"PATCH", "/subscriptions", auth(app.updateSub, nil), true},
-		Route{"POST", "/webhooks/stripe", app.stripeWebhook, true},
-		Route{"GET", "/subscriptions", auth(app.getSub, nil), true},
-		Route{"GET", "/stripe_source", auth(app.getStripeSource, nil), true},
-		Route{"GET", "/notes", auth(app.getNotes, &proOnly), false},
+		Route{"GET", "/notes", auth(app.getNotes, &webOnly), false},
 		Route{"GET", "/demo/notes", app.getDemoNotes, true},
-		Route{"GET", "/notes/{noteUUID}", auth(app.getNote, &proOnly), true},
+		Route{"GET", "/notes/{noteUUID}", auth(app.getNote, &webOnly), true},
 		Route{"GET", "/demo/notes/{noteUUID}", app.getDemoNote, true},
-		Route{"GET", "/calendar", auth(app.getCalendar, &proOnly), true},
+		Route{"GET", "/calendar", auth(app.getCalendar, &webOnly), true},
 		Route{"GET", "/demo/calendar", app.getDemoCalendar, true},
-		Route{"GET", "/digests/{digestUUID}", auth(app.getDigest, &proOnly), true},
+		Route{"GET", "/digests/{digestUUID}", auth(app.getDigest, &webOnly), true},
 		Route{"GET", "/demo/digests/{digestUUID}", app.getDemoDigest, true},
-		Route{"GET", "/digests", auth(app.getDigests, &proOnly), true},
+		Route{"GET", "/digests", auth(app.getDigests, &webOnly), true},
 		Route{"GET", "/demo/digests", app.getDemoDigests, true},
 		//Route{"GET", "/books/{bookUUID}", cors(auth(app.getBook)), true},
 
@@ -367,51 +452,80 @@ func NewRouter(app *App) *mux.Router {
 		Route{"POST", "/legacy/signin", app.legacyPasswordLogin, true},
 		Route{"POST", "/legacy/register", legacyAuth(app.legacyRegister), true},
 		Route{"GET", "/legacy/me", legacyAuth(app.getMe), true},
-		Route{"GET", "/legacy/notes", auth(app.legacyGetNotes, &proOnly), false},
-		Route{"PATCH", "/legacy/migrate", auth(app.legacyMigrate, &proOnly), false},
+		Route{"GET", "/legacy/notes", auth(app.legacyGetNotes, &syncOnly), false},
+		Route{"PATCH", "/legacy/migrate", auth(app.legacyMigrate, &syncOnly), false},
 		Route{"GET", "/auth/{provider}", gothic.BeginAuthHandler, true},
 		Route{"GET", "/auth/{provider}/callback", app.oauthCallbackHandler, true},
 
 		// v1
 		Route{"POST", "/v1/sync", cors(app.Sync), true},
-		Route{"GET", "/v1/sync/fragment", cors(auth(app.GetSyncFragment, &proOnly)), true},
-		Route{"GET", "/v1/sync/state", cors(auth(app.GetSyncState, &proOnly)), true},
+		Route{"GET", "/v1/sync/fragment", cors(auth(app.GetSyncFragment, &syncOnly)), true},
+		Route{"GET", "/v1/sync/state", cors(auth(app.GetSyncState, &syncOnly)), true},
 
 		Route{"OPTIONS", "/v1/books", cors(app.BooksOptions), false},
 		Route{"GET", "/v1/demo/books", app.GetDemoBooks, true},
-		Route{"GET", "/v1/books", cors(auth(app.GetBooks, &proOnly)), true},
-		Route{"GET", "/v1/books/{bookUUID}", cors(auth(app.GetBook, &proOnly)), true},
+		Route{"GET", "/v1/books", cors(auth(app.GetBooks, &syncOnly)), true},
+		Route{"GET", "/v1/books/{bookUUID}", cors(auth(app.GetBook, &syncOnly)), true},
 		Route{"POST", "/v1/books", cors(app.CreateBook), false},
-		Route{"PATCH", "/v1/books/{bookUUID}", cors(auth(app.UpdateBook, &proOnly)), false},
-		Route{"DELETE", "/v1/books/{bookUUID}", cors(auth(app.DeleteBook, &proOnly)), false},
+		Route{"PATCH", "/v1/books/{bookUUID}", cors(auth(app.UpdateBook, &syncOnly)), false},
+		Route{"DELETE", "/v1/books/{bookUUID}", cors(auth(app.DeleteBook, &syncOnly)), false},
 
 		Route{"OPTIONS", "/v1/notes", cors(app.NotesOptions), true},
 		Route{"POST", "/v1/notes", cors(app.CreateNote), false},
-		Route{"PATCH", "/v1/notes/{noteUUID}", auth(app.UpdateNote, &proOnly), false},
-		Route{"DELETE", "/v1/notes/{noteUUID}", auth(app.DeleteNote, &proOnly), false},
+		Route{"PATCH", "/v1/notes/{noteUUID}", auth(app.UpdateNote, &syncOnly), false},
+		Route{"DELETE", "/v1/notes/{noteUUID}", auth(app.DeleteNote, &syncOnly), false},
 
 		Route{"POST", "/v1/register", app.register, true},
 		Route{"GET", "/v1/presignin", cors(app.presignin), true},
 		Route{"POST", "/v1/signin", cors(app.signin), true},
+		Route{"POST", "/v1/signin/totp", cors(app.signinTOTP), true},
 		Route{"OPTIONS", "/v1/signout", cors(app.signoutOptions), true},
 		Route{"POST", "/v1/signout", cors(app.signout), true},
+		Route{"GET", "/v1/sessions", cors(auth(app.GetSessions, nil)), true},
+		Route{"DELETE", "/v1/sessions/{sessionID}", cors(auth(app.DeleteSession, nil)), true},
+		Route{"POST", "/v1/sessions/refresh", cors(app.RefreshSession), true},
+		Route{"POST", "/v1/password-reset", cors(app.createResetToken), true},
+		Route{"GET", "/v1/password-reset", cors(app.getResetToken), true},
+		Route{"PATCH", "/v1/password-reset", cors(app.resetPassword), true},
+		Route{"PATCH", "/v1/account/recovery-key", cors(auth(app.updateRecoveryKey, nil)), true},
+		Route{"POST", "/v1/account/totp", cors(auth(app.enrollTOTP, nil)), true},
+		Route{"PATCH", "/v1/account/totp", cors(auth(app.enableTOTP, nil)), true},
+		Route{"DELETE", "/v1/account/totp", cors(auth(app.disableTOTP, nil)), true},
+		Route{"POST", "/v1/account/export", cors(auth(app.createAccountExport, nil)), true},
+		Route{"GET", "/v1/account/export", app.confirmAccountExport, true},
+		Route{"POST", "/v1/account/export/download", app.downloadAccountExport, true},
+		Route{"DELETE", "/v1/account", cors(auth(app.deleteAccount, nil)), true},
+		Route{"DELETE", "/v1/account/deletion", cors(auth(app.cancelAccountDeletion, nil)), true},
 
 		// v2
 		Route{"OPTIONS", "/v2/notes", cors(app.NotesOptionsV2), true},
-		Route{"POST", "/v2/notes", cors(auth(app.CreateNoteV2, &proOnly)), true},
+		Route{"POST", "/v2/notes", cors(auth(app.CreateNoteV2, &syncOnly)), true},
 
 		Route{"OPTIONS", "/v2/books", cors(app.BooksOptionsV2), true},
-		Route{"POST", "/v2/books", cors(auth(app.CreateBookV2, &proOnly)), true},
+		Route{"POST", "/v2/books", cors(auth(app.CreateBookV2, &syncOnly)), true},
+
+		Route{"POST", "/v2/sync/push", cors(auth(app.SyncPush, &syncOnly)), true},
+		Route{"GET", "/v2/sync/stream", cors(auth(app.GetSyncStream, &syncOnly)), true},
+	}
+
+	// routes for managing the subscriptions on Stripe
+	if app.Billing.Name() == billing.ProviderStripe {
+		routes = append(routes,
+			Route{"PATCH", "/subscriptions", auth(app.updateSub, nil), true},
+			Route{"POST", "/webhooks/stripe", app.stripeWebhook, true},
+			Route{"GET", "/subscriptions", auth(app.getSub, nil), true},
+			Route{"GET", "/stripe_source", auth(app.getStripeSource, nil), true},
+		)
 	}
 
 	router := mux.NewRouter().StrictSlash(true)
 	for _, route := range routes {
-		handler := route.HandlerFunc
+		handler := app.checkClientVersion(route.HandlerFunc)
 
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
-			Handler(applyMiddleware(handler, route.RateLimit))
+			Handler(app.applyMiddleware(handler, route))
 	}
 
 	return router
@@ -20,8 +20,10 @@ package handlers
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -423,3 +425,52 @@ func TestTokenAuthMiddleWare(t *testing.T) {
 		testutils.AssertEqual(t, res.StatusCode, http.StatusUnauthorized, "status code mismatch")
 	})
 }
+
+func TestLimitBodySize(t *testing.T) {
+	handler := limitBodySize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}), 10)
+
+	testCases := []struct {
+		name          string
+		body          string
+		contentLength int64
+		expected      int
+	}{
+		{
+			name:          "within the limit",
+			body:          "0123456789",
+			contentLength: 10,
+			expected:      http.StatusOK,
+		},
+		{
+			name:          "content length over the limit",
+			body:          "0123456789a",
+			contentLength: 11,
+			expected:      http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:          "unknown content length over the limit",
+			body:          "0123456789a",
+			contentLength: -1,
+			expected:      http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+			req.ContentLength = tc.contentLength
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			testutils.AssertEqual(t, w.Code, tc.expected, "status code mismatch")
+		})
+	}
+}
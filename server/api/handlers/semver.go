@@ -19,6 +19,7 @@
 package handlers
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 
@@ -60,3 +61,22 @@ func parseSemver(version string) (semver, error) {
 
 	return ret, nil
 }
+
+func (s semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", s.Major, s.Minor, s.Patch)
+}
+
+// compare returns -1 if s is lower than o, 1 if s is higher than o, and 0 if they are equal
+func (s semver) compare(o semver) int {
+	pairs := [][2]int{{s.Major, o.Major}, {s.Minor, o.Minor}, {s.Patch, o.Patch}}
+
+	for _, p := range pairs {
+		if p[0] < p[1] {
+			return -1
+		} else if p[0] > p[1] {
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,285 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/mailer"
+	"github.com/pkg/errors"
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/sub"
+	"github.com/stripe/stripe-go/webhook"
+)
+
+// types of the Stripe events processed by the webhook
+const (
+	stripeEventSubscriptionCreated      = "customer.subscription.created"
+	stripeEventSubscriptionUpdated      = "customer.subscription.updated"
+	stripeEventSubscriptionDeleted      = "customer.subscription.deleted"
+	stripeEventSubscriptionTrialWillEnd = "customer.subscription.trial_will_end"
+	stripeEventInvoicePaid              = "invoice.paid"
+	stripeEventInvoicePaymentSucceeded  = "invoice.payment_succeeded"
+	stripeEventInvoicePaymentFailed     = "invoice.payment_failed"
+)
+
+// stripeSubscriptionObject is a subscription in the payload of a Stripe event
+type stripeSubscriptionObject struct {
+	ID       string `json:"id"`
+	Customer string `json:"customer"`
+	Status   string `json:"status"`
+	TrialEnd int64  `json:"trial_end"`
+}
+
+// stripeInvoiceObject is an invoice in the payload of a Stripe event
+type stripeInvoiceObject struct {
+	ID                 string `json:"id"`
+	Customer           string `json:"customer"`
+	Subscription       string `json:"subscription"`
+	AmountDue          int64  `json:"amount_due"`
+	Currency           string `json:"currency"`
+	NextPaymentAttempt int64  `json:"next_payment_attempt"`
+}
+
+// eventTime returns the time at which the event was created
+func eventTime(event stripe.Event) time.Time {
+	return time.Unix(event.Created, 0).UTC()
+}
+
+func formatEmailDate(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format("January 2, 2006")
+}
+
+func formatAmount(amount int64, currency string) string {
+	return fmt.Sprintf("%.2f %s", float64(amount)/100, strings.ToUpper(currency))
+}
+
+// startStripeEvent records the event as being processed. It returns false if the event
+// has already been processed and must not be processed again.
+func startStripeEvent(event stripe.Event) (database.StripeEvent, bool, error) {
+	db := database.DBConn
+
+	var record database.StripeEvent
+	conn := db.Where("event_id = ?", event.ID).First(&record)
+	if conn.RecordNotFound() {
+		record = database.StripeEvent{
+			EventID: event.ID,
+			Type:    event.Type,
+			Status:  database.StripeEventStatusProcessing,
+		}
+		if err := db.Create(&record).Error; err != nil {
+			return record, false, errors.Wrap(err, "creating the event")
+		}
+
+		return record, true, nil
+	} else if err := conn.Error; err != nil {
+		return record, false, errors.Wrap(err, "finding the event")
+	}
+
+	if record.Status == database.StripeEventStatusProcessed || record.Status == database.StripeEventStatusIgnored {
+		return record, false, nil
+	}
+
+	// failed events, and the ones whose processing was interrupted, are processed again
+	return record, true, nil
+}
+
+// finishStripeEvent records the result of processing the event
+func (a *App) finishStripeEvent(record database.StripeEvent, status string, processErr error) error {
+	db := database.DBConn
+
+	var errMsg string
+	if processErr != nil {
+		errMsg = processErr.Error()
+	}
+
+	now := a.Clock.Now()
+	if err := db.Model(&record).Updates(map[string]interface{}{
+		"status":       status,
+		"error":        errMsg,
+		"processed_at": &now,
+	}).Error; err != nil {
+		return errors.Wrap(err, "updating the event")
+	}
+
+	return nil
+}
+
+// sendBillingEmail sends the email of the given type to the user with the Stripe customer
+func sendBillingEmail(stripeCustomerID, emailType, subject string, data interface{}) error {
+	db := database.DBConn
+
+	user, err := operations.FindStripeCustomer(stripeCustomerID)
+	if err != nil {
+		return err
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		return errors.Wrap(err, "finding account")
+	}
+	if !account.Email.Valid || account.Email.String == "" {
+		return errors.New("the account has no email")
+	}
+
+	email := mailer.NewEmail("noreply@dnote.io", []string{account.Email.String}, subject)
+	if err := email.ParseTemplate(emailType, data); err != nil {
+		return errors.Wrap(err, "parsing template")
+	}
+	if err := email.Send(); err != nil {
+		return errors.Wrap(err, "sending email")
+	}
+
+	return nil
+}
+
+func processSubscriptionEvent(event stripe.Event) error {
+	var s stripeSubscriptionObject
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return errors.Wrap(err, "unmarshalling the subscription")
+	}
+
+	switch event.Type {
+	case stripeEventSubscriptionDeleted:
+		return operations.MarkUnsubscribed(s.Customer, eventTime(event))
+	case stripeEventSubscriptionTrialWillEnd:
+		subject := "Your Dnote Pro trial ends soon"
+		data := mailer.TrialEndingTmplData{
+			Subject:  subject,
+			TrialEnd: formatEmailDate(s.TrialEnd),
+		}
+
+		return sendBillingEmail(s.Customer, mailer.EmailTypeTrialEnding, subject, data)
+	default:
+		return operations.UpdateCloud(s.Customer, operations.SubscriptionGrantsAccess(s.Status), eventTime(event))
+	}
+}
+
+func processInvoiceEvent(event stripe.Event) error {
+	var i stripeInvoiceObject
+	if err := json.Unmarshal(event.Data.Raw, &i); err != nil {
+		return errors.Wrap(err, "unmarshalling the invoice")
+	}
+
+	if event.Type != stripeEventInvoicePaymentFailed {
+		// a paid invoice does not mean that the subscription is still active, for instance
+		// if the event arrives after the subscription has been canceled
+		if i.Subscription == "" {
+			return nil
+		}
+
+		s, err := sub.Get(i.Subscription, nil)
+		if err != nil {
+			return errors.Wrap(err, "fetching the subscription")
+		}
+
+		return operations.UpdateCloud(i.Customer, operations.SubscriptionGrantsAccess(string(s.Status)), eventTime(event))
+	}
+
+	// the access is revoked by the subscription events if all retries fail
+	subject := "Your payment for Dnote Pro failed"
+	data := mailer.PaymentFailedTmplData{
+		Subject: subject,
+		Amount:  formatAmount(i.AmountDue, i.Currency),
+	}
+	if i.NextPaymentAttempt != 0 {
+		data.NextAttempt = formatEmailDate(i.NextPaymentAttempt)
+	}
+
+	return sendBillingEmail(i.Customer, mailer.EmailTypePaymentFailed, subject, data)
+}
+
+// processStripeEvent processes the event and returns the status of the event
+func processStripeEvent(event stripe.Event) (string, error) {
+	var err error
+
+	switch event.Type {
+	case stripeEventSubscriptionCreated, stripeEventSubscriptionUpdated, stripeEventSubscriptionDeleted, stripeEventSubscriptionTrialWillEnd:
+		err = processSubscriptionEvent(event)
+	case stripeEventInvoicePaid, stripeEventInvoicePaymentSucceeded, stripeEventInvoicePaymentFailed:
+		err = processInvoiceEvent(event)
+	default:
+		return database.StripeEventStatusIgnored, nil
+	}
+
+	// customers of other products share the Stripe account
+	if errors.Cause(err) == operations.ErrStripeCustomerNotFound {
+		return database.StripeEventStatusIgnored, err
+	}
+	if err != nil {
+		return database.StripeEventStatusFailed, err
+	}
+
+	return database.StripeEventStatusProcessed, nil
+}
+
+func (a *App) stripeWebhook(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logger.WithRequest(req).Err("Error reading request body: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	webhookSecret := os.Getenv("StripeWebhookSecret")
+	event, err := webhook.ConstructEvent(body, req.Header.Get("Stripe-Signature"), webhookSecret)
+	if err != nil {
+		logger.WithRequest(req).Err("Error verifying the signature: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	record, ok, err := startStripeEvent(event)
+	if err != nil {
+		logger.WithRequest(req).Err("Error recording the event %s: %v", event.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// the event has already been processed
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	status, processErr := processStripeEvent(event)
+	if err := a.finishStripeEvent(record, status, processErr); err != nil {
+		logger.WithRequest(req).Err("Error recording the result of the event %s: %v", event.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with an error so that Stripe retries the event
+	if status == database.StripeEventStatusFailed {
+		logger.WithRequest(req).Err("Error processing the event %s of type %s: %v", event.ID, event.Type, processErr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Return a response to acknowledge receipt of the event
+	w.WriteHeader(http.StatusOK)
+}
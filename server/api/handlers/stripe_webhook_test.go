@@ -0,0 +1,370 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/mailer"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/stripe/stripe-go"
+)
+
+var testWebhookSecret = "whsec_test"
+
+// setupStripeWebhookServer returns a server for the API, whose Stripe backend is served by
+// the given handler. If the handler is nil, the Stripe backend fails the test if it is called.
+func setupStripeWebhookServer(t *testing.T, stripeHandler http.HandlerFunc) (*httptest.Server, func()) {
+	os.Setenv("StripeWebhookSecret", testWebhookSecret)
+	mailer.InitTemplates("../../mailer/templates/src")
+
+	if stripeHandler == nil {
+		stripeHandler = func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected request to Stripe %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	stripeServer := httptest.NewServer(stripeHandler)
+
+	app := App{
+		Clock:            clock.NewMock(),
+		StripeAPIBackend: testutils.CreateMockStripeBackend(stripeServer),
+	}
+	server := httptest.NewServer(NewRouter(&app))
+
+	return server, func() {
+		server.Close()
+		stripeServer.Close()
+		os.Unsetenv("StripeWebhookSecret")
+	}
+}
+
+func makeStripeEventPayload(id, eventType, object string) string {
+	return makeStripeEventPayloadAt(id, eventType, object, time.Now().Unix())
+}
+
+func makeStripeEventPayloadAt(id, eventType, object string, created int64) string {
+	return fmt.Sprintf(`{
+	"id": "%s",
+	"object": "event",
+	"api_version": "%s",
+	"type": "%s",
+	"created": %d,
+	"data": {"object": %s}
+}`, id, stripe.APIVersion, eventType, created, object)
+}
+
+// serveStripeSubscription returns a handler for the Stripe backend that responds with the
+// subscription sub_1 in the given status
+func serveStripeSubscription(t *testing.T, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v1/subscriptions/sub_1" {
+			t.Errorf("unexpected request to Stripe %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"id": "sub_1", "object": "subscription", "customer": "cus_1", "status": "%s"}`, status)))
+	}
+}
+
+func signStripePayload(payload, secret string) string {
+	ts := time.Now().Unix()
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s", ts, payload)))
+
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func postStripeEvent(t *testing.T, server *httptest.Server, payload string) *http.Response {
+	req := testutils.MakeReq(server, "POST", "/webhooks/stripe", payload)
+	req.Header.Set("Stripe-Signature", signStripePayload(payload, testWebhookSecret))
+
+	return testutils.HTTPDo(t, req)
+}
+
+func setupStripeCustomer(cloud bool) database.User {
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"stripe_customer_id": "cus_1",
+		"cloud":              cloud,
+	}).Error; err != nil {
+		panic(err)
+	}
+
+	return user
+}
+
+func TestStripeWebhook_subscription(t *testing.T) {
+	testCases := []struct {
+		eventType     string
+		status        string
+		cloud         bool
+		expectedCloud bool
+	}{
+		{stripeEventSubscriptionCreated, "active", false, true},
+		{stripeEventSubscriptionCreated, "incomplete", false, false},
+		{stripeEventSubscriptionUpdated, "trialing", false, true},
+		{stripeEventSubscriptionUpdated, "past_due", true, true},
+		{stripeEventSubscriptionUpdated, "unpaid", true, false},
+		{stripeEventSubscriptionUpdated, "canceled", true, false},
+		{stripeEventSubscriptionDeleted, "canceled", true, false},
+		{stripeEventSubscriptionTrialWillEnd, "trialing", true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.eventType, tc.status), func(t *testing.T) {
+			// set up
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			server, teardown := setupStripeWebhookServer(t, nil)
+			defer teardown()
+
+			user := setupStripeCustomer(tc.cloud)
+
+			// execute
+			object := fmt.Sprintf(`{"id": "sub_1", "object": "subscription", "customer": "cus_1", "status": "%s", "trial_end": 1546646400}`, tc.status)
+			res := postStripeEvent(t, server, makeStripeEventPayload("evt_1", tc.eventType, object))
+
+			// test
+			testutils.AssertStatusCode(t, res, http.StatusOK, "status code mismatch")
+
+			var userRecord database.User
+			testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+			testutils.AssertEqual(t, userRecord.Cloud, tc.expectedCloud, "cloud mismatch")
+
+			var event database.StripeEvent
+			testutils.MustExec(t, db.Where("event_id = ?", "evt_1").First(&event), "finding event")
+			testutils.AssertEqual(t, event.Type, tc.eventType, "event type mismatch")
+			testutils.AssertEqual(t, event.Status, database.StripeEventStatusProcessed, "event status mismatch")
+			testutils.AssertEqual(t, event.ProcessedAt != nil, true, "event processed_at mismatch")
+		})
+	}
+}
+
+func TestStripeWebhook_invoice(t *testing.T) {
+	testCases := []struct {
+		eventType     string
+		status        string
+		cloud         bool
+		expectedCloud bool
+	}{
+		{stripeEventInvoicePaid, "active", false, true},
+		{stripeEventInvoicePaymentSucceeded, "active", false, true},
+		{stripeEventInvoicePaid, "canceled", false, false},
+		{stripeEventInvoicePaymentFailed, "past_due", true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.eventType, tc.status), func(t *testing.T) {
+			// set up
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			var stripeHandler http.HandlerFunc
+			if tc.eventType != stripeEventInvoicePaymentFailed {
+				stripeHandler = serveStripeSubscription(t, tc.status)
+			}
+			server, teardown := setupStripeWebhookServer(t, stripeHandler)
+			defer teardown()
+
+			user := setupStripeCustomer(tc.cloud)
+
+			// execute
+			object := `{"id": "in_1", "object": "invoice", "customer": "cus_1", "subscription": "sub_1", "amount_due": 300, "currency": "usd", "next_payment_attempt": 1546646400}`
+			res := postStripeEvent(t, server, makeStripeEventPayload("evt_1", tc.eventType, object))
+
+			// test
+			testutils.AssertStatusCode(t, res, http.StatusOK, "status code mismatch")
+
+			var userRecord database.User
+			testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+			testutils.AssertEqual(t, userRecord.Cloud, tc.expectedCloud, "cloud mismatch")
+
+			var event database.StripeEvent
+			testutils.MustExec(t, db.Where("event_id = ?", "evt_1").First(&event), "finding event")
+			testutils.AssertEqual(t, event.Status, database.StripeEventStatusProcessed, "event status mismatch")
+		})
+	}
+}
+
+func TestStripeWebhook_invoicePaidAfterDeletion(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	server, teardown := setupStripeWebhookServer(t, serveStripeSubscription(t, "canceled"))
+	defer teardown()
+
+	user := setupStripeCustomer(true)
+
+	// execute
+	res1 := postStripeEvent(t, server, makeStripeEventPayloadAt("evt_1", stripeEventSubscriptionDeleted,
+		`{"id": "sub_1", "object": "subscription", "customer": "cus_1", "status": "canceled"}`, 1546646400))
+	res2 := postStripeEvent(t, server, makeStripeEventPayloadAt("evt_2", stripeEventInvoicePaid,
+		`{"id": "in_1", "object": "invoice", "customer": "cus_1", "subscription": "sub_1", "amount_due": 300, "currency": "usd"}`, 1546646460))
+
+	// test
+	testutils.AssertStatusCode(t, res1, http.StatusOK, "res1 status code mismatch")
+	testutils.AssertStatusCode(t, res2, http.StatusOK, "res2 status code mismatch")
+
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+	testutils.AssertEqual(t, userRecord.Cloud, false, "cloud mismatch")
+}
+
+func TestStripeWebhook_outOfOrder(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	server, teardown := setupStripeWebhookServer(t, nil)
+	defer teardown()
+
+	user := setupStripeCustomer(true)
+
+	// execute
+	res1 := postStripeEvent(t, server, makeStripeEventPayloadAt("evt_2", stripeEventSubscriptionDeleted,
+		`{"id": "sub_1", "object": "subscription", "customer": "cus_1", "status": "canceled"}`, 1546646460))
+	// an older event is delivered late
+	res2 := postStripeEvent(t, server, makeStripeEventPayloadAt("evt_1", stripeEventSubscriptionUpdated,
+		`{"id": "sub_1", "object": "subscription", "customer": "cus_1", "status": "active"}`, 1546646400))
+
+	// test
+	testutils.AssertStatusCode(t, res1, http.StatusOK, "res1 status code mismatch")
+	testutils.AssertStatusCode(t, res2, http.StatusOK, "res2 status code mismatch")
+
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+	testutils.AssertEqual(t, userRecord.Cloud, false, "cloud mismatch")
+	testutils.AssertEqual(t, userRecord.StripeEventAt.Unix(), int64(1546646460), "stripe_event_at mismatch")
+}
+
+func TestStripeWebhook_duplicate(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	server, teardown := setupStripeWebhookServer(t, nil)
+	defer teardown()
+
+	user := setupStripeCustomer(true)
+	payload := makeStripeEventPayload("evt_1", stripeEventSubscriptionDeleted, `{"id": "sub_1", "object": "subscription", "customer": "cus_1", "status": "canceled"}`)
+
+	// execute
+	res1 := postStripeEvent(t, server, payload)
+	// the user subscribes again before Stripe delivers the event again
+	testutils.MustExec(t, db.Model(&user).Update("cloud", true), "updating user")
+	res2 := postStripeEvent(t, server, payload)
+
+	// test
+	testutils.AssertStatusCode(t, res1, http.StatusOK, "res1 status code mismatch")
+	testutils.AssertStatusCode(t, res2, http.StatusOK, "res2 status code mismatch")
+
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+	testutils.AssertEqual(t, userRecord.Cloud, true, "the event should not have been processed again")
+
+	var eventCount int
+	testutils.MustExec(t, db.Model(&database.StripeEvent{}).Count(&eventCount), "counting events")
+	testutils.AssertEqual(t, eventCount, 1, "event count mismatch")
+}
+
+func TestStripeWebhook_ignored(t *testing.T) {
+	testCases := []struct {
+		name    string
+		payload string
+	}{
+		{
+			name:    "unsupported type",
+			payload: makeStripeEventPayload("evt_1", "charge.succeeded", `{"id": "ch_1", "object": "charge", "customer": "cus_1"}`),
+		},
+		{
+			name:    "unknown customer",
+			payload: makeStripeEventPayload("evt_1", stripeEventSubscriptionDeleted, `{"id": "sub_1", "object": "subscription", "customer": "cus_other", "status": "canceled"}`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			server, teardown := setupStripeWebhookServer(t, nil)
+			defer teardown()
+
+			user := setupStripeCustomer(true)
+
+			// execute
+			res := postStripeEvent(t, server, tc.payload)
+
+			// test
+			testutils.AssertStatusCode(t, res, http.StatusOK, "status code mismatch")
+
+			var userRecord database.User
+			testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+			testutils.AssertEqual(t, userRecord.Cloud, true, "cloud mismatch")
+
+			var event database.StripeEvent
+			testutils.MustExec(t, db.Where("event_id = ?", "evt_1").First(&event), "finding event")
+			testutils.AssertEqual(t, event.Status, database.StripeEventStatusIgnored, "event status mismatch")
+		})
+	}
+}
+
+func TestStripeWebhook_invalidSignature(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	server, teardown := setupStripeWebhookServer(t, nil)
+	defer teardown()
+
+	payload := makeStripeEventPayload("evt_1", stripeEventSubscriptionDeleted, `{"id": "sub_1", "object": "subscription", "customer": "cus_1"}`)
+
+	// execute
+	req := testutils.MakeReq(server, "POST", "/webhooks/stripe", payload)
+	req.Header.Set("Stripe-Signature", signStripePayload(payload, "whsec_other"))
+	res := testutils.HTTPDo(t, req)
+
+	// test
+	testutils.AssertStatusCode(t, res, http.StatusBadRequest, "status code mismatch")
+
+	var eventCount int
+	testutils.MustExec(t, db.Model(&database.StripeEvent{}).Count(&eventCount), "counting events")
+	testutils.AssertEqual(t, eventCount, 0, "event count mismatch")
+}
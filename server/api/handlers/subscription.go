@@ -20,12 +20,10 @@ package handlers
 
 import (
 	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"os"
 
+	"github.com/dnote/dnote/server/api/billing"
 	"github.com/dnote/dnote/server/api/helpers"
-	"github.com/dnote/dnote/server/api/logger"
 	"github.com/dnote/dnote/server/api/operations"
 	"github.com/dnote/dnote/server/database"
 	"github.com/pkg/errors"
@@ -33,7 +31,6 @@ import (
 	"github.com/stripe/stripe-go/card"
 	"github.com/stripe/stripe-go/customer"
 	"github.com/stripe/stripe-go/sub"
-	"github.com/stripe/stripe-go/webhook"
 )
 
 type stripeToken struct {
@@ -41,24 +38,16 @@ type stripeToken struct {
 	Email string `json:"email"`
 }
 
-var planID = "plan_EpgsEvY27pajfo"
-
-func init() {
-}
+// StripePlanID is the id of the Stripe plan for the paid subscription
+var StripePlanID = "plan_EpgsEvY27pajfo"
 
 // createSub creates a subscription for a the current user
 func (a *App) createSub(w http.ResponseWriter, r *http.Request) {
-	db := database.DBConn
-
 	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
 	if !ok {
 		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
 		return
 	}
-	if user.StripeCustomerID != "" {
-		http.Error(w, "Customer already exists", http.StatusForbidden)
-		return
-	}
 
 	var tok stripeToken
 	if err := json.NewDecoder(r.Body).Decode(&tok); err != nil {
@@ -66,27 +55,15 @@ func (a *App) createSub(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	customerParams := &stripe.CustomerParams{
-		Plan:  &planID,
-		Email: &tok.Email,
-	}
-	err := customerParams.SetSource(tok.ID)
-	if err != nil {
-		http.Error(w, errors.Wrap(err, "setting source").Error(), http.StatusInternalServerError)
-		return
-	}
-
-	//TODO: if customer exists, update not create
-	c, err := customer.New(customerParams)
-	if err != nil {
-		http.Error(w, errors.Wrap(err, "creating customer").Error(), http.StatusInternalServerError)
-		return
-	}
-
-	user.StripeCustomerID = c.ID
-	user.Cloud = true
-	if err := db.Save(&user).Error; err != nil {
-		http.Error(w, errors.Wrap(err, "updating user").Error(), http.StatusInternalServerError)
+	if _, err := a.Billing.Subscribe(user, tok.Email, tok.ID); err != nil {
+		switch errors.Cause(err) {
+		case billing.ErrAlreadySubscribed:
+			http.Error(w, "Customer already exists", http.StatusForbidden)
+		case billing.ErrUnsupported:
+			http.Error(w, "Subscriptions are not available on this server", http.StatusNotFound)
+		default:
+			http.Error(w, errors.Wrap(err, "subscribing").Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 }
@@ -312,43 +289,3 @@ func (a *App) getStripeSource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
-
-func (a *App) stripeWebhook(w http.ResponseWriter, req *http.Request) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logger.Err("Error reading request body: %v\n", err)
-		w.WriteHeader(http.StatusServiceUnavailable)
-		return
-	}
-
-	webhookSecret := os.Getenv("StripeWebhookSecret")
-	event, err := webhook.ConstructEvent(body, req.Header.Get("Stripe-Signature"), webhookSecret)
-	if err != nil {
-		logger.Err("Error verifying the signature: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	switch event.Type {
-	case "customer.subscription.deleted":
-		{
-			var subscription stripe.Subscription
-			if json.Unmarshal(event.Data.Raw, &subscription); err != nil {
-				logger.Err(errors.Wrap(err, "unmarshaling").Error())
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-
-			operations.MarkUnsubscribed(subscription.Customer.ID)
-		}
-	default:
-		{
-			logger.Err("Unsupported webhook event type %s", event.Type)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-	}
-
-	// Return a response to acknowledge receipt of the event
-	w.WriteHeader(http.StatusOK)
-}
@@ -81,7 +81,11 @@ func (a *App) updateProfile(w http.ResponseWriter, r *http.Request) {
 	}
 	tx.Commit()
 
-	session := makeSession(user, account)
+	session, err := a.makeSession(user, account)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "making session").Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(session); err != nil {
@@ -153,7 +157,11 @@ func (a *App) updateEmail(w http.ResponseWriter, r *http.Request) {
 	}
 	tx.Commit()
 
-	session := makeSession(user, account)
+	session, err := a.makeSession(user, account)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "making session").Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(session); err != nil {
@@ -340,7 +348,11 @@ func (a *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session := makeSession(user, account)
+	session, err := a.makeSession(user, account)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "making session").Error(), http.StatusInternalServerError)
+		return
+	}
 	setAuthCookie(w, user)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(session); err != nil {
@@ -476,5 +488,56 @@ func (a *App) updatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithSession(w, user.ID, account.CipherKeyEnc)
+	a.respondWithSession(w, r, user.ID, account.CipherKeyEnc)
+}
+
+type updateRecoveryKeyPayload struct {
+	AuthKey              string `json:"auth_key"`
+	RecoveryAuthKey      string `json:"recovery_auth_key"`
+	CipherKeyRecoveryEnc string `json:"cipher_key_recovery_enc"`
+}
+
+// updateRecoveryKey registers a recovery key for the account, replacing any existing one.
+// The client sends the cipher key wrapped by the recovery key, and an auth key derived
+// from the recovery key, so that the server never sees the recovery key itself.
+func (a *App) updateRecoveryKey(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	var params updateRecoveryKeyPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+	if params.RecoveryAuthKey == "" || params.CipherKeyRecoveryEnc == "" {
+		http.Error(w, "recovery_auth_key and cipher_key_recovery_enc are required", http.StatusBadRequest)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "getting account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authKeyHash := crypt.HashAuthKey(params.AuthKey, account.Salt, account.ServerKDFIteration)
+	if account.AuthKeyHash != authKeyHash {
+		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	tx := db.Begin()
+	if err := operations.SetRecoveryKey(tx, account, params.RecoveryAuthKey, params.CipherKeyRecoveryEnc); err != nil {
+		tx.Rollback()
+		http.Error(w, errors.Wrap(err, "setting recovery key").Error(), http.StatusInternalServerError)
+		return
+	}
+	tx.Commit()
+
+	w.WriteHeader(http.StatusOK)
 }
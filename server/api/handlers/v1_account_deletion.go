@@ -0,0 +1,126 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/server/api/crypt"
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// accountDeletionGracePeriod is the duration after which a requested account deletion is
+// carried out by the job runner. The user can cancel the deletion until then.
+var accountDeletionGracePeriod = 14 * 24 * time.Hour
+
+// AccountDeletionResp is a response for a scheduled account deletion
+type AccountDeletionResp struct {
+	DeleteAt time.Time `json:"delete_at"`
+}
+
+type deleteAccountPayload struct {
+	AuthKey string `json:"auth_key"`
+}
+
+// deleteAccount schedules the deletion of the account after the grace period. It requires
+// the auth key so that a stolen session alone cannot delete the account.
+func (a *App) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	var params deleteAccountPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "getting account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authKeyHash := crypt.HashAuthKey(params.AuthKey, account.Salt, account.ServerKDFIteration)
+	if account.AuthKeyHash != authKeyHash {
+		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// keep the existing schedule so that repeating the request does not postpone the deletion
+	deleteAt := a.Clock.Now().Add(accountDeletionGracePeriod)
+	if user.DeleteAt != nil {
+		deleteAt = *user.DeleteAt
+	} else if err := db.Model(&user).Update("delete_at", deleteAt).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "scheduling deletion").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithRequest(r).WithFields(logger.Fields{
+		"user_id":   user.ID,
+		"delete_at": deleteAt,
+	}).Notice("account deletion scheduled")
+
+	resp := AccountDeletionResp{
+		DeleteAt: deleteAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// cancelAccountDeletion cancels the scheduled deletion of the account
+func (a *App) cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	conn := db.Model(&database.User{}).Where("id = ? AND delete_at IS NOT NULL", user.ID).Update("delete_at", nil)
+	if err := conn.Error; err != nil {
+		http.Error(w, errors.Wrap(err, "cancelling deletion").Error(), http.StatusInternalServerError)
+		return
+	}
+	if conn.RowsAffected == 0 {
+		http.Error(w, "The account is not scheduled for deletion", http.StatusBadRequest)
+		return
+	}
+
+	logger.WithRequest(r).WithFields(logger.Fields{
+		"user_id": user.ID,
+	}).Notice("account deletion cancelled")
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,161 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	scheduled := now.Add(time.Hour)
+	gracePeriodEnd := now.Add(accountDeletionGracePeriod)
+
+	testCases := []struct {
+		name             string
+		authKey          string
+		deleteAt         *time.Time
+		expectedStatus   int
+		expectedDeleteAt *time.Time
+	}{
+		{
+			name:             "valid auth key",
+			authKey:          "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=",
+			expectedStatus:   202,
+			expectedDeleteAt: &gracePeriodEnd,
+		},
+		{
+			name:             "already scheduled",
+			authKey:          "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=",
+			deleteAt:         &scheduled,
+			expectedStatus:   202,
+			expectedDeleteAt: &scheduled,
+		},
+		{
+			name:             "wrong auth key",
+			authKey:          "wrong-auth-key",
+			expectedStatus:   401,
+			expectedDeleteAt: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			c := clock.NewMock()
+			c.SetNow(now)
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: c,
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, "alice@example.com")
+			if tc.deleteAt != nil {
+				testutils.MustExec(t, db.Model(&user).Update("delete_at", *tc.deleteAt), "preparing user delete_at")
+			}
+
+			// Execute
+			dat := fmt.Sprintf(`{"auth_key": "%s"}`, tc.authKey)
+			req := testutils.MakeReq(server, "DELETE", "/v1/account", dat)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			var userRecord database.User
+			testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+			if tc.expectedDeleteAt == nil {
+				testutils.AssertEqual(t, userRecord.DeleteAt, (*time.Time)(nil), "delete_at mismatch")
+				return
+			}
+
+			testutils.AssertNotEqual(t, userRecord.DeleteAt, (*time.Time)(nil), "delete_at should be set")
+			testutils.AssertEqual(t, userRecord.DeleteAt.Equal(*tc.expectedDeleteAt), true, "delete_at mismatch")
+
+			var resp AccountDeletionResp
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding payload"))
+			}
+			testutils.AssertEqual(t, resp.DeleteAt.Equal(*tc.expectedDeleteAt), true, "response delete_at mismatch")
+		})
+	}
+}
+
+func TestCancelAccountDeletion(t *testing.T) {
+	testCases := []struct {
+		name           string
+		scheduled      bool
+		expectedStatus int
+	}{
+		{
+			name:           "scheduled",
+			scheduled:      true,
+			expectedStatus: 200,
+		},
+		{
+			name:           "not scheduled",
+			scheduled:      false,
+			expectedStatus: 400,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, "alice@example.com")
+			if tc.scheduled {
+				testutils.MustExec(t, db.Model(&user).Update("delete_at", time.Now().Add(time.Hour)), "preparing user delete_at")
+			}
+
+			// Execute
+			req := testutils.MakeReq(server, "DELETE", "/v1/account/deletion", "")
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			var userRecord database.User
+			testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+			testutils.AssertEqual(t, userRecord.DeleteAt, (*time.Time)(nil), "delete_at mismatch")
+		})
+	}
+}
@@ -0,0 +1,199 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// AccountExportResp is a response for an account export
+type AccountExportResp struct {
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// createAccountExport requests an archive of the data of the user. The archive is built
+// by the job runner, which emails the user a one-time link to download it.
+func (a *App) createAccountExport(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "finding account").Error(), http.StatusInternalServerError)
+		return
+	}
+	if !account.Email.Valid || account.Email.String == "" {
+		http.Error(w, "The account does not have an email to send the export to", http.StatusBadRequest)
+		return
+	}
+
+	var inProgress int
+	if err := db.Model(&database.AccountExport{}).
+		Where("user_id = ? AND status IN (?)", user.ID, []string{database.AccountExportStatusPending, database.AccountExportStatusProcessing}).
+		Count(&inProgress).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "counting exports in progress").Error(), http.StatusInternalServerError)
+		return
+	}
+	if inProgress > 0 {
+		http.Error(w, "An export is already in progress", http.StatusConflict)
+		return
+	}
+
+	export := database.AccountExport{
+		UserID: user.ID,
+		Status: database.AccountExportStatusPending,
+	}
+	export.CreatedAt = a.Clock.Now()
+	if err := db.Save(&export).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "saving export").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := AccountExportResp{
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// accountExportConfirmTmpl is the page that the link in the email opens. The archive is
+// downloaded only when the user submits the form, so that the link scanners of the email
+// providers do not use up the one-time token by following the link. The action of the form
+// is relative so that it works behind the path prefix of the API.
+var accountExportConfirmTmpl = template.Must(template.New("account_export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Download your Dnote data</title>
+</head>
+<body>
+<h1>Download your Dnote data</h1>
+<p>The link can be used only once.</p>
+<form method="POST" action="export/download">
+<input type="hidden" name="token" value="{{ .Token }}">
+<button type="submit">Download</button>
+</form>
+</body>
+</html>
+`))
+
+// findAccountExport finds the unused export token of the given value and the completed
+// export that it was issued for. It returns a non-zero status and a message if they cannot
+// be used to download the archive.
+func (a *App) findAccountExport(value string) (database.Token, database.AccountExport, int, string) {
+	db := database.DBConn
+
+	var token database.Token
+	var export database.AccountExport
+
+	if value == "" {
+		return token, export, http.StatusBadRequest, "token is required"
+	}
+
+	conn := db.Where("value = ? AND type = ?", value, database.TokenTypeAccountExport).First(&token)
+	if conn.RecordNotFound() {
+		return token, export, http.StatusBadRequest, "invalid token"
+	} else if err := conn.Error; err != nil {
+		return token, export, http.StatusInternalServerError, errors.Wrap(err, "finding token").Error()
+	}
+	if token.UsedAt != nil {
+		return token, export, http.StatusBadRequest, "invalid token"
+	}
+
+	conn = db.Where("token_id = ? AND user_id = ? AND status = ?", token.ID, token.UserID, database.AccountExportStatusCompleted).
+		First(&export)
+	if conn.RecordNotFound() || (export.ExpiresAt != nil && a.Clock.Now().After(*export.ExpiresAt)) {
+		return token, export, http.StatusGone, "This link has been expired. Please request a new export."
+	} else if err := conn.Error; err != nil {
+		return token, export, http.StatusInternalServerError, errors.Wrap(err, "finding export").Error()
+	}
+
+	return token, export, 0, ""
+}
+
+// confirmAccountExport responds with a page on which the user confirms the download of
+// the archive. It does not use the token.
+func (a *App) confirmAccountExport(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("token")
+
+	_, _, status, msg := a.findAccountExport(value)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := accountExportConfirmTmpl.Execute(w, struct{ Token string }{Token: value}); err != nil {
+		http.Error(w, errors.Wrap(err, "rendering page").Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// downloadAccountExport responds with the archive of the export for which the token was
+// issued. The token can be used only once.
+func (a *App) downloadAccountExport(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	token, export, status, msg := a.findAccountExport(r.PostFormValue("token"))
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	// mark the token as used unless another request has used it first
+	conn := db.Model(&database.Token{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", a.Clock.Now())
+	if err := conn.Error; err != nil {
+		http.Error(w, errors.Wrap(err, "using token").Error(), http.StatusInternalServerError)
+		return
+	}
+	if conn.RowsAffected == 0 {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("dnote-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(export.Archive)
+}
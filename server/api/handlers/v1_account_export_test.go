@@ -0,0 +1,280 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestCreateAccountExport(t *testing.T) {
+	testCases := []struct {
+		name           string
+		email          string
+		existingStatus string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:           "no export",
+			email:          "alice@example.com",
+			expectedStatus: 202,
+			expectedCount:  1,
+		},
+		{
+			name:           "completed export",
+			email:          "alice@example.com",
+			existingStatus: database.AccountExportStatusCompleted,
+			expectedStatus: 202,
+			expectedCount:  2,
+		},
+		{
+			name:           "pending export",
+			email:          "alice@example.com",
+			existingStatus: database.AccountExportStatusPending,
+			expectedStatus: 409,
+			expectedCount:  1,
+		},
+		{
+			name:           "processing export",
+			email:          "alice@example.com",
+			existingStatus: database.AccountExportStatusProcessing,
+			expectedStatus: 409,
+			expectedCount:  1,
+		},
+		{
+			name:           "no email",
+			email:          "",
+			expectedStatus: 400,
+			expectedCount:  0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, tc.email)
+			if tc.existingStatus != "" {
+				e := database.AccountExport{UserID: user.ID, Status: tc.existingStatus}
+				testutils.MustExec(t, db.Save(&e), "preparing export")
+			}
+
+			// Execute
+			req := testutils.MakeReq(server, "POST", "/v1/account/export", "")
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			var count int
+			testutils.MustExec(t, db.Model(&database.AccountExport{}).Where("user_id = ?", user.ID).Count(&count), "counting exports")
+			testutils.AssertEqual(t, count, tc.expectedCount, "export count mismatch")
+		})
+	}
+}
+
+func TestConfirmAccountExport(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+
+	c := clock.NewMock()
+	c.SetNow(now)
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+
+	token := database.Token{
+		UserID: user.ID,
+		Value:  "some-token",
+		Type:   database.TokenTypeAccountExport,
+	}
+	testutils.MustExec(t, db.Save(&token), "preparing token")
+
+	export := database.AccountExport{
+		UserID:    user.ID,
+		TokenID:   token.ID,
+		Status:    database.AccountExportStatusCompleted,
+		Archive:   []byte("some-archive"),
+		ExpiresAt: &expiresAt,
+	}
+	testutils.MustExec(t, db.Save(&export), "preparing export")
+
+	// Execute
+	req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v1/account/export?token=%s", token.Value), "")
+	res := testutils.HTTPDo(t, req)
+
+	// Test
+	testutils.AssertStatusCode(t, res, 200, "")
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading body"))
+	}
+	testutils.AssertEqual(t, strings.Contains(string(body), `value="some-token"`), true, "the page should have the token")
+	testutils.AssertEqual(t, strings.Contains(string(body), "some-archive"), false, "the page should not have the archive")
+
+	var tokenRecord database.Token
+	testutils.MustExec(t, db.Where("id = ?", token.ID).First(&tokenRecord), "finding token")
+	testutils.AssertEqual(t, tokenRecord.UsedAt, (*time.Time)(nil), "the token should not be used")
+}
+
+func downloadAccountExport(t *testing.T, server *httptest.Server, token string) *http.Response {
+	form := url.Values{"token": []string{token}}
+	req := testutils.MakeReq(server, "POST", "/v1/account/export/download", form.Encode())
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return testutils.HTTPDo(t, req)
+}
+
+func TestDownloadAccountExport(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	usedAt := now.Add(-time.Minute)
+
+	testCases := []struct {
+		name           string
+		tokenType      string
+		usedAt         *time.Time
+		expiresAt      time.Time
+		otherExport    bool
+		expectedStatus int
+	}{
+		{
+			name:           "valid",
+			tokenType:      database.TokenTypeAccountExport,
+			expiresAt:      now.Add(time.Hour),
+			expectedStatus: 200,
+		},
+		{
+			name:           "used token",
+			tokenType:      database.TokenTypeAccountExport,
+			usedAt:         &usedAt,
+			expiresAt:      now.Add(time.Hour),
+			expectedStatus: 400,
+		},
+		{
+			name:           "wrong token type",
+			tokenType:      database.TokenTypeResetPassword,
+			expiresAt:      now.Add(time.Hour),
+			expectedStatus: 400,
+		},
+		{
+			name:           "token of another export",
+			tokenType:      database.TokenTypeAccountExport,
+			expiresAt:      now.Add(time.Hour),
+			otherExport:    true,
+			expectedStatus: 410,
+		},
+		{
+			name:           "expired export",
+			tokenType:      database.TokenTypeAccountExport,
+			expiresAt:      now.Add(-time.Hour),
+			expectedStatus: 410,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			c := clock.NewMock()
+			c.SetNow(now)
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: c,
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, "alice@example.com")
+
+			token := database.Token{
+				UserID: user.ID,
+				Value:  "some-token",
+				Type:   tc.tokenType,
+				UsedAt: tc.usedAt,
+			}
+			testutils.MustExec(t, db.Save(&token), "preparing token")
+
+			export := database.AccountExport{
+				UserID:    user.ID,
+				TokenID:   token.ID,
+				Status:    database.AccountExportStatusCompleted,
+				Archive:   []byte("some-archive"),
+				ExpiresAt: &tc.expiresAt,
+			}
+			if tc.otherExport {
+				export.TokenID = token.ID + 1
+			}
+			testutils.MustExec(t, db.Save(&export), "preparing export")
+
+			// Execute
+			res := downloadAccountExport(t, server, token.Value)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			if tc.expectedStatus == 200 {
+				body, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "reading body"))
+				}
+
+				testutils.AssertEqual(t, string(body), "some-archive", "archive mismatch")
+				testutils.AssertEqual(t, res.Header.Get("Content-Type"), "application/zip", "content type mismatch")
+
+				var tokenRecord database.Token
+				testutils.MustExec(t, db.Where("id = ?", token.ID).First(&tokenRecord), "finding token")
+				testutils.AssertNotEqual(t, tokenRecord.UsedAt, (*time.Time)(nil), "used_at mismatch")
+
+				// the token can be used only once
+				res := downloadAccountExport(t, server, token.Value)
+				testutils.AssertStatusCode(t, res, 400, "using the token again")
+			}
+		})
+	}
+}
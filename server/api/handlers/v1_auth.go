@@ -19,25 +19,40 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/dnote/dnote/server/api/crypt"
+	"github.com/dnote/dnote/server/api/logger"
 	"github.com/dnote/dnote/server/api/operations"
 	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/mailer"
+	"github.com/jinzhu/gorm"
 )
 
 // ErrLoginFailure is an error for failed login
 var ErrLoginFailure = errors.New("Wrong email and password combination")
 
+// dummySalt is the salt with which the auth key is hashed for the emails that no account has
+var dummySalt = "ZHVtbXktc2FsdC1mb3Itc2k="
+
 // SessionResponse is a response containing a session information
 type SessionResponse struct {
 	Key          string `json:"key"`
 	ExpiresAt    int64  `json:"expires_at"`
 	CipherKeyEnc string `json:"cipher_key_enc"`
+	// RefreshToken is given only to the CLI, because the web client keeps the
+	// session in a cookie that cannot be read by scripts
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 type signinPayload struct {
@@ -70,6 +85,67 @@ func unsetSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &cookie)
 }
 
+// checkSigninWait responds with 429 if the attempts to sign in with the email must wait
+// because of the previous failures. It returns whether the attempt can proceed.
+func (a *App) checkSigninWait(w http.ResponseWriter, email string) bool {
+	wait, err := operations.GetSigninWait(database.DBConn, a.Clock, email)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "checking signin failures").Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many failed attempts to sign in. Please try again later.", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+// handleSigninFailure records a failed attempt to sign in with the email and responds
+// with 401. If the attempt locks the email, it notifies the account, if any.
+func (a *App) handleSigninFailure(w http.ResponseWriter, r *http.Request, email string, account *database.Account) {
+	locked, err := operations.RecordSigninFailure(database.DBConn, a.Clock, email)
+	if err != nil {
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("recording signin failure")
+	}
+
+	if locked && account != nil {
+		if err := sendAccountLockedEmail(email); err != nil {
+			logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("sending account locked email")
+		}
+	}
+
+	http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+}
+
+// clearSigninFailures forgets the failed attempts to sign in with the email after a
+// successful attempt
+func clearSigninFailures(r *http.Request, email string) {
+	if err := operations.ClearSigninFailures(database.DBConn, email); err != nil {
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("clearing signin failures")
+	}
+}
+
+func sendAccountLockedEmail(to string) error {
+	subject := "Signing in to your Dnote account is locked"
+	data := mailer.AccountLockedTmplData{
+		Subject:  subject,
+		Duration: fmt.Sprintf("%d minutes", int(operations.SigninLockDuration.Minutes())),
+	}
+
+	email := mailer.NewEmail("noreply@dnote.io", []string{to}, subject)
+	if err := email.ParseTemplate(mailer.EmailTypeAccountLocked, data); err != nil {
+		return errors.Wrap(err, "parsing template")
+	}
+	if err := email.Send(); err != nil {
+		return errors.Wrap(err, "sending email")
+	}
+
+	return nil
+}
+
 func (a *App) signin(w http.ResponseWriter, r *http.Request) {
 	db := database.DBConn
 
@@ -84,10 +160,17 @@ func (a *App) signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if ok := a.checkSigninWait(w, params.Email); !ok {
+		return
+	}
+
 	var account database.Account
 	conn := db.Where("email = ?", params.Email).First(&account)
 	if conn.RecordNotFound() {
-		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		// hash the key anyway so that the response takes as long as for an existing account
+		crypt.HashAuthKey(params.AuthKey, dummySalt, crypt.ServerKDFIteration)
+
+		a.handleSigninFailure(w, r, params.Email, nil)
 		return
 	} else if err := conn.Error; err != nil {
 		http.Error(w, errors.Wrap(err, "getting user").Error(), http.StatusInternalServerError)
@@ -96,11 +179,18 @@ func (a *App) signin(w http.ResponseWriter, r *http.Request) {
 
 	authKeyHash := crypt.HashAuthKey(params.AuthKey, account.Salt, account.ServerKDFIteration)
 	if account.AuthKeyHash != authKeyHash {
-		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		a.handleSigninFailure(w, r, params.Email, &account)
+		return
+	}
+
+	clearSigninFailures(r, params.Email)
+
+	if account.TOTPEnabled {
+		a.respondWithTOTPChallenge(w, account.UserID)
 		return
 	}
 
-	respondWithSession(w, account.UserID, account.CipherKeyEnc)
+	a.respondWithSession(w, r, account.UserID, account.CipherKeyEnc)
 }
 
 func (a *App) signoutOptions(w http.ResponseWriter, r *http.Request) {
@@ -135,6 +225,10 @@ type registerPayload struct {
 	AuthKey      string `json:"auth_key"`
 	Iteration    int    `json:"iteration"`
 	CipherKeyEnc string `json:"cipher_key_enc"`
+	// CipherKeyRecoveryEnc and RecoveryAuthKey are optional, and are given if the client
+	// generated a recovery key upon registration
+	CipherKeyRecoveryEnc string `json:"cipher_key_recovery_enc"`
+	RecoveryAuthKey      string `json:"recovery_auth_key"`
 }
 
 func validateRegisterPayload(p registerPayload) error {
@@ -150,6 +244,9 @@ func validateRegisterPayload(p registerPayload) error {
 	if p.CipherKeyEnc == "" {
 		return errors.New("cipher_key_enc is required")
 	}
+	if (p.CipherKeyRecoveryEnc == "") != (p.RecoveryAuthKey == "") {
+		return errors.New("cipher_key_recovery_enc and recovery_auth_key must be given together")
+	}
 
 	return nil
 }
@@ -193,30 +290,47 @@ func (a *App) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if params.RecoveryAuthKey != "" {
+		if err := operations.SetRecoveryKey(tx, account, params.RecoveryAuthKey, params.CipherKeyRecoveryEnc); err != nil {
+			tx.Rollback()
+			http.Error(w, "setting recovery key", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	tx.Commit()
 
-	respondWithSession(w, user.ID, account.CipherKeyEnc)
+	a.respondWithSession(w, r, user.ID, account.CipherKeyEnc)
 }
 
 // respondWithSession makes a HTTP response with the session from the user with the given userID.
 // It sets the HTTP-Only cookie for browser clients and also sends a JSON response for non-browser clients.
-func respondWithSession(w http.ResponseWriter, userID int, cipherKeyEnc string) {
+func (a *App) respondWithSession(w http.ResponseWriter, r *http.Request, userID int, cipherKeyEnc string) {
 	db := database.DBConn
 
-	session, err := operations.CreateSession(db, userID)
+	clientType := getRequestClientType(r)
+	session, err := operations.CreateSession(db, a.Clock, userID, clientType, r.UserAgent(), lookupIP(r, a.TrustedProxies))
 	if err != nil {
 		http.Error(w, "creating session", http.StatusBadRequest)
 		return
 	}
 
-	setSessionCookie(w, session.Key, session.ExpiresAt)
+	// The session is renewed as it is used, so the cookie lasts until the session can no
+	// longer be renewed
+	setSessionCookie(w, session.Key, operations.SessionDeadline(session))
 
 	response := SessionResponse{
 		Key:          session.Key,
 		ExpiresAt:    session.ExpiresAt.Unix(),
 		CipherKeyEnc: cipherKeyEnc,
 	}
+	if clientType == "cli" {
+		response.RefreshToken = session.RefreshToken
+	}
 	w.Header().Set("Content-Type", "application/json")
+	// the response has the session key, so that it is neither cached nor stored for the
+	// replays of idempotent requests
+	w.Header().Set("Cache-Control", "no-store")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -228,6 +342,57 @@ type PresigninResponse struct {
 	Iteration int `json:"iteration"`
 }
 
+// getFakeClientKDFIteration returns the iteration count for an email that no account has.
+// It is picked from the iteration counts of the existing accounts, as often as the accounts
+// have them, by an HMAC of the email. The same email always gets the same count, so that
+// the response does not reveal whether an account has the email.
+func (a *App) getFakeClientKDFIteration(db *gorm.DB, email string) (int, error) {
+	rows, err := db.Model(&database.Account{}).
+		Select("client_kdf_iteration, count(*)").
+		Group("client_kdf_iteration").
+		Order("client_kdf_iteration").
+		Rows()
+	if err != nil {
+		return 0, errors.Wrap(err, "counting the iterations")
+	}
+	defer rows.Close()
+
+	var iterations []int
+	var counts []uint64
+	var total uint64
+	for rows.Next() {
+		var iteration int
+		var count uint64
+		if err := rows.Scan(&iteration, &count); err != nil {
+			return 0, errors.Wrap(err, "scanning the row")
+		}
+
+		iterations = append(iterations, iteration)
+		counts = append(counts, count)
+		total += count
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err, "reading the rows")
+	}
+
+	if total == 0 {
+		return crypt.DefaultClientKDFIteration, nil
+	}
+
+	mac := hmac.New(sha256.New, a.PresigninSecret)
+	mac.Write([]byte(email))
+	n := binary.BigEndian.Uint64(mac.Sum(nil)) % total
+
+	for i, count := range counts {
+		if n < count {
+			return iterations[i], nil
+		}
+		n -= count
+	}
+
+	return iterations[len(iterations)-1], nil
+}
+
 func (a *App) presignin(w http.ResponseWriter, r *http.Request) {
 	db := database.DBConn
 
@@ -247,8 +412,14 @@ func (a *App) presignin(w http.ResponseWriter, r *http.Request) {
 
 	var response PresigninResponse
 	if conn.RecordNotFound() {
+		iteration, err := a.getFakeClientKDFIteration(db, email)
+		if err != nil {
+			http.Error(w, errors.Wrap(err, "getting iteration").Error(), http.StatusInternalServerError)
+			return
+		}
+
 		response = PresigninResponse{
-			Iteration: 100000,
+			Iteration: iteration,
 		}
 	} else {
 		response = PresigninResponse{
@@ -0,0 +1,80 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestPresignin(t *testing.T) {
+	// set up
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	server := httptest.NewServer(NewRouter(&App{
+		PresigninSecret: []byte("presignin-secret"),
+	}))
+	defer server.Close()
+
+	u1 := testutils.SetupUserData()
+	testutils.SetupAccountData(u1, "alice@example.com")
+	u2 := testutils.SetupUserData()
+	a2 := testutils.SetupAccountData(u2, "bob@example.com")
+	testutils.MustExec(t, db.Model(&a2).Update("client_kdf_iteration", 200000), "preparing a2 iteration")
+
+	presignin := func(email string) int {
+		req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v1/presignin?email=%s", url.QueryEscape(email)), "")
+		res := testutils.HTTPDo(t, req)
+		testutils.AssertStatusCode(t, res, http.StatusOK, fmt.Sprintf("status code mismatch for %s", email))
+
+		var payload PresigninResponse
+		if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding payload"))
+		}
+
+		return payload.Iteration
+	}
+
+	// execute and test
+	testutils.AssertEqual(t, presignin("alice@example.com"), 100000, "alice iteration mismatch")
+	testutils.AssertEqual(t, presignin("bob@example.com"), 200000, "bob iteration mismatch")
+
+	seen := map[int]bool{}
+	for i := 0; i < 20; i++ {
+		email := fmt.Sprintf("user%d@example.com", i)
+
+		iteration := presignin(email)
+		if iteration != 100000 && iteration != 200000 {
+			t.Errorf("iteration for %s is not one of the existing accounts: %d", email, iteration)
+		}
+		testutils.AssertEqual(t, presignin(email), iteration, fmt.Sprintf("iteration for %s should not change", email))
+
+		seen[iteration] = true
+	}
+	testutils.AssertEqual(t, len(seen), 2, "the iterations should follow the existing accounts")
+}
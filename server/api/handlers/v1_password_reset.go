@@ -0,0 +1,295 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/mailer"
+	"github.com/pkg/errors"
+)
+
+var (
+	// resetTokenTTL is the duration for which a password reset token is valid
+	resetTokenTTL = 30 * time.Minute
+	// resetTokenInterval is the minimum duration between password reset emails for an account
+	resetTokenInterval = 1 * time.Minute
+)
+
+type createResetTokenPayload struct {
+	Email string `json:"email"`
+}
+
+// createResetToken issues a password reset token and emails it to the account owner.
+// It responds with the same status regardless of whether the account exists, so that
+// it cannot be used to find out registered emails.
+func (a *App) createResetToken(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	var params createResetTokenPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+	if params.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	var account database.Account
+	conn := db.Where("email = ?", params.Email).First(&account)
+	if conn.RecordNotFound() {
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if err := conn.Error; err != nil {
+		http.Error(w, errors.Wrap(err, "finding account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := a.Clock.Now()
+
+	var recentCount int
+	if err := db.Model(database.Token{}).
+		Where("user_id = ? AND type = ? AND created_at > ?", account.UserID, database.TokenTypeResetPassword, now.Add(-resetTokenInterval)).
+		Count(&recentCount).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "counting recent tokens").Error(), http.StatusInternalServerError)
+		return
+	}
+	if recentCount > 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	tokenValue, err := generateResetToken()
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "generating token").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token := database.Token{
+		UserID: account.UserID,
+		Value:  tokenValue,
+		Type:   database.TokenTypeResetPassword,
+	}
+	token.CreatedAt = now
+
+	if err := db.Save(&token).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "saving token").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	subject := "Reset your password"
+	data := struct {
+		Subject string
+		Token   string
+	}{
+		subject,
+		tokenValue,
+	}
+	email := mailer.NewEmail("noreply@dnote.io", []string{params.Email}, subject)
+	if err := email.ParseTemplate(mailer.EmailTypeResetPassword, data); err != nil {
+		http.Error(w, errors.Wrap(err, "parsing template").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := email.Send(); err != nil {
+		http.Error(w, errors.Wrap(err, "sending email").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// findResetToken finds a usable password reset token with the given value. It returns
+// a non-zero status code along with an error message if the token cannot be used.
+func (a *App) findResetToken(value string) (database.Token, int, string) {
+	db := database.DBConn
+
+	var token database.Token
+	conn := db.Where("value = ? AND type = ?", value, database.TokenTypeResetPassword).First(&token)
+	if conn.RecordNotFound() {
+		return token, http.StatusBadRequest, "invalid token"
+	} else if err := conn.Error; err != nil {
+		return token, http.StatusInternalServerError, errors.Wrap(err, "finding token").Error()
+	}
+
+	if token.UsedAt != nil {
+		return token, http.StatusBadRequest, "invalid token"
+	}
+
+	// Expire after ttl
+	if a.Clock.Now().Sub(token.CreatedAt) > resetTokenTTL {
+		return token, http.StatusGone, "This link has been expired. Please request a new link."
+	}
+
+	return token, 0, ""
+}
+
+// PasswordResetResponse is a response for a password reset token lookup
+type PasswordResetResponse struct {
+	Email                string `json:"email"`
+	CipherKeyRecoveryEnc string `json:"cipher_key_recovery_enc"`
+	TOTPRequired         bool   `json:"totp_required"`
+}
+
+// getResetToken checks the validity of a password reset token and responds with the
+// email of the account, which clients need in order to derive the new keys. If the account
+// has a recovery key, it also responds with the cipher key wrapped by the recovery key.
+func (a *App) getResetToken(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	token, status, msg := a.findResetToken(r.URL.Query().Get("token"))
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", token.UserID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "finding account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := PasswordResetResponse{
+		Email:                account.Email.String,
+		CipherKeyRecoveryEnc: account.CipherKeyRecoveryEnc,
+		TOTPRequired:         account.TOTPEnabled,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type resetPasswordPayload struct {
+	Token        string `json:"token"`
+	AuthKey      string `json:"auth_key"`
+	CipherKeyEnc string `json:"cipher_key_enc"`
+	Iteration    int    `json:"iteration"`
+	// RecoveryAuthKey is an optional auth key derived from the recovery key. It is
+	// given when the client recovered the cipher key using the recovery key.
+	RecoveryAuthKey string `json:"recovery_auth_key"`
+	// Code is a second factor, required if two-factor authentication is enabled
+	Code string `json:"code"`
+}
+
+func validateResetPasswordPayload(p resetPasswordPayload) error {
+	if p.Token == "" {
+		return errors.New("token is required")
+	}
+	if p.AuthKey == "" {
+		return errors.New("auth_key is required")
+	}
+	if p.Iteration == 0 {
+		return errors.New("iteration is required")
+	}
+	if p.CipherKeyEnc == "" {
+		return errors.New("cipher_key_enc is required")
+	}
+
+	return nil
+}
+
+// resetPassword consumes a password reset token and sets the new credentials. Because
+// the server never sees the plaintext cipher key, the client either recovers it using
+// the recovery key and re-wraps it with the new password, or generates a new one in which
+// case the data encrypted with the previous key is wiped.
+func (a *App) resetPassword(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	var params resetPasswordPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := validateResetPasswordPayload(params); err != nil {
+		http.Error(w, errors.Wrap(err, "validating payload").Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, status, msg := a.findResetToken(params.Token)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", token.UserID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "finding account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recovered := params.RecoveryAuthKey != ""
+	if recovered && !operations.CheckRecoveryKey(account, params.RecoveryAuthKey) {
+		a.respondResetTokenFailure(w, token, "wrong recovery key")
+		return
+	}
+
+	tx := db.Begin()
+
+	if account.TOTPEnabled {
+		ok, err := operations.CheckSecondFactor(tx, a.Clock, account, params.Code)
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, errors.Wrap(err, "checking code").Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			tx.Rollback()
+			a.respondResetTokenFailure(w, token, operations.ErrInvalidTOTPCode.Error())
+			return
+		}
+	}
+
+	if err := operations.ResetPassword(tx, a.Clock, account, token, params.AuthKey, params.CipherKeyEnc, params.Iteration, recovered); err != nil {
+		tx.Rollback()
+
+		if err == operations.ErrResetTokenUsed {
+			http.Error(w, "invalid token", http.StatusBadRequest)
+			return
+		}
+
+		http.Error(w, errors.Wrap(err, "resetting password").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		http.Error(w, errors.Wrap(err, "committing transaction").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.respondWithSession(w, r, account.UserID, params.CipherKeyEnc)
+}
+
+// respondResetTokenFailure counts a wrong recovery key or second factor against the
+// password reset token, so that the token is used up after a few, and responds with the
+// given message
+func (a *App) respondResetTokenFailure(w http.ResponseWriter, token database.Token, msg string) {
+	if _, err := operations.RecordResetTokenFailure(database.DBConn, a.Clock, token); err != nil {
+		http.Error(w, errors.Wrap(err, "recording failure").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(w, msg, http.StatusUnauthorized)
+}
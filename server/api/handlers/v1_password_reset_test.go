@@ -0,0 +1,266 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/mailer"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestCreateResetToken(t *testing.T) {
+	testCases := []struct {
+		name          string
+		email         string
+		expectedCount int
+	}{
+		{
+			name:          "existing email",
+			email:         "alice@example.com",
+			expectedCount: 1,
+		},
+		{
+			name:          "unknown email",
+			email:         "bob@example.com",
+			expectedCount: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			mailer.InitTemplates("../../mailer/templates/src")
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, "alice@example.com")
+
+			// Execute
+			dat := fmt.Sprintf(`{"email": "%s"}`, tc.email)
+			req := testutils.MakeReq(server, "POST", "/v1/password-reset", dat)
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			testutils.AssertStatusCode(t, res, 200, "")
+
+			var tokenCount int
+			testutils.MustExec(t, db.Model(&database.Token{}).Where("type = ?", database.TokenTypeResetPassword).Count(&tokenCount), "counting tokens")
+			testutils.AssertEqual(t, tokenCount, tc.expectedCount, "token count mismatch")
+		})
+	}
+}
+
+func TestGetResetToken(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	usedAt := now.Add(-time.Minute)
+
+	testCases := []struct {
+		name           string
+		createdAt      time.Time
+		usedAt         *time.Time
+		expectedStatus int
+	}{
+		{
+			name:           "valid",
+			createdAt:      now.Add(-time.Minute),
+			expectedStatus: 200,
+		},
+		{
+			name:           "used",
+			createdAt:      now.Add(-time.Minute),
+			usedAt:         &usedAt,
+			expectedStatus: 400,
+		},
+		{
+			name:           "expired",
+			createdAt:      now.Add(-resetTokenTTL - time.Minute),
+			expectedStatus: 410,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			c := clock.NewMock()
+			c.SetNow(now)
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: c,
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, "alice@example.com")
+
+			token := database.Token{
+				UserID: user.ID,
+				Value:  "some-token",
+				Type:   database.TokenTypeResetPassword,
+				UsedAt: tc.usedAt,
+			}
+			token.CreatedAt = tc.createdAt
+			testutils.MustExec(t, db.Save(&token), "preparing token")
+
+			// Execute
+			req := testutils.MakeReq(server, "GET", "/v1/password-reset?token=some-token", "")
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			if tc.expectedStatus == 200 {
+				var payload PasswordResetResponse
+				if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+					t.Fatal(errors.Wrap(err, "decoding payload"))
+				}
+				testutils.AssertEqual(t, payload.Email, "alice@example.com", "email mismatch")
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	usedAt := now.Add(-time.Minute)
+
+	testCases := []struct {
+		name           string
+		createdAt      time.Time
+		usedAt         *time.Time
+		expectedStatus int
+	}{
+		{
+			name:           "valid",
+			createdAt:      now.Add(-time.Minute),
+			expectedStatus: 200,
+		},
+		{
+			name:           "used",
+			createdAt:      now.Add(-time.Minute),
+			usedAt:         &usedAt,
+			expectedStatus: 400,
+		},
+		{
+			name:           "expired",
+			createdAt:      now.Add(-resetTokenTTL - time.Minute),
+			expectedStatus: 410,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			c := clock.NewMock()
+			c.SetNow(now)
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: c,
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, "alice@example.com")
+
+			token := database.Token{
+				UserID: user.ID,
+				Value:  "some-token",
+				Type:   database.TokenTypeResetPassword,
+				UsedAt: tc.usedAt,
+			}
+			token.CreatedAt = tc.createdAt
+			testutils.MustExec(t, db.Save(&token), "preparing token")
+
+			// Execute
+			dat := `{"token": "some-token", "auth_key": "new-auth-key", "cipher_key_enc": "new-cipher-key-enc", "iteration": 100000}`
+			req := testutils.MakeReq(server, "PATCH", "/v1/password-reset", dat)
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			if tc.expectedStatus == 200 {
+				var tokenRecord database.Token
+				testutils.MustExec(t, db.Where("id = ?", token.ID).First(&tokenRecord), "finding token")
+				testutils.AssertNotEqual(t, tokenRecord.UsedAt, (*time.Time)(nil), "used_at mismatch")
+
+				// the token can be used only once
+				req := testutils.MakeReq(server, "PATCH", "/v1/password-reset", dat)
+				res := testutils.HTTPDo(t, req)
+				testutils.AssertStatusCode(t, res, 400, "using the token again")
+			}
+		})
+	}
+}
+
+func TestResetPassword_wrongRecoveryKey(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+
+	token := database.Token{
+		UserID: user.ID,
+		Value:  "some-token",
+		Type:   database.TokenTypeResetPassword,
+	}
+	testutils.MustExec(t, db.Save(&token), "preparing token")
+
+	dat := `{"token": "some-token", "auth_key": "new-auth-key", "cipher_key_enc": "new-cipher-key-enc", "iteration": 100000, "recovery_auth_key": "wrong-key"}`
+
+	// Execute and test
+	for i := 0; i < 5; i++ {
+		req := testutils.MakeReq(server, "PATCH", "/v1/password-reset", dat)
+		res := testutils.HTTPDo(t, req)
+		testutils.AssertStatusCode(t, res, 401, fmt.Sprintf("attempt %d", i+1))
+	}
+
+	var tokenRecord database.Token
+	testutils.MustExec(t, db.Where("id = ?", token.ID).First(&tokenRecord), "finding token")
+	testutils.AssertEqual(t, tokenRecord.FailedAttempts, 5, "failed attempts mismatch")
+	testutils.AssertNotEqual(t, tokenRecord.UsedAt, (*time.Time)(nil), "the token should be used up")
+
+	req := testutils.MakeReq(server, "PATCH", "/v1/password-reset", dat)
+	res := testutils.HTTPDo(t, req)
+	testutils.AssertStatusCode(t, res, 400, "using the token after too many failures")
+}
@@ -0,0 +1,126 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/api/presenters"
+	"github.com/dnote/dnote/server/database"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// GetSessions lists the active sessions of the user
+func (a *App) GetSessions(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	key, err := getCredential(r)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "getting credential").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := operations.GetUserSessions(db, a.Clock, user.ID)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "getting sessions").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(presenters.PresentSessions(sessions, key)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteSession revokes a session of the user
+func (a *App) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionID, err := strconv.Atoi(vars["sessionID"])
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	err = operations.DeleteUserSession(db, user.ID, sessionID)
+	if errors.Cause(err) == operations.ErrSessionNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, errors.Wrap(err, "deleting session").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type refreshSessionPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshSession renews an expired session using the refresh token that was issued
+// along with it, so that the user does not need to sign in again
+func (a *App) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	var params refreshSessionPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, err := operations.RefreshSession(db, a.Clock, params.RefreshToken)
+	if errors.Cause(err) == operations.ErrInvalidRefreshToken {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, errors.Wrap(err, "refreshing session").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := SessionResponse{
+		Key:          session.Key,
+		ExpiresAt:    session.ExpiresAt.Unix(),
+		RefreshToken: session.RefreshToken,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
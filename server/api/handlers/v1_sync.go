@@ -120,6 +120,47 @@ func (e *queryParamError) Error() string {
 	return fmt.Sprintf("invalid query param %s=%s. %s", e.key, e.value, e.message)
 }
 
+// fragmentBuilder builds a sync fragment from items added in ascending order of usn
+type fragmentBuilder struct {
+	fragment SyncFragment
+	count    int
+}
+
+func newFragmentBuilder(userMaxUSN int, currentTime int64) fragmentBuilder {
+	return fragmentBuilder{
+		fragment: SyncFragment{
+			UserMaxUSN:    userMaxUSN,
+			CurrentTime:   currentTime,
+			Notes:         []SyncFragNote{},
+			Books:         []SyncFragBook{},
+			ExpungedNotes: []string{},
+			ExpungedBooks: []string{},
+		},
+	}
+}
+
+func (b *fragmentBuilder) addNote(note database.Note) {
+	if note.Deleted {
+		b.fragment.ExpungedNotes = append(b.fragment.ExpungedNotes, note.UUID)
+	} else {
+		b.fragment.Notes = append(b.fragment.Notes, NewFragNote(note))
+	}
+
+	b.fragment.FragMaxUSN = note.USN
+	b.count++
+}
+
+func (b *fragmentBuilder) addBook(book database.Book) {
+	if book.Deleted {
+		b.fragment.ExpungedBooks = append(b.fragment.ExpungedBooks, book.UUID)
+	} else {
+		b.fragment.Books = append(b.fragment.Books, NewFragBook(book))
+	}
+
+	b.fragment.FragMaxUSN = book.USN
+	b.count++
+}
+
 func (a *App) newFragment(userID, userMaxUSN, afterUSN, limit int) (SyncFragment, error) {
 	db := database.DBConn
 
@@ -153,12 +194,7 @@ func (a *App) newFragment(userID, userMaxUSN, afterUSN, limit int) (SyncFragment
 		return items[i].usn < items[j].usn
 	})
 
-	fragNotes := []SyncFragNote{}
-	fragBooks := []SyncFragBook{}
-	fragExpungedNotes := []string{}
-	fragExpungedBooks := []string{}
-
-	fragMaxUSN := 0
+	b := newFragmentBuilder(userMaxUSN, a.Clock.Now().Unix())
 	for i := 0; i < limit; i++ {
 		if i > len(items)-1 {
 			break
@@ -166,39 +202,17 @@ func (a *App) newFragment(userID, userMaxUSN, afterUSN, limit int) (SyncFragment
 
 		item := items[i]
 
-		fragMaxUSN = item.usn
-
 		switch v := item.val.(type) {
 		case database.Note:
-			note := item.val.(database.Note)
-
-			if note.Deleted {
-				fragExpungedNotes = append(fragExpungedNotes, note.UUID)
-			} else {
-				fragNotes = append(fragNotes, NewFragNote(note))
-			}
+			b.addNote(v)
 		case database.Book:
-			book := item.val.(database.Book)
-
-			if book.Deleted {
-				fragExpungedBooks = append(fragExpungedBooks, book.UUID)
-			} else {
-				fragBooks = append(fragBooks, NewFragBook(book))
-			}
+			b.addBook(v)
 		default:
 			return SyncFragment{}, errors.Errorf("unknown internal item type %s", v)
 		}
 	}
 
-	ret := SyncFragment{
-		FragMaxUSN:    fragMaxUSN,
-		UserMaxUSN:    userMaxUSN,
-		CurrentTime:   a.Clock.Now().Unix(),
-		Notes:         fragNotes,
-		Books:         fragBooks,
-		ExpungedNotes: fragExpungedNotes,
-		ExpungedBooks: fragExpungedBooks,
-	}
+	ret := b.fragment
 
 	return ret, nil
 }
@@ -267,6 +281,7 @@ func (a *App) GetSyncFragment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, errors.Wrap(err, "getting fragment").Error(), http.StatusInternalServerError)
 		return
 	}
+	observeSyncFragment(fragment)
 
 	response := GetSyncFragmentResp{
 		Fragment: fragment,
@@ -0,0 +1,335 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/server/api/crypt"
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// totpChallengeTTL is the duration within which a signin must be completed with a second factor
+var totpChallengeTTL = 5 * time.Minute
+
+// TOTPChallengeResponse is a response for a signin that requires a second factor
+type TOTPChallengeResponse struct {
+	TOTPRequired bool   `json:"totp_required"`
+	Challenge    string `json:"challenge"`
+}
+
+// respondWithTOTPChallenge issues a one-time challenge which the client exchanges for a session
+// along with a second factor
+func (a *App) respondWithTOTPChallenge(w http.ResponseWriter, userID int) {
+	db := database.DBConn
+
+	value, err := generateRandomToken(16)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "generating challenge").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token := database.Token{
+		UserID: userID,
+		Value:  value,
+		Type:   database.TokenTypeTOTPChallenge,
+	}
+	token.CreatedAt = a.Clock.Now()
+	if err := db.Save(&token).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "saving challenge").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := TOTPChallengeResponse{
+		TOTPRequired: true,
+		Challenge:    value,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type signinTOTPPayload struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// signinTOTP completes a signin with a TOTP code or a backup code. A challenge can be
+// used only once, regardless of the result.
+func (a *App) signinTOTP(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	var params signinTOTPPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+	if params.Challenge == "" || params.Code == "" {
+		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var token database.Token
+	conn := db.Where("value = ? AND type = ? AND used_at IS NULL", params.Challenge, database.TokenTypeTOTPChallenge).First(&token)
+	if conn.RecordNotFound() {
+		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		return
+	} else if err := conn.Error; err != nil {
+		http.Error(w, errors.Wrap(err, "finding challenge").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := a.Clock.Now()
+	if now.Sub(token.CreatedAt) > totpChallengeTTL {
+		http.Error(w, "The signin has been expired. Please sign in again.", http.StatusUnauthorized)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", token.UserID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "finding account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Begin()
+
+	// use up the challenge unless another request has used it first, so that a challenge
+	// lets only one code be tested
+	conn = tx.Model(&database.Token{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", now)
+	if err := conn.Error; err != nil {
+		tx.Rollback()
+		http.Error(w, errors.Wrap(err, "marking challenge as used").Error(), http.StatusInternalServerError)
+		return
+	}
+	if conn.RowsAffected == 0 {
+		tx.Rollback()
+		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := operations.CheckSecondFactor(tx, a.Clock, account, params.Code)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, errors.Wrap(err, "checking code").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		http.Error(w, errors.Wrap(err, "committing transaction").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		http.Error(w, operations.ErrInvalidTOTPCode.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	a.respondWithSession(w, r, account.UserID, account.CipherKeyEnc)
+}
+
+type enrollTOTPPayload struct {
+	AuthKey string `json:"auth_key"`
+}
+
+// EnrollTOTPResponse is a response for enrolling two-factor authentication
+type EnrollTOTPResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// enrollTOTP generates a TOTP secret and responds with a provisioning URI to be scanned by
+// an authenticator app. Two-factor authentication is enabled once a code is verified.
+func (a *App) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	var params enrollTOTPPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "getting account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authKeyHash := crypt.HashAuthKey(params.AuthKey, account.Salt, account.ServerKDFIteration)
+	if account.AuthKeyHash != authKeyHash {
+		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	tx := db.Begin()
+	secret, err := operations.EnrollTOTP(tx, account)
+	if errors.Cause(err) == operations.ErrTOTPEnabled {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		tx.Rollback()
+		http.Error(w, errors.Wrap(err, "enrolling totp").Error(), http.StatusInternalServerError)
+		return
+	}
+	tx.Commit()
+
+	response := EnrollTOTPResponse{
+		Secret:          secret,
+		ProvisioningURI: crypt.TOTPProvisioningURI(secret, account.Email.String),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type enableTOTPPayload struct {
+	Code string `json:"code"`
+}
+
+// EnableTOTPResponse is a response for enabling two-factor authentication
+type EnableTOTPResponse struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
+// enableTOTP verifies a code from the enrolled secret and enables two-factor authentication.
+// It responds with the backup codes, which cannot be retrieved again.
+func (a *App) enableTOTP(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	var params enableTOTPPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "getting account").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Begin()
+	codes, err := operations.EnableTOTP(tx, a.Clock, account, params.Code)
+	if err != nil {
+		tx.Rollback()
+
+		switch errors.Cause(err) {
+		case operations.ErrTOTPEnabled:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case operations.ErrTOTPNotEnrolled, operations.ErrInvalidTOTPCode:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, errors.Wrap(err, "enabling totp").Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	tx.Commit()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(EnableTOTPResponse{BackupCodes: codes}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type disableTOTPPayload struct {
+	AuthKey string `json:"auth_key"`
+	Code    string `json:"code"`
+}
+
+// disableTOTP disables two-factor authentication. It requires both the auth key and a
+// second factor.
+func (a *App) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	var params disableTOTPPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		http.Error(w, errors.Wrap(err, "getting account").Error(), http.StatusInternalServerError)
+		return
+	}
+	if !account.TOTPEnabled {
+		http.Error(w, operations.ErrTOTPNotEnrolled.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authKeyHash := crypt.HashAuthKey(params.AuthKey, account.Salt, account.ServerKDFIteration)
+	if account.AuthKeyHash != authKeyHash {
+		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	tx := db.Begin()
+
+	ok, err := operations.CheckSecondFactor(tx, a.Clock, account, params.Code)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, errors.Wrap(err, "checking code").Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		tx.Rollback()
+		http.Error(w, operations.ErrInvalidTOTPCode.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := operations.DisableTOTP(tx, account); err != nil {
+		tx.Rollback()
+		http.Error(w, errors.Wrap(err, "disabling totp").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	w.WriteHeader(http.StatusNoContent)
+}
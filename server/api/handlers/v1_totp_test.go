@@ -0,0 +1,418 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/crypt"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+// testTOTPSecret is a TOTP secret for the tests
+var testTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+func mustTOTPCode(t *testing.T, now time.Time) string {
+	code, err := crypt.TOTPCode(testTOTPSecret, crypt.TOTPStep(now))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "computing code"))
+	}
+
+	return code
+}
+
+// setupTOTPAccount sets up a user whose account has two-factor authentication enabled
+func setupTOTPAccount(t *testing.T) (database.User, database.Account) {
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com")
+	testutils.MustExec(t, db.Model(&account).Updates(map[string]interface{}{
+		"totp_enabled": true,
+		"totp_secret":  testTOTPSecret,
+	}), "preparing account totp")
+
+	return user, account
+}
+
+func TestSigninTOTP(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	usedAt := now.Add(-time.Minute)
+
+	testCases := []struct {
+		name           string
+		createdAt      time.Time
+		usedAt         *time.Time
+		code           string
+		expectedStatus int
+	}{
+		{
+			name:           "valid code",
+			createdAt:      now.Add(-time.Minute),
+			code:           mustTOTPCode(t, now),
+			expectedStatus: 200,
+		},
+		{
+			name:           "wrong code",
+			createdAt:      now.Add(-time.Minute),
+			code:           "wrong-code",
+			expectedStatus: 401,
+		},
+		{
+			name:           "used challenge",
+			createdAt:      now.Add(-time.Minute),
+			usedAt:         &usedAt,
+			code:           mustTOTPCode(t, now),
+			expectedStatus: 401,
+		},
+		{
+			name:           "expired challenge",
+			createdAt:      now.Add(-totpChallengeTTL - time.Minute),
+			code:           mustTOTPCode(t, now),
+			expectedStatus: 401,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			c := clock.NewMock()
+			c.SetNow(now)
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: c,
+			}))
+			defer server.Close()
+
+			user, _ := setupTOTPAccount(t)
+
+			token := database.Token{
+				UserID: user.ID,
+				Value:  "some-challenge",
+				Type:   database.TokenTypeTOTPChallenge,
+				UsedAt: tc.usedAt,
+			}
+			token.CreatedAt = tc.createdAt
+			testutils.MustExec(t, db.Save(&token), "preparing challenge")
+
+			// Execute
+			dat := fmt.Sprintf(`{"challenge": "some-challenge", "code": "%s"}`, tc.code)
+			req := testutils.MakeReq(server, "POST", "/v1/signin/totp", dat)
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			var sessionCount int
+			testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", user.ID).Count(&sessionCount), "counting sessions")
+			if tc.expectedStatus == 200 {
+				testutils.AssertEqual(t, sessionCount, 1, "session count mismatch")
+			} else {
+				testutils.AssertEqual(t, sessionCount, 0, "session count mismatch")
+			}
+		})
+	}
+}
+
+func TestSigninTOTP_challengeUsedOnce(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	c := clock.NewMock()
+	c.SetNow(now)
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	user, _ := setupTOTPAccount(t)
+
+	token := database.Token{
+		UserID: user.ID,
+		Value:  "some-challenge",
+		Type:   database.TokenTypeTOTPChallenge,
+	}
+	token.CreatedAt = now
+	testutils.MustExec(t, db.Save(&token), "preparing challenge")
+
+	// Execute
+	// concurrent requests with the same challenge get to test only one code between them
+	codes := []string{"wrong-code-1", "wrong-code-2", "wrong-code-3", "wrong-code-4", mustTOTPCode(t, now)}
+	statuses := make([]int, len(codes))
+
+	var wg sync.WaitGroup
+	for i, code := range codes {
+		wg.Add(1)
+		go func(i int, code string) {
+			defer wg.Done()
+
+			dat := fmt.Sprintf(`{"challenge": "some-challenge", "code": "%s"}`, code)
+			req := testutils.MakeReq(server, "POST", "/v1/signin/totp", dat)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(errors.Wrap(err, "performing http request"))
+				return
+			}
+			res.Body.Close()
+			statuses[i] = res.StatusCode
+		}(i, code)
+	}
+	wg.Wait()
+
+	// Test
+	for i, status := range statuses {
+		testutils.AssertEqual(t, status == 200 || status == 401, true, fmt.Sprintf("status mismatch for the code %d", i))
+	}
+
+	var sessionCount int
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", user.ID).Count(&sessionCount), "counting sessions")
+	if statuses[len(codes)-1] == 200 {
+		testutils.AssertEqual(t, sessionCount, 1, "session count mismatch")
+	} else {
+		testutils.AssertEqual(t, sessionCount, 0, "session count mismatch")
+	}
+
+	// the challenge cannot be used again
+	dat := fmt.Sprintf(`{"challenge": "some-challenge", "code": "%s"}`, mustTOTPCode(t, now))
+	req := testutils.MakeReq(server, "POST", "/v1/signin/totp", dat)
+	res := testutils.HTTPDo(t, req)
+	testutils.AssertStatusCode(t, res, 401, "using the challenge again")
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	testCases := []struct {
+		name           string
+		authKey        string
+		totpEnabled    bool
+		expectedStatus int
+	}{
+		{
+			name:           "valid auth key",
+			authKey:        "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=",
+			expectedStatus: 200,
+		},
+		{
+			name:           "wrong auth key",
+			authKey:        "wrong-auth-key",
+			expectedStatus: 401,
+		},
+		{
+			name:           "already enabled",
+			authKey:        "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=",
+			totpEnabled:    true,
+			expectedStatus: 409,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			account := testutils.SetupAccountData(user, "alice@example.com")
+			if tc.totpEnabled {
+				testutils.MustExec(t, db.Model(&account).Updates(map[string]interface{}{
+					"totp_enabled": true,
+					"totp_secret":  testTOTPSecret,
+				}), "preparing account totp")
+			}
+
+			// Execute
+			dat := fmt.Sprintf(`{"auth_key": "%s"}`, tc.authKey)
+			req := testutils.MakeReq(server, "POST", "/v1/account/totp", dat)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			var accountRecord database.Account
+			testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+
+			if tc.expectedStatus != 200 {
+				if !tc.totpEnabled {
+					testutils.AssertEqual(t, accountRecord.TOTPSecret, "", "the secret should not be set")
+				}
+				return
+			}
+
+			var resp EnrollTOTPResponse
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding payload"))
+			}
+			testutils.AssertEqual(t, resp.Secret, accountRecord.TOTPSecret, "secret mismatch")
+			testutils.AssertEqual(t, accountRecord.TOTPEnabled, false, "totp should not be enabled before a code is verified")
+			testutils.AssertEqual(t, res.Header.Get("Cache-Control"), "no-store", "Cache-Control mismatch")
+		})
+	}
+}
+
+func TestEnableTOTP(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		secret         string
+		code           string
+		expectedStatus int
+	}{
+		{
+			name:           "valid code",
+			secret:         testTOTPSecret,
+			code:           mustTOTPCode(t, now),
+			expectedStatus: 200,
+		},
+		{
+			name:           "wrong code",
+			secret:         testTOTPSecret,
+			code:           "wrong-code",
+			expectedStatus: 400,
+		},
+		{
+			name:           "not enrolled",
+			secret:         "",
+			code:           mustTOTPCode(t, now),
+			expectedStatus: 400,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			c := clock.NewMock()
+			c.SetNow(now)
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: c,
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			account := testutils.SetupAccountData(user, "alice@example.com")
+			testutils.MustExec(t, db.Model(&account).Update("totp_secret", tc.secret), "preparing account totp_secret")
+
+			// Execute
+			dat := fmt.Sprintf(`{"code": "%s"}`, tc.code)
+			req := testutils.MakeReq(server, "PATCH", "/v1/account/totp", dat)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			var accountRecord database.Account
+			var backupCodeCount int
+			testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+			testutils.MustExec(t, db.Model(&database.BackupCode{}).Where("user_id = ?", user.ID).Count(&backupCodeCount), "counting backup codes")
+
+			if tc.expectedStatus != 200 {
+				testutils.AssertEqual(t, accountRecord.TOTPEnabled, false, "totp should not be enabled")
+				testutils.AssertEqual(t, backupCodeCount, 0, "backup code count mismatch")
+				return
+			}
+
+			var resp EnableTOTPResponse
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding payload"))
+			}
+			testutils.AssertEqual(t, accountRecord.TOTPEnabled, true, "totp should be enabled")
+			testutils.AssertEqual(t, len(resp.BackupCodes), backupCodeCount, "backup codes mismatch")
+			testutils.AssertEqual(t, res.Header.Get("Cache-Control"), "no-store", "Cache-Control mismatch")
+		})
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		authKey        string
+		code           string
+		expectedStatus int
+	}{
+		{
+			name:           "valid",
+			authKey:        "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=",
+			code:           mustTOTPCode(t, now),
+			expectedStatus: 204,
+		},
+		{
+			name:           "wrong code",
+			authKey:        "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=",
+			code:           "wrong-code",
+			expectedStatus: 401,
+		},
+		{
+			name:           "wrong auth key",
+			authKey:        "wrong-auth-key",
+			code:           mustTOTPCode(t, now),
+			expectedStatus: 401,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			c := clock.NewMock()
+			c.SetNow(now)
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: c,
+			}))
+			defer server.Close()
+
+			user, account := setupTOTPAccount(t)
+
+			// Execute
+			dat := fmt.Sprintf(`{"auth_key": "%s", "code": "%s"}`, tc.authKey, tc.code)
+			req := testutils.MakeReq(server, "DELETE", "/v1/account/totp", dat)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+
+			var accountRecord database.Account
+			testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+			testutils.AssertEqual(t, accountRecord.TOTPEnabled, tc.expectedStatus != 204, "totp_enabled mismatch")
+		})
+	}
+}
@@ -31,6 +31,9 @@ import (
 
 type createBookV2Payload struct {
 	Name string `json:"name"`
+	// UUID is an optional uuid generated by the client. Creating a book with the same
+	// uuid again responds with the existing book.
+	UUID string `json:"uuid"`
 }
 
 // CreateBookV2Resp is the response from create book api
@@ -38,10 +41,13 @@ type CreateBookV2Resp struct {
 	Book presenters.Book `json:"book"`
 }
 
-func validateCreateBookV2Payload(p createBookPayload) error {
+func validateCreateBookV2Payload(p createBookV2Payload) error {
 	if p.Name == "" {
 		return errors.New("name is required")
 	}
+	if p.UUID != "" && !helpers.ValidateUUID(p.UUID) {
+		return errors.Errorf("invalid uuid '%s'", p.UUID)
+	}
 
 	return nil
 }
@@ -53,14 +59,14 @@ func (a *App) CreateBookV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var params createBookPayload
+	var params createBookV2Payload
 	err := json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
 		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = validateCreateBookPayload(params)
+	err = validateCreateBookV2Payload(params)
 	if err != nil {
 		http.Error(w, errors.Wrap(err, "validating payload").Error(), http.StatusBadRequest)
 		return
@@ -68,10 +74,14 @@ func (a *App) CreateBookV2(w http.ResponseWriter, r *http.Request) {
 
 	db := database.DBConn
 
+	conn := db.Model(database.Book{}).Where("user_id = ? AND label = ?", user.ID, params.Name)
+	if params.UUID != "" {
+		// a retried request finds the book it created
+		conn = conn.Where("uuid <> ?", params.UUID)
+	}
+
 	var bookCount int
-	err = db.Model(database.Book{}).
-		Where("user_id = ? AND label = ?", user.ID, params.Name).
-		Count(&bookCount).Error
+	err = conn.Count(&bookCount).Error
 	if err != nil {
 		http.Error(w, errors.Wrap(err, "checking duplicate").Error(), http.StatusInternalServerError)
 		return
@@ -81,9 +91,13 @@ func (a *App) CreateBookV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	book, err := operations.CreateBook(user, a.Clock, params.Name)
-	if err != nil {
+	book, err := operations.CreateBook(user, a.Clock, params.UUID, params.Name)
+	if errors.Cause(err) == operations.ErrUUIDTaken {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, errors.Wrap(err, "inserting book").Error(), http.StatusInternalServerError)
+		return
 	}
 	resp := CreateBookResp{
 		Book: presenters.PresentBook(book),
@@ -99,5 +113,5 @@ func (a *App) CreateBookV2(w http.ResponseWriter, r *http.Request) {
 // BooksOptionsV2 is a handler for OPTIONS endpoint for notes
 func (a *App) BooksOptionsV2(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Version")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Version, Idempotency-Key")
 }
@@ -30,6 +30,9 @@ import (
 )
 
 type createNoteV2Payload struct {
+	// UUID is an optional uuid generated by the client. Creating a note with the same
+	// uuid again responds with the existing note.
+	UUID     string `json:"uuid"`
 	BookUUID string `json:"book_uuid"`
 	Content  string `json:"content"`
 	AddedOn  *int64 `json:"added_on"`
@@ -40,6 +43,9 @@ func validateCreateNoteV2Payload(p createNoteV2Payload) error {
 	if p.BookUUID == "" {
 		return errors.New("bookUUID is required")
 	}
+	if p.UUID != "" && !helpers.ValidateUUID(p.UUID) {
+		return errors.Errorf("invalid uuid '%s'", p.UUID)
+	}
 
 	return nil
 }
@@ -77,8 +83,11 @@ func (a *App) CreateNoteV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := operations.CreateNote(user, a.Clock, params.BookUUID, params.Content, params.AddedOn, params.EditedOn, false)
-	if err != nil {
+	note, err := operations.CreateNote(user, a.Clock, params.UUID, params.BookUUID, params.Content, params.AddedOn, params.EditedOn, false)
+	if errors.Cause(err) == operations.ErrUUIDTaken {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, errors.Wrap(err, "creating note").Error(), http.StatusInternalServerError)
 		return
 	}
@@ -101,5 +110,5 @@ func (a *App) CreateNoteV2(w http.ResponseWriter, r *http.Request) {
 // NotesOptionsV2 is a handler for OPTIONS endpoint for notes
 func (a *App) NotesOptionsV2(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Version")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Version, Idempotency-Key")
 }
@@ -0,0 +1,416 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// maxSyncPushChanges is the maximum number of changes that can be pushed in a request
+const maxSyncPushChanges = 500
+
+type syncPushChange struct {
+	Type       string  `json:"type"`
+	Action     string  `json:"action"`
+	ClientUUID string  `json:"client_uuid"`
+	Label      *string `json:"label"`
+	BookUUID   *string `json:"book_uuid"`
+	Content    *string `json:"content"`
+	Public     *bool   `json:"public"`
+	AddedOn    *int64  `json:"added_on"`
+	EditedOn   *int64  `json:"edited_on"`
+}
+
+type syncPushPayload struct {
+	Changes []syncPushChange `json:"changes"`
+}
+
+type syncPushResult struct {
+	ClientUUID string `json:"client_uuid"`
+	UUID       string `json:"uuid"`
+	USN        int    `json:"usn"`
+}
+
+// SyncPushResp is a response for pushing changes. The results are in the same order as
+// the changes in the payload.
+type SyncPushResp struct {
+	Results []syncPushResult `json:"results"`
+}
+
+func validateSyncPushChange(c syncPushChange) error {
+	if c.ClientUUID == "" {
+		return errors.New("client_uuid is required")
+	}
+	if !helpers.ValidateUUID(c.ClientUUID) {
+		return errors.Errorf("invalid client_uuid '%s'", c.ClientUUID)
+	}
+	if c.BookUUID != nil && !helpers.ValidateUUID(*c.BookUUID) {
+		return errors.Errorf("invalid book_uuid '%s'", *c.BookUUID)
+	}
+
+	switch c.Type {
+	case operations.SyncTypeBook:
+		if c.Action == operations.SyncActionCreate && (c.Label == nil || *c.Label == "") {
+			return errors.New("label is required")
+		}
+	case operations.SyncTypeNote:
+		if c.Action == operations.SyncActionCreate && c.BookUUID == nil {
+			return errors.New("book_uuid is required")
+		}
+	default:
+		return errors.Errorf("unknown type '%s'", c.Type)
+	}
+
+	switch c.Action {
+	case operations.SyncActionCreate, operations.SyncActionUpdate, operations.SyncActionDelete:
+	default:
+		return errors.Errorf("unknown action '%s'", c.Action)
+	}
+
+	return nil
+}
+
+func validateSyncPushPayload(p syncPushPayload) error {
+	if len(p.Changes) == 0 {
+		return errors.New("changes are required")
+	}
+	if len(p.Changes) > maxSyncPushChanges {
+		return errors.Errorf("maximum number of changes is %d", maxSyncPushChanges)
+	}
+
+	for idx, c := range p.Changes {
+		if err := validateSyncPushChange(c); err != nil {
+			return errors.Wrapf(err, "change %d", idx)
+		}
+	}
+
+	return nil
+}
+
+func toSyncChanges(changes []syncPushChange) []operations.SyncChange {
+	ret := []operations.SyncChange{}
+
+	for _, c := range changes {
+		ret = append(ret, operations.SyncChange{
+			Type:       c.Type,
+			Action:     c.Action,
+			ClientUUID: c.ClientUUID,
+			Label:      c.Label,
+			BookUUID:   c.BookUUID,
+			Content:    c.Content,
+			Public:     c.Public,
+			AddedOn:    c.AddedOn,
+			EditedOn:   c.EditedOn,
+		})
+	}
+
+	return ret
+}
+
+// SyncPush applies an ordered batch of changes made by a client in a single transaction
+func (a *App) SyncPush(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	var params syncPushPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding payload").Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateSyncPushPayload(params); err != nil {
+		http.Error(w, errors.Wrap(err, "validating payload").Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+	tx := db.Begin()
+
+	results, err := operations.ApplySyncChanges(tx, a.Clock, user, toSyncChanges(params.Changes))
+	if err != nil {
+		tx.Rollback()
+
+		if e, ok := err.(operations.SyncChangeError); ok {
+			status := http.StatusBadRequest
+			if e.Err == operations.ErrSyncItemNotFound {
+				status = http.StatusNotFound
+			} else if e.Err == operations.ErrDuplicateBook || e.Err == operations.ErrUUIDTaken {
+				status = http.StatusConflict
+			}
+
+			http.Error(w, e.Error(), status)
+			return
+		}
+
+		http.Error(w, errors.Wrap(err, "applying changes").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+	observeSyncPush(params.Changes)
+
+	resp := SyncPushResp{
+		Results: []syncPushResult{},
+	}
+	for _, result := range results {
+		resp.Results = append(resp.Results, syncPushResult{
+			ClientUUID: result.ClientUUID,
+			UUID:       result.UUID,
+			USN:        result.USN,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// streamFragmentSize is the maximum number of items in a fragment in a sync stream
+const streamFragmentSize = 100
+
+// syncStreamETag returns the entity tag of the sync stream after the given usn. The stream
+// after a given usn changes only when the max_usn of the user changes. A client that has
+// all the items up to a usn can send the tag of the stream after that usn with the same
+// max_usn, in order to find out whether anything has changed since.
+func syncStreamETag(afterUSN, userMaxUSN int) string {
+	return fmt.Sprintf(`W/"%d-%d"`, afterUSN, userMaxUSN)
+}
+
+// checkNotModified checks if the If-None-Match header of the request matches the given etag
+func checkNotModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.Split(enc, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseGetSyncStreamQuery(q url.Values) (int, error) {
+	afterUSNStr := q.Get("after_usn")
+	if afterUSNStr == "" {
+		return 0, nil
+	}
+
+	afterUSN, err := strconv.Atoi(afterUSNStr)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid after_usn")
+	}
+	if afterUSN < 0 {
+		return 0, &queryParamError{
+			key:     "after_usn",
+			value:   afterUSNStr,
+			message: "minimum value is 0",
+		}
+	}
+
+	return afterUSN, nil
+}
+
+// scanNextRow scans the next row into the given destination. It returns false if there
+// are no more rows.
+func scanNextRow(rows *sql.Rows, dest interface{}) (bool, error) {
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+
+	if err := database.DBConn.ScanRows(rows, dest); err != nil {
+		return false, errors.Wrap(err, "scanning row")
+	}
+
+	return true, nil
+}
+
+// writeSyncStream writes the sync fragments for all items after the given usn as newline
+// delimited JSON, calling flush after each fragment. Notes and books are each read with
+// a single query and merged in the order of usn. The stream is terminated by an empty
+// fragment whose frag_max_usn is 0, so that clients can tell an interrupted stream from
+// a complete one.
+func (a *App) writeSyncStream(w io.Writer, flush func() error, userID, userMaxUSN, afterUSN int) error {
+	db := database.DBConn
+
+	noteRows, err := db.Model(&database.Note{}).Where("user_id = ? AND usn > ? AND usn <= ?", userID, afterUSN, userMaxUSN).Order("usn ASC").Rows()
+	if err != nil {
+		return errors.Wrap(err, "querying notes")
+	}
+	defer noteRows.Close()
+
+	bookRows, err := db.Model(&database.Book{}).Where("user_id = ? AND usn > ? AND usn <= ?", userID, afterUSN, userMaxUSN).Order("usn ASC").Rows()
+	if err != nil {
+		return errors.Wrap(err, "querying books")
+	}
+	defer bookRows.Close()
+
+	var note database.Note
+	hasNote, err := scanNextRow(noteRows, &note)
+	if err != nil {
+		return errors.Wrap(err, "getting note")
+	}
+	var book database.Book
+	hasBook, err := scanNextRow(bookRows, &book)
+	if err != nil {
+		return errors.Wrap(err, "getting book")
+	}
+
+	enc := json.NewEncoder(w)
+	currentTime := a.Clock.Now().Unix()
+	writeFragment := func(frag SyncFragment) error {
+		if err := enc.Encode(frag); err != nil {
+			return errors.Wrap(err, "encoding fragment")
+		}
+		if err := flush(); err != nil {
+			return errors.Wrap(err, "flushing fragment")
+		}
+
+		return nil
+	}
+
+	b := newFragmentBuilder(userMaxUSN, currentTime)
+	for hasNote || hasBook {
+		if hasNote && (!hasBook || note.USN < book.USN) {
+			b.addNote(note)
+
+			note = database.Note{}
+			hasNote, err = scanNextRow(noteRows, &note)
+			if err != nil {
+				return errors.Wrap(err, "getting note")
+			}
+		} else {
+			b.addBook(book)
+
+			book = database.Book{}
+			hasBook, err = scanNextRow(bookRows, &book)
+			if err != nil {
+				return errors.Wrap(err, "getting book")
+			}
+		}
+
+		if b.count == streamFragmentSize {
+			if err := writeFragment(b.fragment); err != nil {
+				return err
+			}
+			observeSyncFragment(b.fragment)
+
+			b = newFragmentBuilder(userMaxUSN, currentTime)
+		}
+	}
+
+	if b.count > 0 {
+		if err := writeFragment(b.fragment); err != nil {
+			return err
+		}
+		observeSyncFragment(b.fragment)
+	}
+
+	end := newFragmentBuilder(userMaxUSN, currentTime)
+	return writeFragment(end.fragment)
+}
+
+// GetSyncStream responds with all sync fragments after the given usn in a single response
+// of newline delimited JSON, compressed if the client accepts it. Clients resume an
+// interrupted stream by requesting it again after the last received fragment. It responds
+// with 304 if the entity tag given by the client matches the after_usn and the max_usn.
+func (a *App) GetSyncStream(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		http.Error(w, "No authenticated user found", http.StatusInternalServerError)
+		return
+	}
+
+	afterUSN, err := parseGetSyncStreamQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "parsing query params").Error(), http.StatusBadRequest)
+		return
+	}
+
+	etag := syncStreamETag(afterUSN, user.MaxUSN)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	if checkNotModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gz = gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+
+	flush := func() error {
+		if gz != nil {
+			if err := gz.Flush(); err != nil {
+				return err
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		return nil
+	}
+
+	if err := a.writeSyncStream(out, flush, user.ID, user.MaxUSN, afterUSN); err != nil {
+		// The response has already begun. Clients will notice the missing final fragment.
+		logger.WithRequest(r).WithFields(logger.Fields{"error": err}).Err("streaming sync fragments")
+	}
+}
@@ -0,0 +1,383 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestValidateSyncPushPayload(t *testing.T) {
+	label := "label"
+	bookUUID := "9bc4b4a2-94d8-4c1d-b7a8-a5d3bfa6a1d5"
+	invalidUUID := "some-uuid"
+	clientUUID := "f4a4b09c-3b9a-4bbd-8f2a-1d62a1e3f2c7"
+
+	testCases := []struct {
+		payload  syncPushPayload
+		expected bool
+	}{
+		{
+			payload: syncPushPayload{
+				Changes: []syncPushChange{
+					{Type: "book", Action: "create", ClientUUID: clientUUID, Label: &label},
+					{Type: "note", Action: "create", ClientUUID: clientUUID, BookUUID: &bookUUID},
+					{Type: "note", Action: "update", ClientUUID: clientUUID},
+					{Type: "book", Action: "delete", ClientUUID: clientUUID},
+				},
+			},
+			expected: true,
+		},
+		{
+			payload:  syncPushPayload{},
+			expected: false,
+		},
+		{
+			payload: syncPushPayload{
+				Changes: []syncPushChange{
+					{Type: "book", Action: "create", ClientUUID: clientUUID},
+				},
+			},
+			expected: false,
+		},
+		{
+			payload: syncPushPayload{
+				Changes: []syncPushChange{
+					{Type: "note", Action: "create", ClientUUID: clientUUID},
+				},
+			},
+			expected: false,
+		},
+		{
+			payload: syncPushPayload{
+				Changes: []syncPushChange{
+					{Type: "note", Action: "update", ClientUUID: invalidUUID},
+				},
+			},
+			expected: false,
+		},
+		{
+			payload: syncPushPayload{
+				Changes: []syncPushChange{
+					{Type: "note", Action: "update", ClientUUID: clientUUID, BookUUID: &invalidUUID},
+				},
+			},
+			expected: false,
+		},
+		{
+			payload: syncPushPayload{
+				Changes: []syncPushChange{
+					{Type: "tag", Action: "create", ClientUUID: clientUUID},
+				},
+			},
+			expected: false,
+		},
+		{
+			payload: syncPushPayload{
+				Changes: []syncPushChange{
+					{Type: "note", Action: "archive", ClientUUID: clientUUID},
+				},
+			},
+			expected: false,
+		},
+		{
+			payload: syncPushPayload{
+				Changes: make([]syncPushChange, maxSyncPushChanges+1),
+			},
+			expected: false,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			err := validateSyncPushPayload(tc.payload)
+
+			testutils.AssertEqual(t, err == nil, tc.expected, fmt.Sprintf("validity mismatch. error: %v", err))
+		})
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	testCases := []struct {
+		ifNoneMatch string
+		etag        string
+		expected    bool
+	}{
+		{
+			ifNoneMatch: "",
+			etag:        `W/"12"`,
+			expected:    false,
+		},
+		{
+			ifNoneMatch: `W/"12"`,
+			etag:        `W/"12"`,
+			expected:    true,
+		},
+		{
+			ifNoneMatch: `"12"`,
+			etag:        `W/"12"`,
+			expected:    true,
+		},
+		{
+			ifNoneMatch: `W/"11"`,
+			etag:        `W/"12"`,
+			expected:    false,
+		},
+		{
+			ifNoneMatch: `W/"11", W/"12"`,
+			etag:        `W/"12"`,
+			expected:    true,
+		},
+		{
+			ifNoneMatch: `*`,
+			etag:        `W/"12"`,
+			expected:    false,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v2/sync/stream", nil)
+			if tc.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+
+			testutils.AssertEqual(t, checkNotModified(r, tc.etag), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	testCases := []struct {
+		acceptEncoding string
+		expected       bool
+	}{
+		{
+			acceptEncoding: "",
+			expected:       false,
+		},
+		{
+			acceptEncoding: "gzip",
+			expected:       true,
+		},
+		{
+			acceptEncoding: "deflate, gzip;q=1.0, *;q=0.5",
+			expected:       true,
+		},
+		{
+			acceptEncoding: "deflate, br",
+			expected:       false,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v2/sync/stream", nil)
+			r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+
+			testutils.AssertEqual(t, acceptsGzip(r), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestParseGetSyncStreamQuery(t *testing.T) {
+	testCases := []struct {
+		input    string
+		afterUSN int
+		ok       bool
+	}{
+		{
+			input:    ``,
+			afterUSN: 0,
+			ok:       true,
+		},
+		{
+			input:    `after_usn=50`,
+			afterUSN: 50,
+			ok:       true,
+		},
+		{
+			input:    `after_usn=-1`,
+			afterUSN: 0,
+			ok:       false,
+		},
+		{
+			input:    `after_usn=foo`,
+			afterUSN: 0,
+			ok:       false,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			q, err := url.ParseQuery(tc.input)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "parsing test input"))
+			}
+
+			afterUSN, err := parseGetSyncStreamQuery(q)
+
+			testutils.AssertEqual(t, err == nil, tc.ok, fmt.Sprintf("validity mismatch. error: %v", err))
+			testutils.AssertEqual(t, afterUSN, tc.afterUSN, "afterUSN mismatch")
+		})
+	}
+}
+
+func TestWriteSyncStream(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	b1 := database.Book{UserID: user.ID, Label: "js", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: user.ID, Label: "css", USN: 4, Deleted: true}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: user.ID, BookUUID: b1.UUID, USN: 3, Deleted: true}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+	n3 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n3 content", USN: 5}
+	testutils.MustExec(t, db.Save(&n3), "preparing n3")
+
+	a := App{Clock: clock.NewMock()}
+
+	var buf bytes.Buffer
+	flushCount := 0
+	flush := func() error {
+		flushCount++
+		return nil
+	}
+
+	// execute
+	if err := a.writeSyncStream(&buf, flush, user.ID, 5, 1); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	var fragments []SyncFragment
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var frag SyncFragment
+		if err := dec.Decode(&frag); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding fragment"))
+		}
+
+		fragments = append(fragments, frag)
+	}
+
+	testutils.AssertEqual(t, len(fragments), 2, "fragment count mismatch")
+	testutils.AssertEqual(t, flushCount, 2, "flush count mismatch")
+
+	frag := fragments[0]
+	testutils.AssertEqual(t, frag.FragMaxUSN, 5, "FragMaxUSN mismatch")
+	testutils.AssertEqual(t, frag.UserMaxUSN, 5, "UserMaxUSN mismatch")
+	testutils.AssertEqual(t, len(frag.Books), 0, "Books length mismatch")
+	testutils.AssertDeepEqual(t, frag.ExpungedBooks, []string{b2.UUID}, "ExpungedBooks mismatch")
+	testutils.AssertEqual(t, len(frag.Notes), 2, "Notes length mismatch")
+	testutils.AssertEqual(t, frag.Notes[0].UUID, n1.UUID, "Notes[0] mismatch")
+	testutils.AssertEqual(t, frag.Notes[1].UUID, n3.UUID, "Notes[1] mismatch")
+	testutils.AssertDeepEqual(t, frag.ExpungedNotes, []string{n2.UUID}, "ExpungedNotes mismatch")
+
+	end := fragments[1]
+	testutils.AssertEqual(t, end.FragMaxUSN, 0, "end FragMaxUSN mismatch")
+	testutils.AssertEqual(t, len(end.Notes)+len(end.Books)+len(end.ExpungedNotes)+len(end.ExpungedBooks), 0, "end fragment should be empty")
+}
+
+func TestGetSyncStream_notModified(t *testing.T) {
+	testCases := []struct {
+		name           string
+		afterUSN       int
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		{
+			name:           "up to date",
+			afterUSN:       5,
+			ifNoneMatch:    `W/"5-5"`,
+			expectedStatus: 304,
+		},
+		{
+			name:           "no tag",
+			afterUSN:       5,
+			ifNoneMatch:    "",
+			expectedStatus: 200,
+		},
+		{
+			name:           "tag of an earlier stream",
+			afterUSN:       3,
+			ifNoneMatch:    `W/"0-5"`,
+			expectedStatus: 200,
+		},
+		{
+			name:           "tag of an earlier max_usn",
+			afterUSN:       3,
+			ifNoneMatch:    `W/"3-3"`,
+			expectedStatus: 200,
+		},
+		{
+			name:           "any",
+			afterUSN:       3,
+			ifNoneMatch:    `*`,
+			expectedStatus: 200,
+		},
+		{
+			name:           "matching",
+			afterUSN:       3,
+			ifNoneMatch:    `W/"3-5"`,
+			expectedStatus: 304,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.MustExec(t, db.Model(&user).Updates(map[string]interface{}{"max_usn": 5, "cloud": true}), "preparing user")
+
+			// Execute
+			endpoint := fmt.Sprintf("/v2/sync/stream?after_usn=%d", tc.afterUSN)
+			req := testutils.MakeReq(server, "GET", endpoint, "")
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			testutils.AssertStatusCode(t, res, tc.expectedStatus, "")
+			testutils.AssertEqual(t, res.Header.Get("ETag"), fmt.Sprintf(`W/"%d-5"`, tc.afterUSN), "ETag mismatch")
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/pkg/errors"
+)
+
+// ClientVersionPolicy is a version requirement for a type of client
+type ClientVersionPolicy struct {
+	// minimum is the lowest version that is allowed to make requests
+	minimum *semver
+	// recommended is the lowest version that is not warned about deprecation
+	recommended *semver
+}
+
+// NewClientVersionPolicy parses the given minimum and recommended versions into a policy.
+// An empty version means that there is no such requirement.
+func NewClientVersionPolicy(minimum, recommended string) (ClientVersionPolicy, error) {
+	var ret ClientVersionPolicy
+
+	if minimum != "" {
+		v, err := parseSemver(minimum)
+		if err != nil {
+			return ret, errors.Wrap(err, "parsing minimum version")
+		}
+
+		ret.minimum = &v
+	}
+	if recommended != "" {
+		v, err := parseSemver(recommended)
+		if err != nil {
+			return ret, errors.Wrap(err, "parsing recommended version")
+		}
+
+		ret.recommended = &v
+	}
+
+	return ret, nil
+}
+
+// getRequestClientVersion returns the version of the client that made the request. If the
+// client did not send its version, it returns an empty string.
+func getRequestClientVersion(r *http.Request) string {
+	if v := r.Header.Get("CLI-Version"); v != "" {
+		return v
+	}
+
+	return r.Header.Get("Version")
+}
+
+// upgradeRequiredResp is the response for a client whose version is below the minimum
+type upgradeRequiredResp struct {
+	Error              string `json:"error"`
+	Message            string `json:"message"`
+	ClientType         string `json:"client_type"`
+	ClientVersion      string `json:"client_version"`
+	MinimumVersion     string `json:"minimum_version"`
+	RecommendedVersion string `json:"recommended_version,omitempty"`
+}
+
+func respondUpgradeRequired(w http.ResponseWriter, clientType string, version semver, p ClientVersionPolicy) {
+	resp := upgradeRequiredResp{
+		Error:          "upgrade_required",
+		Message:        fmt.Sprintf("%s %s is no longer supported. Please upgrade to %s or later.", clientType, version, p.minimum),
+		ClientType:     clientType,
+		ClientVersion:  version.String(),
+		MinimumVersion: p.minimum.String(),
+	}
+	if p.recommended != nil {
+		resp.RecommendedVersion = p.recommended.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUpgradeRequired)
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.WithFields(logger.Fields{"error": err}).Err("encoding response")
+	}
+}
+
+// checkClientVersion rejects requests from clients older than the minimum version for their
+// type, and warns clients older than the recommended version using the Deprecation-Warning
+// header. Requests without a parsable version, such as ones from development builds, are
+// let through.
+func (a *App) checkClientVersion(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientType := getRequestClientType(r)
+		p, ok := a.ClientVersions[clientType]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		version, err := parseSemver(getRequestClientVersion(r))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if p.minimum != nil && version.compare(*p.minimum) < 0 {
+			respondUpgradeRequired(w, clientType, version, p)
+			return
+		}
+
+		if p.recommended != nil && version.compare(*p.recommended) < 0 {
+			msg := fmt.Sprintf("%s %s is deprecated and will stop working in a future release. Please upgrade to %s or later.", clientType, version, p.recommended)
+			w.Header().Set("Deprecation-Warning", msg)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
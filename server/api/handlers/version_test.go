@@ -0,0 +1,161 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestSemverCompare(t *testing.T) {
+	testCases := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"0.4.8", "0.4.8", 0},
+		{"0.4.8", "0.4.9", -1},
+		{"0.4.9", "0.4.8", 1},
+		{"0.4.8", "0.5.0", -1},
+		{"1.0.0", "0.10.0", 1},
+		{"0.10.0", "0.9.12", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.a, tc.b), func(t *testing.T) {
+			a, err := parseSemver(tc.a)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "parsing a"))
+			}
+			b, err := parseSemver(tc.b)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "parsing b"))
+			}
+
+			testutils.AssertEqual(t, a.compare(b), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestNewClientVersionPolicy_invalid(t *testing.T) {
+	if _, err := NewClientVersionPolicy("foo", ""); err == nil {
+		t.Error("expected an error for an invalid minimum version")
+	}
+	if _, err := NewClientVersionPolicy("", "foo"); err == nil {
+		t.Error("expected an error for an invalid recommended version")
+	}
+}
+
+func TestCheckClientVersion(t *testing.T) {
+	cliPolicy, err := NewClientVersionPolicy("0.5.0", "0.6.0")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making a policy"))
+	}
+	app := App{
+		ClientVersions: map[string]ClientVersionPolicy{
+			"cli": cliPolicy,
+		},
+	}
+
+	testCases := []struct {
+		header             string
+		version            string
+		origin             string
+		expectedStatusCode int
+		expectedWarning    bool
+	}{
+		{
+			header:             "CLI-Version",
+			version:            "0.4.9",
+			expectedStatusCode: http.StatusUpgradeRequired,
+			expectedWarning:    false,
+		},
+		{
+			header:             "CLI-Version",
+			version:            "0.5.0",
+			expectedStatusCode: http.StatusOK,
+			expectedWarning:    true,
+		},
+		{
+			header:             "CLI-Version",
+			version:            "0.6.0",
+			expectedStatusCode: http.StatusOK,
+			expectedWarning:    false,
+		},
+		{
+			header:             "CLI-Version",
+			version:            "master",
+			expectedStatusCode: http.StatusOK,
+			expectedWarning:    false,
+		},
+		{
+			// no policy for the type of client
+			header:             "Version",
+			version:            "0.1.0",
+			origin:             "moz-extension://foo",
+			expectedStatusCode: http.StatusOK,
+			expectedWarning:    false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.header, tc.version), func(t *testing.T) {
+			// set up
+			handler := app.checkClientVersion(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req, err := http.NewRequest("GET", "/v1/sync/state", nil)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "constructing request"))
+			}
+			req.Header.Set(tc.header, tc.version)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+
+			// execute
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			// test
+			testutils.AssertEqual(t, w.Code, tc.expectedStatusCode, "status code mismatch")
+			testutils.AssertEqual(t, w.Header().Get("Deprecation-Warning") != "", tc.expectedWarning, "warning mismatch")
+
+			if tc.expectedStatusCode == http.StatusUpgradeRequired {
+				var body upgradeRequiredResp
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatal(errors.Wrap(err, "decoding body"))
+				}
+
+				testutils.AssertEqual(t, body.Error, "upgrade_required", "error mismatch")
+				testutils.AssertEqual(t, body.ClientType, "cli", "client type mismatch")
+				testutils.AssertEqual(t, body.ClientVersion, tc.version, "client version mismatch")
+				testutils.AssertEqual(t, body.MinimumVersion, "0.5.0", "minimum version mismatch")
+				testutils.AssertEqual(t, body.RecommendedVersion, "0.6.0", "recommended version mismatch")
+			}
+		})
+	}
+}
@@ -25,4 +25,10 @@ const (
 	KeyUser key = iota
 	// KeyToken is a key for a token in a context
 	KeyToken
+	// KeyUserRateLimit is a key for the rate limit of the authenticated users in a context
+	KeyUserRateLimit
+	// KeyRequestID is a key for the ID of a request in a context
+	KeyRequestID
+	// KeyClock is a key for the clock of the app that handles a request in a context
+	KeyClock
 )
@@ -46,3 +46,9 @@ func GetDemoUserID() (int, error) {
 func GenUUID() string {
 	return uuid.NewV4().String()
 }
+
+// ValidateUUID checks if the given string is a valid uuid
+func ValidateUUID(u string) bool {
+	_, err := uuid.FromString(u)
+	return err == nil
+}
@@ -16,88 +16,282 @@
  * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
  */
 
-// Package logger provides an interface to transmit log messages to a system log service
+// Package logger provides a structured, leveled logger. Each log is written as a line of
+// JSON, and is also transmitted to a system log service in production.
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/pkg/errors"
-	"log"
+	"io"
 	"log/syslog"
+	"net/http"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/pkg/errors"
+)
+
+// Level is the severity of a log
+type Level int
+
+const (
+	// LevelDebug is the level for the logs useful only while debugging
+	LevelDebug Level = iota
+	// LevelInfo is the level for the logs about the normal operation
+	LevelInfo
+	// LevelNotice is the level for the logs about significant events
+	LevelNotice
+	// LevelError is the level for the logs about errors
+	LevelError
 )
 
-var writer *syslog.Writer
+var levelNames = map[Level]string{
+	LevelDebug:  "debug",
+	LevelInfo:   "info",
+	LevelNotice: "notice",
+	LevelError:  "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a level such as "info"
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.ToLower(s) == name {
+			return level, nil
+		}
+	}
+
+	return LevelDebug, errors.Errorf("unknown log level '%s'", s)
+}
+
+// Fields are the structured data of a log
+type Fields map[string]interface{}
 
-// Init initilizes the syslog writer
+var (
+	mtx      sync.Mutex
+	output   io.Writer = os.Stdout
+	minLevel           = LevelDebug
+	writer   *syslog.Writer
+)
+
+// Init sets the minimum level from the LogLevel environment variable, and initializes the
+// syslog writer in production. The debug logs are left out in production by default.
 func Init() error {
-	var err error
+	if os.Getenv("GO_ENV") == "PRODUCTION" {
+		mtx.Lock()
+		minLevel = LevelInfo
+		mtx.Unlock()
+	}
+
+	if s := os.Getenv("LogLevel"); s != "" {
+		level, err := ParseLevel(s)
+		if err != nil {
+			return errors.Wrap(err, "parsing the log level")
+		}
+
+		mtx.Lock()
+		minLevel = level
+		mtx.Unlock()
+	}
 
-	var endpoint string
 	if os.Getenv("GO_ENV") == "PRODUCTION" {
-		endpoint = "logs7.papertrailapp.com:37297"
+		endpoint := "logs7.papertrailapp.com:37297"
 
-		writer, err = syslog.Dial("udp", endpoint, syslog.LOG_DEBUG|syslog.LOG_KERN, "dnote-api")
+		w, err := syslog.Dial("udp", endpoint, syslog.LOG_DEBUG|syslog.LOG_KERN, "dnote-api")
 		if err != nil {
 			return errors.Wrap(err, "dialing syslog manager")
 		}
+
+		mtx.Lock()
+		writer = w
+		mtx.Unlock()
 	}
 
 	return nil
 }
 
-// Info logs an info message
-func Info(msg string, v ...interface{}) {
-	m := fmt.Sprintf(msg, v...)
-	log.Println(m)
+// redacted replaces the values that must not be logged
+const redacted = "[REDACTED]"
 
-	if writer == nil {
-		return
-	}
+// sensitiveFieldNames are the parts of the names of the fields whose values are secrets
+// or the content of the notes
+var sensitiveFieldNames = []string{
+	"password",
+	"secret",
+	"token",
+	"key",
+	"cipher",
+	"authorization",
+	"cookie",
+	"body",
+	"content",
+}
+
+// sensitiveValuePattern matches secrets in messages, such as the password in a connection
+// string like "user=dnote password=foo"
+var sensitiveValuePattern = regexp.MustCompile(`(?i)((?:password|secret|token|api_key|auth_key)\s*[=:]\s*)("[^"]*"|\S+)`)
+
+func isSensitiveField(name string) bool {
+	n := strings.ToLower(name)
 
-	if err := writer.Info(fmt.Sprintf("INFO: %s", m)); err != nil {
-		log.Println(errors.Wrap(err, "transmiting log"))
+	for _, s := range sensitiveFieldNames {
+		if strings.Contains(n, s) {
+			return true
+		}
 	}
+
+	return false
 }
 
-// Err logs an error message
-func Err(msg string, v ...interface{}) {
-	m := fmt.Sprintf(msg, v...)
-	log.Println(m)
+// redactMessage redacts the secrets in the message
+func redactMessage(msg string) string {
+	return sensitiveValuePattern.ReplaceAllString(msg, "${1}"+redacted)
+}
 
-	if writer == nil {
-		return
+// Entry is a log with fields
+type Entry struct {
+	fields Fields
+}
+
+// WithFields returns an entry with the given fields
+func WithFields(f Fields) Entry {
+	return Entry{}.WithFields(f)
+}
+
+// WithRequest returns an entry with the ID of the request
+func WithRequest(r *http.Request) Entry {
+	return Entry{}.WithRequest(r)
+}
+
+// WithFields returns a copy of the entry with the given fields added
+func (e Entry) WithFields(f Fields) Entry {
+	fields := Fields{}
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	for k, v := range f {
+		fields[k] = v
+	}
+
+	return Entry{fields: fields}
+}
+
+// WithRequest returns a copy of the entry with the ID of the request added
+func (e Entry) WithRequest(r *http.Request) Entry {
+	requestID, ok := r.Context().Value(helpers.KeyRequestID).(string)
+	if !ok {
+		return e
 	}
 
-	if err := writer.Err(fmt.Sprintf("ERROR: %s", m)); err != nil {
-		log.Println(errors.Wrap(err, "transmiting log"))
+	return e.WithFields(Fields{"request_id": requestID})
+}
+
+// makeLine returns the line of JSON for a log
+func (e Entry) makeLine(level Level, msg string) ([]byte, error) {
+	data := map[string]interface{}{}
+	for k, v := range e.fields {
+		if isSensitiveField(k) {
+			data[k] = redacted
+			continue
+		}
+
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		if s, ok := v.(string); ok {
+			v = redactMessage(s)
+		}
+
+		data[k] = v
 	}
+
+	data["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	data["level"] = level.String()
+	data["msg"] = redactMessage(msg)
+
+	return json.Marshal(data)
 }
 
-// Notice logs a notice message
-func Notice(msg string, v ...interface{}) {
-	m := fmt.Sprintf(msg, v...)
-	log.Println(m)
+func (e Entry) log(level Level, msg string, v ...interface{}) {
+	mtx.Lock()
+	defer mtx.Unlock()
 
-	if writer == nil {
+	if level < minLevel {
 		return
 	}
 
-	if err := writer.Notice(fmt.Sprintf("NOTICE: %s", m)); err != nil {
-		log.Println(errors.Wrap(err, "transmiting log"))
+	line, err := e.makeLine(level, fmt.Sprintf(msg, v...))
+	if err != nil {
+		fmt.Fprintln(output, errors.Wrap(err, "marshalling log").Error())
+		return
 	}
-}
 
-// Debug logs a debug message
-func Debug(msg string, v ...interface{}) {
-	m := fmt.Sprintf(msg, v...)
-	log.Println(m)
+	fmt.Fprintln(output, string(line))
 
 	if writer == nil {
 		return
 	}
 
-	if err := writer.Debug(fmt.Sprintf("DEBUG: %s", m)); err != nil {
-		log.Println(errors.Wrap(err, "transmiting log"))
+	var werr error
+	switch level {
+	case LevelDebug:
+		werr = writer.Debug(string(line))
+	case LevelInfo:
+		werr = writer.Info(string(line))
+	case LevelNotice:
+		werr = writer.Notice(string(line))
+	case LevelError:
+		werr = writer.Err(string(line))
+	}
+	if werr != nil {
+		fmt.Fprintln(output, errors.Wrap(werr, "transmiting log").Error())
 	}
 }
+
+// Debug logs a debug message
+func (e Entry) Debug(msg string, v ...interface{}) {
+	e.log(LevelDebug, msg, v...)
+}
+
+// Info logs an info message
+func (e Entry) Info(msg string, v ...interface{}) {
+	e.log(LevelInfo, msg, v...)
+}
+
+// Notice logs a notice message
+func (e Entry) Notice(msg string, v ...interface{}) {
+	e.log(LevelNotice, msg, v...)
+}
+
+// Err logs an error message
+func (e Entry) Err(msg string, v ...interface{}) {
+	e.log(LevelError, msg, v...)
+}
+
+// Info logs an info message
+func Info(msg string, v ...interface{}) {
+	Entry{}.Info(msg, v...)
+}
+
+// Err logs an error message
+func Err(msg string, v ...interface{}) {
+	Entry{}.Err(msg, v...)
+}
+
+// Notice logs a notice message
+func Notice(msg string, v ...interface{}) {
+	Entry{}.Notice(msg, v...)
+}
+
+// Debug logs a debug message
+func Debug(msg string, v ...interface{}) {
+	Entry{}.Debug(msg, v...)
+}
@@ -0,0 +1,130 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/server/api/helpers"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+// captureOutput redirects the logs to a buffer with the given minimum level, and returns
+// the buffer and a function to restore the output
+func captureOutput(level Level) (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+
+	mtx.Lock()
+	prevOutput, prevLevel := output, minLevel
+	output, minLevel = &buf, level
+	mtx.Unlock()
+
+	return &buf, func() {
+		mtx.Lock()
+		output, minLevel = prevOutput, prevLevel
+		mtx.Unlock()
+	}
+}
+
+func parseLine(t *testing.T, b []byte) map[string]interface{} {
+	var ret map[string]interface{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		t.Fatal(errors.Wrapf(err, "unmarshalling the log %s", string(b)))
+	}
+
+	return ret
+}
+
+func TestLog(t *testing.T) {
+	buf, restore := captureOutput(LevelDebug)
+	defer restore()
+
+	WithFields(Fields{"user_id": 1, "path": "/me"}).Info("found %d notes", 3)
+
+	got := parseLine(t, buf.Bytes())
+	testutils.AssertEqual(t, got["level"], "info", "level mismatch")
+	testutils.AssertEqual(t, got["msg"], "found 3 notes", "msg mismatch")
+	testutils.AssertEqual(t, got["user_id"], float64(1), "user_id mismatch")
+	testutils.AssertEqual(t, got["path"], "/me", "path mismatch")
+	testutils.AssertEqual(t, got["time"] != nil, true, "time should be set")
+}
+
+func TestLog_Level(t *testing.T) {
+	buf, restore := captureOutput(LevelNotice)
+	defer restore()
+
+	Debug("debug")
+	Info("info")
+	testutils.AssertEqual(t, buf.Len(), 0, "the logs below the minimum level should be left out")
+
+	Err("error")
+	got := parseLine(t, buf.Bytes())
+	testutils.AssertEqual(t, got["level"], "error", "level mismatch")
+}
+
+func TestLog_Redaction(t *testing.T) {
+	buf, restore := captureOutput(LevelDebug)
+	defer restore()
+
+	WithFields(Fields{
+		"auth_key":       "foo",
+		"cipher_key_enc": "bar",
+		"body":           "my secret note",
+		"note_content":   "my secret note",
+		"status":         200,
+	}).Err("connecting to host=localhost user=dnote password=hunter2 sslmode=disable")
+
+	got := parseLine(t, buf.Bytes())
+	testutils.AssertEqual(t, got["auth_key"], redacted, "auth_key mismatch")
+	testutils.AssertEqual(t, got["cipher_key_enc"], redacted, "cipher_key_enc mismatch")
+	testutils.AssertEqual(t, got["body"], redacted, "body mismatch")
+	testutils.AssertEqual(t, got["note_content"], redacted, "note_content mismatch")
+	testutils.AssertEqual(t, got["status"], float64(200), "status mismatch")
+	testutils.AssertEqual(t, got["msg"], "connecting to host=localhost user=dnote password=[REDACTED] sslmode=disable", "msg mismatch")
+}
+
+func TestLog_ErrorField(t *testing.T) {
+	buf, restore := captureOutput(LevelDebug)
+	defer restore()
+
+	err := errors.Wrap(errors.New("LIKE '100%d'"), "finding notes")
+	WithFields(Fields{"error": err}).Err("searching")
+
+	got := parseLine(t, buf.Bytes())
+	testutils.AssertEqual(t, got["msg"], "searching", "msg mismatch")
+	testutils.AssertEqual(t, got["error"], "finding notes: LIKE '100%d'", "error mismatch")
+}
+
+func TestWithRequest(t *testing.T) {
+	buf, restore := captureOutput(LevelDebug)
+	defer restore()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), helpers.KeyRequestID, "foo-id"))
+
+	WithRequest(r).Info("hello")
+
+	got := parseLine(t, buf.Bytes())
+	testutils.AssertEqual(t, got["request_id"], "foo-id", "request_id mismatch")
+}
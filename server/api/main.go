@@ -19,17 +19,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/dnote/dnote/server/api/billing"
 	"github.com/dnote/dnote/server/api/clock"
 	"github.com/dnote/dnote/server/api/handlers"
 	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/ratelimit"
 	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/database/migrate"
 	"github.com/dnote/dnote/server/mailer"
+	"github.com/dnote/dnote/server/metrics"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/securecookie"
@@ -53,6 +61,165 @@ func getOauthCallbackURL(provider string) string {
 	return fmt.Sprintf("%s:%s/api/auth/%s/callback", os.Getenv("Host"), os.Getenv("WebPort"), provider)
 }
 
+// clientVersionEnvNames is a map from a client type to the name used in the environment
+// variables for its version requirement
+var clientVersionEnvNames = map[string]string{
+	"cli":               "CLI",
+	"firefox-extension": "FirefoxExtension",
+	"chrome-extension":  "ChromeExtension",
+}
+
+// getClientVersions reads the version requirements for the clients from the environment
+// variables such as MinimumCLIVersion and RecommendedCLIVersion
+func getClientVersions() (map[string]handlers.ClientVersionPolicy, error) {
+	ret := map[string]handlers.ClientVersionPolicy{}
+
+	for clientType, name := range clientVersionEnvNames {
+		minimum := os.Getenv(fmt.Sprintf("Minimum%sVersion", name))
+		recommended := os.Getenv(fmt.Sprintf("Recommended%sVersion", name))
+
+		p, err := handlers.NewClientVersionPolicy(minimum, recommended)
+		if err != nil {
+			return ret, errors.Wrapf(err, "getting the version requirement for %s", clientType)
+		}
+
+		ret[clientType] = p
+	}
+
+	return ret, nil
+}
+
+// getRateLimitStore returns the store for the rate limits configured by the
+// RateLimitStore environment variable. The requests are rate limited only in production.
+func getRateLimitStore(c clock.Clock) (ratelimit.Store, error) {
+	if os.Getenv("GO_ENV") != "PRODUCTION" {
+		return nil, nil
+	}
+
+	name := os.Getenv("RateLimitStore")
+
+	switch name {
+	case "", "memory":
+		return ratelimit.NewMemoryStore(c), nil
+	case "postgres":
+		return ratelimit.NewPostgresStore(c), nil
+	default:
+		return nil, errors.Errorf("unknown rate limit store '%s'", name)
+	}
+}
+
+// getRateLimitConfig returns the configuration of the rate limits from the file at
+// RateLimitConfigFile, or the default configuration
+func getRateLimitConfig() (ratelimit.Config, error) {
+	path := os.Getenv("RateLimitConfigFile")
+	if path == "" {
+		return ratelimit.DefaultConfig(), nil
+	}
+
+	return ratelimit.ReadConfig(path)
+}
+
+// the defaults of the server configuration
+const (
+	defaultReadTimeout     = 30 * time.Second
+	defaultWriteTimeout    = 5 * time.Minute
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+	readHeaderTimeout      = 10 * time.Second
+)
+
+// getDuration returns the duration in the environment variable with the given name, or
+// the default duration if the variable is empty
+func getDuration(name string, defaultDuration time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultDuration, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing %s", name)
+	}
+
+	return d, nil
+}
+
+// getMaxBodySize returns the maximum size of the request bodies in bytes configured by
+// MaxRequestBodySize, or the default size
+func getMaxBodySize() (int64, error) {
+	v := os.Getenv("MaxRequestBodySize")
+	if v == "" {
+		return handlers.DefaultMaxBodySize, nil
+	}
+
+	size, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing MaxRequestBodySize")
+	}
+	if size <= 0 {
+		return 0, errors.Errorf("MaxRequestBodySize must be positive, got %d", size)
+	}
+
+	return size, nil
+}
+
+// newServer returns a server with the timeouts configured by ServerReadTimeout,
+// ServerWriteTimeout and ServerIdleTimeout. The write timeout bounds the whole response,
+// so it needs to be long enough for a sync stream of a large account.
+func newServer(addr string, handler http.Handler) (*http.Server, error) {
+	readTimeout, err := getDuration("ServerReadTimeout", defaultReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	writeTimeout, err := getDuration("ServerWriteTimeout", defaultWriteTimeout)
+	if err != nil {
+		return nil, err
+	}
+	idleTimeout, err := getDuration("ServerIdleTimeout", defaultIdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}, nil
+}
+
+// serve serves the requests until the process receives SIGINT or SIGTERM, and then
+// shuts down the server after the in-flight requests are finished or the timeout passes
+func serve(srv *http.Server, shutdownTimeout time.Duration) {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		logger.WithFields(logger.Fields{"error": err}).Err("listening")
+		return
+	case s := <-sig:
+		logger.Notice("Received %s, shutting down", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.WithFields(logger.Fields{"error": err}).Err("shutting down")
+		return
+	}
+
+	logger.Notice("API shut down")
+}
+
 func init() {
 	// Set up Oauth
 	gothic.Store = sessions.NewCookieStore(securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32))
@@ -77,27 +244,120 @@ func init() {
 	}
 }
 
+// runMigrate runs the migrate subcommand with the given arguments
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the SQL of the migrations without running them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrate [-dry-run] up|down|status\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+	db := database.DBConn
+
+	switch fs.Arg(0) {
+	case "up":
+		return migrate.Up(db, migrate.Sequence, os.Stdout, *dryRun)
+	case "down":
+		return migrate.Down(db, migrate.Sequence, os.Stdout, *dryRun)
+	case "status":
+		return migrate.Status(db, migrate.Sequence, os.Stdout)
+	default:
+		fs.Usage()
+		return errors.Errorf("unknown migrate command '%s'", fs.Arg(0))
+	}
+}
+
 func main() {
 	flag.Parse()
 
+	if err := logger.Init(); err != nil {
+		logger.WithFields(logger.Fields{"error": err}).Err("initializing logger")
+	}
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(flag.Args()[1:]); err != nil {
+			logger.WithFields(logger.Fields{"error": err}).Err("migrating")
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	mailer.InitTemplates(*emailTemplateDir)
 
 	database.InitDB()
 	database.InitSchema()
 	defer database.CloseDB()
+	metrics.InstrumentDB(database.DBConn)
 
-	if err := logger.Init(); err != nil {
-		log.Println(errors.Wrap(err, "initializing logger"))
+	if addr := os.Getenv("MetricsAddr"); addr != "" {
+		go func() {
+			logger.Notice("Metrics listening on %s", addr)
+
+			if err := metrics.Serve(addr); err != nil {
+				logger.WithFields(logger.Fields{"error": err}).Err("serving metrics")
+			}
+		}()
+	}
+
+	clientVersions, err := getClientVersions()
+	if err != nil {
+		panic(errors.Wrap(err, "reading client versions"))
+	}
+
+	billingProvider, err := billing.FromEnv(handlers.StripePlanID)
+	if err != nil {
+		panic(errors.Wrap(err, "getting the billing provider"))
+	}
+
+	c := clock.New()
+
+	rateLimitStore, err := getRateLimitStore(c)
+	if err != nil {
+		panic(errors.Wrap(err, "getting the rate limit store"))
+	}
+	rateLimitConfig, err := getRateLimitConfig()
+	if err != nil {
+		panic(errors.Wrap(err, "reading the rate limit configuration"))
+	}
+	trustedProxies, err := handlers.ParseTrustedProxies(os.Getenv("TrustedProxies"))
+	if err != nil {
+		panic(errors.Wrap(err, "parsing the trusted proxies"))
+	}
+	maxBodySize, err := getMaxBodySize()
+	if err != nil {
+		panic(errors.Wrap(err, "reading the maximum request body size"))
 	}
 
 	app := handlers.App{
-		Clock:            clock.New(),
+		Clock:            c,
 		StripeAPIBackend: nil,
+		ClientVersions:   clientVersions,
+		Billing:          billingProvider,
+		RateLimit:        rateLimitConfig,
+		RateLimitStore:   rateLimitStore,
+		TrustedProxies:   trustedProxies,
+		MaxBodySize:      maxBodySize,
+		PresigninSecret:  []byte(os.Getenv("PresigninSecret")),
 	}
 	r := handlers.NewRouter(&app)
 
 	port := os.Getenv("PORT")
-	logger.Notice("API listening on port %s", port)
+	srv, err := newServer(":"+port, r)
+	if err != nil {
+		panic(errors.Wrap(err, "configuring the server"))
+	}
+	shutdownTimeout, err := getDuration("ShutdownTimeout", defaultShutdownTimeout)
+	if err != nil {
+		panic(errors.Wrap(err, "reading the shutdown timeout"))
+	}
 
-	log.Println(http.ListenAndServe(":"+port, r))
+	logger.Notice("API listening on port %s", port)
+	serve(srv, shutdownTimeout)
 }
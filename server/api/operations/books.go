@@ -26,19 +26,45 @@ import (
 	"github.com/pkg/errors"
 )
 
-// CreateBook creates a book with the next usn and updates the user's max_usn
-func CreateBook(user database.User, clock clock.Clock, name string) (database.Book, error) {
+// CreateBook creates a book with the next usn and updates the user's max_usn. If uuid
+// is empty, a new one is generated. Otherwise creation is idempotent, and the user's
+// existing book with the uuid is returned if there is one.
+func CreateBook(user database.User, clock clock.Clock, uuid, name string) (database.Book, error) {
 	db := database.DBConn
 	tx := db.Begin()
 
-	nextUSN, err := incrementUserUSN(tx, user.ID)
+	book, err := createBook(tx, user, clock, uuid, name)
 	if err != nil {
 		tx.Rollback()
+		return book, err
+	}
+
+	tx.Commit()
+
+	return book, nil
+}
+
+// createBook creates a book with the next usn using the given transaction
+func createBook(tx *gorm.DB, user database.User, clock clock.Clock, uuid, name string) (database.Book, error) {
+	if uuid == "" {
+		uuid = helpers.GenUUID()
+	} else {
+		existing, ok, err := findBookByClientUUID(tx, user, uuid)
+		if err != nil {
+			return database.Book{}, err
+		}
+		if ok {
+			return existing, nil
+		}
+	}
+
+	nextUSN, err := incrementUserUSN(tx, user.ID)
+	if err != nil {
 		return database.Book{}, errors.Wrap(err, "incrementing user max_usn")
 	}
 
 	book := database.Book{
-		UUID:      helpers.GenUUID(),
+		UUID:      uuid,
 		UserID:    user.ID,
 		Label:     name,
 		AddedOn:   clock.Now().UnixNano(),
@@ -46,15 +72,34 @@ func CreateBook(user database.User, clock clock.Clock, name string) (database.Bo
 		Encrypted: true,
 	}
 	if err := tx.Create(&book).Error; err != nil {
-		tx.Rollback()
 		return book, errors.Wrap(err, "inserting book")
 	}
 
-	tx.Commit()
-
 	return book, nil
 }
 
+// findBookByClientUUID finds the user's book with the given client-supplied uuid. It
+// returns ErrUUIDTaken if the uuid is used by a book of another user.
+func findBookByClientUUID(tx *gorm.DB, user database.User, uuid string) (database.Book, bool, error) {
+	if err := lockUser(tx, user.ID); err != nil {
+		return database.Book{}, false, err
+	}
+
+	var book database.Book
+	conn := tx.Where("uuid = ?", uuid).First(&book)
+	if conn.RecordNotFound() {
+		return book, false, nil
+	} else if err := conn.Error; err != nil {
+		return book, false, errors.Wrap(err, "finding book")
+	}
+
+	if book.UserID != user.ID {
+		return database.Book{}, false, ErrUUIDTaken
+	}
+
+	return book, true, nil
+}
+
 // DeleteBook marks a book deleted with the next usn and updates the user's max_usn
 func DeleteBook(tx *gorm.DB, user database.User, book database.Book) (database.Book, error) {
 	if user.ID != book.UserID {
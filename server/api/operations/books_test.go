@@ -68,7 +68,7 @@ func TestCreateBook(t *testing.T) {
 
 			c := clock.NewMock()
 
-			book, err := CreateBook(user, c, tc.label)
+			book, err := CreateBook(user, c, "", tc.label)
 			if err != nil {
 				t.Fatal(errors.Wrap(err, "creating book"))
 			}
@@ -101,6 +101,43 @@ func TestCreateBook(t *testing.T) {
 	}
 }
 
+func TestCreateBook_clientUUID(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+	b1 := database.Book{UserID: anotherUser.ID, Label: "css"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	uuid := "3e2b1f1c-8b2a-4c6e-9f4d-1a2b3c4d5e6f"
+	c := clock.NewMock()
+
+	// execute
+	book, err := CreateBook(user, c, uuid, "js")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating book"))
+	}
+	retried, err := CreateBook(user, c, uuid, "js")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "retrying creating book"))
+	}
+	_, takenErr := CreateBook(user, c, b1.UUID, "js")
+
+	// test
+	var bookCount int
+	testutils.MustExec(t, db.Model(&database.Book{}).Count(&bookCount), "counting books")
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	testutils.AssertEqual(t, book.UUID, uuid, "book uuid mismatch")
+	testutils.AssertEqual(t, retried.ID, book.ID, "retried book should be the same as the created book")
+	testutils.AssertEqual(t, retried.USN, book.USN, "retried book usn mismatch")
+	testutils.AssertEqual(t, bookCount, 2, "book count mismatch")
+	testutils.AssertEqual(t, userRecord.MaxUSN, 1, "user max_usn mismatch")
+	testutils.AssertEqual(t, errors.Cause(takenErr), ErrUUIDTaken, "error mismatch for a taken uuid")
+}
+
 func TestDeleteBook(t *testing.T) {
 	testCases := []struct {
 		userUSN     int
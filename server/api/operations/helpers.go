@@ -24,6 +24,20 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrUUIDTaken is an error for a client-supplied uuid that is used by another user's item
+var ErrUUIDTaken = errors.New("uuid is already taken")
+
+// lockUser locks the row of the given user until the end of the transaction, so that
+// concurrent requests to create an item with the same client-supplied uuid are serialized
+func lockUser(tx *gorm.DB, userID int) error {
+	var user database.User
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", userID).First(&user).Error; err != nil {
+		return errors.Wrap(err, "locking user")
+	}
+
+	return nil
+}
+
 // incrementUserUSN increment the given user's max_usn by 1
 // and returns the new, incremented max_usn
 func incrementUserUSN(tx *gorm.DB, userID int) (int, error) {
@@ -0,0 +1,48 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// IdempotencyKeyTTL is the duration for which the response for an idempotency key is stored
+	IdempotencyKeyTTL = 24 * time.Hour
+	// IdempotencyKeyLockTimeout is the duration after which a request with an idempotency key
+	// that is still being processed is considered abandoned, for instance because the server
+	// stopped in the middle. It is as long as the write timeout of the server.
+	IdempotencyKeyLockTimeout = 5 * time.Minute
+)
+
+// DeleteExpiredIdempotencyKeys deletes the stored responses that can no longer be replayed,
+// and the requests that have been abandoned while being processed
+func DeleteExpiredIdempotencyKeys(db *gorm.DB, now time.Time) error {
+	if err := db.
+		Where("created_at < ? OR (status_code = 0 AND created_at < ?)", now.Add(-IdempotencyKeyTTL), now.Add(-IdempotencyKeyLockTimeout)).
+		Delete(database.IdempotencyKey{}).Error; err != nil {
+		return errors.Wrap(err, "deleting idempotency keys")
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestDeleteExpiredIdempotencyKeys(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	now := time.Date(2019, time.April, 2, 10, 0, 0, 0, time.UTC)
+
+	setupKey := func(key string, statusCode int, createdAt time.Time) {
+		k := database.IdempotencyKey{
+			UserID:     user.ID,
+			Key:        key,
+			StatusCode: statusCode,
+		}
+		k.CreatedAt = createdAt
+		testutils.MustExec(t, db.Save(&k), "preparing idempotency key")
+	}
+
+	setupKey("expired", 201, now.Add(-IdempotencyKeyTTL-time.Minute))
+	setupKey("stored", 201, now.Add(-IdempotencyKeyTTL+time.Minute))
+	setupKey("abandoned", 0, now.Add(-IdempotencyKeyLockTimeout-time.Minute))
+	setupKey("in-progress", 0, now.Add(-time.Minute))
+
+	// execute
+	if err := DeleteExpiredIdempotencyKeys(db, now); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	var keys []database.IdempotencyKey
+	testutils.MustExec(t, db.Order("key").Find(&keys), "finding idempotency keys")
+
+	testutils.AssertEqual(t, len(keys), 2, "key count mismatch")
+	testutils.AssertEqual(t, keys[0].Key, "in-progress", "keys[0] mismatch")
+	testutils.AssertEqual(t, keys[1].Key, "stored", "keys[1] mismatch")
+}
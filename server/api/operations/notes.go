@@ -27,14 +27,39 @@ import (
 )
 
 // CreateNote creates a note with the next usn and updates the user's max_usn.
-// It returns the created note.
-func CreateNote(user database.User, clock clock.Clock, bookUUID, content string, addedOn *int64, editedOn *int64, public bool) (database.Note, error) {
+// It returns the created note. If uuid is empty, a new one is generated. Otherwise creation
+// is idempotent, and the user's existing note with the uuid is returned if there is one.
+func CreateNote(user database.User, clock clock.Clock, uuid, bookUUID, content string, addedOn *int64, editedOn *int64, public bool) (database.Note, error) {
 	db := database.DBConn
 	tx := db.Begin()
 
-	nextUSN, err := incrementUserUSN(tx, user.ID)
+	note, err := createNote(tx, user, clock, uuid, bookUUID, content, addedOn, editedOn, public)
 	if err != nil {
 		tx.Rollback()
+		return note, err
+	}
+
+	tx.Commit()
+
+	return note, nil
+}
+
+// createNote creates a note with the next usn using the given transaction
+func createNote(tx *gorm.DB, user database.User, clock clock.Clock, uuid, bookUUID, content string, addedOn *int64, editedOn *int64, public bool) (database.Note, error) {
+	if uuid == "" {
+		uuid = helpers.GenUUID()
+	} else {
+		existing, ok, err := findNoteByClientUUID(tx, user, uuid)
+		if err != nil {
+			return database.Note{}, err
+		}
+		if ok {
+			return existing, nil
+		}
+	}
+
+	nextUSN, err := incrementUserUSN(tx, user.ID)
+	if err != nil {
 		return database.Note{}, errors.Wrap(err, "incrementing user max_usn")
 	}
 
@@ -53,7 +78,7 @@ func CreateNote(user database.User, clock clock.Clock, bookUUID, content string,
 	}
 
 	note := database.Note{
-		UUID:      helpers.GenUUID(),
+		UUID:      uuid,
 		BookUUID:  bookUUID,
 		UserID:    user.ID,
 		AddedOn:   noteAddedOn,
@@ -64,15 +89,34 @@ func CreateNote(user database.User, clock clock.Clock, bookUUID, content string,
 		Encrypted: true,
 	}
 	if err := tx.Create(&note).Error; err != nil {
-		tx.Rollback()
 		return note, errors.Wrap(err, "inserting note")
 	}
 
-	tx.Commit()
-
 	return note, nil
 }
 
+// findNoteByClientUUID finds the user's note with the given client-supplied uuid. It
+// returns ErrUUIDTaken if the uuid is used by a note of another user.
+func findNoteByClientUUID(tx *gorm.DB, user database.User, uuid string) (database.Note, bool, error) {
+	if err := lockUser(tx, user.ID); err != nil {
+		return database.Note{}, false, err
+	}
+
+	var note database.Note
+	conn := tx.Where("uuid = ?", uuid).First(&note)
+	if conn.RecordNotFound() {
+		return note, false, nil
+	} else if err := conn.Error; err != nil {
+		return note, false, errors.Wrap(err, "finding note")
+	}
+
+	if note.UserID != user.ID {
+		return database.Note{}, false, ErrUUIDTaken
+	}
+
+	return note, true, nil
+}
+
 // UpdateNote creates a note with the next usn and updates the user's max_usn
 func UpdateNote(tx *gorm.DB, user database.User, clock clock.Clock, note database.Note, bookUUID, content *string, public *bool) (database.Note, error) {
 	nextUSN, err := incrementUserUSN(tx, user.ID)
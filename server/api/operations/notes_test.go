@@ -90,7 +90,7 @@ func TestCreateNote(t *testing.T) {
 			testutils.MustExec(t, db.Save(&b1), fmt.Sprintf("preparing b1 for test case %d", idx))
 
 			tx := db.Begin()
-			if _, err := CreateNote(user, mockClock, b1.UUID, "note content", tc.addedOn, tc.editedOn, false); err != nil {
+			if _, err := CreateNote(user, mockClock, "", b1.UUID, "note content", tc.addedOn, tc.editedOn, false); err != nil {
 				tx.Rollback()
 				t.Fatal(errors.Wrap(err, "deleting note"))
 			}
@@ -120,6 +120,47 @@ func TestCreateNote(t *testing.T) {
 	}
 }
 
+func TestCreateNote_clientUUID(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+	b1 := database.Book{UserID: user.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: anotherUser.ID, Label: "css"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	n1 := database.Note{UserID: anotherUser.ID, BookUUID: b2.UUID, Body: "n1 content"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	uuid := "8c7d6e5f-4a3b-4c2d-8e1f-0a9b8c7d6e5f"
+	c := clock.NewMock()
+
+	// execute
+	note, err := CreateNote(user, c, uuid, b1.UUID, "note content", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating note"))
+	}
+	retried, err := CreateNote(user, c, uuid, b1.UUID, "note content", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "retrying creating note"))
+	}
+	_, takenErr := CreateNote(user, c, n1.UUID, b1.UUID, "note content", nil, nil, false)
+
+	// test
+	var noteCount int
+	testutils.MustExec(t, db.Model(&database.Note{}).Count(&noteCount), "counting notes")
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	testutils.AssertEqual(t, note.UUID, uuid, "note uuid mismatch")
+	testutils.AssertEqual(t, retried.ID, note.ID, "retried note should be the same as the created note")
+	testutils.AssertEqual(t, retried.USN, note.USN, "retried note usn mismatch")
+	testutils.AssertEqual(t, noteCount, 2, "note count mismatch")
+	testutils.AssertEqual(t, userRecord.MaxUSN, 1, "user max_usn mismatch")
+	testutils.AssertEqual(t, errors.Cause(takenErr), ErrUUIDTaken, "error mismatch for a taken uuid")
+}
+
 func TestUpdateNote(t *testing.T) {
 	testCases := []struct {
 		userUSN     int
@@ -21,25 +21,71 @@ package operations
 import (
 	"time"
 
-	"github.com/jinzhu/gorm"
-	"github.com/pkg/errors"
+	"github.com/dnote/dnote/server/api/clock"
 	"github.com/dnote/dnote/server/api/crypt"
 	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
 )
 
-// CreateSession returns a new session for the user of the given id
-func CreateSession(db *gorm.DB, userID int) (database.Session, error) {
+var (
+	// SessionTTL is the duration for which a session stays valid without being used.
+	// The expiry is pushed back whenever the session is used.
+	SessionTTL = 30 * 24 * time.Hour
+	// SessionMaxLifetime is the maximum duration for which a session can be kept alive,
+	// either by being used or by being refreshed, counted from the time it was created
+	SessionMaxLifetime = 365 * 24 * time.Hour
+	// sessionRenewInterval is the minimum duration between renewals of a session, so that
+	// not every request needs to write to the database
+	sessionRenewInterval = 10 * time.Minute
+)
+
+// ErrInvalidRefreshToken is an error for a refresh token that does not exist or can no
+// longer be used
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// SessionDeadline returns the time after which the given session can no longer be used
+// or refreshed
+func SessionDeadline(session database.Session) time.Time {
+	return session.CreatedAt.Add(SessionMaxLifetime)
+}
+
+// getSessionExpiry returns the expiry of the given session if it is used at the given time
+func getSessionExpiry(session database.Session, now time.Time) time.Time {
+	expiry := now.Add(SessionTTL)
+
+	deadline := SessionDeadline(session)
+	if expiry.After(deadline) {
+		return deadline
+	}
+
+	return expiry
+}
+
+// CreateSession returns a new session for the user of the given id. The client type,
+// user agent and IP address are recorded so that users can identify their sessions.
+func CreateSession(db *gorm.DB, c clock.Clock, userID int, clientType, userAgent, ip string) (database.Session, error) {
 	key, err := crypt.GetRandomStr(32)
 	if err != nil {
 		return database.Session{}, errors.Wrap(err, "generating key")
 	}
+	refreshToken, err := crypt.GetRandomStr(32)
+	if err != nil {
+		return database.Session{}, errors.Wrap(err, "generating refresh token")
+	}
 
+	now := c.Now()
 	session := database.Session{
-		UserID:     userID,
-		Key:        key,
-		LastUsedAt: time.Now(),
-		ExpiresAt:  time.Now().Add(24 * 100 * time.Hour),
+		UserID:       userID,
+		Key:          key,
+		RefreshToken: refreshToken,
+		LastUsedAt:   now,
+		ExpiresAt:    now.Add(SessionTTL),
+		ClientType:   clientType,
+		UserAgent:    userAgent,
+		IP:           ip,
 	}
+	session.CreatedAt = now
 
 	if err := db.Save(&session).Error; err != nil {
 		return database.Session{}, errors.Wrap(err, "saving session")
@@ -66,3 +112,105 @@ func DeleteSession(db *gorm.DB, sessionKey string) error {
 
 	return nil
 }
+
+// ErrSessionNotFound is an error for a session that does not exist or belongs to another user
+var ErrSessionNotFound = errors.New("session not found")
+
+// GetUserSessions returns the unexpired sessions of the given user, the most recently used first
+func GetUserSessions(db *gorm.DB, c clock.Clock, userID int) ([]database.Session, error) {
+	var sessions []database.Session
+	if err := db.Where("user_id = ? AND expires_at > ?", userID, c.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, errors.Wrap(err, "finding sessions")
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSession deletes the session of the given id if it belongs to the given user
+func DeleteUserSession(db *gorm.DB, userID, sessionID int) error {
+	conn := db.Where("id = ? AND user_id = ?", sessionID, userID).Delete(&database.Session{})
+	if err := conn.Error; err != nil {
+		return errors.Wrap(err, "deleting the session")
+	}
+	if conn.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RenewSession records the use of the given session and pushes back its expiry, up to
+// the maximum lifetime of the session. It is a no-op if the session was renewed recently.
+func RenewSession(db *gorm.DB, c clock.Clock, session *database.Session) error {
+	now := c.Now()
+	if now.Sub(session.LastUsedAt) < sessionRenewInterval {
+		return nil
+	}
+
+	if err := db.Model(session).Updates(map[string]interface{}{
+		"last_used_at": now,
+		"expires_at":   getSessionExpiry(*session, now),
+	}).Error; err != nil {
+		return errors.Wrap(err, "updating session")
+	}
+
+	return nil
+}
+
+// RefreshSession renews the session that the given refresh token belongs to, even if
+// the session has expired, as long as it is within the maximum lifetime. Both the session
+// key and the refresh token are rotated, so that a refresh token can be used only once.
+// The rotation is conditional on the old key and refresh token, so that only one of the
+// concurrent refreshes with the same token succeeds.
+func RefreshSession(db *gorm.DB, c clock.Clock, refreshToken string) (database.Session, error) {
+	if refreshToken == "" {
+		return database.Session{}, ErrInvalidRefreshToken
+	}
+
+	var session database.Session
+	conn := db.Where("refresh_token = ?", refreshToken).First(&session)
+	if conn.RecordNotFound() {
+		return session, ErrInvalidRefreshToken
+	} else if err := conn.Error; err != nil {
+		return session, errors.Wrap(err, "finding session")
+	}
+
+	now := c.Now()
+	if now.After(SessionDeadline(session)) {
+		return session, ErrInvalidRefreshToken
+	}
+
+	key, err := crypt.GetRandomStr(32)
+	if err != nil {
+		return session, errors.Wrap(err, "generating key")
+	}
+	newRefreshToken, err := crypt.GetRandomStr(32)
+	if err != nil {
+		return session, errors.Wrap(err, "generating refresh token")
+	}
+	expiresAt := getSessionExpiry(session, now)
+
+	conn = db.Model(&database.Session{}).
+		Where("id = ? AND key = ? AND refresh_token = ?", session.ID, session.Key, refreshToken).
+		Updates(map[string]interface{}{
+			"key":           key,
+			"refresh_token": newRefreshToken,
+			"last_used_at":  now,
+			"expires_at":    expiresAt,
+		})
+	if err := conn.Error; err != nil {
+		return session, errors.Wrap(err, "updating session")
+	}
+	if conn.RowsAffected == 0 {
+		return session, ErrInvalidRefreshToken
+	}
+
+	session.Key = key
+	session.RefreshToken = newRefreshToken
+	session.LastUsedAt = now
+	session.ExpiresAt = expiresAt
+
+	return session, nil
+}
@@ -0,0 +1,262 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestCreateSession(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+
+	now := time.Date(2019, time.April, 2, 10, 0, 0, 0, time.UTC)
+	c := clock.NewMock()
+	c.SetNow(now)
+
+	session, err := CreateSession(db, c, user.ID, "cli", "Go-http-client/1.1", "10.0.0.1")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating session"))
+	}
+
+	var sessionRecord database.Session
+	testutils.MustExec(t, db.Where("id = ?", session.ID).First(&sessionRecord), "finding session")
+
+	testutils.AssertEqual(t, sessionRecord.UserID, user.ID, "UserID mismatch")
+	testutils.AssertEqual(t, sessionRecord.ClientType, "cli", "ClientType mismatch")
+	testutils.AssertEqual(t, sessionRecord.UserAgent, "Go-http-client/1.1", "UserAgent mismatch")
+	testutils.AssertEqual(t, sessionRecord.IP, "10.0.0.1", "IP mismatch")
+	testutils.AssertNotEqual(t, sessionRecord.Key, "", "Key should have been generated")
+	testutils.AssertEqual(t, sessionRecord.LastUsedAt.Unix(), now.Unix(), "LastUsedAt mismatch")
+	testutils.AssertEqual(t, sessionRecord.ExpiresAt.Unix(), now.Add(SessionTTL).Unix(), "ExpiresAt mismatch")
+}
+
+func TestGetUserSessions(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	s1 := database.Session{UserID: user.ID, Key: "s1-key", LastUsedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s1), "preparing s1")
+	s2 := database.Session{UserID: user.ID, Key: "s2-key", LastUsedAt: time.Now().Add(-1 * time.Hour), ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s2), "preparing s2")
+	s3 := database.Session{UserID: user.ID, Key: "s3-key", LastUsedAt: time.Now().Add(-3 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}
+	testutils.MustExec(t, db.Save(&s3), "preparing s3")
+	s4 := database.Session{UserID: anotherUser.ID, Key: "s4-key", LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s4), "preparing s4")
+
+	sessions, err := GetUserSessions(db, clock.New(), user.ID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting sessions"))
+	}
+
+	testutils.AssertEqual(t, len(sessions), 2, "session count mismatch")
+	testutils.AssertEqual(t, sessions[0].Key, "s2-key", "sessions[0] mismatch")
+	testutils.AssertEqual(t, sessions[1].Key, "s1-key", "sessions[1] mismatch")
+}
+
+func TestDeleteUserSession(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	s1 := database.Session{UserID: user.ID, Key: "s1-key", ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s1), "preparing s1")
+	s2 := database.Session{UserID: anotherUser.ID, Key: "s2-key", ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s2), "preparing s2")
+
+	if err := DeleteUserSession(db, user.ID, s2.ID); errors.Cause(err) != ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound for another user's session but got %+v", err)
+	}
+	if err := DeleteUserSession(db, user.ID, s1.ID); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting session"))
+	}
+
+	var s1Count, s2Count int
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("id = ?", s1.ID).Count(&s1Count), "counting s1")
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("id = ?", s2.ID).Count(&s2Count), "counting s2")
+
+	testutils.AssertEqual(t, s1Count, 0, "s1 should have been deleted")
+	testutils.AssertEqual(t, s2Count, 1, "s2 should not have been deleted")
+}
+
+func TestRenewSession(t *testing.T) {
+	t.Run("recently renewed", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		lastUsedAt := time.Now().Add(-time.Minute)
+		expiresAt := time.Now().Add(time.Hour)
+		session := database.Session{UserID: user.ID, Key: "some-key", LastUsedAt: lastUsedAt, ExpiresAt: expiresAt}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		if err := RenewSession(db, clock.New(), &session); err != nil {
+			t.Fatal(errors.Wrap(err, "renewing session"))
+		}
+
+		var sessionRecord database.Session
+		testutils.MustExec(t, db.Where("id = ?", session.ID).First(&sessionRecord), "finding session")
+		testutils.AssertEqual(t, sessionRecord.ExpiresAt.Unix(), expiresAt.Unix(), "ExpiresAt mismatch")
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		session := database.Session{UserID: user.ID, Key: "some-key", LastUsedAt: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour)}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		if err := RenewSession(db, clock.New(), &session); err != nil {
+			t.Fatal(errors.Wrap(err, "renewing session"))
+		}
+
+		var sessionRecord database.Session
+		testutils.MustExec(t, db.Where("id = ?", session.ID).First(&sessionRecord), "finding session")
+		if sessionRecord.ExpiresAt.Before(time.Now().Add(SessionTTL - time.Minute)) {
+			t.Errorf("expected the expiry to be pushed back but got %s", sessionRecord.ExpiresAt)
+		}
+		if sessionRecord.LastUsedAt.Before(time.Now().Add(-time.Minute)) {
+			t.Errorf("expected LastUsedAt to be updated but got %s", sessionRecord.LastUsedAt)
+		}
+	})
+
+	t.Run("near deadline", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		session := database.Session{UserID: user.ID, Key: "some-key", LastUsedAt: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour)}
+		session.CreatedAt = time.Now().Add(-SessionMaxLifetime).Add(2 * time.Hour)
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		if err := RenewSession(db, clock.New(), &session); err != nil {
+			t.Fatal(errors.Wrap(err, "renewing session"))
+		}
+
+		var sessionRecord database.Session
+		testutils.MustExec(t, db.Where("id = ?", session.ID).First(&sessionRecord), "finding session")
+		testutils.AssertEqual(t, sessionRecord.ExpiresAt.Unix(), SessionDeadline(sessionRecord).Unix(), "ExpiresAt should be capped at the deadline")
+	})
+}
+
+func TestRefreshSession(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		session := database.Session{UserID: user.ID, Key: "some-key", RefreshToken: "some-refresh-token", ExpiresAt: time.Now().Add(-time.Hour)}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		got, err := RefreshSession(db, clock.New(), "some-refresh-token")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "refreshing session"))
+		}
+
+		var sessionRecord database.Session
+		testutils.MustExec(t, db.Where("id = ?", session.ID).First(&sessionRecord), "finding session")
+		testutils.AssertEqual(t, sessionRecord.Key, got.Key, "Key mismatch")
+		testutils.AssertEqual(t, sessionRecord.RefreshToken, got.RefreshToken, "RefreshToken mismatch")
+		testutils.AssertNotEqual(t, sessionRecord.Key, "some-key", "Key should have been rotated")
+		testutils.AssertNotEqual(t, sessionRecord.RefreshToken, "some-refresh-token", "RefreshToken should have been rotated")
+		if sessionRecord.ExpiresAt.Before(time.Now()) {
+			t.Errorf("expected the session to be renewed but it expires at %s", sessionRecord.ExpiresAt)
+		}
+
+		if _, err := RefreshSession(db, clock.New(), "some-refresh-token"); errors.Cause(err) != ErrInvalidRefreshToken {
+			t.Errorf("expected ErrInvalidRefreshToken for a used refresh token but got %+v", err)
+		}
+	})
+
+	t.Run("past deadline", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		session := database.Session{UserID: user.ID, Key: "some-key", RefreshToken: "some-refresh-token", ExpiresAt: time.Now().Add(-time.Hour)}
+		session.CreatedAt = time.Now().Add(-SessionMaxLifetime).Add(-time.Hour)
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		if _, err := RefreshSession(db, clock.New(), "some-refresh-token"); errors.Cause(err) != ErrInvalidRefreshToken {
+			t.Errorf("expected ErrInvalidRefreshToken but got %+v", err)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		session := database.Session{UserID: user.ID, Key: "some-key", ExpiresAt: time.Now().Add(time.Hour)}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		if _, err := RefreshSession(db, clock.New(), ""); errors.Cause(err) != ErrInvalidRefreshToken {
+			t.Errorf("expected ErrInvalidRefreshToken but got %+v", err)
+		}
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		session := database.Session{UserID: user.ID, Key: "some-key", RefreshToken: "some-refresh-token", ExpiresAt: time.Now().Add(-time.Hour)}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var succeeded int
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := RefreshSession(db, clock.New(), "some-refresh-token")
+				if errors.Cause(err) == ErrInvalidRefreshToken {
+					return
+				} else if err != nil {
+					t.Error(errors.Wrap(err, "refreshing session"))
+					return
+				}
+
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		testutils.AssertEqual(t, succeeded, 1, "only one refresh should succeed")
+	})
+}
@@ -0,0 +1,122 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// signinFailureWindow is the duration after which the failed attempts to sign in are forgotten
+	signinFailureWindow = time.Hour
+	// freeSigninFailures is the number of failed attempts to sign in before the attempts are delayed
+	freeSigninFailures = 3
+	// signinDelayBase is the delay after the first delayed attempt. It doubles with each failure.
+	signinDelayBase = time.Second
+	// maxSigninFailures is the number of failed attempts to sign in that locks the email
+	maxSigninFailures = 10
+	// SigninLockDuration is the duration for which an email is locked after too many failed
+	// attempts to sign in
+	SigninLockDuration = 30 * time.Minute
+)
+
+// getSigninDelay returns the delay before the next attempt to sign in after the given
+// number of failed attempts
+func getSigninDelay(failureCount int) time.Duration {
+	if failureCount < freeSigninFailures {
+		return 0
+	}
+
+	return signinDelayBase << uint(failureCount-freeSigninFailures)
+}
+
+// GetSigninWait returns the duration for which the attempts to sign in with the email must
+// wait because of the previous failures. It is 0 if the attempts can be made.
+func GetSigninWait(db *gorm.DB, c clock.Clock, email string) (time.Duration, error) {
+	var failure database.SigninFailure
+	conn := db.Where("email = ?", email).First(&failure)
+	if conn.RecordNotFound() {
+		return 0, nil
+	} else if err := conn.Error; err != nil {
+		return 0, errors.Wrap(err, "finding signin failure")
+	}
+
+	now := c.Now()
+
+	if failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+		return failure.LockedUntil.Sub(now), nil
+	}
+	if now.Sub(failure.LastFailedAt) > signinFailureWindow {
+		return 0, nil
+	}
+
+	next := failure.LastFailedAt.Add(getSigninDelay(failure.Count))
+	if now.Before(next) {
+		return next.Sub(now), nil
+	}
+
+	return 0, nil
+}
+
+// RecordSigninFailure records a failed attempt to sign in with the email, and locks the
+// email if there have been too many. It returns whether the email was locked.
+func RecordSigninFailure(db *gorm.DB, c clock.Clock, email string) (bool, error) {
+	now := c.Now()
+
+	// The count is incremented in a single statement so that the concurrent attempts
+	// are all counted
+	var count int
+	row := db.Raw(`INSERT INTO signin_failures (email, count, last_failed_at, created_at, updated_at)
+	VALUES (?, 1, ?, ?, ?)
+	ON CONFLICT (email) DO UPDATE SET
+		count = CASE WHEN signin_failures.last_failed_at < ? THEN 1 ELSE signin_failures.count + 1 END,
+		last_failed_at = EXCLUDED.last_failed_at,
+		updated_at = EXCLUDED.updated_at
+	RETURNING count`, email, now, now, now, now.Add(-signinFailureWindow)).Row()
+	if err := row.Scan(&count); err != nil {
+		return false, errors.Wrap(err, "incrementing signin failures")
+	}
+
+	if count < maxSigninFailures {
+		return false, nil
+	}
+
+	// The failures start over once the lock expires
+	lockedUntil := now.Add(SigninLockDuration)
+	if err := db.Model(database.SigninFailure{}).Where("email = ?", email).
+		Update(map[string]interface{}{"count": 0, "locked_until": lockedUntil}).Error; err != nil {
+		return false, errors.Wrap(err, "locking")
+	}
+
+	return true, nil
+}
+
+// ClearSigninFailures forgets the failed attempts to sign in with the email
+func ClearSigninFailures(db *gorm.DB, email string) error {
+	if err := db.Where("email = ?", email).Delete(database.SigninFailure{}).Error; err != nil {
+		return errors.Wrap(err, "deleting signin failure")
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestGetSigninDelay(t *testing.T) {
+	testCases := []struct {
+		failureCount int
+		expected     time.Duration
+	}{
+		{
+			failureCount: 0,
+			expected:     0,
+		},
+		{
+			failureCount: 2,
+			expected:     0,
+		},
+		{
+			failureCount: 3,
+			expected:     time.Second,
+		},
+		{
+			failureCount: 4,
+			expected:     2 * time.Second,
+		},
+		{
+			failureCount: 9,
+			expected:     64 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d failures", tc.failureCount), func(t *testing.T) {
+			testutils.AssertEqual(t, getSigninDelay(tc.failureCount), tc.expected, "delay mismatch")
+		})
+	}
+}
+
+func TestRecordSigninFailure(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	c := clock.NewMock()
+	email := "alice@example.com"
+
+	for i := 1; i < maxSigninFailures; i++ {
+		locked, err := RecordSigninFailure(db, c, email)
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "recording failure %d", i))
+		}
+		testutils.AssertEqual(t, locked, false, fmt.Sprintf("locked mismatch for failure %d", i))
+
+		wait, err := GetSigninWait(db, c, email)
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "getting wait after failure %d", i))
+		}
+		testutils.AssertEqual(t, wait, getSigninDelay(i), fmt.Sprintf("wait mismatch after failure %d", i))
+	}
+
+	locked, err := RecordSigninFailure(db, c, email)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "recording the last failure"))
+	}
+	testutils.AssertEqual(t, locked, true, "the email should have been locked")
+
+	wait, err := GetSigninWait(db, c, email)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting wait while locked"))
+	}
+	testutils.AssertEqual(t, wait, SigninLockDuration, "wait mismatch while locked")
+
+	// the attempts can be made once the lock expires
+	c.SetNow(c.Now().Add(SigninLockDuration))
+
+	wait, err = GetSigninWait(db, c, email)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting wait after the lock"))
+	}
+	testutils.AssertEqual(t, wait, time.Duration(0), "wait mismatch after the lock")
+}
+
+func TestRecordSigninFailure_Window(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	c := clock.NewMock()
+	email := "alice@example.com"
+
+	for i := 0; i < 5; i++ {
+		if _, err := RecordSigninFailure(db, c, email); err != nil {
+			t.Fatal(errors.Wrapf(err, "recording failure %d", i))
+		}
+	}
+
+	// the failures are forgotten after the window
+	c.SetNow(c.Now().Add(signinFailureWindow + time.Minute))
+	if _, err := RecordSigninFailure(db, c, email); err != nil {
+		t.Fatal(errors.Wrap(err, "recording failure after the window"))
+	}
+
+	var failure database.SigninFailure
+	testutils.MustExec(t, db.Where("email = ?", email).First(&failure), "finding signin failure")
+	testutils.AssertEqual(t, failure.Count, 1, "count mismatch")
+}
+
+func TestClearSigninFailures(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	c := clock.NewMock()
+
+	if _, err := RecordSigninFailure(db, c, "alice@example.com"); err != nil {
+		t.Fatal(errors.Wrap(err, "recording failure for alice"))
+	}
+	if _, err := RecordSigninFailure(db, c, "bob@example.com"); err != nil {
+		t.Fatal(errors.Wrap(err, "recording failure for bob"))
+	}
+
+	if err := ClearSigninFailures(db, "alice@example.com"); err != nil {
+		t.Fatal(errors.Wrap(err, "clearing"))
+	}
+
+	var count int
+	testutils.MustExec(t, db.Model(&database.SigninFailure{}).Count(&count), "counting signin failures")
+	testutils.AssertEqual(t, count, 1, "count mismatch")
+}
@@ -19,6 +19,8 @@
 package operations
 
 import (
+	"time"
+
 	"github.com/dnote/dnote/server/database"
 	"github.com/pkg/errors"
 
@@ -65,18 +67,60 @@ func ReactivateSub(subscriptionID string, user database.User) error {
 	return nil
 }
 
-// MarkUnsubscribed marks the user unsubscribed
-func MarkUnsubscribed(stripeCustomerID string) error {
+// ErrStripeCustomerNotFound is an error indicating that no user has the Stripe customer
+var ErrStripeCustomerNotFound = errors.New("No user has the Stripe customer")
+
+// FindStripeCustomer finds the user with the given Stripe customer id
+func FindStripeCustomer(stripeCustomerID string) (database.User, error) {
 	db := database.DBConn
 
 	var user database.User
-	if err := db.Where("stripe_customer_id = ?", stripeCustomerID).First(&user).Error; err != nil {
-		return errors.Wrap(err, "finding user")
+	conn := db.Where("stripe_customer_id = ?", stripeCustomerID).First(&user)
+	if conn.RecordNotFound() {
+		return user, ErrStripeCustomerNotFound
+	} else if err := conn.Error; err != nil {
+		return user, errors.Wrap(err, "finding user")
+	}
+
+	return user, nil
+}
+
+// UpdateCloud grants or revokes the access to Dnote Pro for the user with the given
+// Stripe customer id, according to the Stripe event created at the given time. It is a
+// no-op if a newer event has already been applied.
+func UpdateCloud(stripeCustomerID string, cloud bool, eventAt time.Time) error {
+	db := database.DBConn
+
+	user, err := FindStripeCustomer(stripeCustomerID)
+	if err != nil {
+		return err
 	}
 
-	if err := db.Model(&user).Update("cloud", false).Error; err != nil {
+	if err := db.Model(&database.User{}).
+		Where("id = ? AND (stripe_event_at IS NULL OR stripe_event_at <= ?)", user.ID, eventAt).
+		Updates(map[string]interface{}{
+			"cloud":           cloud,
+			"stripe_event_at": eventAt,
+		}).Error; err != nil {
 		return errors.Wrap(err, "updating user")
 	}
 
 	return nil
 }
+
+// MarkUnsubscribed marks the user unsubscribed
+func MarkUnsubscribed(stripeCustomerID string, eventAt time.Time) error {
+	return UpdateCloud(stripeCustomerID, false, eventAt)
+}
+
+// SubscriptionGrantsAccess returns whether a subscription with the given status gives
+// access to Dnote Pro. A past due subscription keeps the access while Stripe retries
+// the payment.
+func SubscriptionGrantsAccess(status string) bool {
+	switch status {
+	case "active", "trialing", "past_due":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,259 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"fmt"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Types of the items that clients can push changes for
+const (
+	SyncTypeBook = "book"
+	SyncTypeNote = "note"
+)
+
+// Actions that clients can push
+const (
+	SyncActionCreate = "create"
+	SyncActionUpdate = "update"
+	SyncActionDelete = "delete"
+)
+
+var (
+	// ErrSyncItemNotFound is an error for a change to a book or a note that does not exist
+	ErrSyncItemNotFound = errors.New("not found")
+	// ErrDuplicateBook is an error for creating a book whose label already exists
+	ErrDuplicateBook = errors.New("duplicate book exists")
+)
+
+// SyncChange is a change to a book or a note pushed by a client. ClientUUID is the uuid
+// of the item generated by the client, which is also used on the server.
+type SyncChange struct {
+	Type       string
+	Action     string
+	ClientUUID string
+	Label      *string
+	BookUUID   *string
+	Content    *string
+	Public     *bool
+	AddedOn    *int64
+	EditedOn   *int64
+}
+
+// SyncChangeResult is a result of applying a SyncChange
+type SyncChangeResult struct {
+	ClientUUID string
+	UUID       string
+	USN        int
+}
+
+// SyncChangeError is an error for a change that cannot be applied
+type SyncChangeError struct {
+	Index      int
+	ClientUUID string
+	Err        error
+}
+
+func (e SyncChangeError) Error() string {
+	return fmt.Sprintf("change %d (%s): %s", e.Index, e.ClientUUID, e.Err.Error())
+}
+
+// ApplySyncChanges applies the given changes in order using the given transaction, and
+// returns the results in the same order. Creating an item that already exists with the
+// same uuid is a no-op, so that clients can safely retry a batch. If a change cannot be
+// applied, it returns SyncChangeError and the transaction should be rolled back.
+func ApplySyncChanges(tx *gorm.DB, c clock.Clock, user database.User, changes []SyncChange) ([]SyncChangeResult, error) {
+	results := []SyncChangeResult{}
+
+	for idx, change := range changes {
+		var result SyncChangeResult
+		var err error
+
+		switch change.Type {
+		case SyncTypeBook:
+			result, err = applyBookChange(tx, c, user, change)
+		case SyncTypeNote:
+			result, err = applyNoteChange(tx, c, user, change)
+		default:
+			err = errors.Errorf("unknown type '%s'", change.Type)
+		}
+
+		if err != nil {
+			cause := errors.Cause(err)
+			if cause == ErrSyncItemNotFound || cause == ErrDuplicateBook || cause == ErrUUIDTaken {
+				return nil, SyncChangeError{Index: idx, ClientUUID: change.ClientUUID, Err: cause}
+			}
+
+			return nil, errors.Wrapf(err, "applying change %d", idx)
+		}
+
+		result.ClientUUID = change.ClientUUID
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func findBook(tx *gorm.DB, userID int, uuid string) (database.Book, error) {
+	var book database.Book
+	conn := tx.Where("user_id = ? AND uuid = ?", userID, uuid).First(&book)
+	if conn.RecordNotFound() {
+		return book, ErrSyncItemNotFound
+	} else if err := conn.Error; err != nil {
+		return book, errors.Wrap(err, "finding book")
+	}
+
+	return book, nil
+}
+
+func findNote(tx *gorm.DB, userID int, uuid string) (database.Note, error) {
+	var note database.Note
+	conn := tx.Where("user_id = ? AND uuid = ?", userID, uuid).First(&note)
+	if conn.RecordNotFound() {
+		return note, ErrSyncItemNotFound
+	} else if err := conn.Error; err != nil {
+		return note, errors.Wrap(err, "finding note")
+	}
+
+	return note, nil
+}
+
+func applyBookChange(tx *gorm.DB, c clock.Clock, user database.User, change SyncChange) (SyncChangeResult, error) {
+	var book database.Book
+	var err error
+
+	switch change.Action {
+	case SyncActionCreate:
+		if change.Label == nil {
+			return SyncChangeResult{}, errors.New("label is required")
+		}
+
+		var count int
+		if err := tx.Model(database.Book{}).
+			Where("user_id = ? AND label = ? AND uuid <> ?", user.ID, *change.Label, change.ClientUUID).
+			Count(&count).Error; err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "checking duplicate")
+		}
+		if count > 0 {
+			return SyncChangeResult{}, ErrDuplicateBook
+		}
+
+		book, err = createBook(tx, user, c, change.ClientUUID, *change.Label)
+		if err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "creating book")
+		}
+	case SyncActionUpdate:
+		book, err = findBook(tx, user.ID, change.ClientUUID)
+		if err != nil {
+			return SyncChangeResult{}, err
+		}
+
+		book, err = UpdateBook(tx, c, user, book, change.Label)
+		if err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "updating book")
+		}
+	case SyncActionDelete:
+		book, err = findBook(tx, user.ID, change.ClientUUID)
+		if err != nil {
+			return SyncChangeResult{}, err
+		}
+
+		var notes []database.Note
+		if err := tx.Where("book_uuid = ? AND NOT deleted", book.UUID).Order("usn ASC").Find(&notes).Error; err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "finding notes")
+		}
+		for _, note := range notes {
+			if _, err := DeleteNote(tx, user, note); err != nil {
+				return SyncChangeResult{}, errors.Wrap(err, "deleting a note")
+			}
+		}
+
+		book, err = DeleteBook(tx, user, book)
+		if err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "deleting book")
+		}
+	default:
+		return SyncChangeResult{}, errors.Errorf("unknown action '%s'", change.Action)
+	}
+
+	return SyncChangeResult{UUID: book.UUID, USN: book.USN}, nil
+}
+
+func applyNoteChange(tx *gorm.DB, c clock.Clock, user database.User, change SyncChange) (SyncChangeResult, error) {
+	var note database.Note
+	var err error
+
+	// the book needs to exist if the note is being created or moved to it
+	if change.BookUUID != nil && change.Action != SyncActionDelete {
+		if _, err := findBook(tx, user.ID, *change.BookUUID); err != nil {
+			return SyncChangeResult{}, err
+		}
+	}
+
+	switch change.Action {
+	case SyncActionCreate:
+		if change.BookUUID == nil {
+			return SyncChangeResult{}, errors.New("book_uuid is required")
+		}
+
+		var content string
+		if change.Content != nil {
+			content = *change.Content
+		}
+
+		var public bool
+		if change.Public != nil {
+			public = *change.Public
+		}
+
+		note, err = createNote(tx, user, c, change.ClientUUID, *change.BookUUID, content, change.AddedOn, change.EditedOn, public)
+		if err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "creating note")
+		}
+	case SyncActionUpdate:
+		note, err = findNote(tx, user.ID, change.ClientUUID)
+		if err != nil {
+			return SyncChangeResult{}, err
+		}
+
+		note, err = UpdateNote(tx, user, c, note, change.BookUUID, change.Content, change.Public)
+		if err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "updating note")
+		}
+	case SyncActionDelete:
+		note, err = findNote(tx, user.ID, change.ClientUUID)
+		if err != nil {
+			return SyncChangeResult{}, err
+		}
+
+		note, err = DeleteNote(tx, user, note)
+		if err != nil {
+			return SyncChangeResult{}, errors.Wrap(err, "deleting note")
+		}
+	default:
+		return SyncChangeResult{}, errors.Errorf("unknown action '%s'", change.Action)
+	}
+
+	return SyncChangeResult{UUID: note.UUID, USN: note.USN}, nil
+}
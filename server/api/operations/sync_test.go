@@ -0,0 +1,250 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestApplySyncChanges(t *testing.T) {
+	serverTime := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+	mockClock := clock.NewMock()
+	mockClock.SetNow(serverTime)
+
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 10), "preparing user max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "b1-label", USN: 5}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1-body", USN: 6}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n2-body", USN: 7}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+
+	newLabel := "b2-label"
+	newBody := "n3-body"
+	localBookUUID := "3e2b1f1c-8b2a-4c6e-9f4d-1a2b3c4d5e6f"
+	localNoteUUID := "8c7d6e5f-4a3b-4c2d-8e1f-0a9b8c7d6e5f"
+	updatedBody := "n2-body-updated"
+	addedOn := int64(1541108743)
+
+	changes := []SyncChange{
+		{Type: SyncTypeBook, Action: SyncActionCreate, ClientUUID: localBookUUID, Label: &newLabel},
+		{Type: SyncTypeNote, Action: SyncActionCreate, ClientUUID: localNoteUUID, BookUUID: &localBookUUID, Content: &newBody, AddedOn: &addedOn},
+		{Type: SyncTypeNote, Action: SyncActionUpdate, ClientUUID: n2.UUID, Content: &updatedBody},
+		{Type: SyncTypeNote, Action: SyncActionDelete, ClientUUID: n1.UUID},
+	}
+
+	tx := db.Begin()
+	results, err := ApplySyncChanges(tx, mockClock, user, changes)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "applying changes"))
+	}
+	tx.Commit()
+
+	var book database.Book
+	testutils.MustExec(t, db.Where("label = ?", newLabel).First(&book), "finding created book")
+	var createdNote, deletedNote, updatedNote database.Note
+	testutils.MustExec(t, db.Where("body = ?", newBody).First(&createdNote), "finding created note")
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&deletedNote), "finding n1")
+	testutils.MustExec(t, db.Where("id = ?", n2.ID).First(&updatedNote), "finding n2")
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	testutils.AssertEqual(t, len(results), 4, "result count mismatch")
+	testutils.AssertEqual(t, results[0].ClientUUID, localBookUUID, "results[0] ClientUUID mismatch")
+	testutils.AssertEqual(t, results[0].UUID, localBookUUID, "results[0] UUID mismatch")
+	testutils.AssertEqual(t, results[0].USN, 11, "results[0] USN mismatch")
+	testutils.AssertEqual(t, results[1].ClientUUID, localNoteUUID, "results[1] ClientUUID mismatch")
+	testutils.AssertEqual(t, results[1].UUID, localNoteUUID, "results[1] UUID mismatch")
+	testutils.AssertEqual(t, results[1].USN, 12, "results[1] USN mismatch")
+	testutils.AssertEqual(t, results[2].UUID, n2.UUID, "results[2] UUID mismatch")
+	testutils.AssertEqual(t, results[2].USN, 13, "results[2] USN mismatch")
+	testutils.AssertEqual(t, results[3].UUID, n1.UUID, "results[3] UUID mismatch")
+	testutils.AssertEqual(t, results[3].USN, 14, "results[3] USN mismatch")
+
+	// the items should be created with the uuids generated by the client
+	testutils.AssertEqual(t, book.UUID, localBookUUID, "created book UUID mismatch")
+	testutils.AssertEqual(t, createdNote.UUID, localNoteUUID, "created note UUID mismatch")
+	testutils.AssertEqual(t, createdNote.BookUUID, localBookUUID, "created note BookUUID mismatch")
+	testutils.AssertEqual(t, createdNote.AddedOn, addedOn, "created note AddedOn mismatch")
+	testutils.AssertEqual(t, updatedNote.Body, updatedBody, "n2 Body mismatch")
+	testutils.AssertEqual(t, deletedNote.Deleted, true, "n1 Deleted mismatch")
+	testutils.AssertEqual(t, userRecord.MaxUSN, 14, "user max_usn mismatch")
+}
+
+func TestApplySyncChanges_deleteBook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 10), "preparing user max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "b1-label", USN: 5}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1-body", USN: 6}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	changes := []SyncChange{
+		{Type: SyncTypeBook, Action: SyncActionDelete, ClientUUID: b1.UUID},
+	}
+
+	tx := db.Begin()
+	results, err := ApplySyncChanges(tx, clock.NewMock(), user, changes)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "applying changes"))
+	}
+	tx.Commit()
+
+	var bookRecord database.Book
+	var noteRecord database.Note
+	testutils.MustExec(t, db.Where("id = ?", b1.ID).First(&bookRecord), "finding b1")
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&noteRecord), "finding n1")
+
+	testutils.AssertEqual(t, bookRecord.Deleted, true, "b1 Deleted mismatch")
+	testutils.AssertEqual(t, noteRecord.Deleted, true, "n1 should have been deleted along with the book")
+	testutils.AssertEqual(t, results[0].USN, bookRecord.USN, "result USN mismatch")
+	testutils.AssertEqual(t, bookRecord.USN, 12, "b1 USN mismatch")
+}
+
+func TestApplySyncChanges_retry(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+
+	label := "b1-label"
+	body := "n1-body"
+	bookUUID := "3e2b1f1c-8b2a-4c6e-9f4d-1a2b3c4d5e6f"
+	noteUUID := "8c7d6e5f-4a3b-4c2d-8e1f-0a9b8c7d6e5f"
+
+	changes := []SyncChange{
+		{Type: SyncTypeBook, Action: SyncActionCreate, ClientUUID: bookUUID, Label: &label},
+		{Type: SyncTypeNote, Action: SyncActionCreate, ClientUUID: noteUUID, BookUUID: &bookUUID, Content: &body},
+	}
+
+	// execute
+	var results [][]SyncChangeResult
+	for i := 0; i < 2; i++ {
+		tx := db.Begin()
+		r, err := ApplySyncChanges(tx, clock.NewMock(), user, changes)
+		if err != nil {
+			tx.Rollback()
+			t.Fatal(errors.Wrapf(err, "applying changes %d", i))
+		}
+		tx.Commit()
+
+		results = append(results, r)
+	}
+
+	// test
+	var bookCount, noteCount int
+	testutils.MustExec(t, db.Model(&database.Book{}).Count(&bookCount), "counting books")
+	testutils.MustExec(t, db.Model(&database.Note{}).Count(&noteCount), "counting notes")
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	testutils.AssertEqual(t, bookCount, 1, "book count mismatch")
+	testutils.AssertEqual(t, noteCount, 1, "note count mismatch")
+	testutils.AssertEqual(t, userRecord.MaxUSN, 2, "user max_usn mismatch")
+	testutils.AssertDeepEqual(t, results[1], results[0], "results of the retry mismatch")
+}
+
+func TestApplySyncChanges_error(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: user.ID, Label: "b1-label"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: anotherUser.ID, Label: "b2-label"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+
+	duplicateLabel := "b1-label"
+	newLabel := "b3-label"
+
+	testCases := []struct {
+		changes       []SyncChange
+		expectedIndex int
+		expectedErr   error
+	}{
+		{
+			changes: []SyncChange{
+				{Type: SyncTypeBook, Action: SyncActionCreate, ClientUUID: "0f1e2d3c-4b5a-4978-8695-a4b3c2d1e0f9", Label: &newLabel},
+				{Type: SyncTypeBook, Action: SyncActionCreate, ClientUUID: "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d", Label: &duplicateLabel},
+			},
+			expectedIndex: 1,
+			expectedErr:   ErrDuplicateBook,
+		},
+		{
+			// books of other users cannot be changed
+			changes: []SyncChange{
+				{Type: SyncTypeBook, Action: SyncActionDelete, ClientUUID: b2.UUID},
+			},
+			expectedIndex: 0,
+			expectedErr:   ErrSyncItemNotFound,
+		},
+		{
+			// uuids of other users' items cannot be used
+			changes: []SyncChange{
+				{Type: SyncTypeBook, Action: SyncActionCreate, ClientUUID: b2.UUID, Label: &newLabel},
+			},
+			expectedIndex: 0,
+			expectedErr:   ErrUUIDTaken,
+		},
+		{
+			changes: []SyncChange{
+				{Type: SyncTypeNote, Action: SyncActionDelete, ClientUUID: "ab1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e"},
+			},
+			expectedIndex: 0,
+			expectedErr:   ErrSyncItemNotFound,
+		},
+	}
+
+	for idx, tc := range testCases {
+		tx := db.Begin()
+		_, err := ApplySyncChanges(tx, clock.NewMock(), user, tc.changes)
+		tx.Rollback()
+
+		e, ok := err.(SyncChangeError)
+		if !ok {
+			t.Fatalf("test case %d: expected SyncChangeError but got %+v", idx, err)
+		}
+
+		testutils.AssertEqual(t, e.Index, tc.expectedIndex, "Index mismatch")
+		testutils.AssertEqual(t, e.Err, tc.expectedErr, "Err mismatch")
+	}
+
+	var bookCount int
+	testutils.MustExec(t, db.Model(&database.Book{}).Count(&bookCount), "counting books")
+	testutils.AssertEqual(t, bookCount, 2, "no book should have been created")
+}
@@ -0,0 +1,189 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/crypt"
+	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrTOTPEnabled is an error for enrolling two-factor authentication when it is already enabled
+	ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPNotEnrolled is an error for enabling two-factor authentication before enrolling
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication is not enrolled")
+	// ErrInvalidTOTPCode is an error for a wrong two-factor authentication code
+	ErrInvalidTOTPCode = errors.New("invalid code")
+)
+
+// backupCodeCount is the number of backup codes generated upon enabling two-factor authentication
+var backupCodeCount = 10
+
+// EnrollTOTP generates a new TOTP secret for the account. Two-factor authentication is not
+// enabled until the user verifies a code generated from the secret.
+func EnrollTOTP(tx *gorm.DB, account database.Account) (string, error) {
+	if account.TOTPEnabled {
+		return "", ErrTOTPEnabled
+	}
+
+	secret, err := crypt.GenerateTOTPSecret()
+	if err != nil {
+		return "", errors.Wrap(err, "generating secret")
+	}
+
+	if err := tx.Model(&account).Update("totp_secret", secret).Error; err != nil {
+		return "", errors.Wrap(err, "saving secret")
+	}
+
+	return secret, nil
+}
+
+// EnableTOTP verifies the code against the enrolled secret and enables two-factor
+// authentication. It returns newly generated backup codes in plaintext, which are not
+// stored and therefore can be shown to the user only once.
+func EnableTOTP(tx *gorm.DB, c clock.Clock, account database.Account, code string) ([]string, error) {
+	if account.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	if account.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	step, ok, err := crypt.ValidateTOTP(account.TOTPSecret, code, c.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "validating code")
+	}
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	if err := tx.Model(&account).
+		Update(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+		return nil, errors.Wrap(err, "enabling totp")
+	}
+
+	codes, err := generateBackupCodes(tx, account.UserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating backup codes")
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP disables two-factor authentication and removes the secret and backup codes
+func DisableTOTP(tx *gorm.DB, account database.Account) error {
+	if err := tx.Model(&account).
+		Update(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+		return errors.Wrap(err, "disabling totp")
+	}
+
+	if err := tx.Where("user_id = ?", account.UserID).Delete(&database.BackupCode{}).Error; err != nil {
+		return errors.Wrap(err, "deleting backup codes")
+	}
+
+	return nil
+}
+
+// generateBackupCodes replaces the backup codes of the user with new ones
+func generateBackupCodes(tx *gorm.DB, userID int) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.BackupCode{}).Error; err != nil {
+		return nil, errors.Wrap(err, "deleting existing backup codes")
+	}
+
+	codes := []string{}
+	for i := 0; i < backupCodeCount; i++ {
+		code, err := crypt.GenerateBackupCode()
+		if err != nil {
+			return nil, errors.Wrap(err, "generating backup code")
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(crypt.NormalizeBackupCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, errors.Wrap(err, "hashing backup code")
+		}
+
+		backupCode := database.BackupCode{
+			UserID:   userID,
+			CodeHash: string(hash),
+		}
+		if err := tx.Save(&backupCode).Error; err != nil {
+			return nil, errors.Wrap(err, "saving backup code")
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// CheckSecondFactor checks the given code against the TOTP secret of the account or, failing
+// that, against the unused backup codes. A TOTP code cannot be reused, and a backup code is
+// marked as used upon a successful check.
+func CheckSecondFactor(tx *gorm.DB, c clock.Clock, account database.Account, code string) (bool, error) {
+	if !account.TOTPEnabled {
+		return false, ErrTOTPNotEnrolled
+	}
+
+	step, ok, err := crypt.ValidateTOTP(account.TOTPSecret, code, c.Now())
+	if err != nil {
+		return false, errors.Wrap(err, "validating code")
+	}
+	if ok {
+		if step <= account.TOTPLastStep {
+			return false, nil
+		}
+
+		if err := tx.Model(&account).Update("totp_last_step", step).Error; err != nil {
+			return false, errors.Wrap(err, "updating totp_last_step")
+		}
+
+		return true, nil
+	}
+
+	var backupCodes []database.BackupCode
+	if err := tx.Where("user_id = ? AND used_at IS NULL", account.UserID).Find(&backupCodes).Error; err != nil {
+		return false, errors.Wrap(err, "finding backup codes")
+	}
+
+	normalized := []byte(crypt.NormalizeBackupCode(code))
+	for _, backupCode := range backupCodes {
+		if bcrypt.CompareHashAndPassword([]byte(backupCode.CodeHash), normalized) != nil {
+			continue
+		}
+
+		if err := tx.Model(&backupCode).Update("used_at", c.Now()).Error; err != nil {
+			return false, errors.Wrap(err, "marking backup code as used")
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,152 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/crypt"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func mustTOTPCode(t *testing.T, secret string, now time.Time) string {
+	code, err := crypt.TOTPCode(secret, crypt.TOTPStep(now))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "computing code"))
+	}
+
+	return code
+}
+
+func TestEnableTOTP(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	mockClock := clock.NewMock()
+	mockClock.SetNow(time.Date(2019, time.April, 2, 10, 0, 0, 0, time.UTC))
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com")
+
+	tx := db.Begin()
+	secret, err := EnrollTOTP(tx, account)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "enrolling"))
+	}
+	tx.Commit()
+
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&account), "finding account")
+	testutils.AssertEqual(t, account.TOTPSecret, secret, "TOTPSecret mismatch")
+	testutils.AssertEqual(t, account.TOTPEnabled, false, "TOTPEnabled should be false before verification")
+
+	// a wrong code
+	tx = db.Begin()
+	if _, err := EnableTOTP(tx, mockClock, account, "000000"); errors.Cause(err) != ErrInvalidTOTPCode {
+		t.Errorf("expected ErrInvalidTOTPCode but got %+v", err)
+	}
+	tx.Rollback()
+
+	// a correct code
+	tx = db.Begin()
+	codes, err := EnableTOTP(tx, mockClock, account, mustTOTPCode(t, secret, mockClock.Now()))
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "enabling"))
+	}
+	tx.Commit()
+
+	var backupCodeCount int
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&account), "finding account")
+	testutils.MustExec(t, db.Model(&database.BackupCode{}).Where("user_id = ?", user.ID).Count(&backupCodeCount), "counting backup codes")
+
+	testutils.AssertEqual(t, account.TOTPEnabled, true, "TOTPEnabled mismatch")
+	testutils.AssertEqual(t, account.TOTPLastStep, crypt.TOTPStep(mockClock.Now()), "TOTPLastStep mismatch")
+	testutils.AssertEqual(t, len(codes), 10, "backup code length mismatch")
+	testutils.AssertEqual(t, backupCodeCount, 10, "backup code count mismatch")
+
+	// enrolling again
+	tx = db.Begin()
+	if _, err := EnrollTOTP(tx, account); errors.Cause(err) != ErrTOTPEnabled {
+		t.Errorf("expected ErrTOTPEnabled but got %+v", err)
+	}
+	tx.Rollback()
+}
+
+func TestCheckSecondFactor(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Date(2019, time.April, 2, 10, 0, 0, 0, time.UTC)
+	mockClock := clock.NewMock()
+	mockClock.SetNow(now)
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com")
+	testutils.MustExec(t, db.Model(&account).Update(map[string]interface{}{
+		"totp_secret":  secret,
+		"totp_enabled": true,
+	}), "preparing account")
+
+	tx := db.Begin()
+	codes, err := generateBackupCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "generating backup codes"))
+	}
+	tx.Commit()
+
+	check := func(code string) bool {
+		var a database.Account
+		testutils.MustExec(t, db.Where("id = ?", account.ID).First(&a), "finding account")
+
+		tx := db.Begin()
+		ok, err := CheckSecondFactor(tx, mockClock, a, code)
+		if err != nil {
+			tx.Rollback()
+			t.Fatal(errors.Wrap(err, "checking second factor"))
+		}
+		tx.Commit()
+
+		return ok
+	}
+
+	code := mustTOTPCode(t, secret, now)
+	testutils.AssertEqual(t, check(code), true, "current code should pass")
+	testutils.AssertEqual(t, check(code), false, "a code should not be reused")
+
+	mockClock.SetNow(now.Add(crypt.TOTPPeriod))
+	testutils.AssertEqual(t, check(mustTOTPCode(t, secret, now.Add(crypt.TOTPPeriod))), true, "code in the next step should pass")
+
+	mockClock.SetNow(now.Add(5 * crypt.TOTPPeriod))
+	testutils.AssertEqual(t, check(mustTOTPCode(t, secret, now.Add(2*crypt.TOTPPeriod))), false, "code out of the window should fail")
+
+	testutils.AssertEqual(t, check("wrong-code"), false, "a wrong backup code should fail")
+	testutils.AssertEqual(t, check(codes[0]), true, "a backup code should pass")
+	testutils.AssertEqual(t, check(codes[0]), false, "a backup code should not be reused")
+
+	var usedCount int
+	testutils.MustExec(t, db.Model(&database.BackupCode{}).Where("user_id = ? AND used_at IS NOT NULL", user.ID).Count(&usedCount), "counting used backup codes")
+	testutils.AssertEqual(t, usedCount, 1, "used backup code count mismatch")
+}
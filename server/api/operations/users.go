@@ -21,21 +21,13 @@ package operations
 import (
 	"time"
 
+	"github.com/dnote/dnote/server/api/clock"
 	"github.com/dnote/dnote/server/api/crypt"
 	"github.com/dnote/dnote/server/database"
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
-func generateResetToken() (string, error) {
-	ret, err := crypt.GetRandomStr(16)
-	if err != nil {
-		return "", errors.Wrap(err, "generating random token")
-	}
-
-	return ret, nil
-}
-
 func generateVerificationCode() (string, error) {
 	ret, err := crypt.GetRandomStr(16)
 	if err != nil {
@@ -147,3 +139,201 @@ func LegacyRegisterUser(tx *gorm.DB, userID int, email, authKey string, cipherKe
 
 	return nil
 }
+
+// SetRecoveryKey stores the cipher key wrapped by a recovery key, along with a hash of the
+// auth key derived from the recovery key. The recovery key itself never leaves the client.
+func SetRecoveryKey(tx *gorm.DB, account database.Account, recoveryAuthKey, cipherKeyRecoveryEnc string) error {
+	salt, err := crypt.GetRandomStr(16)
+	if err != nil {
+		return errors.Wrap(err, "generating salt")
+	}
+
+	if err := tx.Model(&account).
+		Update(map[string]interface{}{
+			"cipher_key_recovery_enc": cipherKeyRecoveryEnc,
+			"recovery_key_hash":       crypt.HashAuthKey(recoveryAuthKey, salt, crypt.ServerKDFIteration),
+			"recovery_key_salt":       salt,
+		}).Error; err != nil {
+		return errors.Wrap(err, "updating account")
+	}
+
+	return nil
+}
+
+// CheckRecoveryKey checks if the given auth key derived from a recovery key matches the one
+// registered for the account
+func CheckRecoveryKey(account database.Account, recoveryAuthKey string) bool {
+	if account.RecoveryKeyHash == "" {
+		return false
+	}
+
+	return account.RecoveryKeyHash == crypt.HashAuthKey(recoveryAuthKey, account.RecoveryKeySalt, crypt.ServerKDFIteration)
+}
+
+// ErrResetTokenUsed is an error indicating that the password reset token has already been
+// used, possibly by a concurrent request
+var ErrResetTokenUsed = errors.New("The password reset token has been used")
+
+// maxResetTokenFailures is the number of the wrong recovery keys or second factors after
+// which a password reset token is used up
+var maxResetTokenFailures = 5
+
+// RecordResetTokenFailure counts a wrong recovery key or second factor given with the
+// password reset token, and uses up the token if there have been too many. It returns
+// whether the token was used up.
+func RecordResetTokenFailure(db *gorm.DB, c clock.Clock, token database.Token) (bool, error) {
+	// The count is incremented in a single statement so that the concurrent attempts
+	// are all counted
+	var count int
+	row := db.Raw(`UPDATE tokens SET
+		failed_attempts = failed_attempts + 1,
+		used_at = CASE WHEN failed_attempts + 1 >= ? THEN COALESCE(used_at, ?) ELSE used_at END
+	WHERE id = ?
+	RETURNING failed_attempts`, maxResetTokenFailures, c.Now(), token.ID).Row()
+	if err := row.Scan(&count); err != nil {
+		return false, errors.Wrap(err, "incrementing failed attempts")
+	}
+
+	return count >= maxResetTokenFailures, nil
+}
+
+// ResetPassword replaces the credentials of the given account and consumes the reset token.
+// If the client recovered the cipher key using the recovery key, the data is left intact.
+// Otherwise the data encrypted with the previous cipher key cannot be decrypted anymore.
+// In that case encrypted notes and books are deleted, so that clients expunge them upon the
+// next sync, and the recovery key, which wraps the previous cipher key, is removed.
+// All existing sessions and outstanding reset tokens are invalidated.
+func ResetPassword(tx *gorm.DB, c clock.Clock, account database.Account, token database.Token, authKey, cipherKeyEnc string, iteration int, recovered bool) error {
+	if account.UserID != token.UserID {
+		return errors.New("Not allowed")
+	}
+
+	// use up the token unless another request has used it first. The row stays locked
+	// until the transaction ends, so that the concurrent requests wait for it.
+	conn := tx.Model(database.Token{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", c.Now())
+	if err := conn.Error; err != nil {
+		return errors.Wrap(err, "using reset token")
+	}
+	if conn.RowsAffected == 0 {
+		return ErrResetTokenUsed
+	}
+
+	salt, err := crypt.GetRandomStr(16)
+	if err != nil {
+		return errors.Wrap(err, "generating salt")
+	}
+
+	if err := tx.Model(&account).
+		Update(map[string]interface{}{
+			"auth_key_hash":        crypt.HashAuthKey(authKey, salt, crypt.ServerKDFIteration),
+			"salt":                 salt,
+			"client_kdf_iteration": iteration,
+			"server_kdf_iteration": crypt.ServerKDFIteration,
+			"cipher_key_enc":       cipherKeyEnc,
+		}).Error; err != nil {
+		return errors.Wrap(err, "updating account")
+	}
+
+	var user database.User
+	if err := tx.Where("id = ?", account.UserID).First(&user).Error; err != nil {
+		return errors.Wrap(err, "finding user")
+	}
+
+	if !recovered {
+		if err := wipeEncryptedData(tx, user); err != nil {
+			return errors.Wrap(err, "wiping encrypted data")
+		}
+
+		if err := tx.Model(&account).
+			Update(map[string]interface{}{
+				"cipher_key_recovery_enc": "",
+				"recovery_key_hash":       "",
+				"recovery_key_salt":       "",
+			}).Error; err != nil {
+			return errors.Wrap(err, "removing recovery key")
+		}
+	}
+
+	if err := tx.Model(database.Token{}).
+		Where("user_id = ? AND type = ? AND used_at IS NULL", user.ID, database.TokenTypeResetPassword).
+		Update("used_at", c.Now()).Error; err != nil {
+		return errors.Wrap(err, "marking reset tokens as used")
+	}
+
+	if err := DeleteUserSessions(tx, user.ID); err != nil {
+		return errors.Wrap(err, "deleting user sessions")
+	}
+
+	return nil
+}
+
+// wipeEncryptedData deletes all encrypted notes and books of the given user
+func wipeEncryptedData(tx *gorm.DB, user database.User) error {
+	var notes []database.Note
+	if err := tx.Where("user_id = ? AND encrypted = ? AND deleted = ?", user.ID, true, false).Find(&notes).Error; err != nil {
+		return errors.Wrap(err, "finding encrypted notes")
+	}
+	for _, note := range notes {
+		if _, err := DeleteNote(tx, user, note); err != nil {
+			return errors.Wrapf(err, "deleting note %s", note.UUID)
+		}
+	}
+
+	var books []database.Book
+	if err := tx.Where("user_id = ? AND encrypted = ? AND deleted = ?", user.ID, true, false).Find(&books).Error; err != nil {
+		return errors.Wrap(err, "finding encrypted books")
+	}
+	for _, book := range books {
+		if _, err := DeleteBook(tx, user, book); err != nil {
+			return errors.Wrapf(err, "deleting book %s", book.UUID)
+		}
+	}
+
+	return nil
+}
+
+// PurgeUser permanently deletes the given user along with all of the data of the user
+func PurgeUser(tx *gorm.DB, user database.User) error {
+	var account database.Account
+	conn := tx.Where("user_id = ?", user.ID).First(&account)
+	if err := conn.Error; err != nil && !conn.RecordNotFound() {
+		return errors.Wrap(err, "finding account")
+	}
+	if account.Email.Valid {
+		if err := tx.Where("email = ?", account.Email.String).Delete(&database.SigninFailure{}).Error; err != nil {
+			return errors.Wrap(err, "deleting signin failures")
+		}
+	}
+
+	if err := tx.Exec("DELETE FROM digest_notes WHERE digest_uuid IN (SELECT uuid FROM digests WHERE user_id = ?)", user.ID).Error; err != nil {
+		return errors.Wrap(err, "deleting digest notes")
+	}
+
+	userData := []struct {
+		name  string
+		model interface{}
+	}{
+		{"digests", &database.Digest{}},
+		{"notes", &database.Note{}},
+		{"books", &database.Book{}},
+		{"tokens", &database.Token{}},
+		{"sessions", &database.Session{}},
+		{"email preferences", &database.EmailPreference{}},
+		{"notifications", &database.Notification{}},
+		{"backup codes", &database.BackupCode{}},
+		{"idempotency keys", &database.IdempotencyKey{}},
+		{"account exports", &database.AccountExport{}},
+		{"account", &database.Account{}},
+	}
+	for _, d := range userData {
+		if err := tx.Where("user_id = ?", user.ID).Delete(d.model).Error; err != nil {
+			return errors.Wrapf(err, "deleting %s", d.name)
+		}
+	}
+
+	if err := tx.Where("id = ?", user.ID).Delete(&database.User{}).Error; err != nil {
+		return errors.Wrap(err, "deleting user")
+	}
+
+	return nil
+}
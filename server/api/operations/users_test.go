@@ -0,0 +1,306 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/crypt"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestResetPassword(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	mockClock := clock.NewMock()
+	mockClock.SetNow(time.Date(2019, time.April, 2, 10, 0, 0, 0, time.UTC))
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 10), "preparing user max_usn")
+	account := testutils.SetupAccountData(user, "alice@example.com")
+	testutils.MustExec(t, db.Model(&account).Update("cipher_key_recovery_enc", "recoveryEnc"), "preparing account cipher_key_recovery_enc")
+	testutils.SetupSession(t, user)
+
+	anotherUser := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&anotherUser).Update("max_usn", 20), "preparing anotherUser max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "b1-label-enc", Encrypted: true, USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: user.ID, Label: "b2-label", Encrypted: false, USN: 2}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	b3 := database.Book{UserID: anotherUser.ID, Label: "b3-label-enc", Encrypted: true, USN: 3}
+	testutils.MustExec(t, db.Save(&b3), "preparing b3")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1-body-enc", Encrypted: true, USN: 4}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: user.ID, BookUUID: b2.UUID, Body: "n2-body", Encrypted: false, USN: 5}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+	n3 := database.Note{UserID: anotherUser.ID, BookUUID: b3.UUID, Body: "n3-body-enc", Encrypted: true, USN: 6}
+	testutils.MustExec(t, db.Save(&n3), "preparing n3")
+
+	t1 := database.Token{UserID: user.ID, Type: database.TokenTypeResetPassword, Value: "t1-value"}
+	testutils.MustExec(t, db.Save(&t1), "preparing t1")
+	t2 := database.Token{UserID: user.ID, Type: database.TokenTypeResetPassword, Value: "t2-value"}
+	testutils.MustExec(t, db.Save(&t2), "preparing t2")
+
+	// execute
+	tx := db.Begin()
+	if err := ResetPassword(tx, mockClock, account, t1, "newAuthKey", "newCipherKeyEnc", 120000, false); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "resetting password"))
+	}
+	tx.Commit()
+
+	// test
+	var accountRecord database.Account
+	var b1Record, b2Record, b3Record database.Book
+	var n1Record, n2Record, n3Record database.Note
+	var t1Record, t2Record database.Token
+	var userRecord database.User
+	var sessionCount int
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+	testutils.MustExec(t, db.Where("id = ?", b1.ID).First(&b1Record), "finding b1")
+	testutils.MustExec(t, db.Where("id = ?", b2.ID).First(&b2Record), "finding b2")
+	testutils.MustExec(t, db.Where("id = ?", b3.ID).First(&b3Record), "finding b3")
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&n1Record), "finding n1")
+	testutils.MustExec(t, db.Where("id = ?", n2.ID).First(&n2Record), "finding n2")
+	testutils.MustExec(t, db.Where("id = ?", n3.ID).First(&n3Record), "finding n3")
+	testutils.MustExec(t, db.Where("id = ?", t1.ID).First(&t1Record), "finding t1")
+	testutils.MustExec(t, db.Where("id = ?", t2.ID).First(&t2Record), "finding t2")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", user.ID).Count(&sessionCount), "counting sessions")
+
+	testutils.AssertEqual(t, accountRecord.AuthKeyHash, crypt.HashAuthKey("newAuthKey", accountRecord.Salt, crypt.ServerKDFIteration), "AuthKeyHash mismatch")
+	testutils.AssertEqual(t, accountRecord.CipherKeyEnc, "newCipherKeyEnc", "CipherKeyEnc mismatch")
+	testutils.AssertEqual(t, accountRecord.ClientKDFIteration, 120000, "ClientKDFIteration mismatch")
+	testutils.AssertNotEqual(t, accountRecord.Salt, account.Salt, "Salt should have been regenerated")
+	testutils.AssertEqual(t, accountRecord.CipherKeyRecoveryEnc, "", "CipherKeyRecoveryEnc mismatch")
+
+	testutils.AssertEqual(t, n1Record.Deleted, true, "n1 Deleted mismatch")
+	testutils.AssertEqual(t, n1Record.Body, "", "n1 Body mismatch")
+	testutils.AssertEqual(t, n1Record.USN, 11, "n1 USN mismatch")
+	testutils.AssertEqual(t, b1Record.Deleted, true, "b1 Deleted mismatch")
+	testutils.AssertEqual(t, b1Record.Label, "", "b1 Label mismatch")
+	testutils.AssertEqual(t, b1Record.USN, 12, "b1 USN mismatch")
+	testutils.AssertEqual(t, userRecord.MaxUSN, 12, "user max_usn mismatch")
+
+	testutils.AssertEqual(t, n2Record.Deleted, false, "n2 Deleted mismatch")
+	testutils.AssertEqual(t, n2Record.Body, "n2-body", "n2 Body mismatch")
+	testutils.AssertEqual(t, b2Record.Deleted, false, "b2 Deleted mismatch")
+	testutils.AssertEqual(t, n3Record.Deleted, false, "n3 Deleted mismatch")
+	testutils.AssertEqual(t, b3Record.Deleted, false, "b3 Deleted mismatch")
+
+	testutils.AssertNotEqual(t, t1Record.UsedAt, (*time.Time)(nil), "t1 UsedAt mismatch")
+	testutils.AssertNotEqual(t, t2Record.UsedAt, (*time.Time)(nil), "t2 UsedAt mismatch")
+	testutils.AssertEqual(t, sessionCount, 0, "session count mismatch")
+}
+
+func TestResetPassword_recovered(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	mockClock := clock.NewMock()
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 10), "preparing user max_usn")
+	account := testutils.SetupAccountData(user, "alice@example.com")
+	testutils.MustExec(t, db.Model(&account).Update("cipher_key_recovery_enc", "recoveryEnc"), "preparing account cipher_key_recovery_enc")
+
+	b1 := database.Book{UserID: user.ID, Label: "b1-label-enc", Encrypted: true, USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1-body-enc", Encrypted: true, USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	t1 := database.Token{UserID: user.ID, Type: database.TokenTypeResetPassword, Value: "t1-value"}
+	testutils.MustExec(t, db.Save(&t1), "preparing t1")
+
+	// execute
+	tx := db.Begin()
+	if err := ResetPassword(tx, mockClock, account, t1, "newAuthKey", "newCipherKeyEnc", 120000, true); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "resetting password"))
+	}
+	tx.Commit()
+
+	// test
+	var accountRecord database.Account
+	var b1Record database.Book
+	var n1Record database.Note
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+	testutils.MustExec(t, db.Where("id = ?", b1.ID).First(&b1Record), "finding b1")
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&n1Record), "finding n1")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	testutils.AssertEqual(t, accountRecord.CipherKeyEnc, "newCipherKeyEnc", "CipherKeyEnc mismatch")
+	testutils.AssertEqual(t, accountRecord.CipherKeyRecoveryEnc, "recoveryEnc", "CipherKeyRecoveryEnc mismatch")
+	testutils.AssertEqual(t, n1Record.Deleted, false, "n1 Deleted mismatch")
+	testutils.AssertEqual(t, n1Record.Body, "n1-body-enc", "n1 Body mismatch")
+	testutils.AssertEqual(t, b1Record.Deleted, false, "b1 Deleted mismatch")
+	testutils.AssertEqual(t, userRecord.MaxUSN, 10, "user max_usn mismatch")
+}
+
+func TestCheckRecoveryKey(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com")
+
+	testutils.AssertEqual(t, CheckRecoveryKey(account, "recoveryAuthKey"), false, "should fail without a recovery key")
+
+	tx := db.Begin()
+	if err := SetRecoveryKey(tx, account, "recoveryAuthKey", "recoveryEnc"); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "setting recovery key"))
+	}
+	tx.Commit()
+
+	var accountRecord database.Account
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+
+	testutils.AssertEqual(t, accountRecord.CipherKeyRecoveryEnc, "recoveryEnc", "CipherKeyRecoveryEnc mismatch")
+	testutils.AssertNotEqual(t, accountRecord.RecoveryKeyHash, "recoveryAuthKey", "recovery auth key should be hashed")
+	testutils.AssertEqual(t, CheckRecoveryKey(accountRecord, "recoveryAuthKey"), true, "should pass with the correct key")
+	testutils.AssertEqual(t, CheckRecoveryKey(accountRecord, "wrongKey"), false, "should fail with a wrong key")
+}
+
+func TestPurgeUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+	testutils.SetupSession(t, user)
+	testutils.SetupEmailPreferenceData(user, true)
+
+	anotherUser := testutils.SetupUserData()
+	testutils.SetupAccountData(anotherUser, "bob@example.com")
+
+	b1 := database.Book{UserID: user.ID, Label: "b1-label"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1-body"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	b2 := database.Book{UserID: anotherUser.ID, Label: "b2-label"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	n2 := database.Note{UserID: anotherUser.ID, BookUUID: b2.UUID, Body: "n2-body"}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+
+	d1 := database.Digest{UserID: user.ID, Notes: []database.Note{n1}}
+	testutils.MustExec(t, db.Save(&d1), "preparing d1")
+	tok1 := database.Token{UserID: user.ID, Type: database.TokenTypeEmailVerification, Value: "tok1"}
+	testutils.MustExec(t, db.Save(&tok1), "preparing tok1")
+	sf := database.SigninFailure{Email: "alice@example.com", Count: 1}
+	testutils.MustExec(t, db.Save(&sf), "preparing signin failure")
+
+	// Execute
+	tx := db.Begin()
+	if err := PurgeUser(tx, user); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "purging user"))
+	}
+	tx.Commit()
+
+	// Test
+	var userCount, accountCount, bookCount, noteCount, digestCount, digestNoteCount, tokenCount, sessionCount, emailPreferenceCount, signinFailureCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.MustExec(t, db.Model(&database.Account{}).Count(&accountCount), "counting accounts")
+	testutils.MustExec(t, db.Model(&database.Book{}).Count(&bookCount), "counting books")
+	testutils.MustExec(t, db.Model(&database.Note{}).Count(&noteCount), "counting notes")
+	testutils.MustExec(t, db.Model(&database.Digest{}).Count(&digestCount), "counting digests")
+	testutils.MustExec(t, db.Table("digest_notes").Where("digest_uuid = ?", d1.UUID).Count(&digestNoteCount), "counting digest notes")
+	testutils.MustExec(t, db.Model(&database.Token{}).Count(&tokenCount), "counting tokens")
+	testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+	testutils.MustExec(t, db.Model(&database.EmailPreference{}).Count(&emailPreferenceCount), "counting email preferences")
+	testutils.MustExec(t, db.Model(&database.SigninFailure{}).Count(&signinFailureCount), "counting signin failures")
+
+	testutils.AssertEqual(t, userCount, 1, "user count mismatch")
+	testutils.AssertEqual(t, accountCount, 1, "account count mismatch")
+	testutils.AssertEqual(t, bookCount, 1, "book count mismatch")
+	testutils.AssertEqual(t, noteCount, 1, "note count mismatch")
+	testutils.AssertEqual(t, digestCount, 0, "digest count mismatch")
+	testutils.AssertEqual(t, digestNoteCount, 0, "digest note count mismatch")
+	testutils.AssertEqual(t, tokenCount, 0, "token count mismatch")
+	testutils.AssertEqual(t, sessionCount, 0, "session count mismatch")
+	testutils.AssertEqual(t, emailPreferenceCount, 0, "email preference count mismatch")
+	testutils.AssertEqual(t, signinFailureCount, 0, "signin failure count mismatch")
+
+	var noteRecord database.Note
+	testutils.MustExec(t, db.First(&noteRecord), "finding the remaining note")
+	testutils.AssertEqual(t, noteRecord.UUID, n2.UUID, "the note of another user should remain")
+}
+
+func TestResetPassword_usedToken(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	mockClock := clock.NewMock()
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com")
+
+	t1 := database.Token{UserID: user.ID, Type: database.TokenTypeResetPassword, Value: "t1-value"}
+	testutils.MustExec(t, db.Save(&t1), "preparing t1")
+
+	// another request uses the token after it has been looked up
+	testutils.MustExec(t, db.Model(&t1).Update("used_at", time.Now()), "using t1")
+
+	// execute
+	tx := db.Begin()
+	err := ResetPassword(tx, mockClock, account, t1, "newAuthKey", "newCipherKeyEnc", 120000, false)
+	tx.Rollback()
+
+	// test
+	testutils.AssertEqual(t, err, ErrResetTokenUsed, "error mismatch")
+
+	var accountRecord database.Account
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+	testutils.AssertEqual(t, accountRecord.AuthKeyHash, account.AuthKeyHash, "AuthKeyHash should not change")
+}
+
+func TestRecordResetTokenFailure(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	mockClock := clock.NewMock()
+
+	user := testutils.SetupUserData()
+	t1 := database.Token{UserID: user.ID, Type: database.TokenTypeResetPassword, Value: "t1-value"}
+	testutils.MustExec(t, db.Save(&t1), "preparing t1")
+
+	for i := 1; i <= maxResetTokenFailures; i++ {
+		usedUp, err := RecordResetTokenFailure(db, mockClock, t1)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "recording failure"))
+		}
+
+		testutils.AssertEqual(t, usedUp, i == maxResetTokenFailures, fmt.Sprintf("used up mismatch at %d", i))
+
+		var t1Record database.Token
+		testutils.MustExec(t, db.Where("id = ?", t1.ID).First(&t1Record), "finding t1")
+		testutils.AssertEqual(t, t1Record.FailedAttempts, i, "failed attempts mismatch")
+		testutils.AssertEqual(t, t1Record.UsedAt != nil, i == maxResetTokenFailures, fmt.Sprintf("used_at mismatch at %d", i))
+	}
+}
@@ -154,3 +154,38 @@ func PresentDigest(digest database.Digest) Digest {
 
 	return ret
 }
+
+// Session is a result of PresentSessions. It does not contain the session key.
+type Session struct {
+	ID         int       `json:"id"`
+	ClientType string    `json:"client_type"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// PresentSessions presents sessions. currentKey is the key of the session used for the
+// request, which is marked as current.
+func PresentSessions(sessions []database.Session, currentKey string) []Session {
+	ret := []Session{}
+
+	for _, session := range sessions {
+		p := Session{
+			ID:         session.ID,
+			ClientType: session.ClientType,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  formatTs(session.CreatedAt),
+			LastUsedAt: formatTs(session.LastUsedAt),
+			ExpiresAt:  formatTs(session.ExpiresAt),
+			Current:    session.Key == currentKey,
+		}
+
+		ret = append(ret, p)
+	}
+
+	return ret
+}
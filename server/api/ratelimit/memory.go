@@ -0,0 +1,93 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+)
+
+type counter struct {
+	windowStart time.Time
+	expiresAt   time.Time
+	count       int
+}
+
+// MemoryStore is a store that keeps the counts in the memory of the process. It cannot
+// be shared by several API instances.
+type MemoryStore struct {
+	clock    clock.Clock
+	mtx      sync.Mutex
+	counters map[string]*counter
+}
+
+// NewMemoryStore returns a new memory store, and starts removing the expired counts
+// from it in the background
+func NewMemoryStore(c clock.Clock) *MemoryStore {
+	s := &MemoryStore{
+		clock:    c,
+		counters: map[string]*counter{},
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			s.deleteExpired()
+		}
+	}()
+
+	return s
+}
+
+// Take counts a request for the key against the limit
+func (s *MemoryStore) Take(key string, limit Limit) (Result, error) {
+	now := s.clock.Now()
+	windowStart := now.Truncate(limit.Period)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || !c.windowStart.Equal(windowStart) {
+		c = &counter{
+			windowStart: windowStart,
+			expiresAt:   windowStart.Add(limit.Period),
+		}
+		s.counters[key] = c
+	}
+	c.count++
+
+	return newResult(limit, c.count, windowStart, now), nil
+}
+
+// deleteExpired deletes the counts of the windows that have ended
+func (s *MemoryStore) deleteExpired() {
+	now := s.clock.Now()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for key, c := range s.counters {
+		if !now.Before(c.expiresAt) {
+			delete(s.counters, key)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.March, 1, 10, 0, 30, 0, time.UTC))
+	s := NewMemoryStore(c)
+	limit := Limit{Requests: 2, Period: time.Minute}
+
+	testCases := []struct {
+		key               string
+		expectedAllowed   bool
+		expectedRemaining int
+	}{
+		{
+			key:               "foo",
+			expectedAllowed:   true,
+			expectedRemaining: 1,
+		},
+		{
+			key:               "foo",
+			expectedAllowed:   true,
+			expectedRemaining: 0,
+		},
+		{
+			key:               "foo",
+			expectedAllowed:   false,
+			expectedRemaining: 0,
+		},
+		{
+			key:               "bar",
+			expectedAllowed:   true,
+			expectedRemaining: 1,
+		},
+	}
+
+	for idx, tc := range testCases {
+		res, err := s.Take(tc.key, limit)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "taking"))
+		}
+
+		testutils.AssertEqual(t, res.Allowed, tc.expectedAllowed, fmt.Sprintf("allowed mismatch for case %d", idx))
+		testutils.AssertEqual(t, res.Remaining, tc.expectedRemaining, fmt.Sprintf("remaining mismatch for case %d", idx))
+		testutils.AssertEqual(t, res.Limit, 2, fmt.Sprintf("limit mismatch for case %d", idx))
+		testutils.AssertEqual(t, res.Reset, 30*time.Second, fmt.Sprintf("reset mismatch for case %d", idx))
+	}
+
+	// the count resets in the next window
+	c.SetNow(time.Date(2019, time.March, 1, 10, 1, 0, 0, time.UTC))
+
+	res, err := s.Take("foo", limit)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "taking"))
+	}
+
+	testutils.AssertEqual(t, res.Allowed, true, "allowed mismatch in the next window")
+	testutils.AssertEqual(t, res.Remaining, 1, "remaining mismatch in the next window")
+	testutils.AssertEqual(t, res.Reset, time.Minute, "reset mismatch in the next window")
+}
+
+func TestMemoryStoreDeleteExpired(t *testing.T) {
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.March, 1, 10, 0, 30, 0, time.UTC))
+	s := NewMemoryStore(c)
+
+	if _, err := s.Take("foo", Limit{Requests: 2, Period: time.Minute}); err != nil {
+		t.Fatal(errors.Wrap(err, "taking foo"))
+	}
+	if _, err := s.Take("bar", Limit{Requests: 2, Period: time.Hour}); err != nil {
+		t.Fatal(errors.Wrap(err, "taking bar"))
+	}
+
+	c.SetNow(time.Date(2019, time.March, 1, 10, 1, 0, 0, time.UTC))
+	s.deleteExpired()
+
+	_, fooExists := s.counters["foo"]
+	_, barExists := s.counters["bar"]
+	testutils.AssertEqual(t, fooExists, false, "foo should have been deleted")
+	testutils.AssertEqual(t, barExists, true, "bar should not have been deleted")
+}
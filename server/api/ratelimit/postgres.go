@@ -0,0 +1,88 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/database"
+	"github.com/pkg/errors"
+)
+
+// PostgresStore is a store that keeps the counts in the database, so that several API
+// instances can share them
+type PostgresStore struct {
+	clock clock.Clock
+}
+
+// NewPostgresStore returns a new Postgres store, and starts removing the expired
+// counts from the database in the background
+func NewPostgresStore(c clock.Clock) *PostgresStore {
+	s := &PostgresStore{clock: c}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			if err := s.deleteExpired(); err != nil {
+				logger.WithFields(logger.Fields{"error": err}).Err("deleting expired rate limit counters")
+			}
+		}
+	}()
+
+	return s
+}
+
+// Take counts a request for the key against the limit. The count is incremented in a
+// single statement, so that the concurrent requests from several instances are all counted.
+func (s *PostgresStore) Take(key string, limit Limit) (Result, error) {
+	db := database.DBConn
+
+	now := s.clock.Now()
+	windowStart := now.Truncate(limit.Period)
+	expiresAt := windowStart.Add(limit.Period)
+
+	var count int
+	row := db.Raw(`INSERT INTO rate_limit_counters (key, window_start, expires_at, count)
+	VALUES (?, ?, ?, 1)
+	ON CONFLICT (key) DO UPDATE SET
+		count = CASE WHEN rate_limit_counters.window_start = EXCLUDED.window_start
+			THEN rate_limit_counters.count + 1 ELSE 1 END,
+		window_start = EXCLUDED.window_start,
+		expires_at = EXCLUDED.expires_at
+	RETURNING count`, key, windowStart, expiresAt).Row()
+	if err := row.Scan(&count); err != nil {
+		return Result{}, errors.Wrap(err, "incrementing the counter")
+	}
+
+	return newResult(limit, count, windowStart, now), nil
+}
+
+// deleteExpired deletes the counts of the windows that have ended
+func (s *PostgresStore) deleteExpired() error {
+	db := database.DBConn
+
+	if err := db.Where("expires_at <= ?", s.clock.Now()).Delete(database.RateLimitCounter{}).Error; err != nil {
+		return errors.Wrap(err, "deleting counters")
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/api/clock"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+func TestPostgresStoreTake(t *testing.T) {
+	defer testutils.ClearData()
+
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.March, 1, 10, 0, 30, 0, time.UTC))
+	s := NewPostgresStore(c)
+	limit := Limit{Requests: 2, Period: time.Minute}
+
+	expected := []bool{true, true, false}
+	for idx, e := range expected {
+		res, err := s.Take("foo", limit)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "taking"))
+		}
+
+		testutils.AssertEqual(t, res.Allowed, e, fmt.Sprintf("allowed mismatch for request %d", idx))
+		testutils.AssertEqual(t, res.Reset, 30*time.Second, fmt.Sprintf("reset mismatch for request %d", idx))
+	}
+
+	// the count resets in the next window
+	c.SetNow(time.Date(2019, time.March, 1, 10, 1, 0, 0, time.UTC))
+
+	res, err := s.Take("foo", limit)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "taking"))
+	}
+	testutils.AssertEqual(t, res.Allowed, true, "allowed mismatch in the next window")
+	testutils.AssertEqual(t, res.Remaining, 1, "remaining mismatch in the next window")
+
+	// expired counters are deleted
+	c.SetNow(time.Date(2019, time.March, 1, 10, 2, 0, 0, time.UTC))
+	if err := s.deleteExpired(); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting expired"))
+	}
+
+	var count int
+	testutils.MustExec(t, database.DBConn.Model(&database.RateLimitCounter{}).Count(&count), "counting counters")
+	testutils.AssertEqual(t, count, 0, "counter count mismatch")
+}
@@ -0,0 +1,203 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package ratelimit provides the rate limits of the routes and the stores that keep
+// the counts of the requests. The counts are kept in fixed windows, so that several
+// API instances sharing a store agree on when a window resets.
+package ratelimit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Limit is a limit on the number of requests in a period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+type limitJSON struct {
+	Requests int    `json:"requests"`
+	Period   string `json:"period"`
+}
+
+// MarshalJSON marshals the limit with a period such as "1m"
+func (l Limit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(limitJSON{Requests: l.Requests, Period: l.Period.String()})
+}
+
+// UnmarshalJSON unmarshals the limit with a period such as "1m"
+func (l *Limit) UnmarshalJSON(b []byte) error {
+	var v limitJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	period, err := time.ParseDuration(v.Period)
+	if err != nil {
+		return errors.Wrapf(err, "parsing the period '%s'", v.Period)
+	}
+	if v.Requests <= 0 || period <= 0 {
+		return errors.Errorf("invalid limit of %d requests in %s", v.Requests, v.Period)
+	}
+
+	l.Requests = v.Requests
+	l.Period = period
+
+	return nil
+}
+
+// Result is the result of counting a request against a limit
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time remaining until the window resets
+	Reset time.Duration
+}
+
+// Store keeps the counts of the requests
+type Store interface {
+	// Take counts a request for the key against the limit
+	Take(key string, limit Limit) (Result, error)
+}
+
+// newResult returns the result of having made count requests in the window that
+// started at windowStart
+func newResult(limit Limit, count int, windowStart, now time.Time) Result {
+	remaining := limit.Requests - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return Result{
+		Allowed:   count <= limit.Requests,
+		Limit:     limit.Requests,
+		Remaining: remaining,
+		Reset:     windowStart.Add(limit.Period).Sub(now),
+	}
+}
+
+// Rule is the limits of a route. IP limits the requests from a client IP, and User limits
+// the requests from an authenticated user.
+type Rule struct {
+	IP   *Limit `json:"ip,omitempty"`
+	User *Limit `json:"user,omitempty"`
+}
+
+// Config is the configuration of the rate limits
+type Config struct {
+	// Default is the rule for the routes without their own rule. The requests to all such
+	// routes are counted together.
+	Default Rule `json:"default"`
+	// Routes is a map from a route such as "POST /v1/signin" to its rule
+	Routes map[string]Rule `json:"routes"`
+}
+
+// Bucket is a limit and the name under which the requests are counted against it
+type Bucket struct {
+	Name  string
+	Limit Limit
+}
+
+// Buckets returns the buckets for the requests to the route from an IP and from a user.
+// A route without its own limit falls back to the default limit, which is shared by
+// all such routes. A nil bucket means that the requests are not limited.
+func (c Config) Buckets(method, pattern string) (ip *Bucket, user *Bucket) {
+	route := method + " " + pattern
+	rule := c.Routes[route]
+
+	pick := func(routeLimit, defaultLimit *Limit) *Bucket {
+		if routeLimit != nil {
+			return &Bucket{Name: route, Limit: *routeLimit}
+		}
+		if defaultLimit != nil {
+			return &Bucket{Name: "default", Limit: *defaultLimit}
+		}
+
+		return nil
+	}
+
+	return pick(rule.IP, c.Default.IP), pick(rule.User, c.Default.User)
+}
+
+func perMinute(n int) *Limit {
+	return &Limit{Requests: n, Period: time.Minute}
+}
+
+func perHour(n int) *Limit {
+	return &Limit{Requests: n, Period: time.Hour}
+}
+
+// DefaultConfig returns the default configuration. The routes for signing in and
+// registering are much stricter than the sync routes which the clients call repeatedly.
+func DefaultConfig() Config {
+	syncRule := Rule{IP: perMinute(300), User: perMinute(300)}
+
+	return Config{
+		Default: Rule{IP: perMinute(60), User: perMinute(120)},
+		Routes: map[string]Rule{
+			"POST /v1/signin":                  {IP: perMinute(5)},
+			"POST /v1/signin/totp":             {IP: perMinute(5)},
+			"POST /legacy/signin":              {IP: perMinute(5)},
+			"GET /v1/presignin":                {IP: perMinute(20)},
+			"POST /v1/register":                {IP: perHour(5)},
+			"POST /legacy/register":            {IP: perHour(5)},
+			"POST /v1/password-reset":          {IP: perHour(5)},
+			"POST /v1/account/export":          {User: perHour(5)},
+			"GET /v1/account/export":           {IP: perMinute(10)},
+			"POST /v1/account/export/download": {IP: perMinute(10)},
+			"DELETE /v1/account":               {User: perHour(5)},
+			"GET /v1/sync/fragment":            syncRule,
+			"GET /v1/sync/state":               syncRule,
+			"GET /v2/sync/stream":              syncRule,
+		},
+	}
+}
+
+// ReadConfig reads the configuration file at the given path over the default
+// configuration. The rules in the file replace the default rules of the same routes.
+func ReadConfig(path string) (Config, error) {
+	ret := DefaultConfig()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ret, errors.Wrap(err, "reading the file")
+	}
+
+	var f Config
+	if err := json.Unmarshal(b, &f); err != nil {
+		return ret, errors.Wrap(err, "unmarshalling the file")
+	}
+
+	if f.Default.IP != nil {
+		ret.Default.IP = f.Default.IP
+	}
+	if f.Default.User != nil {
+		ret.Default.User = f.Default.User
+	}
+	for route, rule := range f.Routes {
+		ret.Routes[route] = rule
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,111 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestConfigBuckets(t *testing.T) {
+	c := Config{
+		Default: Rule{IP: &Limit{Requests: 60, Period: time.Minute}},
+		Routes: map[string]Rule{
+			"POST /v1/signin": {IP: &Limit{Requests: 5, Period: time.Minute}},
+			"GET /v1/sync/fragment": {
+				User: &Limit{Requests: 300, Period: time.Minute},
+			},
+		},
+	}
+
+	t.Run("route rule", func(t *testing.T) {
+		ip, user := c.Buckets("POST", "/v1/signin")
+
+		testutils.AssertDeepEqual(t, ip, &Bucket{Name: "POST /v1/signin", Limit: Limit{Requests: 5, Period: time.Minute}}, "ip bucket mismatch")
+		testutils.AssertEqual(t, user == nil, true, "user bucket should be nil")
+	})
+
+	t.Run("route rule falling back to the default", func(t *testing.T) {
+		ip, user := c.Buckets("GET", "/v1/sync/fragment")
+
+		testutils.AssertDeepEqual(t, ip, &Bucket{Name: "default", Limit: Limit{Requests: 60, Period: time.Minute}}, "ip bucket mismatch")
+		testutils.AssertDeepEqual(t, user, &Bucket{Name: "GET /v1/sync/fragment", Limit: Limit{Requests: 300, Period: time.Minute}}, "user bucket mismatch")
+	})
+
+	t.Run("no route rule", func(t *testing.T) {
+		ip, user := c.Buckets("GET", "/me")
+
+		testutils.AssertDeepEqual(t, ip, &Bucket{Name: "default", Limit: Limit{Requests: 60, Period: time.Minute}}, "ip bucket mismatch")
+		testutils.AssertEqual(t, user == nil, true, "user bucket should be nil")
+	})
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making temp dir"))
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ratelimit.json")
+	content := `{
+  "default": {"user": {"requests": 30, "period": "10s"}},
+  "routes": {
+    "POST /v1/signin": {"ip": {"requests": 2, "period": "1h"}}
+  }
+}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the file"))
+	}
+
+	c, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading"))
+	}
+
+	defaultConfig := DefaultConfig()
+	testutils.AssertDeepEqual(t, c.Default.IP, defaultConfig.Default.IP, "default ip limit mismatch")
+	testutils.AssertDeepEqual(t, c.Default.User, &Limit{Requests: 30, Period: 10 * time.Second}, "default user limit mismatch")
+	testutils.AssertDeepEqual(t, c.Routes["POST /v1/signin"], Rule{IP: &Limit{Requests: 2, Period: time.Hour}}, "signin rule mismatch")
+	testutils.AssertDeepEqual(t, c.Routes["POST /v1/register"], defaultConfig.Routes["POST /v1/register"], "register rule mismatch")
+}
+
+func TestReadConfig_InvalidLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making temp dir"))
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ratelimit.json")
+	content := `{"default": {"ip": {"requests": 0, "period": "1m"}}}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the file"))
+	}
+
+	if _, err := ReadConfig(path); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -58,14 +58,41 @@ const (
 	TokenTypeEmailVerification = "email_verification"
 	// TokenTypeEmailPreference is a type of a token for updating email preference
 	TokenTypeEmailPreference = "email_preference"
+	// TokenTypeResetPassword is a type of a token for resetting the password
+	TokenTypeResetPassword = "reset_password"
+	// TokenTypeTOTPChallenge is a type of a token for completing a signin with two-factor authentication
+	TokenTypeTOTPChallenge = "totp_challenge"
+	// TokenTypeAccountExport is a type of a token for downloading an account export once
+	TokenTypeAccountExport = "account_export"
+)
+
+const (
+	// AccountExportStatusPending is a status of an account export waiting to be built
+	AccountExportStatusPending = "pending"
+	// AccountExportStatusProcessing is a status of an account export being built
+	AccountExportStatusProcessing = "processing"
+	// AccountExportStatusCompleted is a status of an account export that can be downloaded
+	AccountExportStatusCompleted = "completed"
+	// AccountExportStatusFailed is a status of an account export that could not be built
+	AccountExportStatusFailed = "failed"
+)
+
+const (
+	// StripeEventStatusProcessing is a status of a Stripe event being processed
+	StripeEventStatusProcessing = "processing"
+	// StripeEventStatusProcessed is a status of a Stripe event that has been processed
+	StripeEventStatusProcessed = "processed"
+	// StripeEventStatusIgnored is a status of a Stripe event that requires no processing
+	StripeEventStatusIgnored = "ignored"
+	// StripeEventStatusFailed is a status of a Stripe event whose processing failed. It is
+	// processed again when Stripe retries it.
+	StripeEventStatusFailed = "failed"
 )
 
 // InitDB opens the connection with the database
 func InitDB() {
 	var err error
 
-	fmt.Println(getPGConnectionString())
-
 	DBConn, err = gorm.Open("postgres", getPGConnectionString())
 	if err != nil {
 		panic(err)
@@ -93,6 +120,12 @@ func InitSchema() {
 		EmailPreference{},
 		Session{},
 		Digest{},
+		BackupCode{},
+		IdempotencyKey{},
+		StripeEvent{},
+		RateLimitCounter{},
+		SigninFailure{},
+		AccountExport{},
 	).Error; err != nil {
 		panic(err)
 	}
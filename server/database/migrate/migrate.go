@@ -0,0 +1,183 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package migrate runs the versioned migrations of the server database. The migrations
+// make the changes that AutoMigrate cannot, such as dropping columns and backfilling data.
+package migrate
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// migration is a change to the schema with the SQL statements to apply and revert it
+type migration struct {
+	name string
+	// check returns an error if the migration cannot be applied without losing data
+	check func(tx *gorm.DB) error
+	up    []string
+	down  []string
+}
+
+// Sequence is a list of the migrations in the order they are applied. The version of a
+// migration is its position in the sequence, starting from 1. A released migration must
+// not be changed; add a new one instead.
+var Sequence = []migration{}
+
+func createTable(db *gorm.DB) error {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp with time zone NOT NULL DEFAULT now()
+	)`).Error
+	if err != nil {
+		return errors.Wrap(err, "creating the migrations table")
+	}
+
+	return nil
+}
+
+// getVersion returns the version of the latest applied migration, or 0 if none has
+// been applied
+func getVersion(db *gorm.DB) (int, error) {
+	var ret int
+	if err := db.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Row().Scan(&ret); err != nil {
+		return 0, errors.Wrap(err, "getting the schema version")
+	}
+
+	return ret, nil
+}
+
+// execute runs the statements of a migration in a transaction and records the change in
+// the migrations table. If dryRun is true, the statements are only written to w.
+func execute(db *gorm.DB, w io.Writer, version int, m migration, statements []string, record string, dryRun bool) error {
+	fmt.Fprintf(w, "-- %d %s\n", version, m.name)
+
+	if dryRun {
+		for _, s := range statements {
+			fmt.Fprintf(w, "%s;\n", s)
+		}
+
+		return nil
+	}
+
+	tx := db.Begin()
+
+	for _, s := range statements {
+		if err := tx.Exec(s).Error; err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "executing '%s'", s)
+		}
+	}
+	if err := tx.Exec(record, version, m.name).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "recording the migration")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "committing")
+	}
+
+	return nil
+}
+
+// Up applies the pending migrations in order. If dryRun is true, the SQL of the pending
+// migrations is written to w without being run.
+func Up(db *gorm.DB, migrations []migration, w io.Writer, dryRun bool) error {
+	if err := createTable(db); err != nil {
+		return err
+	}
+
+	version, err := getVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return errors.Errorf("the schema version %d is newer than the latest migration %d", version, len(migrations))
+	}
+
+	for idx := version; idx < len(migrations); idx++ {
+		m := migrations[idx]
+
+		if m.check != nil {
+			if err := m.check(db); err != nil {
+				return errors.Wrapf(err, "checking '%s'", m.name)
+			}
+		}
+
+		record := "INSERT INTO schema_migrations (version, name) VALUES (?, ?)"
+		if err := execute(db, w, idx+1, m, m.up, record, dryRun); err != nil {
+			return errors.Wrapf(err, "applying '%s'", m.name)
+		}
+	}
+
+	return nil
+}
+
+// Down reverts the latest applied migration. If dryRun is true, the SQL is written to w
+// without being run.
+func Down(db *gorm.DB, migrations []migration, w io.Writer, dryRun bool) error {
+	if err := createTable(db); err != nil {
+		return err
+	}
+
+	version, err := getVersion(db)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return errors.New("no migration has been applied")
+	}
+	if version > len(migrations) {
+		return errors.Errorf("the schema version %d is newer than the latest migration %d", version, len(migrations))
+	}
+
+	m := migrations[version-1]
+	record := "DELETE FROM schema_migrations WHERE version = ? AND name = ?"
+	if err := execute(db, w, version, m, m.down, record, dryRun); err != nil {
+		return errors.Wrapf(err, "reverting '%s'", m.name)
+	}
+
+	return nil
+}
+
+// Status writes the migrations to w along with whether they have been applied
+func Status(db *gorm.DB, migrations []migration, w io.Writer) error {
+	if err := createTable(db); err != nil {
+		return err
+	}
+
+	version, err := getVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for idx, m := range migrations {
+		state := "pending"
+		if idx < version {
+			state = "applied"
+		}
+
+		fmt.Fprintf(w, "%d %s %s\n", idx+1, m.name, state)
+	}
+
+	return nil
+}
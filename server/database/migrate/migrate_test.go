@@ -0,0 +1,213 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+var testMigrations = []migration{
+	{
+		name: "create-foo",
+		up:   []string{"CREATE TABLE migrate_test_foo (id integer)"},
+		down: []string{"DROP TABLE migrate_test_foo"},
+	},
+	{
+		name: "add-foo-name",
+		up:   []string{"ALTER TABLE migrate_test_foo ADD COLUMN name text"},
+		down: []string{"ALTER TABLE migrate_test_foo DROP COLUMN name"},
+	},
+}
+
+func clearMigrations(t *testing.T) {
+	db := database.DBConn
+
+	for _, s := range []string{
+		"DROP TABLE IF EXISTS schema_migrations",
+		"DROP TABLE IF EXISTS migrate_test_foo",
+	} {
+		if err := db.Exec(s).Error; err != nil {
+			t.Fatal(errors.Wrapf(err, "executing '%s'", s))
+		}
+	}
+}
+
+func mustGetVersion(t *testing.T, db *gorm.DB) int {
+	v, err := getVersion(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting version"))
+	}
+
+	return v
+}
+
+func TestUp(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+
+	db := database.DBConn
+
+	var buf bytes.Buffer
+	if err := Up(db, testMigrations, &buf, false); err != nil {
+		t.Fatal(errors.Wrap(err, "running up"))
+	}
+
+	testutils.AssertEqual(t, mustGetVersion(t, db), 2, "version mismatch")
+	testutils.AssertEqual(t, db.Dialect().HasColumn("migrate_test_foo", "name"), true, "column mismatch")
+	testutils.AssertEqual(t, buf.String(), "-- 1 create-foo\n-- 2 add-foo-name\n", "output mismatch")
+
+	// running again should not apply anything
+	buf.Reset()
+	if err := Up(db, testMigrations, &buf, false); err != nil {
+		t.Fatal(errors.Wrap(err, "running up again"))
+	}
+
+	testutils.AssertEqual(t, mustGetVersion(t, db), 2, "version mismatch after running again")
+	testutils.AssertEqual(t, buf.String(), "", "output mismatch after running again")
+}
+
+func TestUpDryRun(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+
+	db := database.DBConn
+
+	if err := Up(db, testMigrations[:1], &bytes.Buffer{}, false); err != nil {
+		t.Fatal(errors.Wrap(err, "running the first migration"))
+	}
+
+	var buf bytes.Buffer
+	if err := Up(db, testMigrations, &buf, true); err != nil {
+		t.Fatal(errors.Wrap(err, "running up"))
+	}
+
+	testutils.AssertEqual(t, mustGetVersion(t, db), 1, "version mismatch")
+	testutils.AssertEqual(t, db.Dialect().HasColumn("migrate_test_foo", "name"), false, "column mismatch")
+	testutils.AssertEqual(t, buf.String(), "-- 2 add-foo-name\nALTER TABLE migrate_test_foo ADD COLUMN name text;\n", "output mismatch")
+}
+
+func TestUpFailure(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+
+	db := database.DBConn
+
+	migrations := []migration{
+		testMigrations[0],
+		{
+			name: "invalid",
+			up: []string{
+				"ALTER TABLE migrate_test_foo ADD COLUMN name text",
+				"ALTER TABLE migrate_test_bar ADD COLUMN name text",
+			},
+		},
+	}
+
+	if err := Up(db, migrations, &bytes.Buffer{}, false); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the failed migration should be rolled back entirely
+	testutils.AssertEqual(t, mustGetVersion(t, db), 1, "version mismatch")
+	testutils.AssertEqual(t, db.Dialect().HasColumn("migrate_test_foo", "name"), false, "column mismatch")
+}
+
+func TestUpCheck(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+
+	db := database.DBConn
+
+	migrations := []migration{
+		testMigrations[0],
+		{
+			name: "unsafe",
+			check: func(db *gorm.DB) error {
+				return errors.New("unsafe")
+			},
+			up: []string{"ALTER TABLE migrate_test_foo ADD COLUMN name text"},
+		},
+	}
+
+	if err := Up(db, migrations, &bytes.Buffer{}, false); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	testutils.AssertEqual(t, mustGetVersion(t, db), 1, "version mismatch")
+	testutils.AssertEqual(t, db.Dialect().HasColumn("migrate_test_foo", "name"), false, "column mismatch")
+}
+
+func TestDown(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+
+	db := database.DBConn
+
+	if err := Up(db, testMigrations, &bytes.Buffer{}, false); err != nil {
+		t.Fatal(errors.Wrap(err, "running up"))
+	}
+
+	var buf bytes.Buffer
+	if err := Down(db, testMigrations, &buf, false); err != nil {
+		t.Fatal(errors.Wrap(err, "running down"))
+	}
+
+	testutils.AssertEqual(t, mustGetVersion(t, db), 1, "version mismatch")
+	testutils.AssertEqual(t, db.Dialect().HasColumn("migrate_test_foo", "name"), false, "column mismatch")
+	testutils.AssertEqual(t, buf.String(), "-- 2 add-foo-name\n", "output mismatch")
+
+	if err := Down(db, testMigrations, &bytes.Buffer{}, false); err != nil {
+		t.Fatal(errors.Wrap(err, "running down again"))
+	}
+
+	testutils.AssertEqual(t, mustGetVersion(t, db), 0, "version mismatch after running again")
+	testutils.AssertEqual(t, db.Dialect().HasTable("migrate_test_foo"), false, "table mismatch")
+
+	if err := Down(db, testMigrations, &bytes.Buffer{}, false); err == nil {
+		t.Fatal("expected an error when no migration is applied")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+
+	db := database.DBConn
+
+	if err := Up(db, testMigrations[:1], &bytes.Buffer{}, false); err != nil {
+		t.Fatal(errors.Wrap(err, "running the first migration"))
+	}
+
+	var buf bytes.Buffer
+	if err := Status(db, testMigrations, &buf); err != nil {
+		t.Fatal(errors.Wrap(err, "getting status"))
+	}
+
+	testutils.AssertEqual(t, buf.String(), "1 create-foo applied\n2 add-foo-name pending\n", "output mismatch")
+}
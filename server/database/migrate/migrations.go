@@ -0,0 +1,79 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// hasColumn checks if the table has the column
+func hasColumn(db *gorm.DB, table, column string) (bool, error) {
+	var count int
+	if err := db.Raw(`SELECT count(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, table, column).Row().Scan(&count); err != nil {
+		return false, errors.Wrapf(err, "checking the column %s.%s", table, column)
+	}
+
+	return count > 0, nil
+}
+
+// m1 drops the columns left from the accounts that signed in with a password or GitHub
+// before the notes were encrypted. Reverting it adds back the columns without the data.
+// It is not in Sequence yet because the legacy sign-in still reads the columns, and the API
+// would add them back when it starts. Add it in the change that removes the legacy sign-in
+// along with the fields of the columns in database.Account.
+var m1 = migration{
+	name: "drop-deprecated-account-columns",
+	check: func(db *gorm.DB) error {
+		for _, column := range []string{"password", "account_id"} {
+			ok, err := hasColumn(db, "accounts", column)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			// the accounts that have not set up the encryption cannot sign in without the column
+			var count int
+			if err := db.Raw(`SELECT count(*) FROM accounts
+				WHERE COALESCE(auth_key_hash, '') = '' AND COALESCE(` + column + `, '') <> ''`).Row().Scan(&count); err != nil {
+				return errors.Wrap(err, "counting the legacy accounts")
+			}
+			if count > 0 {
+				return errors.Errorf("%d accounts still sign in with accounts.%s", count, column)
+			}
+		}
+
+		return nil
+	},
+	up: []string{
+		"ALTER TABLE accounts DROP COLUMN IF EXISTS password",
+		"ALTER TABLE accounts DROP COLUMN IF EXISTS account_id",
+		"ALTER TABLE accounts DROP COLUMN IF EXISTS nickname",
+		"ALTER TABLE accounts DROP COLUMN IF EXISTS provider",
+	},
+	down: []string{
+		"ALTER TABLE accounts ADD COLUMN IF NOT EXISTS password text",
+		"ALTER TABLE accounts ADD COLUMN IF NOT EXISTS account_id text",
+		"ALTER TABLE accounts ADD COLUMN IF NOT EXISTS nickname text",
+		"ALTER TABLE accounts ADD COLUMN IF NOT EXISTS provider text",
+	},
+}
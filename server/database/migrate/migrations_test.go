@@ -0,0 +1,85 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+)
+
+func TestM1(t *testing.T) {
+	testCases := []struct {
+		name        string
+		authKeyHash string
+		password    string
+		accountID   string
+		expectedErr bool
+	}{
+		{
+			name:        "encrypted account",
+			authKeyHash: "some-hash",
+			password:    "some-password-hash",
+			accountID:   "123",
+			expectedErr: false,
+		},
+		{
+			name:        "legacy account with password",
+			authKeyHash: "",
+			password:    "some-password-hash",
+			expectedErr: true,
+		},
+		{
+			name:        "legacy account with github",
+			authKeyHash: "",
+			accountID:   "123",
+			expectedErr: true,
+		},
+		{
+			name:        "legacy account without credentials",
+			authKeyHash: "",
+			expectedErr: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+
+			// Setup
+			db := database.DBConn
+
+			user := testutils.SetupUserData()
+			account := database.Account{
+				UserID:      user.ID,
+				AuthKeyHash: tc.authKeyHash,
+				Password:    database.ToNullString(tc.password),
+				AccountID:   tc.accountID,
+			}
+			testutils.MustExec(t, db.Save(&account), "preparing account")
+
+			// Execute
+			err := m1.check(db)
+
+			// Test
+			testutils.AssertEqual(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
@@ -70,26 +70,46 @@ type User struct {
 	Cloud            bool   `json:"-" gorm:"default:false"`
 	IsAdmin          bool   `json:"-" gorm:"default:false"`
 	Account          Account
-	LastLoginAt      *time.Time `json:"-"`
-	MaxUSN           int        `json:"-" gorm:"default:0"`
-	Encrypted        bool       `json:"encrypted" gorm:"default:False"`
+	// StripeEventAt is the creation time of the latest Stripe event that was applied to
+	// the access of the user, so that the events delivered out of order are not applied
+	StripeEventAt *time.Time `json:"-"`
+	LastLoginAt   *time.Time `json:"-"`
+	MaxUSN        int        `json:"-" gorm:"default:0"`
+	Encrypted     bool       `json:"encrypted" gorm:"default:False"`
+	// DeleteAt is the time after which the account is deleted by the job runner. It is
+	// nil unless the user has requested the deletion.
+	DeleteAt *time.Time `json:"-" gorm:"index"`
 }
 
 // Account is a model for an account
 type Account struct {
 	Model
-	UserID             int    `gorm:"index"`
-	AccountID          string // Deprecated
-	Nickname           string // Deprecated
-	Provider           string // Deprecated
-	Email              NullString
-	EmailVerified      bool       `gorm:"default:false"`
-	Password           NullString // Deprecated
-	ClientKDFIteration int
-	ServerKDFIteration int
-	AuthKeyHash        string
-	Salt               string
-	CipherKeyEnc       string
+	UserID               int    `gorm:"index"`
+	AccountID            string // Deprecated
+	Nickname             string // Deprecated
+	Provider             string // Deprecated
+	Email                NullString
+	EmailVerified        bool       `gorm:"default:false"`
+	Password             NullString // Deprecated
+	ClientKDFIteration   int
+	ServerKDFIteration   int
+	AuthKeyHash          string
+	Salt                 string
+	CipherKeyEnc         string
+	CipherKeyRecoveryEnc string
+	RecoveryKeyHash      string
+	RecoveryKeySalt      string
+	TOTPSecret           string
+	TOTPEnabled          bool `gorm:"default:false"`
+	TOTPLastStep         int64
+}
+
+// BackupCode is a model for a one-time backup code for two-factor authentication
+type BackupCode struct {
+	Model
+	UserID   int `gorm:"index"`
+	CodeHash string
+	UsedAt   *time.Time
 }
 
 // Token is a model for a token
@@ -99,6 +119,8 @@ type Token struct {
 	Value  string `gorm:"index"`
 	Type   string
 	UsedAt *time.Time
+	// FailedAttempts is the number of the wrong credentials given with the token
+	FailedAttempts int `gorm:"default:0"`
 }
 
 // Notification is the learning notification sent to the user
@@ -118,10 +140,72 @@ type EmailPreference struct {
 // Session represents a user session
 type Session struct {
 	Model
-	UserID     int    `gorm:"index"`
-	Key        string `gorm:"index"`
-	LastUsedAt time.Time
-	ExpiresAt  time.Time
+	UserID       int    `gorm:"index"`
+	Key          string `gorm:"index"`
+	RefreshToken string `gorm:"index"`
+	LastUsedAt   time.Time
+	ExpiresAt    time.Time
+	ClientType   string
+	UserAgent    string
+	IP           string
+}
+
+// IdempotencyKey is a model for a response stored for a request made with an
+// Idempotency-Key header. StatusCode is 0 while the request is being processed.
+type IdempotencyKey struct {
+	Model
+	UserID      int    `gorm:"unique_index:idx_idempotency_keys_user_id_key"`
+	Key         string `gorm:"unique_index:idx_idempotency_keys_user_id_key"`
+	Method      string
+	Path        string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// StripeEvent is a model for an event received from the Stripe webhook. It is kept to
+// process each event only once, because Stripe may deliver an event more than once.
+type StripeEvent struct {
+	Model
+	EventID     string `gorm:"unique_index"`
+	Type        string
+	Status      string
+	Error       string
+	ProcessedAt *time.Time
+}
+
+// SigninFailure is a model for the failed attempts to sign in with an email. It is kept
+// for any email, whether or not an account has it, so that it does not reveal the accounts.
+type SigninFailure struct {
+	Model
+	Email        string `gorm:"unique_index"`
+	Count        int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// RateLimitCounter is a model for the number of requests counted for a rate limit key
+// in the current window
+type RateLimitCounter struct {
+	Key         string `gorm:"primary_key"`
+	WindowStart time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+	Count       int
+}
+
+// AccountExport is a model for an archive of the data of a user. It is built by the job
+// runner after the user requests it, and is deleted after ExpiresAt. TokenID is the token
+// emailed to the user to download it.
+type AccountExport struct {
+	Model
+	UserID      int `gorm:"index"`
+	TokenID     int `gorm:"index"`
+	Status      string
+	Archive     []byte
+	Error       string
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time `gorm:"index"`
 }
 
 // Digest is a digest of notes
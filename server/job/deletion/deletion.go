@@ -0,0 +1,133 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package deletion carries out the account deletions whose grace period has passed
+package deletion
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/operations"
+	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/sub"
+)
+
+// cancelSubs cancels the subscriptions of the given user that have not been canceled,
+// including the ones that are trialing or past due, at the end of the current period
+func cancelSubs(user database.User) error {
+	if user.StripeCustomerID == "" {
+		return nil
+	}
+
+	// without a status filter, Stripe lists all the subscriptions but the canceled ones
+	listParams := &stripe.SubscriptionListParams{}
+	listParams.Filters.AddFilter("customer", "", user.StripeCustomerID)
+	i := sub.List(listParams)
+
+	for i.Next() {
+		s := i.Subscription()
+		if s.Status == stripe.SubscriptionStatusCanceled || s.Status == stripe.SubscriptionStatusIncompleteExpired {
+			continue
+		}
+		if s.CancelAtPeriodEnd {
+			continue
+		}
+
+		if err := operations.CancelSub(s.ID, user); err != nil {
+			return errors.Wrapf(err, "cancelling subscription %s", s.ID)
+		}
+	}
+	if err := i.Err(); err != nil {
+		return errors.Wrap(err, "fetching subscriptions")
+	}
+
+	return nil
+}
+
+// deleteUser deletes the given user unless the deletion has been cancelled. The
+// subscriptions are cancelled before the transaction so that no call to Stripe is made
+// while it is open, and so that the data is kept for the next run if the cancellation
+// fails. Cancelling is idempotent, and a user who cancels the deletion in the meantime can
+// reactivate the subscription. The user is deleted first in the transaction so that a
+// concurrent cancellation waits for it.
+func deleteUser(db *gorm.DB, user database.User, now time.Time) (bool, error) {
+	var due int
+	if err := db.Model(&database.User{}).Where("id = ? AND delete_at <= ?", user.ID, now).Count(&due).Error; err != nil {
+		return false, errors.Wrap(err, "checking the deletion")
+	}
+	if due == 0 {
+		return false, nil
+	}
+
+	if err := cancelSubs(user); err != nil {
+		return false, errors.Wrap(err, "cancelling subscriptions")
+	}
+
+	tx := db.Begin()
+
+	conn := tx.Where("id = ? AND delete_at <= ?", user.ID, now).Delete(&database.User{})
+	if err := conn.Error; err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, "deleting user")
+	}
+	if conn.RowsAffected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	if err := operations.PurgeUser(tx, user); err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, "purging user")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, errors.Wrap(err, "committing transaction")
+	}
+
+	return true, nil
+}
+
+// Process deletes the accounts whose deletion is due. An account that fails to be deleted
+// is retried in the next run.
+func Process() error {
+	db := database.DBConn
+	now := time.Now()
+
+	var users []database.User
+	if err := db.Where("delete_at <= ?", now).Order("id").Find(&users).Error; err != nil {
+		return errors.Wrap(err, "finding users to delete")
+	}
+
+	for _, user := range users {
+		ok, err := deleteUser(db, user, now)
+		if err != nil {
+			logger.WithFields(logger.Fields{"user_id": user.ID, "error": err}).Err("deleting the account")
+			continue
+		}
+		if ok {
+			logger.WithFields(logger.Fields{"user_id": user.ID}).Notice("account deleted")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package deletion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+	"github.com/stripe/stripe-go"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+func TestProcess(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	now := time.Now()
+
+	u1 := testutils.SetupUserData()
+	testutils.SetupAccountData(u1, "alice@example.com")
+	testutils.MustExec(t, db.Model(&u1).Update("delete_at", now.Add(-time.Minute)), "preparing u1 delete_at")
+	b1 := database.Book{UserID: u1.ID, Label: "b1-label"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	u2 := testutils.SetupUserData()
+	testutils.SetupAccountData(u2, "bob@example.com")
+	testutils.MustExec(t, db.Model(&u2).Update("delete_at", now.Add(time.Hour)), "preparing u2 delete_at")
+	b2 := database.Book{UserID: u2.ID, Label: "b2-label"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+
+	u3 := testutils.SetupUserData()
+	testutils.SetupAccountData(u3, "chuck@example.com")
+
+	// Execute
+	if err := Process(); err != nil {
+		t.Fatal(errors.Wrap(err, "processing"))
+	}
+
+	// Test
+	var userCount, accountCount, bookCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.MustExec(t, db.Model(&database.Account{}).Count(&accountCount), "counting accounts")
+	testutils.MustExec(t, db.Model(&database.Book{}).Count(&bookCount), "counting books")
+
+	testutils.AssertEqual(t, userCount, 2, "user count mismatch")
+	testutils.AssertEqual(t, accountCount, 2, "account count mismatch")
+	testutils.AssertEqual(t, bookCount, 1, "book count mismatch")
+
+	var u1Count int
+	testutils.MustExec(t, db.Model(&database.User{}).Where("id = ?", u1.ID).Count(&u1Count), "counting u1")
+	testutils.AssertEqual(t, u1Count, 0, "u1 should be deleted")
+}
+
+func TestDeleteUser_cancelled(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	now := time.Now()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+	testutils.MustExec(t, db.Model(&user).Update("delete_at", now.Add(-time.Minute)), "preparing user delete_at")
+
+	// the user cancels the deletion after the runner has found it
+	testutils.MustExec(t, db.Model(&user).Update("delete_at", nil), "cancelling deletion")
+
+	// Execute
+	ok, err := deleteUser(db, user, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "deleting user"))
+	}
+
+	// Test
+	testutils.AssertEqual(t, ok, false, "the user should not be deleted")
+
+	var userCount, accountCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.MustExec(t, db.Model(&database.Account{}).Count(&accountCount), "counting accounts")
+	testutils.AssertEqual(t, userCount, 1, "user count mismatch")
+	testutils.AssertEqual(t, accountCount, 1, "account count mismatch")
+}
+
+const testSubscriptions = `{
+  "object": "list",
+  "url": "/v1/subscriptions",
+  "has_more": false,
+  "data": [
+    {"id": "sub_active", "object": "subscription", "customer": "cus_1", "status": "active"},
+    {"id": "sub_trialing", "object": "subscription", "customer": "cus_1", "status": "trialing"},
+    {"id": "sub_past_due", "object": "subscription", "customer": "cus_1", "status": "past_due"},
+    {"id": "sub_ending", "object": "subscription", "customer": "cus_1", "status": "active", "cancel_at_period_end": true},
+    {"id": "sub_canceled", "object": "subscription", "customer": "cus_1", "status": "canceled"}
+  ]
+}`
+
+// setupStripe serves the subscriptions of the customer, and records the paths of the
+// subscriptions that are updated. If failUpdate is true, the updates fail.
+func setupStripe(t *testing.T, failUpdate bool) (*[]string, func()) {
+	var mu sync.Mutex
+	var updated []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == "GET" && r.URL.Path == "/v1/subscriptions" {
+			testutils.AssertEqual(t, r.URL.Query().Get("customer"), "cus_1", "customer mismatch")
+			testutils.AssertEqual(t, r.URL.Query().Get("status"), "", "the subscriptions should not be filtered by status")
+			w.Write([]byte(testSubscriptions))
+			return
+		}
+		if r.Method == "POST" {
+			if failUpdate {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": {"type": "invalid_request_error", "message": "some error"}}`))
+				return
+			}
+
+			mu.Lock()
+			updated = append(updated, r.URL.Path)
+			mu.Unlock()
+			w.Write([]byte(`{"id": "sub_1", "object": "subscription", "cancel_at_period_end": true}`))
+			return
+		}
+
+		t.Errorf("unexpected request to Stripe %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	stripe.SetBackend(stripe.APIBackend, testutils.CreateMockStripeBackend(server))
+
+	return &updated, server.Close
+}
+
+func TestDeleteUser_subscriptions(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	now := time.Now()
+
+	updated, teardown := setupStripe(t, false)
+	defer teardown()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+	testutils.MustExec(t, db.Model(&user).Updates(map[string]interface{}{
+		"delete_at":          now.Add(-time.Minute),
+		"stripe_customer_id": "cus_1",
+	}), "preparing user")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&user), "finding user")
+
+	// Execute
+	ok, err := deleteUser(db, user, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "deleting user"))
+	}
+
+	// Test
+	testutils.AssertEqual(t, ok, true, "the user should be deleted")
+
+	sort.Strings(*updated)
+	testutils.AssertDeepEqual(t, *updated, []string{
+		"/v1/subscriptions/sub_active",
+		"/v1/subscriptions/sub_past_due",
+		"/v1/subscriptions/sub_trialing",
+	}, "cancelled subscriptions mismatch")
+
+	var userCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.AssertEqual(t, userCount, 0, "user count mismatch")
+}
+
+func TestDeleteUser_cancelSubsFailed(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	now := time.Now()
+
+	_, teardown := setupStripe(t, true)
+	defer teardown()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+	testutils.MustExec(t, db.Model(&user).Updates(map[string]interface{}{
+		"delete_at":          now.Add(-time.Minute),
+		"stripe_customer_id": "cus_1",
+	}), "preparing user")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&user), "finding user")
+
+	// Execute
+	ok, err := deleteUser(db, user, now)
+
+	// Test
+	if err == nil {
+		t.Fatal("an error should be returned")
+	}
+	testutils.AssertEqual(t, ok, false, "the user should not be deleted")
+
+	var userCount, accountCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.MustExec(t, db.Model(&database.Account{}).Count(&accountCount), "counting accounts")
+	testutils.AssertEqual(t, userCount, 1, "the user should be kept for the next run")
+	testutils.AssertEqual(t, accountCount, 1, "the account should be kept for the next run")
+}
@@ -19,17 +19,20 @@
 package digest
 
 import (
-	"log"
 	"time"
 
+	"github.com/dnote/dnote/server/api/billing"
+	"github.com/dnote/dnote/server/api/logger"
 	"github.com/dnote/dnote/server/database"
 	"github.com/dnote/dnote/server/mailer"
+	"github.com/dnote/dnote/server/metrics"
+	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
 // Make builds a weekly digest email
 func Make(user database.User, emailAddr string) (*mailer.Email, error) {
-	log.Printf("Sending for %s", emailAddr)
+	logger.WithFields(logger.Fields{"user_id": user.ID}).Debug("Making the weekly digest")
 	db := database.DBConn
 
 	subject := "Weekly Digest"
@@ -92,19 +95,46 @@ func Make(user database.User, emailAddr string) (*mailer.Email, error) {
 	return email, nil
 }
 
-// Send sends the weekly digests to users
-func Send() error {
-	db := database.DBConn
-
+// getRecipients returns the users whose plan is entitled to the digests
+func getRecipients(db *gorm.DB, p billing.Provider) ([]database.User, error) {
 	var users []database.User
 	if err := db.
 		Preload("Account").
-		Where("cloud = ?", true).
+		Order("id").
 		Find(&users).Error; err != nil {
-		return err
+		return nil, errors.Wrap(err, "finding users")
+	}
+
+	var ret []database.User
+	for _, user := range users {
+		ok, err := billing.HasFeature(p, user, billing.FeatureWeb)
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking the plan of the user %d", user.ID)
+		}
+		if ok {
+			ret = append(ret, user)
+		}
+	}
+
+	return ret, nil
+}
+
+// Send sends the weekly digests to the users whose plan is entitled to them
+func Send(p billing.Provider) error {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDigestDuration(time.Since(start))
+	}()
+
+	db := database.DBConn
+
+	users, err := getRecipients(db, p)
+	if err != nil {
+		return errors.Wrap(err, "getting the recipients")
 	}
 
 	for _, user := range users {
+		metrics.AddDigestUserProcessed()
 		account := user.Account
 
 		if !account.Email.Valid || !account.EmailVerified {
@@ -113,15 +143,18 @@ func Send() error {
 
 		email, err := Make(user, account.Email.String)
 		if err != nil {
-			log.Printf("Error occurred while sending to %s: %s", account.Email.String, err.Error())
+			logger.WithFields(logger.Fields{"user_id": user.ID, "error": err}).Err("making the weekly digest")
+			metrics.AddDigestEmail(metrics.DigestResultFailed)
 			continue
 		}
 
 		err = email.Send()
 		if err != nil {
-			log.Printf("Error occurred while sending to %s: %s", account.Email.String, err.Error())
+			logger.WithFields(logger.Fields{"user_id": user.ID, "error": err}).Err("sending the weekly digest")
+			metrics.AddDigestEmail(metrics.DigestResultFailed)
 			continue
 		}
+		metrics.AddDigestEmail(metrics.DigestResultSent)
 
 		notif := database.Notification{
 			Type:   "email_weekly",
@@ -129,7 +162,7 @@ func Send() error {
 		}
 
 		if err := db.Create(&notif).Error; err != nil {
-			log.Printf("Error occurred while creating notification for %s: %s", account.Email.String, err.Error())
+			logger.WithFields(logger.Fields{"user_id": user.ID, "error": err}).Err("creating notification")
 		}
 	}
 
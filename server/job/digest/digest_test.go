@@ -0,0 +1,103 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package digest
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/server/api/billing"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+func TestGetRecipients(t *testing.T) {
+	static, err := billing.NewStatic(billing.PlanFile{
+		DefaultPlan: "basic",
+		Plans: []billing.Plan{
+			{ID: "basic", Name: "Basic", Features: []billing.Feature{billing.FeatureSync}},
+			{ID: "pro", Name: "Pro", Features: []billing.Feature{billing.FeatureSync, billing.FeatureWeb}},
+		},
+		Users: map[string]string{
+			"bob@example.com": "pro",
+		},
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making the static provider"))
+	}
+
+	testCases := []struct {
+		name     string
+		provider billing.Provider
+		expected []string
+	}{
+		{
+			name:     "stripe",
+			provider: billing.NewStripe("plan_1"),
+			expected: []string{"alice@example.com"},
+		},
+		{
+			name:     "self-hosted",
+			provider: billing.NewSelfHosted(),
+			expected: []string{"alice@example.com", "bob@example.com", "chuck@example.com"},
+		},
+		{
+			name:     "static",
+			provider: static,
+			expected: []string{"bob@example.com"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			u1 := testutils.SetupUserData()
+			testutils.SetupAccountData(u1, "alice@example.com")
+
+			u2 := testutils.SetupUserData()
+			testutils.SetupAccountData(u2, "bob@example.com")
+			testutils.MustExec(t, db.Model(&u2).Update("cloud", false), "preparing u2 cloud")
+
+			u3 := testutils.SetupUserData()
+			testutils.SetupAccountData(u3, "chuck@example.com")
+			testutils.MustExec(t, db.Model(&u3).Update("cloud", false), "preparing u3 cloud")
+
+			// execute
+			users, err := getRecipients(db, tc.provider)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			// test
+			var emails []string
+			for _, user := range users {
+				emails = append(emails, user.Account.Email.String)
+			}
+
+			testutils.AssertDeepEqual(t, emails, tc.expected, "recipients mismatch")
+		})
+	}
+}
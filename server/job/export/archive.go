@@ -0,0 +1,310 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// The types below are the records in the archive. They leave out the secrets kept by
+// the server, such as the hashes of the keys and the session keys.
+
+type accountRecord struct {
+	Name               string     `json:"name"`
+	Email              string     `json:"email"`
+	EmailVerified      bool       `json:"email_verified"`
+	CreatedAt          time.Time  `json:"created_at"`
+	LastLoginAt        *time.Time `json:"last_login_at"`
+	Encrypted          bool       `json:"encrypted"`
+	ClientKDFIteration int        `json:"client_kdf_iteration"`
+	// CipherKeyEnc is the key of the notes encrypted with the master key of the user. It is
+	// needed to decrypt the notes and the books in the archive.
+	CipherKeyEnc         string `json:"cipher_key_enc"`
+	CipherKeyRecoveryEnc string `json:"cipher_key_recovery_enc"`
+	TOTPEnabled          bool   `json:"totp_enabled"`
+}
+
+type bookRecord struct {
+	UUID      string    `json:"uuid"`
+	Label     string    `json:"label"`
+	AddedOn   int64     `json:"added_on"`
+	EditedOn  int64     `json:"edited_on"`
+	Deleted   bool      `json:"deleted"`
+	Encrypted bool      `json:"encrypted"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type noteRecord struct {
+	UUID      string    `json:"uuid"`
+	BookUUID  string    `json:"book_uuid"`
+	Content   string    `json:"content"`
+	AddedOn   int64     `json:"added_on"`
+	EditedOn  int64     `json:"edited_on"`
+	Public    bool      `json:"public"`
+	Deleted   bool      `json:"deleted"`
+	Encrypted bool      `json:"encrypted"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type digestRecord struct {
+	UUID      string    `json:"uuid"`
+	NoteUUIDs []string  `json:"note_uuids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type sessionRecord struct {
+	ClientType string    `json:"client_type"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type emailPreferenceRecord struct {
+	DigestWeekly bool `json:"digest_weekly"`
+}
+
+type notificationRecord struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func getAccountRecord(db *gorm.DB, user database.User) (accountRecord, error) {
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		return accountRecord{}, errors.Wrap(err, "finding account")
+	}
+
+	return accountRecord{
+		Name:                 user.Name,
+		Email:                account.Email.String,
+		EmailVerified:        account.EmailVerified,
+		CreatedAt:            user.CreatedAt,
+		LastLoginAt:          user.LastLoginAt,
+		Encrypted:            user.Encrypted,
+		ClientKDFIteration:   account.ClientKDFIteration,
+		CipherKeyEnc:         account.CipherKeyEnc,
+		CipherKeyRecoveryEnc: account.CipherKeyRecoveryEnc,
+		TOTPEnabled:          account.TOTPEnabled,
+	}, nil
+}
+
+func getBookRecords(db *gorm.DB, userID int) ([]bookRecord, error) {
+	var books []database.Book
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&books).Error; err != nil {
+		return nil, errors.Wrap(err, "finding books")
+	}
+
+	ret := []bookRecord{}
+	for _, b := range books {
+		ret = append(ret, bookRecord{
+			UUID:      b.UUID,
+			Label:     b.Label,
+			AddedOn:   b.AddedOn,
+			EditedOn:  b.EditedOn,
+			Deleted:   b.Deleted,
+			Encrypted: b.Encrypted,
+			CreatedAt: b.CreatedAt,
+			UpdatedAt: b.UpdatedAt,
+		})
+	}
+
+	return ret, nil
+}
+
+func getNoteRecords(db *gorm.DB, userID int) ([]noteRecord, error) {
+	var notes []database.Note
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&notes).Error; err != nil {
+		return nil, errors.Wrap(err, "finding notes")
+	}
+
+	ret := []noteRecord{}
+	for _, n := range notes {
+		ret = append(ret, noteRecord{
+			UUID:      n.UUID,
+			BookUUID:  n.BookUUID,
+			Content:   n.Body,
+			AddedOn:   n.AddedOn,
+			EditedOn:  n.EditedOn,
+			Public:    n.Public,
+			Deleted:   n.Deleted,
+			Encrypted: n.Encrypted,
+			CreatedAt: n.CreatedAt,
+			UpdatedAt: n.UpdatedAt,
+		})
+	}
+
+	return ret, nil
+}
+
+func getDigestRecords(db *gorm.DB, userID int) ([]digestRecord, error) {
+	var digests []database.Digest
+	if err := db.Where("user_id = ?", userID).Order("created_at").Preload("Notes").Find(&digests).Error; err != nil {
+		return nil, errors.Wrap(err, "finding digests")
+	}
+
+	ret := []digestRecord{}
+	for _, d := range digests {
+		noteUUIDs := []string{}
+		for _, n := range d.Notes {
+			noteUUIDs = append(noteUUIDs, n.UUID)
+		}
+
+		ret = append(ret, digestRecord{
+			UUID:      d.UUID,
+			NoteUUIDs: noteUUIDs,
+			CreatedAt: d.CreatedAt,
+		})
+	}
+
+	return ret, nil
+}
+
+func getSessionRecords(db *gorm.DB, userID int) ([]sessionRecord, error) {
+	var sessions []database.Session
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&sessions).Error; err != nil {
+		return nil, errors.Wrap(err, "finding sessions")
+	}
+
+	ret := []sessionRecord{}
+	for _, s := range sessions {
+		ret = append(ret, sessionRecord{
+			ClientType: s.ClientType,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+
+	return ret, nil
+}
+
+func getEmailPreferenceRecord(db *gorm.DB, userID int) (*emailPreferenceRecord, error) {
+	var pref database.EmailPreference
+	conn := db.Where("user_id = ?", userID).First(&pref)
+	if conn.RecordNotFound() {
+		return nil, nil
+	} else if err := conn.Error; err != nil {
+		return nil, errors.Wrap(err, "finding email preference")
+	}
+
+	return &emailPreferenceRecord{DigestWeekly: pref.DigestWeekly}, nil
+}
+
+func getNotificationRecords(db *gorm.DB, userID int) ([]notificationRecord, error) {
+	var notifications []database.Notification
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&notifications).Error; err != nil {
+		return nil, errors.Wrap(err, "finding notifications")
+	}
+
+	ret := []notificationRecord{}
+	for _, n := range notifications {
+		ret = append(ret, notificationRecord{
+			Type:      n.Type,
+			CreatedAt: n.CreatedAt,
+		})
+	}
+
+	return ret, nil
+}
+
+// archiveFile is a file in the archive with the data to be encoded into JSON
+type archiveFile struct {
+	name string
+	data interface{}
+}
+
+func writeArchive(files []archiveFile) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating %s", f.name)
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return nil, errors.Wrapf(err, "encoding %s", f.name)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "closing the archive")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// makeArchive builds a zip archive of the data of the user, with a JSON file for each
+// kind of the data
+func makeArchive(db *gorm.DB, user database.User) ([]byte, error) {
+	account, err := getAccountRecord(db, user)
+	if err != nil {
+		return nil, err
+	}
+	books, err := getBookRecords(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := getNoteRecords(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	digests, err := getDigestRecords(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := getSessionRecords(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	emailPreference, err := getEmailPreferenceRecord(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	notifications, err := getNotificationRecords(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeArchive([]archiveFile{
+		{"account.json", account},
+		{"books.json", books},
+		{"notes.json", notes},
+		{"digests.json", digests},
+		{"sessions.json", sessions},
+		{"email_preference.json", emailPreference},
+		{"notifications.json", notifications},
+	})
+}
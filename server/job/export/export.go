@@ -0,0 +1,213 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package export builds the archives of the data that the users requested to download
+package export
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/mailer"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ArchiveTTL is the duration for which an archive can be downloaded after it is built
+	ArchiveTTL = 48 * time.Hour
+	// processingTimeout is the duration after which an export still being built is
+	// considered failed, for instance because the runner was stopped in the middle
+	processingTimeout = 1 * time.Hour
+)
+
+func generateToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating random bytes")
+	}
+
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// cleanUp deletes the expired archives along with their tokens, and fails the exports
+// that have been processing for too long so that the users can request them again
+func cleanUp(db *gorm.DB, now time.Time) error {
+	if err := db.
+		Where("expires_at < ? OR (status = ? AND updated_at < ?)", now, database.AccountExportStatusFailed, now.Add(-ArchiveTTL)).
+		Delete(&database.AccountExport{}).Error; err != nil {
+		return errors.Wrap(err, "deleting expired exports")
+	}
+	if err := db.
+		Where("type = ? AND created_at < ?", database.TokenTypeAccountExport, now.Add(-ArchiveTTL)).
+		Delete(&database.Token{}).Error; err != nil {
+		return errors.Wrap(err, "deleting expired tokens")
+	}
+	if err := db.Model(&database.AccountExport{}).
+		Where("status = ? AND updated_at < ?", database.AccountExportStatusProcessing, now.Add(-processingTimeout)).
+		Updates(map[string]interface{}{
+			"status": database.AccountExportStatusFailed,
+			"error":  "timed out",
+		}).Error; err != nil {
+		return errors.Wrap(err, "failing stale exports")
+	}
+
+	return nil
+}
+
+// claim marks the export as processing. It returns false if another runner has claimed
+// it first.
+func claim(db *gorm.DB, export database.AccountExport) (bool, error) {
+	conn := db.Model(&database.AccountExport{}).
+		Where("id = ? AND status = ?", export.ID, database.AccountExportStatusPending).
+		Update("status", database.AccountExportStatusProcessing)
+	if err := conn.Error; err != nil {
+		return false, errors.Wrap(err, "updating status")
+	}
+
+	return conn.RowsAffected == 1, nil
+}
+
+func sendEmail(emailAddr, token string) error {
+	data := mailer.AccountExportTmplData{
+		Subject:  "Your Dnote data is ready to download",
+		Token:    token,
+		Duration: fmt.Sprintf("%d hours", int(ArchiveTTL.Hours())),
+	}
+
+	email := mailer.NewEmail("noreply@dnote.io", []string{emailAddr}, data.Subject)
+	if err := email.ParseTemplate(mailer.EmailTypeAccountExport, data); err != nil {
+		return errors.Wrap(err, "parsing template")
+	}
+	if err := email.Send(); err != nil {
+		return errors.Wrap(err, "sending email")
+	}
+
+	return nil
+}
+
+// build builds the archive of the export and emails the user a token to download it. If
+// the email cannot be sent, the export is failed by process so that the user can request
+// it again.
+func build(db *gorm.DB, export database.AccountExport, now time.Time) error {
+	var user database.User
+	if err := db.Where("id = ?", export.UserID).Preload("Account").First(&user).Error; err != nil {
+		return errors.Wrap(err, "finding user")
+	}
+	if !user.Account.Email.Valid || user.Account.Email.String == "" {
+		return errors.New("the account does not have an email")
+	}
+
+	archive, err := makeArchive(db, user)
+	if err != nil {
+		return errors.Wrap(err, "making archive")
+	}
+
+	tokenValue, err := generateToken()
+	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+
+	tx := db.Begin()
+
+	token := database.Token{
+		UserID: user.ID,
+		Value:  tokenValue,
+		Type:   database.TokenTypeAccountExport,
+	}
+	if err := tx.Save(&token).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving token")
+	}
+
+	expiresAt := now.Add(ArchiveTTL)
+	if err := tx.Model(&export).Updates(map[string]interface{}{
+		"status":       database.AccountExportStatusCompleted,
+		"archive":      archive,
+		"token_id":     token.ID,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving archive")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "committing")
+	}
+
+	// send the link only after the token has been saved so that it works when it arrives
+	if err := sendEmail(user.Account.Email.String, tokenValue); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func process(db *gorm.DB, export database.AccountExport, now time.Time) error {
+	ok, err := claim(db, export)
+	if err != nil {
+		return errors.Wrap(err, "claiming")
+	}
+	if !ok {
+		return nil
+	}
+
+	if err := build(db, export, now); err != nil {
+		if e := db.Model(&export).Updates(map[string]interface{}{
+			"status": database.AccountExportStatusFailed,
+			"error":  err.Error(),
+		}).Error; e != nil {
+			logger.WithFields(logger.Fields{"user_id": export.UserID, "error": e}).Err("failing the export")
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Process builds the pending account exports and deletes the expired ones
+func Process() error {
+	db := database.DBConn
+	now := time.Now()
+
+	if err := cleanUp(db, now); err != nil {
+		return errors.Wrap(err, "cleaning up")
+	}
+
+	var exports []database.AccountExport
+	if err := db.Select("id, user_id, status").
+		Where("status = ?", database.AccountExportStatusPending).
+		Order("id").
+		Find(&exports).Error; err != nil {
+		return errors.Wrap(err, "finding pending exports")
+	}
+
+	for _, export := range exports {
+		if err := process(db, export, now); err != nil {
+			logger.WithFields(logger.Fields{"user_id": export.UserID, "error": err}).Err("building the account export")
+		}
+	}
+
+	return nil
+}
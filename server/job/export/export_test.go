@@ -0,0 +1,187 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/mailer"
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	testutils.InitTestDB()
+	mailer.InitTemplates("../../mailer/templates/src")
+}
+
+func readArchive(t *testing.T, archive []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening archive"))
+	}
+
+	ret := map[string][]byte{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "opening %s", f.Name))
+		}
+
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "reading %s", f.Name))
+		}
+
+		ret[f.Name] = b
+	}
+
+	return ret
+}
+
+func TestProcess(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+	session := testutils.SetupSession(t, user)
+
+	b1 := database.Book{UserID: user.ID, Label: "book-label"}
+	testutils.MustExec(t, db.Save(&b1), "preparing book")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "note-body"}
+	testutils.MustExec(t, db.Save(&n1), "preparing note")
+
+	export := database.AccountExport{UserID: user.ID, Status: database.AccountExportStatusPending}
+	testutils.MustExec(t, db.Save(&export), "preparing export")
+
+	// Execute
+	if err := Process(); err != nil {
+		t.Fatal(errors.Wrap(err, "processing"))
+	}
+
+	// Test
+	var exportRecord database.AccountExport
+	testutils.MustExec(t, db.Where("id = ?", export.ID).First(&exportRecord), "finding export")
+	testutils.AssertEqual(t, exportRecord.Status, database.AccountExportStatusCompleted, "status mismatch")
+	testutils.AssertNotEqual(t, exportRecord.ExpiresAt, (*time.Time)(nil), "expires_at mismatch")
+
+	var tokens []database.Token
+	testutils.MustExec(t, db.Where("user_id = ? AND type = ?", user.ID, database.TokenTypeAccountExport).Find(&tokens), "finding tokens")
+	testutils.AssertEqual(t, len(tokens), 1, "token count mismatch")
+	testutils.AssertEqual(t, exportRecord.TokenID, tokens[0].ID, "token_id mismatch")
+
+	files := readArchive(t, exportRecord.Archive)
+	for _, name := range []string{"account.json", "books.json", "notes.json", "digests.json", "sessions.json", "email_preference.json", "notifications.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("the archive does not have %s", name)
+		}
+	}
+
+	var notes []noteRecord
+	if err := json.Unmarshal(files["notes.json"], &notes); err != nil {
+		t.Fatal(errors.Wrap(err, "unmarshalling notes"))
+	}
+	testutils.AssertEqual(t, len(notes), 1, "note count mismatch")
+	testutils.AssertEqual(t, notes[0].UUID, n1.UUID, "note uuid mismatch")
+	testutils.AssertEqual(t, notes[0].Content, "note-body", "note content mismatch")
+
+	var sessions []map[string]interface{}
+	if err := json.Unmarshal(files["sessions.json"], &sessions); err != nil {
+		t.Fatal(errors.Wrap(err, "unmarshalling sessions"))
+	}
+	testutils.AssertEqual(t, len(sessions), 1, "session count mismatch")
+	if bytes.Contains(files["sessions.json"], []byte(session.Key)) {
+		t.Error("the archive has the session key")
+	}
+}
+
+func TestProcess_claimed(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com")
+
+	export := database.AccountExport{UserID: user.ID, Status: database.AccountExportStatusProcessing}
+	testutils.MustExec(t, db.Save(&export), "preparing export")
+
+	// Execute
+	if err := process(db, export, time.Now()); err != nil {
+		t.Fatal(errors.Wrap(err, "processing"))
+	}
+
+	// Test
+	var exportRecord database.AccountExport
+	testutils.MustExec(t, db.Where("id = ?", export.ID).First(&exportRecord), "finding export")
+	testutils.AssertEqual(t, exportRecord.Status, database.AccountExportStatusProcessing, "status mismatch")
+	testutils.AssertEqual(t, len(exportRecord.Archive), 0, "archive mismatch")
+}
+
+func TestCleanUp(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	now := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Minute)
+	valid := now.Add(time.Hour)
+
+	user := testutils.SetupUserData()
+
+	e1 := database.AccountExport{UserID: user.ID, Status: database.AccountExportStatusCompleted, ExpiresAt: &expired}
+	testutils.MustExec(t, db.Save(&e1), "preparing export 1")
+	e2 := database.AccountExport{UserID: user.ID, Status: database.AccountExportStatusCompleted, ExpiresAt: &valid}
+	testutils.MustExec(t, db.Save(&e2), "preparing export 2")
+	e3 := database.AccountExport{UserID: user.ID, Status: database.AccountExportStatusProcessing}
+	testutils.MustExec(t, db.Save(&e3), "preparing export 3")
+	testutils.MustExec(t, db.Model(&e3).UpdateColumn("updated_at", now.Add(-2*processingTimeout)), "preparing export 3 updated_at")
+
+	tok1 := database.Token{UserID: user.ID, Type: database.TokenTypeAccountExport, Value: "token-1"}
+	testutils.MustExec(t, db.Save(&tok1), "preparing token 1")
+	testutils.MustExec(t, db.Model(&tok1).UpdateColumn("created_at", now.Add(-ArchiveTTL-time.Minute)), "preparing token 1 created_at")
+	tok2 := database.Token{UserID: user.ID, Type: database.TokenTypeAccountExport, Value: "token-2"}
+	testutils.MustExec(t, db.Save(&tok2), "preparing token 2")
+	testutils.MustExec(t, db.Model(&tok2).UpdateColumn("created_at", now), "preparing token 2 created_at")
+
+	// Execute
+	if err := cleanUp(db, now); err != nil {
+		t.Fatal(errors.Wrap(err, "cleaning up"))
+	}
+
+	// Test
+	var exportCount, tokenCount int
+	testutils.MustExec(t, db.Model(&database.AccountExport{}).Count(&exportCount), "counting exports")
+	testutils.MustExec(t, db.Model(&database.Token{}).Count(&tokenCount), "counting tokens")
+	testutils.AssertEqual(t, exportCount, 2, "export count mismatch")
+	testutils.AssertEqual(t, tokenCount, 1, "token count mismatch")
+
+	var e3Record database.AccountExport
+	testutils.MustExec(t, db.Where("id = ?", e3.ID).First(&e3Record), "finding export 3")
+	testutils.AssertEqual(t, e3Record.Status, database.AccountExportStatusFailed, "export 3 status mismatch")
+}
@@ -20,17 +20,28 @@ package main
 
 import (
 	"flag"
-	"log"
 	"os"
-
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dnote/dnote/server/api/billing"
+	"github.com/dnote/dnote/server/api/handlers"
+	"github.com/dnote/dnote/server/api/logger"
+	"github.com/dnote/dnote/server/api/operations"
 	"github.com/dnote/dnote/server/database"
+	"github.com/dnote/dnote/server/job/deletion"
 	"github.com/dnote/dnote/server/job/digest"
+	"github.com/dnote/dnote/server/job/export"
 	"github.com/dnote/dnote/server/mailer"
+	"github.com/dnote/dnote/server/metrics"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/robfig/cron"
+	"github.com/stripe/stripe-go"
 )
 
 var (
@@ -52,33 +63,116 @@ func init() {
 	}
 }
 
-func scheduleJob(c *cron.Cron, spec string, cmd func()) {
+// runner keeps track of the running jobs so that the process can wait for them to
+// finish before exiting
+type runner struct {
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// wrap returns a job that runs the given command unless the runner is stopped
+func (r *runner) wrap(cmd func()) func() {
+	return func() {
+		r.mu.Lock()
+		if r.stopped {
+			r.mu.Unlock()
+			return
+		}
+		r.wg.Add(1)
+		r.mu.Unlock()
+
+		defer r.wg.Done()
+		cmd()
+	}
+}
+
+// stop prevents the jobs from starting and waits for the running jobs to finish
+func (r *runner) stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+func scheduleJob(c *cron.Cron, r *runner, spec string, cmd func()) {
 	s, err := cron.ParseStandard(spec)
 	if err != nil {
 		panic(errors.Wrap(err, "parsing schedule"))
 	}
 
-	c.Schedule(s, cron.FuncJob(cmd))
+	c.Schedule(s, cron.FuncJob(r.wrap(cmd)))
 }
 
 func main() {
 	flag.Parse()
 
+	if err := logger.Init(); err != nil {
+		logger.WithFields(logger.Fields{"error": err}).Err("initializing logger")
+	}
+
 	mailer.InitTemplates(*emailTemplateDir)
+	stripe.Key = os.Getenv("StripeSecretKey")
+
+	billingProvider, err := billing.FromEnv(handlers.StripePlanID)
+	if err != nil {
+		panic(errors.Wrap(err, "getting the billing provider"))
+	}
 
 	database.InitDB()
 	defer database.CloseDB()
+	metrics.InstrumentDB(database.DBConn)
+
+	if addr := os.Getenv("MetricsAddr"); addr != "" {
+		go func() {
+			logger.Notice("Metrics listening on %s", addr)
+
+			if err := metrics.Serve(addr); err != nil {
+				logger.WithFields(logger.Fields{"error": err}).Err("serving metrics")
+			}
+		}()
+	}
 
 	// Run jobs on initial start
-	log.Println("Job is running")
+	logger.Info("Job is running")
 
 	// Schedule jobs
 	c := cron.New()
+	r := &runner{}
 
-	scheduleJob(c, "0 20 * * 5", func() { digest.Send() })
+	scheduleJob(c, r, "0 20 * * 5", func() {
+		if err := digest.Send(billingProvider); err != nil {
+			logger.WithFields(logger.Fields{"error": err}).Err("sending the weekly digests")
+		}
+	})
+	scheduleJob(c, r, "* * * * *", func() {
+		if err := export.Process(); err != nil {
+			logger.WithFields(logger.Fields{"error": err}).Err("processing the account exports")
+		}
+	})
+	scheduleJob(c, r, "0 * * * *", func() {
+		if err := deletion.Process(); err != nil {
+			logger.WithFields(logger.Fields{"error": err}).Err("processing the account deletions")
+		}
+	})
+	scheduleJob(c, r, "30 * * * *", func() {
+		if err := operations.DeleteExpiredIdempotencyKeys(database.DBConn, time.Now()); err != nil {
+			logger.WithFields(logger.Fields{"error": err}).Err("deleting the expired idempotency keys")
+		}
+	})
 
 	c.Start()
 
-	// Block forever
-	select {}
+	// Run until the process is asked to stop, and let the running jobs finish
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	s := <-sig
+	logger.Notice("Received %s, waiting for the running jobs to finish", s)
+
+	c.Stop()
+	r.stop()
+
+	logger.Notice("Job shut down")
 }
@@ -27,6 +27,7 @@ import (
 	"path"
 
 	"github.com/aymerick/douceur/inliner"
+	"github.com/dnote/dnote/server/api/logger"
 	"github.com/pkg/errors"
 	"gopkg.in/gomail.v2"
 )
@@ -46,6 +47,16 @@ var (
 	EmailTypeWeeklyDigest = "weekly_digest"
 	// EmailTypeEmailVerification represents an email verification email
 	EmailTypeEmailVerification = "email_verification"
+	// EmailTypeResetPassword represents a password reset email
+	EmailTypeResetPassword = "reset_password"
+	// EmailTypePaymentFailed represents an email about a failed subscription payment
+	EmailTypePaymentFailed = "payment_failed"
+	// EmailTypeTrialEnding represents an email about a trial that ends soon
+	EmailTypeTrialEnding = "trial_ending"
+	// EmailTypeAccountLocked represents an email about signing in being locked after failed attempts
+	EmailTypeAccountLocked = "account_locked"
+	// EmailTypeAccountExport represents an email with a link to download an account export
+	EmailTypeAccountExport = "account_export"
 )
 
 func getTemplatePath(templateDirPath, filename string) string {
@@ -78,8 +89,35 @@ func InitTemplates(templateDirPath string) {
 		panic(errors.Wrap(err, "initializing template"))
 	}
 
+	resetPasswordTmpl, err := initTemplate(templateDirPath, EmailTypeResetPassword)
+	if err != nil {
+		panic(errors.Wrap(err, "initializing template"))
+	}
+
+	paymentFailedTmpl, err := initTemplate(templateDirPath, EmailTypePaymentFailed)
+	if err != nil {
+		panic(errors.Wrap(err, "initializing template"))
+	}
+	trialEndingTmpl, err := initTemplate(templateDirPath, EmailTypeTrialEnding)
+	if err != nil {
+		panic(errors.Wrap(err, "initializing template"))
+	}
+	accountLockedTmpl, err := initTemplate(templateDirPath, EmailTypeAccountLocked)
+	if err != nil {
+		panic(errors.Wrap(err, "initializing template"))
+	}
+	accountExportTmpl, err := initTemplate(templateDirPath, EmailTypeAccountExport)
+	if err != nil {
+		panic(errors.Wrap(err, "initializing template"))
+	}
+
 	T[EmailTypeWeeklyDigest] = weeklyDigestTmpl
 	T[EmailTypeEmailVerification] = emailVerificationTmpl
+	T[EmailTypeResetPassword] = resetPasswordTmpl
+	T[EmailTypePaymentFailed] = paymentFailedTmpl
+	T[EmailTypeTrialEnding] = trialEndingTmpl
+	T[EmailTypeAccountLocked] = accountLockedTmpl
+	T[EmailTypeAccountExport] = accountExportTmpl
 }
 
 // NewEmail returns a pointer to an Email struct with the given data
@@ -101,9 +139,11 @@ func isWhitelisted(emails []string) bool {
 func (e *Email) Send() error {
 	// If not production, never actually send an email
 	if os.Getenv("GO_ENV") != "PRODUCTION" {
-		fmt.Println("Not sending email because not production")
-		fmt.Println(e.subject, e.to, e.from)
-		// fmt.Println("Body", e.Body)
+		logger.WithFields(logger.Fields{
+			"subject": e.subject,
+			"to":      e.to,
+			"from":    e.from,
+		}).Debug("Not sending email because not production")
 		return nil
 	}
 
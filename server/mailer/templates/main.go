@@ -68,6 +68,91 @@ func emailVerificationHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(body))
 }
 
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Subject string
+		Token   string
+	}{
+		"Reset your password",
+		"testToken",
+	}
+	email := mailer.NewEmail("noreply@dnote.io", []string{"sung@dnote.io"}, "Reset your password")
+	err := email.ParseTemplate(mailer.EmailTypeResetPassword, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := email.Body
+	w.Write([]byte(body))
+}
+
+func paymentFailedHandler(w http.ResponseWriter, r *http.Request) {
+	data := mailer.PaymentFailedTmplData{
+		Subject:     "Your payment for Dnote Pro failed",
+		Amount:      "$3.00",
+		NextAttempt: "January 5, 2019",
+	}
+	email := mailer.NewEmail("noreply@dnote.io", []string{"sung@dnote.io"}, data.Subject)
+	err := email.ParseTemplate(mailer.EmailTypePaymentFailed, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := email.Body
+	w.Write([]byte(body))
+}
+
+func trialEndingHandler(w http.ResponseWriter, r *http.Request) {
+	data := mailer.TrialEndingTmplData{
+		Subject:  "Your Dnote Pro trial ends soon",
+		TrialEnd: "January 5, 2019",
+	}
+	email := mailer.NewEmail("noreply@dnote.io", []string{"sung@dnote.io"}, data.Subject)
+	err := email.ParseTemplate(mailer.EmailTypeTrialEnding, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := email.Body
+	w.Write([]byte(body))
+}
+
+func accountLockedHandler(w http.ResponseWriter, r *http.Request) {
+	data := mailer.AccountLockedTmplData{
+		Subject:  "Signing in to your Dnote account is locked",
+		Duration: "30 minutes",
+	}
+	email := mailer.NewEmail("noreply@dnote.io", []string{"sung@dnote.io"}, data.Subject)
+	err := email.ParseTemplate(mailer.EmailTypeAccountLocked, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := email.Body
+	w.Write([]byte(body))
+}
+
+func accountExportHandler(w http.ResponseWriter, r *http.Request) {
+	data := mailer.AccountExportTmplData{
+		Subject:  "Your Dnote data is ready to download",
+		Token:    "testToken",
+		Duration: "48 hours",
+	}
+	email := mailer.NewEmail("noreply@dnote.io", []string{"sung@dnote.io"}, data.Subject)
+	err := email.ParseTemplate(mailer.EmailTypeAccountExport, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := email.Body
+	w.Write([]byte(body))
+}
+
 func init() {
 	err := godotenv.Load(".env.dev")
 	if err != nil {
@@ -85,5 +170,10 @@ func main() {
 
 	http.HandleFunc("/weekly-digest", weeklyDigestHandler)
 	http.HandleFunc("/email-verification", emailVerificationHandler)
+	http.HandleFunc("/reset-password", resetPasswordHandler)
+	http.HandleFunc("/payment-failed", paymentFailedHandler)
+	http.HandleFunc("/trial-ending", trialEndingHandler)
+	http.HandleFunc("/account-locked", accountLockedHandler)
+	http.HandleFunc("/account-export", accountExportHandler)
 	log.Fatal(http.ListenAndServe(":2300", nil))
 }
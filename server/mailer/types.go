@@ -26,3 +26,31 @@ type DigestTmplData struct {
 	ActiveNoteCount   int
 	EmailSessionToken string
 }
+
+// PaymentFailedTmplData is a template data for emails about a failed subscription payment
+type PaymentFailedTmplData struct {
+	Subject string
+	Amount  string
+	// NextAttempt is the date of the next payment attempt. It is empty if the payment
+	// is not retried.
+	NextAttempt string
+}
+
+// TrialEndingTmplData is a template data for emails about a trial that ends soon
+type TrialEndingTmplData struct {
+	Subject  string
+	TrialEnd string
+}
+
+// AccountLockedTmplData is a template data for emails about signing in being locked
+type AccountLockedTmplData struct {
+	Subject  string
+	Duration string
+}
+
+// AccountExportTmplData is a template data for emails with a link to download an account export
+type AccountExportTmplData struct {
+	Subject  string
+	Token    string
+	Duration string
+}
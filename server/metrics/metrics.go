@@ -0,0 +1,204 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package metrics provides the Prometheus metrics of the API server and the job runner
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dnote/dnote/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dnote"
+
+// directions of the synced items
+const (
+	SyncDirectionPush = "push"
+	SyncDirectionPull = "pull"
+)
+
+// results of sending a digest email
+const (
+	DigestResultSent   = "sent"
+	DigestResultFailed = "failed"
+)
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "The latency of the HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	syncItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "items_total",
+		Help:      "The number of the items pushed or pulled by the clients.",
+	}, []string{"direction", "type"})
+
+	syncFragmentItems = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "fragment_items",
+		Help:      "The number of the items in the sync fragments sent to the clients.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "The latency of the database queries by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	activeSessions = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "The number of the sessions that have not expired.",
+	}, countActiveSessions)
+
+	digestUsersProcessed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "digest",
+		Name:      "users_processed_total",
+		Help:      "The number of the users processed by the digest job.",
+	})
+
+	digestEmails = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "digest",
+		Name:      "emails_total",
+		Help:      "The number of the digest emails by result.",
+	}, []string{"result"})
+
+	digestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "digest",
+		Name:      "duration_seconds",
+		Help:      "The duration of the runs of the digest job.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestDuration,
+		syncItems,
+		syncFragmentItems,
+		dbQueryDuration,
+		activeSessions,
+		digestUsersProcessed,
+		digestEmails,
+		digestDuration,
+	)
+}
+
+// countActiveSessions counts the sessions that have not expired when the metrics are scraped
+func countActiveSessions() float64 {
+	db := database.DBConn
+	if db == nil {
+		return 0
+	}
+
+	var count int
+	if err := db.Model(&database.Session{}).Where("expires_at > ?", time.Now()).Count(&count).Error; err != nil {
+		return 0
+	}
+
+	return float64(count)
+}
+
+// ObserveHTTPRequest records a request to the route with the given pattern
+func ObserveHTTPRequest(method, route string, status int, d time.Duration) {
+	httpRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+// AddSyncItems records the items of the given type pushed or pulled by a client
+func AddSyncItems(direction, itemType string, n int) {
+	syncItems.WithLabelValues(direction, itemType).Add(float64(n))
+}
+
+// ObserveSyncFragment records the number of the items in a sync fragment
+func ObserveSyncFragment(n int) {
+	syncFragmentItems.Observe(float64(n))
+}
+
+// AddDigestUserProcessed records a user processed by the digest job
+func AddDigestUserProcessed() {
+	digestUsersProcessed.Inc()
+}
+
+// AddDigestEmail records a digest email with the given result
+func AddDigestEmail(result string) {
+	digestEmails.WithLabelValues(result).Inc()
+}
+
+// ObserveDigestDuration records the duration of a run of the digest job
+func ObserveDigestDuration(d time.Duration) {
+	digestDuration.Observe(d.Seconds())
+}
+
+const dbStartKey = "metrics:start"
+
+// InstrumentDB records the latency of the queries made with the given database handle
+func InstrumentDB(db *gorm.DB) {
+	start := func(scope *gorm.Scope) {
+		scope.Set(dbStartKey, time.Now())
+	}
+	observe := func(operation string) func(scope *gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			v, ok := scope.Get(dbStartKey)
+			if !ok {
+				return
+			}
+			if t, ok := v.(time.Time); ok {
+				dbQueryDuration.WithLabelValues(operation).Observe(time.Since(t).Seconds())
+			}
+		}
+	}
+
+	c := db.Callback()
+	c.Create().Before("gorm:create").Register("metrics:before_create", start)
+	c.Create().After("gorm:create").Register("metrics:after_create", observe("create"))
+	c.Query().Before("gorm:query").Register("metrics:before_query", start)
+	c.Query().After("gorm:query").Register("metrics:after_query", observe("query"))
+	c.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", start)
+	c.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", observe("row_query"))
+	c.Update().Before("gorm:update").Register("metrics:before_update", start)
+	c.Update().After("gorm:update").Register("metrics:after_update", observe("update"))
+	c.Delete().Before("gorm:delete").Register("metrics:before_delete", start)
+	c.Delete().After("gorm:delete").Register("metrics:after_delete", observe("delete"))
+}
+
+// Serve serves the metrics at /metrics on the given address
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return http.ListenAndServe(addr, mux)
+}
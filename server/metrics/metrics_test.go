@@ -0,0 +1,87 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/server/testutils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAddSyncItems(t *testing.T) {
+	push := syncItems.WithLabelValues(SyncDirectionPush, "note")
+	pull := syncItems.WithLabelValues(SyncDirectionPull, "note")
+	prevPush, prevPull := testutil.ToFloat64(push), testutil.ToFloat64(pull)
+
+	AddSyncItems(SyncDirectionPush, "note", 3)
+	AddSyncItems(SyncDirectionPush, "note", 2)
+	AddSyncItems(SyncDirectionPull, "note", 0)
+
+	testutils.AssertEqual(t, testutil.ToFloat64(push)-prevPush, float64(5), "push count mismatch")
+	testutils.AssertEqual(t, testutil.ToFloat64(pull)-prevPull, float64(0), "pull count mismatch")
+}
+
+func TestAddDigestEmail(t *testing.T) {
+	sent := digestEmails.WithLabelValues(DigestResultSent)
+	failed := digestEmails.WithLabelValues(DigestResultFailed)
+	prevSent, prevFailed := testutil.ToFloat64(sent), testutil.ToFloat64(failed)
+
+	AddDigestEmail(DigestResultSent)
+	AddDigestEmail(DigestResultSent)
+	AddDigestEmail(DigestResultFailed)
+
+	testutils.AssertEqual(t, testutil.ToFloat64(sent)-prevSent, float64(2), "sent count mismatch")
+	testutils.AssertEqual(t, testutil.ToFloat64(failed)-prevFailed, float64(1), "failed count mismatch")
+}
+
+func TestHandler(t *testing.T) {
+	ObserveHTTPRequest("GET", "/notes/{noteUUID}", 200, 20*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutils.AssertEqual(t, w.Code, 200, "status code mismatch")
+
+	expected := `dnote_http_request_duration_seconds_count{method="GET",route="/notes/{noteUUID}",status="200"} 1`
+	if !strings.Contains(string(body), expected) {
+		t.Errorf("the metrics do not contain %s", expected)
+	}
+
+	for _, name := range []string{
+		"dnote_active_sessions",
+		"dnote_sync_fragment_items",
+		"dnote_digest_users_processed_total",
+		"dnote_digest_duration_seconds",
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("the metrics do not contain %s", name)
+		}
+	}
+}
@@ -252,6 +252,24 @@ func ClearData() {
 	if err := db.Delete(&database.Digest{}).Error; err != nil {
 		panic(errors.Wrap(err, "Failed to clear digests"))
 	}
+	if err := db.Delete(&database.BackupCode{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear backup codes"))
+	}
+	if err := db.Delete(&database.IdempotencyKey{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear idempotency keys"))
+	}
+	if err := db.Delete(&database.StripeEvent{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear stripe events"))
+	}
+	if err := db.Delete(&database.RateLimitCounter{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear rate limit counters"))
+	}
+	if err := db.Delete(&database.SigninFailure{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear signin failures"))
+	}
+	if err := db.Delete(&database.AccountExport{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear account exports"))
+	}
 }
 
 // HTTPDo makes an HTTP request and returns a response
@@ -0,0 +1,52 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import React from 'react';
+
+import Button from '../Common/Button';
+import authStyles from '../Common/Auth.module.scss';
+import styles from './RecoveryKey.module.scss';
+
+// RecoveryKey shows the recovery key generated upon registration. It cannot be
+// shown again.
+function RecoveryKey({ recoveryKey, onContinue, submitting }) {
+  return (
+    <div id="T-recovery-key" className={authStyles.form}>
+      <p className={styles.description}>
+        This is your recovery key. It is the only way to keep your notes if you
+        forget the password. Store it in a safe place, because it cannot be
+        shown again.
+      </p>
+
+      <div className={styles.key}>{recoveryKey}</div>
+
+      <Button
+        type="button"
+        kind="third"
+        stretch
+        className={authStyles['auth-button']}
+        isBusy={submitting}
+        onClick={onContinue}
+      >
+        I have saved the recovery key
+      </Button>
+    </div>
+  );
+}
+
+export default RecoveryKey;
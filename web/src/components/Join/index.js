@@ -22,14 +22,16 @@ import { Link } from 'react-router-dom';
 import { connect } from 'react-redux';
 
 import JoinForm from './JoinForm';
+import RecoveryKey from './RecoveryKey';
 import Logo from '../Icons/Logo';
 import Flash from '../Common/Flash';
 
 import { getReferrer } from '../../libs/url';
+import { b64ToBuf } from '../../libs/encoding';
 import { updateAuthEmail } from '../../actions/form';
 import { register } from '../../services/users';
 import { getCurrentUser } from '../../actions/auth';
-import { registerHelper } from '../../crypto';
+import { registerHelper, recoveryKeyHelper } from '../../crypto';
 import { DEFAULT_KDF_ITERATION } from '../../crypto/consts';
 
 import authStyles from '../Common/Auth.module.scss';
@@ -37,6 +39,7 @@ import authStyles from '../Common/Auth.module.scss';
 function Join({ doGetCurrentUser, formData, doUpdateAuthEmail, location }) {
   const [errMsg, setErrMsg] = useState('');
   const [submitting, setSubmitting] = useState(false);
+  const [recoveryKey, setRecoveryKey] = useState('');
 
   const referrer = getReferrer(location);
 
@@ -63,14 +66,32 @@ function Join({ doGetCurrentUser, formData, doUpdateAuthEmail, location }) {
         password,
         iteration: DEFAULT_KDF_ITERATION
       });
+      const recovery = await recoveryKeyHelper(b64ToBuf(cipherKey));
       await register({
         email,
         authKey,
         cipherKeyEnc,
-        iteration: DEFAULT_KDF_ITERATION
+        iteration: DEFAULT_KDF_ITERATION,
+        cipherKeyRecoveryEnc: recovery.cipherKeyRecoveryEnc,
+        recoveryAuthKey: recovery.recoveryAuthKey
       });
       localStorage.setItem('cipherKey', cipherKey);
 
+      // show the recovery key before fetching the current user, because the
+      // guestOnly HOC redirects the user once the current user is fetched
+      setRecoveryKey(recovery.recoveryKey);
+      setSubmitting(false);
+    } catch (err) {
+      console.log(err);
+      setErrMsg(err.message);
+      setSubmitting(false);
+    }
+  }
+
+  async function handleContinue() {
+    setSubmitting(true);
+
+    try {
       // guestOnly HOC will redirect the user accordingly after the current user is fetched
       await doGetCurrentUser();
       doUpdateAuthEmail('');
@@ -106,20 +127,30 @@ function Join({ doGetCurrentUser, formData, doUpdateAuthEmail, location }) {
               </Flash>
             )}
 
-            <JoinForm
-              onJoin={handleJoin}
-              submitting={submitting}
-              email={formData.auth.email}
-              onUpdateEmail={doUpdateAuthEmail}
-            />
+            {recoveryKey ? (
+              <RecoveryKey
+                recoveryKey={recoveryKey}
+                onContinue={handleContinue}
+                submitting={submitting}
+              />
+            ) : (
+              <JoinForm
+                onJoin={handleJoin}
+                submitting={submitting}
+                email={formData.auth.email}
+                onUpdateEmail={doUpdateAuthEmail}
+              />
+            )}
           </div>
 
-          <div className={authStyles.footer}>
-            <div className={authStyles.callout}>Already have an account?</div>
-            <Link to="/login" className={authStyles.cta}>
-              Sign in
-            </Link>
-          </div>
+          {!recoveryKey && (
+            <div className={authStyles.footer}>
+              <div className={authStyles.callout}>Already have an account?</div>
+              <Link to="/login" className={authStyles.cta}>
+                Sign in
+              </Link>
+            </div>
+          )}
         </div>
       </div>
     </div>
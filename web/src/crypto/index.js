@@ -18,7 +18,7 @@
 
 // module crypto.js provides cryptography operations using the Web Crypto API
 
-import { utf8ToBuf, bufToB64, b64ToBuf } from '../libs/encoding';
+import { utf8ToBuf, bufToB64, b64ToBuf, bufToB32 } from '../libs/encoding';
 import { PBKDF2, HKDF, SHA256, AES_GCM, AES_GCM_NONCE_SIZE } from './consts';
 import { demoCipherKey } from '../libs/demo';
 
@@ -153,6 +153,52 @@ export async function registerHelper({ email, password, iteration }) {
   };
 }
 
+// makeRecoveryKeys derives, from the recovery key, an encryption key used for
+// wrapping the cipher key and an authentication key, in the same way as the CLI
+export async function makeRecoveryKeys(recoveryKeyBuf) {
+  const salt = new ArrayBuffer(0);
+
+  const encKey = await hkdf(
+    recoveryKeyBuf,
+    salt,
+    utf8ToBuf('recovery-enc'),
+    SHA256,
+    256
+  );
+  const authKey = await hkdf(
+    recoveryKeyBuf,
+    salt,
+    utf8ToBuf('recovery-auth'),
+    SHA256,
+    256
+  );
+
+  return { encKey, authKey };
+}
+
+// encodeRecoveryKey encodes the recovery key in groups of base32 characters
+// separated by dashes, so that it is easy to write down
+export function encodeRecoveryKey(recoveryKeyBuf) {
+  const encoded = bufToB32(recoveryKeyBuf);
+
+  return encoded.match(/.{1,4}/g).join('-');
+}
+
+// recoveryKeyHelper generates a recovery key and wraps the cipher key with it.
+// The recovery key is shown to the user and is never sent to the server.
+export async function recoveryKeyHelper(cipherKeyBuf) {
+  const recoveryKey = genRandomBytes(32);
+  const { encKey, authKey } = await makeRecoveryKeys(recoveryKey);
+
+  const cipherKeyRecoveryEnc = await aes256GcmEncrypt(encKey, cipherKeyBuf);
+
+  return {
+    recoveryKey: encodeRecoveryKey(recoveryKey),
+    cipherKeyRecoveryEnc: bufToB64(cipherKeyRecoveryEnc),
+    recoveryAuthKey: bufToB64(authKey)
+  };
+}
+
 export async function loginHelper({ email, password, iteration }) {
   const emailBuf = utf8ToBuf(email);
   const passwordBuf = utf8ToBuf(password);
@@ -22,7 +22,10 @@ import {
   hkdf,
   importAes256GcmKey,
   aes256GcmEncrypt,
-  aes256GcmDecrypt
+  aes256GcmDecrypt,
+  makeRecoveryKeys,
+  encodeRecoveryKey,
+  recoveryKeyHelper
 } from './index';
 import { bufToB64, b64ToBuf, utf8ToBuf, bufToUtf8 } from '../libs/encoding';
 
@@ -101,3 +104,42 @@ describe('aes256Gcm', () => {
     });
   }
 });
+
+describe('makeRecoveryKeys', () => {
+  it('derives the same keys as the CLI', async () => {
+    const recoveryKey = b64ToBuf(
+      'AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8='
+    );
+    const { encKey, authKey } = await makeRecoveryKeys(recoveryKey);
+
+    expect(bufToB64(encKey)).to.equal(
+      'scbyZ89kEEiNnqHwpXyl+HlW5BlsZPJ5t8nPs3IFjVk='
+    );
+    expect(bufToB64(authKey)).to.equal(
+      'nrZ+LWGw85dTnTWeizrs+p4MvqXXxFkQV+eu6WGwZV4='
+    );
+  });
+});
+
+describe('encodeRecoveryKey', () => {
+  it('encodes the recovery key in groups of base32 characters', () => {
+    const recoveryKey = b64ToBuf(
+      'AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8='
+    );
+
+    expect(encodeRecoveryKey(recoveryKey)).to.equal(
+      'AAAQ-EAYE-AUDA-OCAJ-BIFQ-YDIO-B4IB-CEQT-CQKR-MFYY-DENB-WHA5-DYPQ'
+    );
+  });
+});
+
+describe('recoveryKeyHelper', () => {
+  it('wraps the cipher key with the recovery key', async () => {
+    const cipherKey = b64ToBuf('79fkmXp1Eu+O+1IBqHjDvcwciJM4k+nO9bEjj8bvSXo=');
+    const result = await recoveryKeyHelper(cipherKey);
+
+    expect(result.recoveryKey).to.match(/^([A-Z2-7]{4}-){12}[A-Z2-7]{4}$/);
+    expect(result.recoveryAuthKey).to.be.a('string');
+    expect(result.cipherKeyRecoveryEnc).to.be.a('string');
+  });
+});
@@ -64,3 +64,28 @@ export function b64ToBuf(base64Str) {
 
   return buf;
 }
+
+const b32Alphabet = 'ABCDEFGHIJKLMNOPQRSTUVWXYZ234567';
+
+// bufToB32 encodes the given ArrayBuffer using base32 without padding
+export function bufToB32(buf) {
+  const bytes = new Uint8Array(buf);
+
+  let ret = '';
+  let bits = 0;
+  let value = 0;
+  for (let i = 0; i < bytes.length; i++) {
+    value = (value << 8) | bytes[i];
+    bits += 8;
+
+    while (bits >= 5) {
+      ret += b32Alphabet[(value >>> (bits - 5)) & 31];
+      bits -= 5;
+    }
+  }
+  if (bits > 0) {
+    ret += b32Alphabet[(value << (5 - bits)) & 31];
+  }
+
+  return ret;
+}
@@ -17,7 +17,13 @@
  */
 
 import { expect } from 'chai';
-import { utf8ToBuf, bufToUtf8, bufToB64, b64ToBuf } from './encoding';
+import {
+  utf8ToBuf,
+  bufToUtf8,
+  bufToB64,
+  b64ToBuf,
+  bufToB32
+} from './encoding';
 
 describe('utf8ToBuf', () => {
   it('converts a string to an ArrayBuffer', () => {
@@ -70,3 +76,20 @@ describe('b64ToBuf', () => {
     expect(result).to.deep.equal(buf);
   });
 });
+
+describe('bufToB32', () => {
+  const testCases = [
+    { input: 'f', expected: 'MY' },
+    { input: 'hello', expected: 'NBSWY3DP' },
+    { input: 'foobar', expected: 'MZXW6YTBOI' }
+  ];
+
+  for (let i = 0; i < testCases.length; i++) {
+    const tc = testCases[i];
+
+    it(`encodes ${tc.input} using base32 without padding`, () => {
+      const result = bufToB32(utf8ToBuf(tc.input));
+      expect(result).to.equal(tc.expected);
+    });
+  }
+});
@@ -56,12 +56,21 @@ export function updatePassword({
   return apiClient.patch('/account/password', payload);
 }
 
-export function register({ email, authKey, cipherKeyEnc, iteration }) {
+export function register({
+  email,
+  authKey,
+  cipherKeyEnc,
+  iteration,
+  cipherKeyRecoveryEnc,
+  recoveryAuthKey
+}) {
   const payload = {
     email,
     auth_key: authKey,
     iteration,
-    cipher_key_enc: cipherKeyEnc
+    cipher_key_enc: cipherKeyEnc,
+    cipher_key_recovery_enc: cipherKeyRecoveryEnc,
+    recovery_auth_key: recoveryAuthKey
   };
 
   return apiClient.post('/v1/register', payload);
@@ -84,7 +93,7 @@ export function signout() {
 export function sendResetPasswordEmail({ email }) {
   const payload = { email };
 
-  return apiClient.post('/reset-token', payload);
+  return apiClient.post('/v1/password-reset', payload);
 }
 
 export function sendEmailVerificationEmail() {